@@ -96,6 +96,9 @@ var PreConsumedQuota = 500
 
 var RetryTimes = 0
 
+// RetryTimeout 单个请求从开始到最后一次重试的总时长上限（秒），0 表示不限制
+var RetryTimeout = 0
+
 var RootUserEmail = ""
 
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
@@ -114,6 +117,8 @@ var GeminiSafetySetting = GetEnvOrDefaultString("GEMINI_SAFETY_SETTING", "BLOCK_
 
 const (
 	RequestIdKey = "X-Oneapi-Request-Id"
+	// BatchIdKey 批处理任务发起的内部请求在 context 中携带任务 id
+	BatchIdKey = "X-Oneapi-Batch-Id"
 )
 
 const (
@@ -213,6 +218,7 @@ const (
 	ChannelTypeDify           = 37
 	ChannelTypeJina           = 38
 	ChannelCloudflare         = 39
+	ChannelTypeVertexAi       = 41
 
 	ChannelTypeDummy // this one is only for count, do not add any channel after this
 
@@ -259,4 +265,6 @@ var ChannelBaseURLs = []string{
 	"",                                          //37
 	"https://api.jina.ai",                       //38
 	"https://api.cloudflare.com",                //39
+	"",                                          //40
+	"",                                          //41
 }
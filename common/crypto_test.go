@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecryptString(t *testing.T) {
+	asserts := assert.New(t)
+	plaintext := `{"messages":[{"role":"user","content":"你好"}]}`
+	encrypted, err := EncryptString("secret", plaintext)
+	asserts.NoError(err)
+	asserts.NotContains(encrypted, "messages")
+	decrypted, err := DecryptString("secret", encrypted)
+	asserts.NoError(err)
+	asserts.Equal(plaintext, decrypted)
+
+	// 每次加密使用随机 nonce
+	again, err := EncryptString("secret", plaintext)
+	asserts.NoError(err)
+	asserts.NotEqual(encrypted, again)
+
+	// 密钥错误或密文被篡改时解密失败
+	_, err = DecryptString("other", encrypted)
+	asserts.Error(err)
+	_, err = DecryptString("secret", encrypted[:len(encrypted)-4]+"AAAA")
+	asserts.Error(err)
+	_, err = DecryptString("secret", "c2hvcnQ=")
+	asserts.Error(err)
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// RateLimitConfig 每分钟请求数、每分钟 token 数与每日请求数限制，0 表示不限制
+type RateLimitConfig struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+	RPD int `json:"rpd"`
+}
+
+func (config RateLimitConfig) IsEmpty() bool {
+	return config.RPM <= 0 && config.TPM <= 0 && config.RPD <= 0
+}
+
+// GroupRateLimit 按用户分组配置的限流，分组内所有用户共享同一额度
+var GroupRateLimit = map[string]RateLimitConfig{}
+
+func GroupRateLimit2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupRateLimit)
+	if err != nil {
+		SysError("error marshalling group rate limit: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupRateLimitByJSONString(jsonStr string) error {
+	GroupRateLimit = make(map[string]RateLimitConfig)
+	return json.Unmarshal([]byte(jsonStr), &GroupRateLimit)
+}
+
+func GetGroupRateLimit(name string) RateLimitConfig {
+	return GroupRateLimit[name]
+}
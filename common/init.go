@@ -22,7 +22,8 @@ func printHelp() {
 	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
 }
 
-func init() {
+// InitEnv 解析命令行参数并读取环境变量，需要在 main 中最先调用
+func InitEnv() {
 	flag.Parse()
 
 	if *PrintVersion {
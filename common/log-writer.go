@@ -0,0 +1,109 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFileWriter 按天切分日志文件，单个文件超过 maxSize 时也会切分，只保留最近 maxFiles 个文件
+type rotatingFileWriter struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	now      func() time.Time
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	date string
+}
+
+func newRotatingFileWriter(dir string, maxSize int64, maxFiles int) (*rotatingFileWriter, error) {
+	w := &rotatingFileWriter{dir: dir, maxSize: maxSize, maxFiles: maxFiles, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingFileWriter) currentPath() string {
+	return filepath.Join(w.dir, fmt.Sprintf("oneapi-%s.log", w.date))
+}
+
+func (w *rotatingFileWriter) open() error {
+	w.date = w.now().Format("20060102")
+	fd, err := os.OpenFile(w.currentPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+	w.file = fd
+	w.size = info.Size()
+	return nil
+}
+
+func (w *rotatingFileWriter) rotate() error {
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+	if w.date == w.now().Format("20060102") {
+		// 同一天内因大小切分，把当前文件改名保留
+		rotatedPath := filepath.Join(w.dir, fmt.Sprintf("oneapi-%s-%s.log", w.date, w.now().Format("150405.000")))
+		if err := os.Rename(w.currentPath(), rotatedPath); err != nil {
+			return err
+		}
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.removeOldFiles()
+	return nil
+}
+
+func (w *rotatingFileWriter) removeOldFiles() {
+	if w.maxFiles <= 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(w.dir, "oneapi-*.log"))
+	if err != nil || len(files) <= w.maxFiles {
+		return
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return modTimes[files[i]].Before(modTimes[files[j]])
+	})
+	for _, file := range files[:len(files)-w.maxFiles] {
+		if file == w.currentPath() || !strings.HasPrefix(filepath.Base(file), "oneapi-") {
+			continue
+		}
+		_ = os.Remove(file)
+	}
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil || w.date != w.now().Format("20060102") || (w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize && w.size > 0) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func logFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "oneapi-*.log"))
+	assert.NoError(t, err)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTestRotatingFileWriter(t *testing.T, maxSize int64, maxFiles int, now *time.Time) *rotatingFileWriter {
+	w := &rotatingFileWriter{dir: t.TempDir(), maxSize: maxSize, maxFiles: maxFiles, now: func() time.Time { return *now }}
+	assert.NoError(t, w.open())
+	t.Cleanup(func() {
+		_ = w.file.Close()
+	})
+	return w
+}
+
+func TestRotatingFileWriterBySize(t *testing.T) {
+	asserts := assert.New(t)
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	w := newTestRotatingFileWriter(t, 10, 0, &now)
+
+	_, err := w.Write([]byte("12345678\n"))
+	asserts.NoError(err)
+	// 超过大小上限时切分，已有内容的文件改名保留
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("abcdefgh\n"))
+	asserts.NoError(err)
+	asserts.Equal([]string{"oneapi-20261018-100001.000.log", "oneapi-20261018.log"}, logFiles(t, w.dir))
+	rotated, err := os.ReadFile(filepath.Join(w.dir, "oneapi-20261018-100001.000.log"))
+	asserts.NoError(err)
+	asserts.Equal("12345678\n", string(rotated))
+	current, err := os.ReadFile(filepath.Join(w.dir, "oneapi-20261018.log"))
+	asserts.NoError(err)
+	asserts.Equal("abcdefgh\n", string(current))
+
+	// 单条日志超过上限时写入空文件，不会反复切分
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("0123456789abcdef\n"))
+	asserts.NoError(err)
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("x"))
+	asserts.NoError(err)
+	asserts.Len(logFiles(t, w.dir), 4)
+}
+
+func TestRotatingFileWriterByDate(t *testing.T) {
+	asserts := assert.New(t)
+	now := time.Date(2026, 10, 18, 23, 59, 59, 0, time.Local)
+	w := newTestRotatingFileWriter(t, 0, 0, &now)
+
+	_, err := w.Write([]byte("day one\n"))
+	asserts.NoError(err)
+	// 跨天后写入新日期的文件，旧文件保持原名
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("day two\n"))
+	asserts.NoError(err)
+	asserts.Equal([]string{"oneapi-20261018.log", "oneapi-20261019.log"}, logFiles(t, w.dir))
+	previous, err := os.ReadFile(filepath.Join(w.dir, "oneapi-20261018.log"))
+	asserts.NoError(err)
+	asserts.Equal("day one\n", string(previous))
+}
+
+func TestRotatingFileWriterRemovesOldFiles(t *testing.T) {
+	asserts := assert.New(t)
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	w := newTestRotatingFileWriter(t, 0, 2, &now)
+	for i := 0; i < 4; i++ {
+		_, err := w.Write([]byte("line\n"))
+		asserts.NoError(err)
+		// 按修改时间清理，确保每个文件的修改时间不同
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		asserts.NoError(os.Chtimes(w.currentPath(), modTime, modTime))
+		now = now.AddDate(0, 0, 1)
+	}
+	// 只保留最近的文件，当前文件不会被删除
+	asserts.Equal([]string{"oneapi-20261020.log", "oneapi-20261021.log"}, logFiles(t, w.dir))
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"hash/fnv"
 	"io"
 	"log"
+	"math/rand"
 	"os"
-	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,44 +21,146 @@ const (
 	loggerError = "ERR"
 )
 
-const maxLogCount = 1000000
+// LogJsonFormat 为 true 时以 JSON 格式输出日志，便于 Loki/ELK 等采集
+var LogJsonFormat = false
 
-var logCount int
-var setupLogLock sync.Mutex
-var setupLogWorking bool
+// 低于该级别的日志不输出：0 info，1 warn，2 error
+var logMinLevel = 0
 
+// logInfoSampleRate 请求相关 info 日志的采样率，按请求 id 采样，同一请求的日志一起保留或丢弃
+var logInfoSampleRate = 1.0
+
+// SetupLogger 读取日志配置，并在设置了日志目录时写入按天和大小切分的日志文件：
+// LOG_FORMAT=json 输出 JSON 日志；LOG_LEVEL 为 info/warn/error；LOG_INFO_SAMPLE_RATE 为 info 日志采样率；
+// LOG_MAX_SIZE 为单个日志文件大小上限（MB）；LOG_MAX_FILES 为保留的日志文件数，0 为不限制
 func SetupLogger() {
+	LogJsonFormat = strings.ToLower(os.Getenv("LOG_FORMAT")) == "json"
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "warn", "warning":
+		logMinLevel = 1
+	case "error":
+		logMinLevel = 2
+	default:
+		logMinLevel = 0
+	}
+	logInfoSampleRate = GetEnvOrDefaultFloat("LOG_INFO_SAMPLE_RATE", 1)
 	if *LogDir != "" {
-		ok := setupLogLock.TryLock()
-		if !ok {
-			log.Println("setup log is already working")
-			return
-		}
-		defer func() {
-			setupLogLock.Unlock()
-			setupLogWorking = false
-		}()
-		logPath := filepath.Join(*LogDir, fmt.Sprintf("oneapi-%s.log", time.Now().Format("20060102")))
-		fd, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		maxSize := int64(GetEnvOrDefault("LOG_MAX_SIZE", 0)) * 1024 * 1024
+		writer, err := newRotatingFileWriter(*LogDir, maxSize, GetEnvOrDefault("LOG_MAX_FILES", 0))
 		if err != nil {
 			log.Fatal("failed to open log file")
 		}
-		gin.DefaultWriter = io.MultiWriter(os.Stdout, fd)
-		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, fd)
+		gin.DefaultWriter = io.MultiWriter(os.Stdout, writer)
+		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, writer)
 	}
 }
 
-func SysLog(s string) {
+type logFieldsKey struct{}
+
+// logFields 请求相关的日志字段，随请求处理过程逐步补充
+type logFields struct {
+	lock   sync.RWMutex
+	values map[string]interface{}
+}
+
+func ContextWithLogFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, logFieldsKey{}, &logFields{values: make(map[string]interface{})})
+}
+
+// SetLogField 为当前请求的日志设置字段，如 user_id、token_id、channel_id、model
+func SetLogField(ctx context.Context, key string, value interface{}) {
+	fields, ok := requestContext(ctx).Value(logFieldsKey{}).(*logFields)
+	if !ok {
+		return
+	}
+	fields.lock.Lock()
+	fields.values[key] = value
+	fields.lock.Unlock()
+}
+
+// GetLogFields 返回当前请求的日志字段
+func GetLogFields(ctx context.Context) map[string]interface{} {
+	result := make(map[string]interface{})
+	fields, ok := requestContext(ctx).Value(logFieldsKey{}).(*logFields)
+	if !ok {
+		return result
+	}
+	fields.lock.RLock()
+	for key, value := range fields.values {
+		result[key] = value
+	}
+	fields.lock.RUnlock()
+	return result
+}
+
+func levelEnabled(level string) bool {
+	switch level {
+	case loggerError:
+		return true
+	case loggerWarn:
+		return logMinLevel <= 1
+	default:
+		return logMinLevel <= 0
+	}
+}
+
+func jsonLevelName(level string) string {
+	switch level {
+	case loggerWarn:
+		return "warn"
+	case loggerError:
+		return "error"
+	case "FATAL":
+		return "fatal"
+	default:
+		return "info"
+	}
+}
+
+// WriteJsonLog 输出一行 JSON 日志
+func WriteJsonLog(writer io.Writer, level string, msg string, fields map[string]interface{}) {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = jsonLevelName(level)
+	entry["msg"] = msg
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_, _ = writer.Write(append(data, '\n'))
+}
+
+func sysLogHelper(writer io.Writer, level string, s string) {
+	if !levelEnabled(level) {
+		return
+	}
+	if LogJsonFormat {
+		WriteJsonLog(writer, level, s, map[string]interface{}{"source": "sys"})
+		return
+	}
 	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	_, _ = fmt.Fprintf(writer, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+}
+
+func SysLog(s string) {
+	sysLogHelper(gin.DefaultWriter, loggerINFO, s)
+}
+
+func SysWarn(s string) {
+	sysLogHelper(gin.DefaultErrorWriter, loggerWarn, s)
 }
 
 func SysError(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	sysLogHelper(gin.DefaultErrorWriter, loggerError, s)
 }
 
 func LogInfo(ctx context.Context, msg string) {
+	if !logSampled(ctx) {
+		return
+	}
 	logHelper(ctx, loggerINFO, msg)
 }
 
@@ -68,25 +172,49 @@ func LogError(ctx context.Context, msg string) {
 	logHelper(ctx, loggerError, msg)
 }
 
+// logSampled 按请求 id 决定 info 日志是否输出，没有请求 id 时随机采样
+func logSampled(ctx context.Context) bool {
+	if logInfoSampleRate >= 1 {
+		return true
+	}
+	if logInfoSampleRate <= 0 {
+		return false
+	}
+	id, _ := ctx.Value(RequestIdKey).(string)
+	if id == "" {
+		return rand.Float64() < logInfoSampleRate
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return float64(h.Sum32()%10000) < logInfoSampleRate*10000
+}
+
 func logHelper(ctx context.Context, level string, msg string) {
+	if !levelEnabled(level) {
+		return
+	}
 	writer := gin.DefaultErrorWriter
 	if level == loggerINFO {
 		writer = gin.DefaultWriter
 	}
 	id := ctx.Value(RequestIdKey)
+	if LogJsonFormat {
+		fields := GetLogFields(ctx)
+		if id != nil {
+			fields["request_id"] = id
+		}
+		WriteJsonLog(writer, level, msg, fields)
+		return
+	}
 	now := time.Now()
 	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", level, now.Format("2006/01/02 - 15:04:05"), id, msg)
-	logCount++ // we don't need accurate count, so no lock here
-	if logCount > maxLogCount && !setupLogWorking {
-		logCount = 0
-		setupLogWorking = true
-		go func() {
-			SetupLogger()
-		}()
-	}
 }
 
 func FatalLog(v ...any) {
+	if LogJsonFormat {
+		WriteJsonLog(gin.DefaultErrorWriter, "FATAL", fmt.Sprint(v...), map[string]interface{}{"source": "sys"})
+		os.Exit(1)
+	}
 	t := time.Now()
 	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[FATAL] %v | %v \n", t.Format("2006/01/02 - 15:04:05"), v)
 	os.Exit(1)
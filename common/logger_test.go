@@ -0,0 +1,131 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs 把日志输出重定向到缓冲区，并在测试结束后恢复日志配置
+func captureLogs(t *testing.T, jsonFormat bool, minLevel int, sampleRate float64) (*bytes.Buffer, *bytes.Buffer) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	writer, errorWriter := gin.DefaultWriter, gin.DefaultErrorWriter
+	format, level, rate := LogJsonFormat, logMinLevel, logInfoSampleRate
+	gin.DefaultWriter, gin.DefaultErrorWriter = out, errOut
+	LogJsonFormat, logMinLevel, logInfoSampleRate = jsonFormat, minLevel, sampleRate
+	t.Cleanup(func() {
+		gin.DefaultWriter, gin.DefaultErrorWriter = writer, errorWriter
+		LogJsonFormat, logMinLevel, logInfoSampleRate = format, level, rate
+	})
+	return out, errOut
+}
+
+func TestWriteJsonLog(t *testing.T) {
+	asserts := assert.New(t)
+	var buffer bytes.Buffer
+	WriteJsonLog(&buffer, loggerWarn, "slow upstream", map[string]interface{}{"channel_id": 3, "msg": "overridden"})
+	asserts.True(strings.HasSuffix(buffer.String(), "\n"))
+
+	var entry map[string]interface{}
+	asserts.NoError(json.Unmarshal(buffer.Bytes(), &entry))
+	asserts.Equal("warn", entry["level"])
+	asserts.Equal("slow upstream", entry["msg"])
+	asserts.EqualValues(3, entry["channel_id"])
+	asserts.NotEmpty(entry["time"])
+}
+
+func TestLogHelperJsonFields(t *testing.T) {
+	asserts := assert.New(t)
+	out, errOut := captureLogs(t, true, 0, 1)
+	ctx := context.WithValue(ContextWithLogFields(context.Background()), RequestIdKey, "req-1")
+	SetLogField(ctx, "user_id", 1)
+	SetLogField(ctx, "model", "gpt-4o")
+
+	// 请求日志带上请求 id 与请求字段，info 与 error 分别写入两个输出
+	LogInfo(ctx, "relay start")
+	LogError(ctx, "relay failed")
+	var info, failure map[string]interface{}
+	asserts.NoError(json.Unmarshal(out.Bytes(), &info))
+	asserts.NoError(json.Unmarshal(errOut.Bytes(), &failure))
+	asserts.Equal("info", info["level"])
+	asserts.Equal("relay start", info["msg"])
+	asserts.Equal("req-1", info["request_id"])
+	asserts.EqualValues(1, info["user_id"])
+	asserts.Equal("gpt-4o", info["model"])
+	asserts.Equal("error", failure["level"])
+	asserts.Equal("req-1", failure["request_id"])
+
+	// 系统日志标记来源
+	out.Reset()
+	SysLog("started")
+	var sys map[string]interface{}
+	asserts.NoError(json.Unmarshal(out.Bytes(), &sys))
+	asserts.Equal("sys", sys["source"])
+	asserts.Equal("started", sys["msg"])
+}
+
+func TestLogLevelThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		minLevel int
+		info     bool
+		warn     bool
+	}{
+		{0, true, true},
+		{1, false, true},
+		{2, false, false},
+	} {
+		out, errOut := captureLogs(t, false, tc.minLevel, 1)
+		ctx := context.Background()
+		LogInfo(ctx, "info message")
+		SysLog("sys message")
+		LogWarn(ctx, "warn message")
+		LogError(ctx, "error message")
+		SysError("sys error")
+		name := fmt.Sprintf("min level %d", tc.minLevel)
+		assert.Equal(t, tc.info, strings.Contains(out.String(), "info message"), name)
+		assert.Equal(t, tc.info, strings.Contains(out.String(), "sys message"), name)
+		assert.Equal(t, tc.warn, strings.Contains(errOut.String(), "warn message"), name)
+		// error 日志总是输出
+		assert.Contains(t, errOut.String(), "error message", name)
+		assert.Contains(t, errOut.String(), "sys error", name)
+	}
+}
+
+func TestLogSampled(t *testing.T) {
+	asserts := assert.New(t)
+	captureLogs(t, false, 0, 1)
+	requestCtx := func(id string) context.Context {
+		return context.WithValue(context.Background(), RequestIdKey, id)
+	}
+	asserts.True(logSampled(requestCtx("req-1")))
+
+	logInfoSampleRate = 0
+	asserts.False(logSampled(requestCtx("req-1")))
+	asserts.False(logSampled(context.Background()))
+
+	// 同一请求的采样结果稳定，整体比例接近采样率
+	logInfoSampleRate = 0.3
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		ctx := requestCtx(fmt.Sprintf("req-%d", i))
+		result := logSampled(ctx)
+		asserts.Equal(result, logSampled(ctx))
+		if result {
+			sampled++
+		}
+	}
+	asserts.InDelta(3000, sampled, 300)
+
+	// 未采样的请求不输出 info，但 warn 与 error 照常输出
+	out, errOut := captureLogs(t, false, 0, 0)
+	LogInfo(requestCtx("req-1"), "info message")
+	LogWarn(requestCtx("req-1"), "warn message")
+	asserts.Empty(out.String())
+	asserts.Contains(errOut.String(), "warn message")
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultLatencyBuckets 请求耗时的默认分桶（秒）
+var DefaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300}
+
+var (
+	MetricRelayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_relay_requests_total",
+		Help: "Relay requests sent to upstream channels.",
+	}, []string{"model", "channel", "group", "status"})
+	MetricRelayDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "new_api_relay_duration_seconds",
+		Help:    "Total latency of relay requests.",
+		Buckets: DefaultLatencyBuckets,
+	}, []string{"model", "channel", "group"})
+	MetricRelayFirstResponse = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "new_api_relay_first_response_seconds",
+		Help:    "Time to first streamed token of relay requests.",
+		Buckets: DefaultLatencyBuckets,
+	}, []string{"model", "channel"})
+	MetricRelayRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_relay_retries_total",
+		Help: "Relay retries on another channel.",
+	}, []string{"model", "group"})
+	MetricRelayStreamsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "new_api_relay_streams_in_flight",
+		Help: "Streaming responses currently being relayed.",
+	})
+	MetricChannelErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_channel_errors_total",
+		Help: "Relay errors by channel.",
+	}, []string{"channel", "status"})
+	MetricChannelAutoDisabled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_channel_auto_disabled_total",
+		Help: "Channels or channel keys disabled automatically.",
+	}, []string{"channel"})
+	MetricQuotaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_quota_consumed_total",
+		Help: "Quota consumed by relay requests.",
+	}, []string{"model", "group"})
+	MetricTaskPollerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_task_poller_runs_total",
+		Help: "Background task poller runs.",
+	}, []string{"task"})
+	MetricTaskPollerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "new_api_task_poller_duration_seconds",
+		Help:    "Duration of background task poller runs.",
+		Buckets: DefaultLatencyBuckets,
+	}, []string{"task"})
+	MetricRedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_redis_errors_total",
+		Help: "Redis command errors.",
+	}, []string{"command"})
+	MetricDBErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "new_api_db_errors_total",
+		Help: "Database errors.",
+	}, []string{"operation"})
+)
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// ModelFallback 模型回退链，key 为分组（"*" 对所有分组生效），value 为 模型 -> 依次尝试的回退模型
+var ModelFallback = map[string]map[string][]string{}
+
+func ModelFallback2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelFallback)
+	if err != nil {
+		SysError("error marshalling model fallback: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelFallbackByJSONString(jsonStr string) error {
+	ModelFallback = make(map[string]map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &ModelFallback)
+}
+
+func GetModelFallbacks(group string, model string) []string {
+	if fallbacks, ok := ModelFallback[group][model]; ok {
+		return fallbacks
+	}
+	return ModelFallback["*"][model]
+}
@@ -192,6 +192,12 @@ var (
 	modelRatioMapMutex                    = sync.RWMutex{}
 )
 
+// 提示缓存读取与写入的 token 相对输入 token 的计费倍率，默认参考 Anthropic 的定价
+var (
+	CacheReadRatio     = 0.1
+	CacheCreationRatio = 1.25
+)
+
 var CompletionRatio map[string]float64 = nil
 var defaultCompletionRatio = map[string]float64{
 	"gpt-4-gizmo-*": 2,
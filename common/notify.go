@@ -0,0 +1,278 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bytedance/gopkg/util/gopool"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 通知事件类型
+const (
+	NotifyEventChannelDisabled = "channel_disabled"
+	NotifyEventChannelEnabled  = "channel_enabled"
+	NotifyEventBalanceLow      = "channel_balance_low"
+	NotifyEventQuotaLow        = "user_quota_low"
+	NotifyEventTaskFailed      = "task_failed"
+	NotifyEventTopUpCompleted  = "topup_completed"
+	NotifyEventUserAlert       = "user_alert"
+)
+
+// 通知渠道类型
+const (
+	NotifySinkWebhook  = "webhook"
+	NotifySinkSlack    = "slack"
+	NotifySinkDiscord  = "discord"
+	NotifySinkFeishu   = "feishu"
+	NotifySinkDingTalk = "dingtalk"
+	NotifySinkTelegram = "telegram"
+)
+
+// NotificationSink 通知渠道配置，Events 为空时接收所有事件
+type NotificationSink struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	ChatId  string   `json:"chat_id"`
+	Events  []string `json:"events"`
+	Enabled bool     `json:"enabled"`
+}
+
+type NotifyEvent struct {
+	Type    string                 `json:"event"`
+	Subject string                 `json:"subject"`
+	Content string                 `json:"content"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	// DedupeKey 相同事件类型与 DedupeKey 的通知在去重窗口内只发送一次，为空时不去重
+	DedupeKey string `json:"-"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+var NotificationSinks []NotificationSink
+
+// NotificationDedupeSeconds 同一事件的去重窗口
+var NotificationDedupeSeconds = 600
+
+// ChannelBalanceLowThreshold 渠道余额（美元）低于该值时发送通知，0 表示不检查；
+// 只对以美元返回余额的渠道生效，其他渠道的余额单位不同（点数、人民币等），不做比较
+var ChannelBalanceLowThreshold = 0.0
+
+var notifyDedupe = make(map[string]int64)
+var notifyDedupeLock sync.Mutex
+var notifyHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+func NotificationSinks2JSONString() string {
+	jsonBytes, err := json.Marshal(NotificationSinks)
+	if err != nil {
+		SysError("error marshalling notification sinks: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateNotificationSinksByJSONString(jsonStr string) error {
+	var sinks []NotificationSink
+	if err := json.Unmarshal([]byte(jsonStr), &sinks); err != nil {
+		return err
+	}
+	NotificationSinks = sinks
+	return nil
+}
+
+func (sink *NotificationSink) accepts(eventType string) bool {
+	if !sink.Enabled {
+		return false
+	}
+	if len(sink.Events) == 0 {
+		return true
+	}
+	for _, e := range sink.Events {
+		if e == eventType || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Deduplicated 判断 key 是否在去重窗口内已出现过，多节点部署时通过 Redis 去重
+func Deduplicated(key string, window time.Duration) bool {
+	if RedisEnabled {
+		ok, err := RDB.SetNX(context.Background(), key, 1, window).Result()
+		if err == nil {
+			return !ok
+		}
+	}
+	now := time.Now().Unix()
+	notifyDedupeLock.Lock()
+	defer notifyDedupeLock.Unlock()
+	for k, expireAt := range notifyDedupe {
+		if expireAt <= now {
+			delete(notifyDedupe, k)
+		}
+	}
+	if expireAt, ok := notifyDedupe[key]; ok && expireAt > now {
+		return true
+	}
+	notifyDedupe[key] = now + int64(window.Seconds())
+	return false
+}
+
+// ClearDeduplicated 清除 key 的去重记录
+func ClearDeduplicated(key string) {
+	if RedisEnabled {
+		if err := RDB.Del(context.Background(), key).Err(); err != nil {
+			SysError("failed to clear dedupe key: " + err.Error())
+		}
+	}
+	notifyDedupeLock.Lock()
+	defer notifyDedupeLock.Unlock()
+	delete(notifyDedupe, key)
+}
+
+func notifyDedupeKey(eventType string, dedupeKey string) string {
+	return "notify:" + eventType + ":" + dedupeKey
+}
+
+func notifyDeduplicated(event *NotifyEvent) bool {
+	if event.DedupeKey == "" || NotificationDedupeSeconds <= 0 {
+		return false
+	}
+	return Deduplicated(notifyDedupeKey(event.Type, event.DedupeKey), time.Duration(NotificationDedupeSeconds)*time.Second)
+}
+
+// ClearNotifyDedupe 状态恢复后清除事件的去重记录，之后再次出现时立即通知，不必等去重窗口过期
+func ClearNotifyDedupe(eventType string, dedupeKey string) {
+	ClearDeduplicated(notifyDedupeKey(eventType, dedupeKey))
+}
+
+// Notify 将事件异步发送到所有订阅了该事件的通知渠道
+func Notify(event NotifyEvent) {
+	sinks := make([]NotificationSink, 0)
+	for _, sink := range NotificationSinks {
+		if sink.accepts(event.Type) {
+			sinks = append(sinks, sink)
+		}
+	}
+	if len(sinks) == 0 || notifyDeduplicated(&event) {
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	for _, sink := range sinks {
+		sink := sink
+		gopool.Go(func() {
+			if err := SendNotification(sink, event); err != nil {
+				SysError(fmt.Sprintf("failed to send %s notification to %s: %s", event.Type, sink.Name, err.Error()))
+			}
+		})
+	}
+}
+
+func hmacSha256(secret string, data string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// SendNotification 按渠道类型组装消息并发送
+func SendNotification(sink NotificationSink, event NotifyEvent) error {
+	return sendNotification(notifyHttpClient, sink, event)
+}
+
+// SendUserNotification 发送到用户自行填写的地址，只允许访问公网且不跟随重定向
+func SendUserNotification(sink NotificationSink, event NotifyEvent) error {
+	return sendNotification(PublicHttpClient, sink, event)
+}
+
+func sendNotification(client *http.Client, sink NotificationSink, event NotifyEvent) error {
+	text := event.Subject
+	if event.Content != "" && event.Content != event.Subject {
+		text += "\n" + event.Content
+	}
+	requestUrl := sink.URL
+	headers := map[string]string{}
+	var payload interface{}
+	switch sink.Type {
+	case NotifySinkWebhook:
+		payload = event
+	case NotifySinkSlack:
+		payload = map[string]interface{}{"text": text}
+	case NotifySinkDiscord:
+		payload = map[string]interface{}{"content": text}
+	case NotifySinkFeishu:
+		message := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if sink.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			message["timestamp"] = timestamp
+			message["sign"] = base64.StdEncoding.EncodeToString(hmacSha256(timestamp+"\n"+sink.Secret, ""))
+		}
+		payload = message
+	case NotifySinkDingTalk:
+		payload = map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}
+		if sink.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			sign := base64.StdEncoding.EncodeToString(hmacSha256(sink.Secret, timestamp+"\n"+sink.Secret))
+			parsed, err := url.Parse(sink.URL)
+			if err != nil {
+				return err
+			}
+			query := parsed.Query()
+			query.Set("timestamp", timestamp)
+			query.Set("sign", sign)
+			parsed.RawQuery = query.Encode()
+			requestUrl = parsed.String()
+		}
+	case NotifySinkTelegram:
+		if TelegramBotToken == "" {
+			return fmt.Errorf("telegram bot token is not configured")
+		}
+		requestUrl = fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", TelegramBotToken)
+		payload = map[string]interface{}{"chat_id": sink.ChatId, "text": text}
+	default:
+		return fmt.Errorf("unknown notification sink type: %s", sink.Type)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if sink.Type == NotifySinkWebhook && sink.Secret != "" {
+		// 签名为 hex(hmac_sha256(secret, 时间戳 + "." + 请求体))
+		timestamp := strconv.FormatInt(event.Timestamp, 10)
+		headers["X-Notify-Timestamp"] = timestamp
+		headers["X-Notify-Signature"] = "sha256=" + hex.EncodeToString(hmacSha256(sink.Secret, timestamp+"."+string(body)))
+	}
+	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notification endpoint returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func disableRedis(t *testing.T) {
+	redisEnabled := RedisEnabled
+	RedisEnabled = false
+	t.Cleanup(func() {
+		RedisEnabled = redisEnabled
+	})
+}
+
+func TestNotifyDedupeClearedOnRecovery(t *testing.T) {
+	disableRedis(t)
+	asserts := assert.New(t)
+	event := NotifyEvent{Type: NotifyEventChannelDisabled, DedupeKey: "1"}
+	asserts.False(notifyDeduplicated(&event))
+	asserts.True(notifyDeduplicated(&event))
+	// 渠道重新启用后清除去重记录，再次禁用时立即通知
+	ClearNotifyDedupe(NotifyEventChannelDisabled, "1")
+	asserts.False(notifyDeduplicated(&event))
+}
+
+func TestNotifyDedupeRedis(t *testing.T) {
+	asserts := assert.New(t)
+	server := miniredis.RunT(t)
+	redisEnabled, rdb := RedisEnabled, RDB
+	RedisEnabled = true
+	RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		RedisEnabled, RDB = redisEnabled, rdb
+	})
+
+	asserts.False(Deduplicated("notify:test:1", time.Minute))
+	asserts.True(Deduplicated("notify:test:1", time.Minute))
+	ClearNotifyDedupe("test", "1")
+	asserts.False(Deduplicated("notify:test:1", time.Minute))
+}
+
+func TestSendWebhookNotification(t *testing.T) {
+	asserts := assert.New(t)
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	sink := NotificationSink{Type: NotifySinkWebhook, URL: server.URL, Secret: "secret", Enabled: true}
+	event := NotifyEvent{Type: NotifyEventChannelDisabled, Subject: "subject", Timestamp: 1700000000}
+	asserts.NoError(SendNotification(sink, event))
+
+	var received NotifyEvent
+	asserts.NoError(json.Unmarshal(body, &received))
+	asserts.Equal(NotifyEventChannelDisabled, received.Type)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	asserts.Equal("sha256="+hex.EncodeToString(mac.Sum(nil)), header.Get("X-Notify-Signature"))
+}
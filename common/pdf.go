@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"sync"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDFFontPath 账单等 PDF 使用的 TrueType 字体文件，需要覆盖中日韩字符时配置（如文泉驿微米黑），
+// 未配置或读取失败时使用内置的 Helvetica，只支持西文字符
+var PDFFontPath = os.Getenv("PDF_FONT_PATH")
+
+const (
+	pdfFontFamily         = "pdf-font"
+	pdfFallbackFontFamily = "Helvetica"
+	pdfMargin             = 50.0
+)
+
+var pdfFont []byte
+var pdfFontOnce sync.Once
+
+// loadPDFFont 读取配置的字体文件，只读取一次
+func loadPDFFont() []byte {
+	pdfFontOnce.Do(func() {
+		if PDFFontPath == "" {
+			return
+		}
+		font, err := os.ReadFile(PDFFontPath)
+		if err != nil {
+			SysError("failed to load pdf font, falling back to Helvetica: " + err.Error())
+			return
+		}
+		pdfFont = font
+	})
+	return pdfFont
+}
+
+// PDFDocument 生成只包含文字与表格的简单 PDF（A4 纵向），用于账单等导出，超出页面时自动分页
+type PDFDocument struct {
+	pdf    *fpdf.Fpdf
+	family string
+	// translate 将文字转换为当前字体的编码，内置字体只支持 cp1252，其余字符显示为 "."
+	translate func(string) string
+}
+
+func NewPDFDocument() *PDFDocument {
+	pdf := fpdf.New("P", "pt", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	doc := &PDFDocument{pdf: pdf, family: pdfFallbackFontFamily, translate: pdf.UnicodeTranslatorFromDescriptor("")}
+	if font := loadPDFFont(); font != nil {
+		// 字体没有粗体字形时粗体复用同一字体
+		pdf.AddUTF8FontFromBytes(pdfFontFamily, "", font)
+		pdf.AddUTF8FontFromBytes(pdfFontFamily, "B", font)
+		doc.family = pdfFontFamily
+		doc.translate = func(text string) string {
+			return text
+		}
+	}
+	doc.AddPage()
+	return doc
+}
+
+func (doc *PDFDocument) AddPage() {
+	doc.pdf.AddPage()
+}
+
+func (doc *PDFDocument) setFont(size float64, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	doc.pdf.SetFont(doc.family, style, size)
+}
+
+func (doc *PDFDocument) contentWidth() float64 {
+	pageWidth, _ := doc.pdf.GetPageSize()
+	left, _, right, _ := doc.pdf.GetMargins()
+	return pageWidth - left - right
+}
+
+// truncate 按实际字宽截断文字，避免列之间重叠，返回转换为当前字体编码后的文字
+func (doc *PDFDocument) truncate(text string, width float64) string {
+	if translated := doc.translate(text); doc.pdf.GetStringWidth(translated) <= width {
+		return translated
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && doc.pdf.GetStringWidth(doc.translate(string(runes)+"...")) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return doc.translate(string(runes) + "...")
+}
+
+// AddText 在当前位置写入文字，超出页面宽度时自动换行
+func (doc *PDFDocument) AddText(text string, size float64, bold bool) {
+	doc.setFont(size, bold)
+	doc.pdf.MultiCell(0, size*1.5, doc.translate(text), "", "L", false)
+}
+
+// AddRow 写入一行表格，widths 为各列占可用宽度的比例
+func (doc *PDFDocument) AddRow(columns []string, widths []float64, size float64, bold bool) {
+	doc.setFont(size, bold)
+	lineHeight := size * 1.6
+	contentWidth := doc.contentWidth()
+	for i, column := range columns {
+		width := contentWidth / float64(len(columns))
+		if i < len(widths) {
+			width = contentWidth * widths[i]
+		}
+		// 留出列间距
+		doc.pdf.CellFormat(width, lineHeight, doc.truncate(column, width-size/2), "", 0, "L", false, 0, "")
+	}
+	doc.pdf.Ln(lineHeight)
+}
+
+// AddLine 写入一条横线
+func (doc *PDFDocument) AddLine() {
+	left, _, _, _ := doc.pdf.GetMargins()
+	y := doc.pdf.GetY() + 3
+	doc.pdf.SetLineWidth(0.5)
+	doc.pdf.Line(left, y, left+doc.contentWidth(), y)
+	doc.pdf.SetY(y + 3)
+}
+
+func (doc *PDFDocument) AddSpace(height float64) {
+	doc.pdf.Ln(height)
+}
+
+func (doc *PDFDocument) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := doc.pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
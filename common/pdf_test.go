@@ -0,0 +1,99 @@
+package common
+
+import (
+	"bytes"
+	"go/build"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setPDFFontPath 切换测试使用的字体文件，重新读取字体
+func setPDFFontPath(t *testing.T, path string) {
+	fontPath := PDFFontPath
+	PDFFontPath, pdfFont, pdfFontOnce = path, nil, sync.Once{}
+	t.Cleanup(func() {
+		PDFFontPath, pdfFont, pdfFontOnce = fontPath, nil, sync.Once{}
+	})
+}
+
+// testPDFFontPath 使用 fpdf 自带的 DejaVu 字体，找不到时跳过测试
+func testPDFFontPath(t *testing.T) string {
+	pkg, err := build.Import("github.com/go-pdf/fpdf", "", build.FindOnly)
+	if err != nil {
+		t.Skip("fpdf source not found: " + err.Error())
+	}
+	return filepath.Join(pkg.Dir, "font", "DejaVuSansCondensed.ttf")
+}
+
+func TestPDFDocument(t *testing.T) {
+	asserts := assert.New(t)
+	setPDFFontPath(t, testPDFFontPath(t))
+	doc := NewPDFDocument()
+	doc.AddText("账单 INVOICE", 18, true)
+	doc.AddText("Ünïcödé address", 10, false)
+	doc.AddLine()
+	doc.AddRow([]string{"说明", "额度", "金额"}, []float64{0.55, 0.2, 0.25}, 10, true)
+	doc.AddRow([]string{strings.Repeat("很长的描述", 50), "$1.00", "7.20 CNY"}, []float64{0.55, 0.2, 0.25}, 10, false)
+	asserts.Equal(1, doc.pdf.PageCount())
+
+	data, err := doc.Bytes()
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.True(bytes.HasPrefix(data, []byte("%PDF-")))
+	// 配置字体后嵌入字体子集，而不是使用只支持西文的内置字体
+	asserts.Contains(string(data), "/FontFile2")
+	asserts.NotContains(string(data), "/Helvetica")
+}
+
+func TestPDFDocumentFallbackFont(t *testing.T) {
+	asserts := assert.New(t)
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "missing.ttf")} {
+		setPDFFontPath(t, path)
+		doc := NewPDFDocument()
+		doc.AddText("Café 账单", 10, false)
+		doc.AddRow([]string{"Model", "说明"}, nil, 9, false)
+		data, err := doc.Bytes()
+		if !asserts.NoError(err) {
+			return
+		}
+		// 未配置或读取失败时使用内置字体，不在 cp1252 中的字符显示为 "."
+		asserts.Contains(string(data), "/Helvetica")
+		asserts.NotContains(string(data), "/FontFile2")
+		asserts.Equal("Caf\xe9 ..", doc.translate("Café 账单"))
+	}
+}
+
+func TestPDFDocumentPageBreak(t *testing.T) {
+	asserts := assert.New(t)
+	doc := NewPDFDocument()
+	for i := 0; i < 100; i++ {
+		doc.AddRow([]string{"model", "token", "1"}, nil, 9, false)
+	}
+	asserts.Greater(doc.pdf.PageCount(), 1)
+	_, err := doc.Bytes()
+	asserts.NoError(err)
+}
+
+func TestPDFDocumentTruncate(t *testing.T) {
+	asserts := assert.New(t)
+	setPDFFontPath(t, testPDFFontPath(t))
+	doc := NewPDFDocument()
+	doc.setFont(10, false)
+	asserts.Equal("short", doc.truncate("short", 100))
+	truncated := doc.truncate(strings.Repeat("Ünïcödé", 50), 100)
+	asserts.True(strings.HasSuffix(truncated, "..."))
+	asserts.LessOrEqual(doc.pdf.GetStringWidth(truncated), 100.0)
+
+	// 内置字体按转换后的编码计算字宽
+	setPDFFontPath(t, "")
+	doc = NewPDFDocument()
+	doc.setFont(10, false)
+	truncated = doc.truncate(strings.Repeat("Café", 50), 100)
+	asserts.True(strings.HasSuffix(truncated, "..."))
+	asserts.LessOrEqual(doc.pdf.GetStringWidth(truncated), 100.0)
+}
@@ -68,3 +68,113 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Usage returns the number of requests of key within duration and the unix time of the oldest one
+func (l *InMemoryRateLimiter) Usage(key string, duration int64) (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		return 0, 0
+	}
+	now := time.Now().Unix()
+	count := 0
+	var oldest int64
+	for _, t := range *queue {
+		if now-t < duration {
+			if count == 0 {
+				oldest = t
+			}
+			count++
+		}
+	}
+	return count, oldest
+}
+
+type usageRecord struct {
+	id     string
+	time   int64
+	amount int
+}
+
+// InMemoryUsageCounter 滑动窗口内的用量累计，用于按 token 数限流
+type InMemoryUsageCounter struct {
+	store  map[string][]usageRecord
+	mutex  sync.Mutex
+	window int64
+}
+
+func (l *InMemoryUsageCounter) Init(window int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.store == nil {
+		l.store = make(map[string][]usageRecord)
+		l.window = window
+	}
+}
+
+func (l *InMemoryUsageCounter) prune(key string, now int64) []usageRecord {
+	records := l.store[key]
+	i := 0
+	for i < len(records) && now-records[i].time >= l.window {
+		i++
+	}
+	records = records[i:]
+	if len(records) == 0 {
+		delete(l.store, key)
+	} else {
+		l.store[key] = records
+	}
+	return records
+}
+
+// Add 记录一条用量，id 用于之后通过 Update 修正这条记录
+func (l *InMemoryUsageCounter) Add(key string, id string, amount int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now().Unix()
+	l.prune(key, now)
+	l.store[key] = append(l.store[key], usageRecord{id: id, time: now, amount: amount})
+}
+
+// Update 修正 id 对应记录的用量，amount 不大于 0 时删除该记录；记录已过期时按新记录添加
+func (l *InMemoryUsageCounter) Update(key string, id string, amount int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now().Unix()
+	records := l.prune(key, now)
+	for i := range records {
+		if records[i].id != id {
+			continue
+		}
+		if amount > 0 {
+			records[i].amount = amount
+		} else {
+			records = append(records[:i], records[i+1:]...)
+			if len(records) == 0 {
+				delete(l.store, key)
+			} else {
+				l.store[key] = records
+			}
+		}
+		return
+	}
+	if amount > 0 {
+		l.store[key] = append(records, usageRecord{id: id, time: now, amount: amount})
+	}
+}
+
+// Sum returns the total amount of key within the window and the unix time of the oldest record
+func (l *InMemoryUsageCounter) Sum(key string) (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	records := l.prune(key, time.Now().Unix())
+	total := 0
+	for _, record := range records {
+		total += record.amount
+	}
+	if len(records) == 0 {
+		return 0, 0
+	}
+	return total, records[0].time
+}
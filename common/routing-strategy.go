@@ -0,0 +1,42 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+const (
+	RoutingStrategyWeightedRandom = "weighted_random"
+	RoutingStrategyLeastLatency   = "least_latency"
+	RoutingStrategyCheapest       = "cheapest"
+	RoutingStrategyLeastInFlight  = "least_in_flight"
+	RoutingStrategySticky         = "sticky"
+)
+
+// RoutingStrategy 同一优先级内的渠道选择策略，key 为 "分组/模型"、"分组" 或 "*"，未配置时按权重随机
+var RoutingStrategy = map[string]string{}
+
+func RoutingStrategy2JSONString() string {
+	jsonBytes, err := json.Marshal(RoutingStrategy)
+	if err != nil {
+		SysError("error marshalling routing strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRoutingStrategyByJSONString(jsonStr string) error {
+	RoutingStrategy = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &RoutingStrategy)
+}
+
+func GetRoutingStrategy(group string, model string) string {
+	if strategy, ok := RoutingStrategy[group+"/"+model]; ok {
+		return strategy
+	}
+	if strategy, ok := RoutingStrategy[group]; ok {
+		return strategy
+	}
+	if strategy, ok := RoutingStrategy["*"]; ok {
+		return strategy
+	}
+	return RoutingStrategyWeightedRandom
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// 用户填写的回调地址（如提醒 Webhook）只允许访问公网，防止借服务端请求内网服务
+
+var errNonPublicAddress = errors.New("address is not a public address")
+
+// nonPublicPrefixes 不属于公网的地址段，参考 IANA IPv4/IPv6 Special-Purpose Address Registry
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // 本网络
+	netip.MustParsePrefix("10.0.0.0/8"),      // 私有
+	netip.MustParsePrefix("100.64.0.0/10"),   // 运营商级 NAT，含阿里云元数据服务 100.100.100.200
+	netip.MustParsePrefix("127.0.0.0/8"),     // 回环
+	netip.MustParsePrefix("169.254.0.0/16"),  // 链路本地，含云厂商元数据服务 169.254.169.254
+	netip.MustParsePrefix("172.16.0.0/12"),   // 私有
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF 协议分配
+	netip.MustParsePrefix("192.0.2.0/24"),    // 文档
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 中继
+	netip.MustParsePrefix("192.168.0.0/16"),  // 私有
+	netip.MustParsePrefix("198.18.0.0/15"),   // 基准测试
+	netip.MustParsePrefix("198.51.100.0/24"), // 文档
+	netip.MustParsePrefix("203.0.113.0/24"),  // 文档
+	netip.MustParsePrefix("224.0.0.0/4"),     // 组播
+	netip.MustParsePrefix("240.0.0.0/4"),     // 保留及广播
+	netip.MustParsePrefix("::/128"),          // 未指定
+	netip.MustParsePrefix("::1/128"),         // 回环
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64，可映射到任意 IPv4 地址
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // 本地 NAT64
+	netip.MustParsePrefix("100::/64"),        // 丢弃
+	netip.MustParsePrefix("2001::/23"),       // IETF 协议分配，含 Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // 文档
+	netip.MustParsePrefix("2002::/16"),       // 6to4，可映射到任意 IPv4 地址
+	netip.MustParsePrefix("fc00::/7"),        // 唯一本地
+	netip.MustParsePrefix("fe80::/10"),       // 链路本地
+	netip.MustParsePrefix("fec0::/10"),       // 站点本地（已废弃）
+	netip.MustParsePrefix("ff00::/8"),        // 组播
+}
+
+// IsPublicIP 判断 IP 是否为公网地址，IPv4 映射的 IPv6 地址按 IPv4 判断
+func IsPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidatePublicURL 校验地址为 http/https，且域名解析出的所有地址都是公网地址
+func ValidatePublicURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return errors.New("host is empty")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicIP(ip) {
+			return errNonPublicAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address found for %s", host)
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return errNonPublicAddress
+		}
+	}
+	return nil
+}
+
+// publicDialControl 在建立连接时再次检查目标地址，防止校验后 DNS 记录被改为内网地址
+func publicDialControl(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("dial %s: %w", address, errNonPublicAddress)
+	}
+	return nil
+}
+
+// PublicHttpClient 只能访问公网地址的客户端，不使用代理，不跟随重定向
+var PublicHttpClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: publicDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2001:4860:4860::8888", true},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"10.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.100.100.200", false},
+		{"100.127.255.255", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.31.255.255", false},
+		{"192.0.0.8", false},
+		{"192.168.0.1", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.100.100.200", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"64:ff9b:1::1", false},
+		{"2001::1", false},
+		{"2002:7f00:1::", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"fe80::1", false},
+		{"fec0::1", false},
+		{"ff02::1", false},
+	} {
+		assert.Equal(t, tc.public, IsPublicIP(net.ParseIP(tc.ip)), tc.ip)
+	}
+	assert.False(t, IsPublicIP(nil))
+}
+
+func TestValidatePublicURL(t *testing.T) {
+	asserts := assert.New(t)
+	ctx := context.Background()
+	for _, rawURL := range []string{
+		"http://127.0.0.1:3000/api",
+		"http://localhost/api",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/",
+		"http://172.16.0.1/",
+		"http://192.168.1.1/",
+		"http://[fd00::1]/",
+		"http://0.0.0.0/",
+		"http://100.100.100.200/latest/meta-data",
+		"http://198.18.0.1/",
+		"http://[64:ff9b::7f00:1]/",
+		"http://[fec0::1]/",
+		"ftp://8.8.8.8/",
+		"http:///path",
+	} {
+		asserts.Error(ValidatePublicURL(ctx, rawURL), rawURL)
+	}
+	asserts.NoError(ValidatePublicURL(ctx, "https://8.8.8.8/webhook"))
+	asserts.NoError(ValidatePublicURL(ctx, "http://[2001:4860:4860::8888]/webhook"))
+}
+
+func TestPublicHttpClient(t *testing.T) {
+	asserts := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// 建立连接时拒绝内网地址，即使校验时解析到的是公网地址
+	_, err := PublicHttpClient.Get(server.URL)
+	asserts.ErrorIs(err, errNonPublicAddress)
+	for _, address := range []string{"8.8.8.8:443", "[2001:4860:4860::8888]:443"} {
+		asserts.NoError(publicDialControl("tcp", address, nil), address)
+	}
+	for _, address := range []string{
+		net.JoinHostPort("::ffff:127.0.0.1", "80"),
+		"100.100.100.200:80",
+		"0.1.2.3:80",
+		"198.18.0.1:80",
+		"[64:ff9b::a9fe:a9fe]:80",
+		"[fd00::1]:80",
+		"[fec0::1]:80",
+		"localhost:80",
+	} {
+		asserts.ErrorIs(publicDialControl("tcp", address, nil), errNonPublicAddress, address)
+	}
+
+	// 不跟随重定向
+	asserts.Equal(http.ErrUseLastResponse, PublicHttpClient.CheckRedirect(nil, nil))
+}
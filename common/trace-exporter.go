@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+var TracingEnabled = false
+var TraceSampleRatio = 1.0
+
+// InitTracing 根据环境变量初始化链路追踪，变量名与 OpenTelemetry SDK 保持一致：
+// OTEL_TRACES_EXPORTER 为 otlp 或 console 时启用，默认 none；
+// OTLP/HTTP 导出器自行读取 OTEL_EXPORTER_OTLP_ENDPOINT、OTEL_EXPORTER_OTLP_TRACES_ENDPOINT、OTEL_EXPORTER_OTLP_HEADERS 等变量；
+// OTEL_TRACES_SAMPLER_ARG 为采样率；OTEL_SERVICE_NAME 为服务名
+func InitTracing() {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(GetEnvOrDefaultString("OTEL_TRACES_EXPORTER", "none")) {
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "console":
+		exporter, err = stdouttrace.New()
+	default:
+		return
+	}
+	if err != nil {
+		SysError("failed to create trace exporter: " + err.Error())
+		return
+	}
+	TraceSampleRatio = GetEnvOrDefaultFloat("OTEL_TRACES_SAMPLER_ARG", 1)
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceNameKey.String(GetEnvOrDefaultString("OTEL_SERVICE_NAME", "new-api")),
+		semconv.ServiceVersionKey.String(Version),
+	))
+	if err != nil {
+		SysError("failed to create trace resource: " + err.Error())
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// 调用方带有 traceparent 时沿用其采样结果，否则按 trace id 采样
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	TracingEnabled = true
+	SysLog(fmt.Sprintf("tracing enabled, sample ratio %.2f", TraceSampleRatio))
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 链路追踪基于 OpenTelemetry SDK，这里只做一层薄封装，方便在 gin 的请求 context 上挂载 span
+
+const tracerName = "one-api"
+
+const (
+	SpanKindInternal = trace.SpanKindInternal
+	SpanKindServer   = trace.SpanKindServer
+	SpanKindClient   = trace.SpanKindClient
+)
+
+type Span struct {
+	span trace.Span
+
+	lock    sync.Mutex
+	ended   bool
+	failed  bool
+	ginCtx  *gin.Context
+	restore context.Context
+}
+
+func spanAttribute(key string, value interface{}) attribute.KeyValue {
+	switch value := value.(type) {
+	case bool:
+		return attribute.Bool(key, value)
+	case int:
+		return attribute.Int(key, value)
+	case int64:
+		return attribute.Int64(key, value)
+	case float64:
+		return attribute.Float64(key, value)
+	case string:
+		return attribute.String(key, value)
+	default:
+		return attribute.String(key, fmt.Sprint(value))
+	}
+}
+
+// SetAttribute 设置 span 属性，span 为 nil 时不做任何操作
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(spanAttribute(key, value))
+}
+
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.failed = true
+	s.lock.Unlock()
+	s.span.SetStatus(codes.Error, message)
+}
+
+// End 结束 span，可重复调用，只有第一次生效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	failed := s.failed
+	s.lock.Unlock()
+	if s.ginCtx != nil && s.ginCtx.IsAborted() && !failed {
+		s.span.SetStatus(codes.Error, fmt.Sprintf("aborted with status %d", s.ginCtx.Writer.Status()))
+	}
+	s.span.End()
+	if s.ginCtx != nil {
+		s.ginCtx.Request = s.ginCtx.Request.WithContext(s.restore)
+	}
+}
+
+func requestContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		return c.Request.Context()
+	}
+	return ctx
+}
+
+// StartSpan 以 ctx 中的 span 为父节点开始新的 span，未启用追踪时返回 nil
+func StartSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, *Span) {
+	if !TracingEnabled {
+		return ctx, nil
+	}
+	// gin.Context 默认不会从请求的 context 中取值，需要显式带上父 span
+	if reqCtx := requestContext(ctx); reqCtx != ctx {
+		ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(reqCtx))
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind))
+	if requestId, ok := requestContext(ctx).Value(RequestIdKey).(string); ok && requestId != "" {
+		span.SetAttributes(attribute.String("request.id", requestId))
+	}
+	return ctx, &Span{span: span}
+}
+
+// StartGinSpan 在请求的 context 上开始 span，后续的 span 都作为其子节点，span 结束时恢复原来的 context
+func StartGinSpan(c *gin.Context, name string, kind trace.SpanKind) *Span {
+	if !TracingEnabled {
+		return nil
+	}
+	parentCtx := c.Request.Context()
+	ctx, span := StartSpan(parentCtx, name, kind)
+	c.Request = c.Request.WithContext(ctx)
+	span.ginCtx = c
+	span.restore = parentCtx
+	return span
+}
+
+// ExtractTraceContext 从请求头（traceparent 等）中取出调用方的链路
+func ExtractTraceContext(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectTraceContext 把 ctx 中的链路写入请求头，传递给上游
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(requestContext(ctx), propagation.HeaderCarrier(header))
+}
@@ -15,3 +15,38 @@ var GetMediaToken = common.GetEnvOrDefaultBool("GET_MEDIA_TOKEN", true)
 var GetMediaTokenNotStream = common.GetEnvOrDefaultBool("GET_MEDIA_TOKEN_NOT_STREAM", true)
 
 var UpdateTask = common.GetEnvOrDefaultBool("UPDATE_TASK", true)
+
+// MaxFileSize /v1/files 单个文件大小上限，单位 MB
+var MaxFileSize = common.GetEnvOrDefault("MAX_FILE_SIZE", 100)
+
+// ChannelKeyCooldownSeconds 多密钥渠道中密钥被上游限流(429)后的冷却时间
+var ChannelKeyCooldownSeconds = common.GetEnvOrDefault("CHANNEL_KEY_COOLDOWN_SECONDS", 60)
+
+// 熔断器：按渠道+模型统计真实请求的错误率，超过阈值后在一段时间内不再选择该渠道
+var CircuitBreakerEnabled = common.GetEnvOrDefaultBool("CIRCUIT_BREAKER_ENABLED", false)
+var CircuitBreakerRedisEnabled = common.GetEnvOrDefaultBool("CIRCUIT_BREAKER_REDIS_ENABLED", true)
+var CircuitBreakerWindowSeconds = common.GetEnvOrDefault("CIRCUIT_BREAKER_WINDOW_SECONDS", 60)
+var CircuitBreakerMinRequests = common.GetEnvOrDefault("CIRCUIT_BREAKER_MIN_REQUESTS", 10)
+var CircuitBreakerErrorRate = float64(common.GetEnvOrDefault("CIRCUIT_BREAKER_ERROR_PERCENT", 50)) / 100
+var CircuitBreakerOpenSeconds = common.GetEnvOrDefault("CIRCUIT_BREAKER_OPEN_SECONDS", 30)
+
+// CircuitBreakerSlowSeconds 超过该耗时的请求视为慢请求并计入错误率，0 为不统计
+var CircuitBreakerSlowSeconds = common.GetEnvOrDefault("CIRCUIT_BREAKER_SLOW_SECONDS", 0)
+
+// ChannelQueueSize 渠道并发已满时每个渠道最多排队的请求数
+var ChannelQueueSize = common.GetEnvOrDefault("CHANNEL_QUEUE_SIZE", 100)
+
+// ChannelQueueTimeoutSeconds 请求在渠道队列中的最长等待时间
+var ChannelQueueTimeoutSeconds = common.GetEnvOrDefault("CHANNEL_QUEUE_TIMEOUT_SECONDS", 30)
+
+// ResponseCacheMemoryEntries 未启用 Redis 时内存中最多缓存的响应数
+var ResponseCacheMemoryEntries = common.GetEnvOrDefault("RESPONSE_CACHE_MEMORY_ENTRIES", 1000)
+
+// MetricsEnabled 是否开放 /metrics 指标接口，默认关闭
+var MetricsEnabled = common.GetEnvOrDefaultBool("METRICS_ENABLED", false)
+
+// MetricsToken 访问 /metrics 所需的 Bearer Token，为空时不校验
+var MetricsToken = common.GetEnvOrDefaultString("METRICS_TOKEN", "")
+
+// PayloadCaptureEncryptionKey 请求与响应内容落库时的加密密钥，为空时明文保存
+var PayloadCaptureEncryptionKey = common.GetEnvOrDefaultString("PAYLOAD_CAPTURE_ENCRYPTION_KEY", "")
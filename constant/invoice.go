@@ -0,0 +1,10 @@
+package constant
+
+// 账单与收据中展示的公司信息
+var InvoiceCompanyName = ""
+var InvoiceCompanyAddress = ""
+var InvoiceCompanyTaxId = ""
+var InvoiceCompanyEmail = ""
+
+// InvoiceTaxRate 税率（百分比），账单金额视为含税价
+var InvoiceTaxRate = 0.0
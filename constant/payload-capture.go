@@ -0,0 +1,63 @@
+package constant
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// PayloadCaptureGroups 需要保存完整请求与响应内容的用户分组，令牌也可以单独开启
+var PayloadCaptureGroups = map[string]bool{}
+
+// PayloadCaptureRedactPatterns 保存前用于脱敏的正则，匹配内容替换为 [REDACTED]
+var PayloadCaptureRedactPatterns = []string{`sk-[A-Za-z0-9_\-]{20,}`}
+var PayloadCaptureRedactRegexps = []*regexp.Regexp{regexp.MustCompile(`sk-[A-Za-z0-9_\-]{20,}`)}
+
+// PayloadCaptureMaxBytes 每项保存内容的大小上限，超出部分截断
+var PayloadCaptureMaxBytes = 64 << 10
+
+// PayloadCaptureRetentionDays 保存内容的保留天数，0 为永久保留
+var PayloadCaptureRetentionDays = 30
+
+func PayloadCaptureGroups2JSONString() string {
+	groups := make([]string, 0, len(PayloadCaptureGroups))
+	for group := range PayloadCaptureGroups {
+		groups = append(groups, group)
+	}
+	jsonBytes, _ := json.Marshal(groups)
+	return string(jsonBytes)
+}
+
+func UpdatePayloadCaptureGroupsByJSONString(jsonStr string) error {
+	var groups []string
+	if err := json.Unmarshal([]byte(jsonStr), &groups); err != nil {
+		return err
+	}
+	PayloadCaptureGroups = make(map[string]bool, len(groups))
+	for _, group := range groups {
+		PayloadCaptureGroups[group] = true
+	}
+	return nil
+}
+
+func PayloadCaptureRedactPatterns2JSONString() string {
+	jsonBytes, _ := json.Marshal(PayloadCaptureRedactPatterns)
+	return string(jsonBytes)
+}
+
+func UpdatePayloadCaptureRedactPatternsByJSONString(jsonStr string) error {
+	var patterns []string
+	if err := json.Unmarshal([]byte(jsonStr), &patterns); err != nil {
+		return err
+	}
+	regexps := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		regexps = append(regexps, re)
+	}
+	PayloadCaptureRedactPatterns = patterns
+	PayloadCaptureRedactRegexps = regexps
+	return nil
+}
@@ -6,3 +6,12 @@ var EpayId = ""
 var EpayKey = ""
 var Price = 7.3
 var MinTopUp = 1
+
+// Stripe Checkout 配置，StripePrice 为每 1 美元额度对应的 StripeCurrency 价格
+var StripeApiSecret = ""
+var StripeWebhookSecret = ""
+var StripeCurrency = "usd"
+var StripePrice = 1.0
+
+// StripeApiAddress 可改为本地模拟服务地址用于测试
+var StripeApiAddress = "https://api.stripe.com"
@@ -0,0 +1,25 @@
+package constant
+
+// ResponseCacheEnabled 是否缓存完全相同请求的响应，命中时不再请求上游
+var ResponseCacheEnabled = false
+
+// ResponseCacheTTL 响应缓存的有效期（秒），0 为不缓存
+var ResponseCacheTTL = 3600
+
+// ResponseCacheQuotaRatio 命中缓存时按正常额度的该比例计费
+var ResponseCacheQuotaRatio = 0.1
+
+// ResponseCacheMaxBytes 单个响应超过该大小时不缓存
+var ResponseCacheMaxBytes = 1 << 20
+
+// SemanticCacheEnabled 精确缓存未命中时按对话文本的向量相似度查找缓存
+var SemanticCacheEnabled = false
+
+// SemanticCacheThreshold 余弦相似度达到该值时视为命中
+var SemanticCacheThreshold = 0.95
+
+// SemanticCacheEmbeddingModel 计算对话文本向量使用的 embeddings 模型，由系统调用，不向用户计费
+var SemanticCacheEmbeddingModel = "text-embedding-3-small"
+
+// SemanticCacheChannelId 计算向量使用的渠道，0 为从 default 分组中选择支持该模型的渠道
+var SemanticCacheChannelId = 0
@@ -0,0 +1,506 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"strconv"
+	"time"
+)
+
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+type CreateBatchRequest struct {
+	InputFileId      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+type batchRequestLine struct {
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+func nullableTimestamp(timestamp int64) any {
+	if timestamp == 0 {
+		return nil
+	}
+	return timestamp
+}
+
+func batchObject(batch *model.Batch) gin.H {
+	var batchErrors any
+	if batch.Errors != "" {
+		batchErrors = gin.H{
+			"object": "list",
+			"data": []gin.H{
+				{
+					"code":    "batch_failed",
+					"message": batch.Errors,
+				},
+			},
+		}
+	}
+	var metadata map[string]string
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &metadata)
+	}
+	return gin.H{
+		"id":                batch.BatchId,
+		"object":            "batch",
+		"endpoint":          batch.Endpoint,
+		"errors":            batchErrors,
+		"input_file_id":     batch.InputFileId,
+		"completion_window": batch.CompletionWindow,
+		"status":            batch.Status,
+		"output_file_id":    batch.OutputFileId,
+		"error_file_id":     batch.ErrorFileId,
+		"created_at":        batch.CreatedAt,
+		"in_progress_at":    nullableTimestamp(batch.InProgressAt),
+		"completed_at":      nullableTimestamp(batch.CompletedAt),
+		"failed_at":         nullableTimestamp(batch.FailedAt),
+		"cancelled_at":      nullableTimestamp(batch.CancelledAt),
+		"expires_at":        nullableTimestamp(batch.ExpiresAt),
+		"expired_at":        nullableTimestamp(batch.ExpiredAt),
+		"request_counts": gin.H{
+			"total":     batch.TotalCount,
+			"completed": batch.CompletedCount,
+			"failed":    batch.FailedCount,
+		},
+		"metadata": metadata,
+	}
+}
+
+func CreateBatch(c *gin.Context) {
+	userId := c.GetInt("id")
+	var request CreateBatchRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_endpoint", "unsupported endpoint: "+request.Endpoint)
+		return
+	}
+	if request.CompletionWindow == "" {
+		request.CompletionWindow = "24h"
+	}
+	completionWindow, err := time.ParseDuration(request.CompletionWindow)
+	if err != nil || completionWindow <= 0 {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_completion_window", "invalid completion_window: "+request.CompletionWindow)
+		return
+	}
+	file, err := model.GetUserFileById(request.InputFileId, userId)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_input_file", "No such File object: "+request.InputFileId)
+		return
+	}
+	if file.Purpose != model.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_input_file", "input file must be uploaded with purpose 'batch'")
+		return
+	}
+	batch := &model.Batch{
+		BatchId:          "batch_" + common.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        common.GetTimestamp(),
+		ClientIp:         c.ClientIP(),
+	}
+	batch.ExpiresAt = batch.CreatedAt + int64(completionWindow.Seconds())
+	if request.Metadata != nil {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	err = batch.Insert()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "create_batch_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func getUserBatch(c *gin.Context) (*model.Batch, bool) {
+	batch, err := model.GetUserBatchById(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIErrorResponse(c, http.StatusNotFound, "batch_not_found", "No such Batch object: "+c.Param("id"))
+		} else {
+			openAIErrorResponse(c, http.StatusInternalServerError, "get_batch_failed", err.Error())
+		}
+		return nil, false
+	}
+	return batch, true
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	batches, err := model.GetUserBatches(userId, c.Query("after"), limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "list_batches_failed", err.Error())
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]gin.H, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batchObject(batch))
+	}
+	response := gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": hasMore,
+	}
+	if len(batches) > 0 {
+		response["first_id"] = batches[0].BatchId
+		response["last_id"] = batches[len(batches)-1].BatchId
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	if batch.Status != model.BatchStatusValidating && batch.Status != model.BatchStatusInProgress {
+		openAIErrorResponse(c, http.StatusConflict, "batch_not_cancellable", fmt.Sprintf("Cannot cancel a batch with status '%s'", batch.Status))
+		return
+	}
+	err := model.CancelUserBatch(batch)
+	if errors.Is(err, model.ErrBatchNotCancellable) {
+		openAIErrorResponse(c, http.StatusConflict, "batch_not_cancellable", "Cannot cancel a batch that has already finished")
+		return
+	}
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "cancel_batch_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+// batchHandler 批处理中的请求经由完整的路由与中间件执行，鉴权、限流、并发限制、重试与渠道切换都与直接调用接口一致
+var batchHandler http.Handler
+
+// UpdateBatchBulk 轮询并依次执行待处理的批处理任务
+func UpdateBatchBulk(handler http.Handler) {
+	batchHandler = handler
+	err := model.FailInterruptedBatches()
+	if err != nil {
+		common.SysError("failed to reset interrupted batches: " + err.Error())
+	}
+	for {
+		time.Sleep(time.Duration(10) * time.Second)
+		batches, err := model.GetValidatingBatches(10)
+		if err != nil {
+			common.SysError("failed to get validating batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			runBatch(batch)
+		}
+	}
+}
+
+func failBatch(batch *model.Batch, message string) {
+	err := model.FailBatch(batch.Id, message)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// scanBatchLines 逐行读取输入文件，不把整个文件加载到内存
+func scanBatchLines(batch *model.Batch, handle func(lineNumber int, line *batchRequestLine) error) error {
+	scanner := bufio.NewScanner(model.NewFileReader(batch.InputFileId))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var line batchRequestLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			return fmt.Errorf("line %d: invalid json", lineNumber)
+		}
+		if err := handle(lineNumber, &line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// validateBatchInput 执行前先完整校验一遍输入文件，返回请求数
+func validateBatchInput(batch *model.Batch) (int, error) {
+	if _, err := model.GetUserFileById(batch.InputFileId, batch.UserId); err != nil {
+		return 0, fmt.Errorf("input file %s not found", batch.InputFileId)
+	}
+	customIds := make(map[string]bool)
+	err := scanBatchLines(batch, func(lineNumber int, line *batchRequestLine) error {
+		if line.CustomId == "" || customIds[line.CustomId] {
+			return fmt.Errorf("line %d: custom_id is missing or duplicated", lineNumber)
+		}
+		if line.Method != http.MethodPost || line.Url != batch.Endpoint {
+			return fmt.Errorf("line %d: method must be POST and url must be %s", lineNumber, batch.Endpoint)
+		}
+		customIds[line.CustomId] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(customIds) == 0 {
+		return 0, errors.New("input file is empty")
+	}
+	return len(customIds), nil
+}
+
+// batchResultFile 结果逐行写入数据库中的文件，文件 id 预先生成并记录在任务中，第一次写入时才开始写
+type batchResultFile struct {
+	batch    *model.Batch
+	filename string
+	writer   *model.FileWriter
+	file     *model.File
+}
+
+func newBatchResultFile(batch *model.Batch, filename string) *batchResultFile {
+	return &batchResultFile{
+		batch:    batch,
+		filename: filename,
+		file: &model.File{
+			FileId:   "file-" + common.GetUUID(),
+			UserId:   batch.UserId,
+			Filename: batch.BatchId + filename,
+			Purpose:  model.FilePurposeBatchOutput,
+		},
+	}
+}
+
+func (f *batchResultFile) writeLine(jsonData []byte) error {
+	if f.writer == nil {
+		f.file.CreatedAt = common.GetTimestamp()
+		f.writer = model.NewFileWriter(f.file)
+	}
+	_, err := f.writer.Write(append(jsonData, '\n'))
+	return err
+}
+
+// close 保存文件并返回文件 id，没有写入任何内容时返回空
+func (f *batchResultFile) close() string {
+	if f.writer == nil {
+		return ""
+	}
+	if err := f.writer.Close(); err != nil {
+		common.SysError(fmt.Sprintf("failed to save %s of batch %s: %s", f.filename, f.batch.BatchId, err.Error()))
+		return ""
+	}
+	return f.file.FileId
+}
+
+func (f *batchResultFile) abort() {
+	if f.writer != nil {
+		f.writer.Abort()
+	}
+}
+
+func expireBatch(batch *model.Batch) {
+	err := model.ExpireBatch(batch)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+func runBatch(batch *model.Batch) {
+	if batch.IsExpired() {
+		expireBatch(batch)
+		return
+	}
+	totalCount, err := validateBatchInput(batch)
+	if err != nil {
+		failBatch(batch, err.Error())
+		return
+	}
+	output := newBatchResultFile(batch, "_output.jsonl")
+	errorOutput := newBatchResultFile(batch, "_error.jsonl")
+	started, err := model.StartBatch(batch.Id, totalCount, []string{output.file.FileId, errorOutput.file.FileId})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if !started {
+		// 校验期间已被取消
+		return
+	}
+	batch.Status = model.BatchStatusInProgress
+	batch.TotalCount = totalCount
+	common.SysLog(fmt.Sprintf("batch %s started with %d requests", batch.BatchId, totalCount))
+
+	errCancelled := errors.New("batch cancelled")
+	errExpired := errors.New("batch expired")
+	err = scanBatchLines(batch, func(lineNumber int, line *batchRequestLine) error {
+		if status, _ := model.GetBatchStatus(batch.Id); status != model.BatchStatusInProgress {
+			return errCancelled
+		}
+		if batch.IsExpired() {
+			return errExpired
+		}
+		statusCode, requestId, body, openaiErr := executeBatchLine(batch, line)
+		result := gin.H{
+			"id":        "batch_req_" + common.GetUUID(),
+			"custom_id": line.CustomId,
+			"response":  nil,
+			"error":     nil,
+		}
+		if openaiErr != nil {
+			result["error"] = gin.H{
+				"code":    openaiErr.Code,
+				"message": openaiErr.Message,
+			}
+		} else {
+			result["response"] = gin.H{
+				"status_code": statusCode,
+				"request_id":  requestId,
+				"body":        body,
+			}
+		}
+		jsonData, _ := json.Marshal(result)
+		var err error
+		if openaiErr != nil || statusCode != http.StatusOK {
+			batch.FailedCount++
+			err = errorOutput.writeLine(jsonData)
+		} else {
+			batch.CompletedCount++
+			err = output.writeLine(jsonData)
+		}
+		if err != nil {
+			return err
+		}
+		return model.UpdateBatchCounts(batch.Id, batch.CompletedCount, batch.FailedCount)
+	})
+	if err != nil && !errors.Is(err, errCancelled) && !errors.Is(err, errExpired) {
+		output.abort()
+		errorOutput.abort()
+		common.SysError(fmt.Sprintf("batch %s failed: %s", batch.BatchId, err.Error()))
+		failBatch(batch, err.Error())
+		return
+	}
+	batch.OutputFileId = output.close()
+	batch.ErrorFileId = errorOutput.close()
+	if errors.Is(err, errExpired) {
+		// 过期的任务保留已经完成的请求结果
+		err = model.ExpireBatch(batch)
+	} else {
+		err = model.FinishBatch(batch)
+	}
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s: %d completed, %d failed", batch.BatchId, batch.Status, batch.CompletedCount, batch.FailedCount))
+}
+
+// batchResponseWriter 收集内部请求的响应
+type batchResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+// batchRetryTimes 被限流时等待后重试的次数，每次等待的时间逐渐增加
+var (
+	batchRetryTimes    = 5
+	batchRetryInterval = 10 * time.Second
+)
+
+// executeBatchLine 以创建任务时使用的令牌发起内部请求
+func executeBatchLine(batch *model.Batch, line *batchRequestLine) (int, string, json.RawMessage, *dto.OpenAIError) {
+	var streamRequest struct {
+		Stream bool `json:"stream"`
+	}
+	if json.Unmarshal(line.Body, &streamRequest) == nil && streamRequest.Stream {
+		return 0, "", nil, &dto.OpenAIError{Code: "invalid_request", Message: "stream is not supported in batch requests"}
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil || token.UserId != batch.UserId {
+		return 0, "", nil, &dto.OpenAIError{Code: "invalid_token", Message: "the token used to create the batch is no longer available"}
+	}
+
+	var writer *batchResponseWriter
+	for i := 0; i <= batchRetryTimes; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * batchRetryInterval)
+		}
+		ctx := context.WithValue(context.Background(), common.BatchIdKey, batch.BatchId)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, line.Url, bytes.NewReader(line.Body))
+		if err != nil {
+			return 0, "", nil, &dto.OpenAIError{Code: "invalid_request", Message: err.Error()}
+		}
+		// 使用创建任务时的客户端 IP，日志与按 IP 的限制和直接调用一致
+		clientIp := common.GetStringIfEmpty(batch.ClientIp, "127.0.0.1")
+		req.RemoteAddr = net.JoinHostPort(clientIp, "0")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer sk-"+token.Key)
+		writer = &batchResponseWriter{header: make(http.Header)}
+		batchHandler.ServeHTTP(writer, req)
+		if writer.statusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+	body := writer.body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return writer.statusCode, writer.header.Get(common.RequestIdKey), body, nil
+}
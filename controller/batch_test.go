@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readBatchResultFile(t *testing.T, fileId string) []map[string]any {
+	var results []map[string]any
+	scanner := bufio.NewScanner(model.NewFileReader(fileId))
+	for scanner.Scan() {
+		var result map[string]any
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestRunBatch(t *testing.T) {
+	model.DB = testutil.SetupDB(t, &model.Token{}, &model.File{}, &model.FileChunk{}, &model.Batch{})
+	asserts := assert.New(t)
+	batchRetryInterval = time.Millisecond
+
+	token := &model.Token{Id: 7, UserId: 3, Key: "batchtoken", Name: "batch"}
+	asserts.NoError(model.DB.Create(token).Error)
+	input := strings.Join([]string{
+		`{"custom_id": "ok", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "messages": []}}`,
+		`{"custom_id": "limited", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "user": "limited"}}`,
+		``,
+		`{"custom_id": "bad", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "unknown", "messages": []}}`,
+		`{"custom_id": "stream", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "gpt-4o", "stream": true}}`,
+	}, "\n")
+	inputFile, err := saveUserFile(3, "input.jsonl", model.FilePurposeBatch, strings.NewReader(input))
+	asserts.NoError(err)
+	batch := &model.Batch{
+		BatchId:     "batch_test",
+		UserId:      3,
+		TokenId:     7,
+		Endpoint:    "/v1/chat/completions",
+		InputFileId: inputFile.FileId,
+		Status:      model.BatchStatusValidating,
+		ClientIp:    "203.0.113.7",
+	}
+	asserts.NoError(batch.Insert())
+
+	attempts := make(map[string]int)
+	batchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 内部请求使用创建任务的令牌，经由完整的路由执行
+		asserts.Equal("Bearer sk-batchtoken", r.Header.Get("Authorization"))
+		asserts.Equal("batch_test", r.Context().Value(common.BatchIdKey))
+		// 内部请求带上创建任务时的客户端 IP
+		asserts.Equal("203.0.113.7:0", r.RemoteAddr)
+		body, _ := io.ReadAll(r.Body)
+		var request struct {
+			Model string `json:"model"`
+			User  string `json:"user"`
+		}
+		_ = json.Unmarshal(body, &request)
+		attempts[request.User]++
+		w.Header().Set(common.RequestIdKey, "req-1")
+		switch {
+		case request.Model == "unknown":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error": {"message": "no channel"}}`))
+		case request.User == "limited" && attempts["limited"] == 1:
+			// 首次被限流，等待后重试成功
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte(`{"id": "chatcmpl-1", "object": "chat.completion"}`))
+		}
+	})
+	runBatch(batch)
+	asserts.Equal(2, attempts["limited"])
+
+	saved, err := model.GetUserBatchById("batch_test", 3)
+	asserts.NoError(err)
+	asserts.Equal(model.BatchStatusCompleted, saved.Status)
+	asserts.Equal(4, saved.TotalCount)
+	asserts.Equal(saved.OutputFileId+","+saved.ErrorFileId, saved.ResultFileIds)
+	asserts.Equal(2, saved.CompletedCount)
+	asserts.Equal(2, saved.FailedCount)
+
+	outputs := readBatchResultFile(t, saved.OutputFileId)
+	asserts.Len(outputs, 2)
+	asserts.Equal("ok", outputs[0]["custom_id"])
+	response := outputs[0]["response"].(map[string]any)
+	asserts.EqualValues(200, response["status_code"])
+	asserts.Equal("req-1", response["request_id"])
+	asserts.Equal("chatcmpl-1", response["body"].(map[string]any)["id"])
+	asserts.Equal("limited", outputs[1]["custom_id"])
+
+	errorsOutput := readBatchResultFile(t, saved.ErrorFileId)
+	asserts.Len(errorsOutput, 2)
+	asserts.Equal("bad", errorsOutput[0]["custom_id"])
+	asserts.EqualValues(503, errorsOutput[0]["response"].(map[string]any)["status_code"])
+	asserts.Equal("stream", errorsOutput[1]["custom_id"])
+	asserts.Equal("invalid_request", errorsOutput[1]["error"].(map[string]any)["code"])
+}
+
+func TestRunBatchInvalidInput(t *testing.T) {
+	model.DB = testutil.SetupDB(t, &model.File{}, &model.FileChunk{}, &model.Batch{})
+	asserts := assert.New(t)
+
+	input := `{"custom_id": "a", "method": "POST", "url": "/v1/embeddings", "body": {}}`
+	inputFile, err := saveUserFile(3, "input.jsonl", model.FilePurposeBatch, strings.NewReader(input))
+	asserts.NoError(err)
+	batch := &model.Batch{BatchId: "batch_invalid", UserId: 3, Endpoint: "/v1/chat/completions", InputFileId: inputFile.FileId, Status: model.BatchStatusValidating}
+	asserts.NoError(batch.Insert())
+	runBatch(batch)
+
+	saved, err := model.GetUserBatchById("batch_invalid", 3)
+	asserts.NoError(err)
+	asserts.Equal(model.BatchStatusFailed, saved.Status)
+	asserts.Contains(saved.Errors, "line 1")
+}
+
+func TestRunBatchExpired(t *testing.T) {
+	model.DB = testutil.SetupDB(t, &model.File{}, &model.FileChunk{}, &model.Batch{})
+	asserts := assert.New(t)
+
+	input := `{"custom_id": "a", "method": "POST", "url": "/v1/chat/completions", "body": {}}`
+	inputFile, err := saveUserFile(3, "input.jsonl", model.FilePurposeBatch, strings.NewReader(input))
+	asserts.NoError(err)
+	batch := &model.Batch{BatchId: "batch_expired", UserId: 3, Endpoint: "/v1/chat/completions", InputFileId: inputFile.FileId,
+		Status: model.BatchStatusValidating, ExpiresAt: common.GetTimestamp() - 1}
+	asserts.NoError(batch.Insert())
+	batchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expired batch should not be executed")
+	})
+	runBatch(batch)
+
+	saved, err := model.GetUserBatchById("batch_expired", 3)
+	asserts.NoError(err)
+	asserts.Equal(model.BatchStatusExpired, saved.Status)
+	asserts.NotZero(saved.ExpiredAt)
+	asserts.Empty(saved.OutputFileId)
+}
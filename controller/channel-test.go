@@ -26,7 +26,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func testChannel(channel *model.Channel, testModel string) (err error, openAIErrorWithStatusCode *dto.OpenAIErrorWithStatusCode) {
+// testChannel 使用指定的密钥测试渠道，多密钥渠道的测试结果只对应这一个密钥
+func testChannel(channel *model.Channel, key string, testModel string) (err error, openAIErrorWithStatusCode *dto.OpenAIErrorWithStatusCode) {
 	tik := time.Now()
 	if channel.Type == common.ChannelTypeMidjourney {
 		return errors.New("midjourney channel test is not supported"), nil
@@ -67,12 +68,11 @@ func testChannel(channel *model.Channel, testModel string) (err error, openAIErr
 		}
 	}
 
-	c.Request.Header.Set("Authorization", "Bearer "+channel.Key)
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Set("channel", channel.Type)
 	c.Set("base_url", channel.GetBaseURL())
 
-	middleware.SetupContextForSelectedChannel(c, channel, testModel)
+	middleware.SetupContextForSelectedChannelKey(c, channel, testModel, key)
 
 	meta := relaycommon.GenRelayInfo(c)
 	apiType, _ := constant.ChannelType2APIType(channel.Type)
@@ -175,7 +175,7 @@ func TestChannel(c *gin.Context) {
 	}
 	testModel := c.Query("model")
 	tik := time.Now()
-	err, _ = testChannel(channel, testModel)
+	err, _ = testChannel(channel, channel.SelectKey(), testModel)
 	tok := time.Now()
 	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
@@ -220,41 +220,15 @@ func testAllChannels(notify bool) error {
 	}
 	gopool.Go(func() {
 		for _, channel := range channels {
-			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
-			tik := time.Now()
-			err, openaiWithStatusErr := testChannel(channel, "")
-			tok := time.Now()
-			milliseconds := tok.Sub(tik).Milliseconds()
-
-			ban := false
-			if milliseconds > disableThreshold {
-				err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
-				ban = true
-			}
-
-			// request error disables the channel
-			if openaiWithStatusErr != nil {
-				oaiErr := openaiWithStatusErr.Error
-				err = errors.New(fmt.Sprintf("type %s, httpCode %d, code %v, message %s", oaiErr.Type, openaiWithStatusErr.StatusCode, oaiErr.Code, oaiErr.Message))
-				ban = service.ShouldDisableChannel(channel.Type, openaiWithStatusErr)
-			}
-
-			// parse *int to bool
-			if channel.AutoBan != nil && *channel.AutoBan == 0 {
-				ban = false
-			}
-
-			// disable channel
-			if ban && isChannelEnabled {
-				service.DisableChannel(channel.Id, channel.Name, err.Error())
-			}
-
-			// enable channel
-			if !isChannelEnabled && service.ShouldEnableChannel(err, openaiWithStatusErr, channel.Status) {
-				service.EnableChannel(channel.Id, channel.Name)
+			if channel.IsMultiKey() {
+				// 多密钥渠道逐个测试密钥，只禁用或启用被测试的密钥
+				for _, key := range channel.GetKeys() {
+					testChannelKeyAndUpdateStatus(channel, key, disableThreshold)
+					time.Sleep(common.RequestInterval)
+				}
+				continue
 			}
-
-			channel.UpdateResponseTime(milliseconds)
+			testChannelKeyAndUpdateStatus(channel, channel.Key, disableThreshold)
 			time.Sleep(common.RequestInterval)
 		}
 		testAllChannelsLock.Lock()
@@ -270,6 +244,59 @@ func testAllChannels(notify bool) error {
 	return nil
 }
 
+// testChannelKeyAndUpdateStatus 测试渠道的一个密钥并按结果自动禁用或启用，
+// 多密钥渠道只处置被测试的密钥，手动禁用的渠道不做处理
+func testChannelKeyAndUpdateStatus(channel *model.Channel, key string, disableThreshold int64) {
+	multiKey := channel.IsMultiKey()
+	status := channel.Status
+	if multiKey && status != common.ChannelStatusManuallyDisabled {
+		status = channel.GetKeyStatus(key)
+	}
+	isEnabled := status == common.ChannelStatusEnabled
+	tik := time.Now()
+	err, openaiWithStatusErr := testChannel(channel, key, "")
+	tok := time.Now()
+	milliseconds := tok.Sub(tik).Milliseconds()
+
+	ban := false
+	if milliseconds > disableThreshold {
+		err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
+		ban = true
+	}
+
+	// request error disables the channel
+	if openaiWithStatusErr != nil {
+		oaiErr := openaiWithStatusErr.Error
+		err = errors.New(fmt.Sprintf("type %s, httpCode %d, code %v, message %s", oaiErr.Type, openaiWithStatusErr.StatusCode, oaiErr.Code, oaiErr.Message))
+		ban = service.ShouldDisableChannel(channel.Type, openaiWithStatusErr)
+	}
+
+	// parse *int to bool
+	if channel.AutoBan != nil && *channel.AutoBan == 0 {
+		ban = false
+	}
+
+	// disable channel
+	if ban && isEnabled {
+		if multiKey {
+			service.DisableChannelKey(channel.Id, channel.Name, key, err.Error())
+		} else {
+			service.DisableChannel(channel.Id, channel.Name, err.Error())
+		}
+	}
+
+	// enable channel
+	if !isEnabled && service.ShouldEnableChannel(err, openaiWithStatusErr, status) {
+		if multiKey {
+			service.EnableChannelKey(channel.Id, channel.Name, key)
+		} else {
+			service.EnableChannel(channel.Id, channel.Name)
+		}
+	}
+
+	channel.UpdateResponseTime(milliseconds)
+}
+
 func TestAllChannels(c *gin.Context) {
 	err := testAllChannels(true)
 	if err != nil {
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestChannelKeyAndUpdateStatus(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled := common.RedisEnabled
+	logConsumeEnabled := common.LogConsumeEnabled
+	autoDisable, autoEnable := common.AutomaticDisableChannelEnabled, common.AutomaticEnableChannelEnabled
+	rootUserEmail := common.RootUserEmail
+	common.RedisEnabled = false
+	common.LogConsumeEnabled = false
+	common.AutomaticDisableChannelEnabled = true
+	common.AutomaticEnableChannelEnabled = true
+	common.RootUserEmail = "root@example.com"
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+		common.LogConsumeEnabled = logConsumeEnabled
+		common.AutomaticDisableChannelEnabled, common.AutomaticEnableChannelEnabled = autoDisable, autoEnable
+		common.RootUserEmail = rootUserEmail
+	})
+	model.DB = testutil.SetupDB(t, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+
+	validKeys := map[string]bool{"Bearer k2": true}
+	var authorizations []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		authorizations = append(authorizations, authorization)
+		w.Header().Set("Content-Type", "application/json")
+		if !validKeys[authorization] {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"invalid api key","type":"invalid_request_error","code":"invalid_api_key"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-3.5-turbo","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	baseURL := upstream.URL
+	channel := &model.Channel{Type: common.ChannelTypeOpenAI, Name: "multi", Key: "k1\nk2", MultiKeyMode: model.ChannelKeyModeRoundRobin,
+		Status: common.ChannelStatusEnabled, BaseURL: &baseURL, Models: "gpt-3.5-turbo", Group: "default"}
+	asserts.NoError(channel.Insert())
+
+	// 每个密钥单独发送给上游，失败的密钥只禁用自身
+	for _, key := range channel.GetKeys() {
+		testChannelKeyAndUpdateStatus(channel, key, 10000000)
+	}
+	asserts.Equal([]string{"Bearer k1", "Bearer k2"}, authorizations)
+	asserts.Equal(common.ChannelStatusAutoDisabled, channel.GetKeyStatus("k1"))
+	asserts.Equal(common.ChannelStatusEnabled, channel.GetKeyStatus("k2"))
+	saved, err := model.GetChannelById(channel.Id, true)
+	asserts.NoError(err)
+	asserts.Equal(common.ChannelStatusEnabled, saved.Status)
+
+	// 全部密钥失效后渠道被禁用，恢复的密钥只启用自身与渠道，不会恢复其他被禁用的密钥
+	validKeys = map[string]bool{}
+	testChannelKeyAndUpdateStatus(saved, "k2", 10000000)
+	saved, err = model.GetChannelById(channel.Id, true)
+	asserts.NoError(err)
+	asserts.Equal(common.ChannelStatusAutoDisabled, saved.Status)
+	validKeys = map[string]bool{"Bearer k2": true}
+	for _, key := range saved.GetKeys() {
+		testChannelKeyAndUpdateStatus(saved, key, 10000000)
+	}
+	asserts.Equal(common.ChannelStatusAutoDisabled, saved.GetKeyStatus("k1"))
+	asserts.Equal(common.ChannelStatusEnabled, saved.GetKeyStatus("k2"))
+	saved, err = model.GetChannelById(channel.Id, true)
+	asserts.NoError(err)
+	asserts.Equal(common.ChannelStatusEnabled, saved.Status)
+}
+
+func TestUpdateChannelManualEnableRestoresKeys(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+	model.DB = testutil.SetupDB(t, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+	channel := &model.Channel{Type: common.ChannelTypeOpenAI, Name: "multi", Key: "k1\nk2", MultiKeyMode: model.ChannelKeyModeRoundRobin,
+		Status: common.ChannelStatusAutoDisabled, Models: "gpt-3.5-turbo", Group: "default"}
+	asserts.NoError(channel.Insert())
+	_, err := model.UpdateChannelKeyStatus(channel.Id, "k1", common.ChannelStatusAutoDisabled, "invalid key")
+	asserts.NoError(err)
+
+	// 管理员手动启用渠道时恢复全部密钥
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/channel/", strings.NewReader(fmt.Sprintf(`{"id":%d,"status":1}`, channel.Id)))
+	UpdateChannel(c)
+	asserts.Contains(recorder.Body.String(), `"success":true`)
+	asserts.Equal(common.ChannelStatusEnabled, channel.GetKeyStatus("k1"))
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"strconv"
 	"strings"
 )
@@ -90,7 +91,7 @@ func FetchUpstreamModels(c *gin.Context) {
 		return
 	}
 	url := fmt.Sprintf("%s/v1/models", *channel.BaseURL)
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.SelectKey()))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -186,6 +187,40 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelKeys(channel),
+	})
+	return
+}
+
+func GetChannelHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelHealth(),
+	})
+	return
+}
+
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -197,6 +232,22 @@ func AddChannel(c *gin.Context) {
 		return
 	}
 	channel.CreatedTime = common.GetTimestamp()
+	if channel.IsMultiKey() {
+		// 多密钥渠道，所有密钥共用一个渠道
+		err = channel.Insert()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+		})
+		return
+	}
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
@@ -297,6 +348,7 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	origin, _ := model.GetChannelById(channel.Id, false)
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -305,6 +357,14 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Status == common.ChannelStatusEnabled {
+		if origin != nil && origin.Status != common.ChannelStatusEnabled {
+			// 手动启用渠道时恢复其全部密钥，自动启用只恢复测试通过的密钥
+			model.EnableAllChannelKeys(channel.Id)
+		}
+		// 手动启用渠道后，再次被自动禁用时应立即通知
+		service.ClearChannelDisabledNotify(channel.Id)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"strconv"
+)
+
+func openAIErrorResponse(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Param:   "",
+			Code:    code,
+		},
+	})
+}
+
+func fileObject(file *model.File) gin.H {
+	return gin.H{
+		"id":         file.FileId,
+		"object":     "file",
+		"bytes":      file.Bytes,
+		"created_at": file.CreatedAt,
+		"filename":   file.Filename,
+		"purpose":    file.Purpose,
+		"status":     "processed",
+	}
+}
+
+// saveUserFile 将文件内容分块写入数据库
+func saveUserFile(userId int, filename string, purpose string, reader io.Reader) (*model.File, error) {
+	file := &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    userId,
+		Filename:  filename,
+		Purpose:   purpose,
+		CreatedAt: common.GetTimestamp(),
+	}
+	writer := model.NewFileWriter(file)
+	size, err := io.Copy(writer, io.LimitReader(reader, int64(constant.MaxFileSize)<<20+1))
+	if err == nil && size > int64(constant.MaxFileSize)<<20 {
+		err = fmt.Errorf("file size exceeds the limit of %d MB", constant.MaxFileSize)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	return file, nil
+}
+
+func UploadFile(c *gin.Context) {
+	userId := c.GetInt("id")
+	purpose := c.PostForm("purpose")
+	if purpose != model.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_purpose", "only purpose 'batch' is supported")
+		return
+	}
+	formFile, header, err := c.Request.FormFile("file")
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_file", "file is required")
+		return
+	}
+	defer formFile.Close()
+	file, err := saveUserFile(userId, header.Filename, purpose, formFile)
+	if err != nil {
+		common.SysError("failed to save file: " + err.Error())
+		openAIErrorResponse(c, http.StatusBadRequest, "save_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func ListFiles(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+	files, err := model.GetUserFiles(userId, c.Query("purpose"), 0, limit)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "list_files_failed", err.Error())
+		return
+	}
+	data := make([]gin.H, 0, len(files))
+	for _, file := range files {
+		data = append(data, fileObject(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func getUserFile(c *gin.Context) (*model.File, bool) {
+	file, err := model.GetUserFileById(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIErrorResponse(c, http.StatusNotFound, "file_not_found", "No such File object: "+c.Param("id"))
+		} else {
+			openAIErrorResponse(c, http.StatusInternalServerError, "get_file_failed", err.Error())
+		}
+		return nil, false
+	}
+	return file, true
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", model.NewFileReader(file.FileId), nil)
+}
+
+func DeleteFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	err := file.Delete()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "delete_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.FileId,
+		"object":  "file",
+		"deleted": true,
+	})
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/service"
+	"strconv"
+)
+
+func sendInvoiceFile(c *gin.Context, filename string, contentType string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func getInvoices(c *gin.Context, userId int) {
+	var startTime, endTime int64
+	month := c.Query("month")
+	if month != "" {
+		var err error
+		month, startTime, endTime, err = service.ParseStatementMonth(month)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	invoices, err := service.GetUserInvoices(userId, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("format") == "csv" {
+		filename := fmt.Sprintf("invoices-%d.csv", userId)
+		if month != "" {
+			filename = fmt.Sprintf("invoices-%d-%s.csv", userId, month)
+		}
+		sendInvoiceFile(c, filename, "text/csv; charset=utf-8", service.RenderInvoicesCSV(invoices))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    invoices,
+	})
+}
+
+// getInvoice userId 为 0 时不校验发票所属用户（管理员）
+func getInvoice(c *gin.Context, userId int) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	invoice, err := service.GetInvoice(c.Param("type"), id)
+	if err == nil && userId != 0 && invoice.UserId != userId {
+		err = fmt.Errorf("发票不存在")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	switch c.Query("format") {
+	case "pdf":
+		data, err := service.RenderInvoicePDF(invoice)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		sendInvoiceFile(c, invoice.Number+".pdf", "application/pdf", data)
+	case "csv":
+		sendInvoiceFile(c, invoice.Number+".csv", "text/csv; charset=utf-8", service.RenderInvoicesCSV([]*service.Invoice{invoice}))
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    invoice,
+		})
+	}
+}
+
+func getStatement(c *gin.Context, userId int) {
+	statement, err := service.GetUserStatement(userId, c.Query("month"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("statement-%d-%s", userId, statement.Month)
+	switch c.Query("format") {
+	case "pdf":
+		data, err := service.RenderStatementPDF(statement)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		sendInvoiceFile(c, filename+".pdf", "application/pdf", data)
+	case "csv":
+		sendInvoiceFile(c, filename+".csv", "text/csv; charset=utf-8", service.RenderStatementCSV(statement))
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statement,
+		})
+	}
+}
+
+func GetSelfInvoices(c *gin.Context) {
+	getInvoices(c, c.GetInt("id"))
+}
+
+func GetSelfInvoice(c *gin.Context) {
+	getInvoice(c, c.GetInt("id"))
+}
+
+func GetSelfStatement(c *gin.Context) {
+	getStatement(c, c.GetInt("id"))
+}
+
+func getInvoiceUserId(c *gin.Context) (int, bool) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	if userId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的用户 ID",
+		})
+		return 0, false
+	}
+	return userId, true
+}
+
+func GetUserInvoices(c *gin.Context) {
+	if userId, ok := getInvoiceUserId(c); ok {
+		getInvoices(c, userId)
+	}
+}
+
+func GetUserInvoice(c *gin.Context) {
+	getInvoice(c, 0)
+}
+
+func GetUserStatement(c *gin.Context) {
+	if userId, ok := getInvoiceUserId(c); ok {
+		getStatement(c, userId)
+	}
+}
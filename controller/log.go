@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"strconv"
 )
 
@@ -197,3 +198,35 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+// GetLogPayloadCapture 返回消费日志关联的完整请求与响应内容
+func GetLogPayloadCapture(c *gin.Context) {
+	var capture *model.PayloadCapture
+	var err error
+	if requestId := c.Query("request_id"); requestId != "" {
+		capture, err = model.GetPayloadCaptureByRequestId(requestId)
+	} else {
+		logId, _ := strconv.Atoi(c.Param("id"))
+		capture, err = model.GetPayloadCaptureByLogId(logId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "未找到该请求的内容记录",
+		})
+		return
+	}
+	err = service.DecryptPayloadCapture(capture)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    capture,
+	})
+}
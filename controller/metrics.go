@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"one-api/constant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsHandler = promhttp.Handler()
+
+// Metrics 以 Prometheus 文本格式输出运行指标，配置了 METRICS_TOKEN 时需要携带对应的 Bearer Token
+func Metrics(c *gin.Context) {
+	if constant.MetricsToken != "" {
+		expected := "Bearer " + constant.MetricsToken
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte(expected)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}
+	metricsHandler.ServeHTTP(c.Writer, c.Request)
+}
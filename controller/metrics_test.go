@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	asserts := assert.New(t)
+	token := constant.MetricsToken
+	constant.MetricsToken = "secret"
+	t.Cleanup(func() {
+		constant.MetricsToken = token
+	})
+	common.MetricRelayRetries.WithLabelValues("gpt-4o", "default").Inc()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/metrics", nil)
+	Metrics(c)
+	asserts.Equal(http.StatusUnauthorized, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/metrics", nil)
+	c.Request.Header.Set("Authorization", "Bearer secret")
+	Metrics(c)
+	asserts.Equal(http.StatusOK, recorder.Code)
+	asserts.Contains(recorder.Body.String(), `new_api_relay_retries_total{group="default",model="gpt-4o"} 1`)
+}
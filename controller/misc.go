@@ -62,6 +62,9 @@ func GetStatus(c *gin.Context) {
 			"data_export_default_time": common.DataExportDefaultTime,
 			"default_collapse_sidebar": common.DefaultCollapseSidebar,
 			"enable_online_topup":      constant.PayAddress != "" && constant.EpayId != "" && constant.EpayKey != "",
+			"enable_stripe_topup":      constant.StripeApiSecret != "" && constant.StripeWebhookSecret != "",
+			"stripe_currency":          constant.StripeCurrency,
+			"stripe_price":             constant.StripePrice,
 			"mj_notify_enabled":        constant.MjNotifyEnabled,
 		},
 	})
@@ -15,7 +15,9 @@ import (
 	"one-api/relay/constant"
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
@@ -31,6 +33,10 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 		err = relay.AudioHelper(c)
 	case relayconstant.RelayModeRerank:
 		err = relay.RerankHelper(c, relayMode)
+	case relayconstant.RelayModeClaudeMessages:
+		err = relay.ClaudeHelper(c)
+	case relayconstant.RelayModeGemini:
+		err = relay.GeminiHelper(c)
 	default:
 		err = relay.TextHelper(c)
 	}
@@ -39,6 +45,7 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 
 func Relay(c *gin.Context) {
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
+	defer service.StartPayloadCapture(c)()
 	retryTimes := common.RetryTimes
 	requestId := c.GetString(common.RequestIdKey)
 	channelId := c.GetInt("channel_id")
@@ -46,32 +53,37 @@ func Relay(c *gin.Context) {
 	channelName := c.GetString("channel_name")
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
-	openaiErr := relayHandler(c, relayMode)
+	relayStartTime := time.Now()
+	openaiErr := relayWithChannel(c, relayMode, channelId, originalModel)
 	c.Set("use_channel", []string{fmt.Sprintf("%d", channelId)})
 	if openaiErr != nil {
-		go processChannelError(c, channelId, channelType, channelName, openaiErr)
+		go processChannelError(c, channelId, channelType, channelName, getMultiKeyChannelKey(c), c.GetBool("auto_ban"), openaiErr)
 	} else {
 		retryTimes = 0
 	}
-	for i := 0; shouldRetry(c, channelId, openaiErr, retryTimes) && i < retryTimes; i++ {
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i)
-		if err != nil {
-			common.LogError(c.Request.Context(), fmt.Sprintf("CacheGetRandomSatisfiedChannel failed: %s", err.Error()))
+	openaiErr = retryRelay(c, relayMode, group, originalModel, []int{channelId}, retryTimes, relayStartTime, openaiErr)
+	// 当前模型的渠道全部失败后，按分组配置的回退链依次尝试其他模型
+	for _, fallbackModel := range common.GetModelFallbacks(group, originalModel) {
+		if !shouldFallbackModel(c, relayMode, openaiErr) {
 			break
 		}
-		channelId = channel.Id
-		useChannel := c.GetStringSlice("use_channel")
-		useChannel = append(useChannel, fmt.Sprintf("%d", channel.Id))
-		c.Set("use_channel", useChannel)
-		common.LogInfo(c.Request.Context(), fmt.Sprintf("using channel #%d to retry (remain times %d)", channel.Id, i))
-		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
-
-		requestBody, err := common.GetRequestBody(c)
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		openaiErr = relayHandler(c, relayMode)
-		if openaiErr != nil {
-			go processChannelError(c, channel.Id, channel.Type, channel.Name, openaiErr)
+		if isRetryDeadlineExceeded(relayStartTime) {
+			common.LogInfo(c.Request.Context(), fmt.Sprintf("retry deadline of %d seconds exceeded, stop falling back", common.RetryTimeout))
+			break
 		}
+		if !isTokenModelAllowed(c, fallbackModel) {
+			continue
+		}
+		channel, err := model.CacheGetNextSatisfiedChannel(c.GetInt("id"), group, fallbackModel, nil)
+		if err != nil {
+			common.LogError(c.Request.Context(), fmt.Sprintf("no available channel for fallback model %s: %s", fallbackModel, err.Error()))
+			continue
+		}
+		common.LogInfo(c.Request.Context(), fmt.Sprintf("model %s failed, falling back to model %s using channel #%d", originalModel, fallbackModel, channel.Id))
+		c.Set("request_model", originalModel)
+		c.Set("fallback_model", fallbackModel)
+		openaiErr = relayWithSelectedChannel(c, relayMode, channel, fallbackModel)
+		openaiErr = retryRelay(c, relayMode, group, fallbackModel, []int{channel.Id}, common.RetryTimes, relayStartTime, openaiErr)
 	}
 	useChannel := c.GetStringSlice("use_channel")
 	if len(useChannel) > 1 {
@@ -80,16 +92,118 @@ func Relay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
+		if c.Writer.Written() {
+			// 响应已经开始写出（如流式输出中途出错），无法再返回错误信息
+			common.LogError(c.Request.Context(), fmt.Sprintf("relay error after response written: %s", openaiErr.Error.Message))
+			return
+		}
 		if openaiErr.StatusCode == http.StatusTooManyRequests {
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
+		if relayMode == relayconstant.RelayModeClaudeMessages {
+			c.JSON(openaiErr.StatusCode, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    openaiErr.Error.Type,
+					"message": openaiErr.Error.Message,
+				},
+			})
+			return
+		}
+		if relayMode == relayconstant.RelayModeGemini {
+			c.JSON(openaiErr.StatusCode, gin.H{
+				"error": gin.H{
+					"code":    openaiErr.StatusCode,
+					"message": openaiErr.Error.Message,
+					"status":  openaiErr.Error.Type,
+				},
+			})
+			return
+		}
 		c.JSON(openaiErr.StatusCode, gin.H{
 			"error": openaiErr.Error,
 		})
 	}
 }
 
+// retryRelay 在同一模型下重试，已经尝试过的渠道不会再次被选中
+func retryRelay(c *gin.Context, relayMode int, group string, modelName string, excludeChannelIds []int, retryTimes int, startTime time.Time, openaiErr *dto.OpenAIErrorWithStatusCode) *dto.OpenAIErrorWithStatusCode {
+	for i := 0; shouldRetry(c, excludeChannelIds[len(excludeChannelIds)-1], openaiErr, retryTimes) && i < retryTimes; i++ {
+		if isRetryDeadlineExceeded(startTime) {
+			common.LogInfo(c.Request.Context(), fmt.Sprintf("retry deadline of %d seconds exceeded, stop retrying", common.RetryTimeout))
+			break
+		}
+		channel, err := model.CacheGetNextSatisfiedChannel(c.GetInt("id"), group, modelName, excludeChannelIds)
+		if err != nil {
+			common.LogError(c.Request.Context(), fmt.Sprintf("CacheGetNextSatisfiedChannel failed: %s", err.Error()))
+			break
+		}
+		excludeChannelIds = append(excludeChannelIds, channel.Id)
+		common.MetricRelayRetries.WithLabelValues(modelName, group).Inc()
+		common.LogInfo(c.Request.Context(), fmt.Sprintf("using channel #%d to retry (remain times %d)", channel.Id, i))
+		openaiErr = relayWithSelectedChannel(c, relayMode, channel, modelName)
+	}
+	return openaiErr
+}
+
+// relayWithSelectedChannel 使用新选出的渠道重新发起请求
+func relayWithSelectedChannel(c *gin.Context, relayMode int, channel *model.Channel, modelName string) *dto.OpenAIErrorWithStatusCode {
+	useChannel := c.GetStringSlice("use_channel")
+	useChannel = append(useChannel, fmt.Sprintf("%d", channel.Id))
+	c.Set("use_channel", useChannel)
+	middleware.SetupContextForSelectedChannel(c, channel, modelName)
+
+	requestBody, _ := common.GetRequestBody(c)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	openaiErr := relayWithChannel(c, relayMode, channel.Id, modelName)
+	if openaiErr != nil {
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, getMultiKeyChannelKey(c), c.GetBool("auto_ban"), openaiErr)
+	}
+	return openaiErr
+}
+
+// shouldFallbackModel 上游或渠道故障时允许切换到回退模型，仅对对话类请求生效
+func shouldFallbackModel(c *gin.Context, relayMode int, openaiErr *dto.OpenAIErrorWithStatusCode) bool {
+	if openaiErr == nil {
+		return false
+	}
+	switch relayMode {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions,
+		relayconstant.RelayModeClaudeMessages, relayconstant.RelayModeGemini:
+	default:
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	if c.Writer.Written() {
+		return false
+	}
+	// 上游账户额度不足，换用其他模型的渠道
+	if openaiErr.Error.Code == "insufficient_quota" {
+		return true
+	}
+	// 用户或令牌额度不足等本地错误换模型也无法解决，只有渠道已满这类渠道侧的本地错误允许回退
+	if openaiErr.LocalError && openaiErr.Error.Code != "channel_concurrency_limited" {
+		return false
+	}
+	return shouldRetry(c, 0, openaiErr, 1)
+}
+
+func isTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !c.GetBool("token_model_limit_enabled") {
+		return true
+	}
+	tokenModelLimit, _ := c.Get("token_model_limit")
+	modelLimit, ok := tokenModelLimit.(map[string]bool)
+	if !ok {
+		return false
+	}
+	_, ok = modelLimit[modelName]
+	return ok
+}
+
 func shouldRetry(c *gin.Context, channelId int, openaiErr *dto.OpenAIErrorWithStatusCode, retryTimes int) bool {
 	if openaiErr == nil {
 		return false
@@ -100,6 +214,10 @@ func shouldRetry(c *gin.Context, channelId int, openaiErr *dto.OpenAIErrorWithSt
 	if _, ok := c.Get("specific_channel_id"); ok {
 		return false
 	}
+	if c.Writer.Written() {
+		// 已经向客户端写出数据，重试会导致响应内容重复或错乱
+		return false
+	}
 	if openaiErr.StatusCode == http.StatusTooManyRequests {
 		return true
 	}
@@ -129,14 +247,98 @@ func shouldRetry(c *gin.Context, channelId int, openaiErr *dto.OpenAIErrorWithSt
 	return true
 }
 
-func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, err *dto.OpenAIErrorWithStatusCode) {
-	autoBan := c.GetBool("auto_ban")
+func isRetryDeadlineExceeded(startTime time.Time) bool {
+	if common.RetryTimeout <= 0 {
+		return false
+	}
+	return time.Since(startTime) >= time.Duration(common.RetryTimeout)*time.Second
+}
+
+// getUsedChannelIds 返回本次请求已经尝试过的渠道，重试时不再选择
+func getUsedChannelIds(c *gin.Context) []int {
+	useChannel := c.GetStringSlice("use_channel")
+	channelIds := make([]int, 0, len(useChannel))
+	for _, idStr := range useChannel {
+		if id, err := strconv.Atoi(idStr); err == nil {
+			channelIds = append(channelIds, id)
+		}
+	}
+	return channelIds
+}
+
+// relayWithChannel 转发到当前选中的渠道，统计渠道的在途请求数并记录本次结果
+func relayWithChannel(c *gin.Context, relayMode int, channelId int, modelName string) *dto.OpenAIErrorWithStatusCode {
+	model.IncreaseChannelInFlight(channelId)
+	defer model.DecreaseChannelInFlight(channelId)
+	span := common.StartGinSpan(c, "relay.channel", common.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("channel.id", channelId)
+	span.SetAttribute("channel.name", c.GetString("channel_name"))
+	span.SetAttribute("channel.type", c.GetInt("channel_type"))
+	span.SetAttribute("model", modelName)
+	startTime := time.Now()
+	openaiErr := relayHandler(c, relayMode)
+	// 命中响应缓存时没有请求上游，不计入渠道的熔断与延迟统计
+	if !c.GetBool("response_cache_hit") {
+		recordChannelResult(channelId, modelName, openaiErr, startTime)
+	}
+	recordRelayMetrics(c, channelId, modelName, openaiErr, startTime)
+	if openaiErr != nil {
+		span.SetAttribute("http.status_code", openaiErr.StatusCode)
+		span.SetError(openaiErr.Error.Message)
+	}
+	return openaiErr
+}
+
+func recordRelayMetrics(c *gin.Context, channelId int, modelName string, openaiErr *dto.OpenAIErrorWithStatusCode, startTime time.Time) {
+	channel := strconv.Itoa(channelId)
+	group := c.GetString("group")
+	status := strconv.Itoa(http.StatusOK)
+	if openaiErr != nil {
+		status = strconv.Itoa(openaiErr.StatusCode)
+	}
+	common.MetricRelayRequests.WithLabelValues(modelName, channel, group, status).Inc()
+	common.MetricRelayDuration.WithLabelValues(modelName, channel, group).Observe(time.Since(startTime).Seconds())
+}
+
+// recordChannelResult 将真实请求结果计入渠道熔断统计，仅上游故障类错误计为失败
+func recordChannelResult(channelId int, modelName string, openaiErr *dto.OpenAIErrorWithStatusCode, startTime time.Time) {
+	failed := false
+	if openaiErr != nil && !openaiErr.LocalError {
+		failed = openaiErr.StatusCode >= http.StatusInternalServerError ||
+			openaiErr.StatusCode == http.StatusTooManyRequests ||
+			openaiErr.StatusCode == http.StatusRequestTimeout
+	}
+	model.RecordChannelResult(channelId, modelName, failed, time.Since(startTime))
+}
+
+// channelKey 为多密钥渠道本次使用的密钥，单密钥渠道为空
+func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, channelKey string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
 	common.LogError(c.Request.Context(), fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelId, err.StatusCode, err.Error.Message))
+	common.MetricChannelErrors.WithLabelValues(strconv.Itoa(channelId), strconv.Itoa(err.StatusCode)).Inc()
+	if channelKey != "" {
+		if err.StatusCode == http.StatusTooManyRequests {
+			model.CooldownChannelKey(channelKey)
+		}
+		if service.ShouldDisableChannel(channelType, err) && autoBan {
+			common.MetricChannelAutoDisabled.WithLabelValues(strconv.Itoa(channelId)).Inc()
+			service.DisableChannelKey(channelId, channelName, channelKey, err.Error.Message)
+		}
+		return
+	}
 	if service.ShouldDisableChannel(channelType, err) && autoBan {
+		common.MetricChannelAutoDisabled.WithLabelValues(strconv.Itoa(channelId)).Inc()
 		service.DisableChannel(channelId, channelName, err.Error.Message)
 	}
 }
 
+func getMultiKeyChannelKey(c *gin.Context) string {
+	if !c.GetBool("channel_multi_key") {
+		return ""
+	}
+	return c.GetString("channel_key")
+}
+
 func RelayMidjourney(c *gin.Context) {
 	relayMode := c.GetInt("relay_mode")
 	var err *dto.MidjourneyResponse
@@ -206,9 +408,9 @@ func RelayTask(c *gin.Context) {
 		retryTimes = 0
 	}
 	for i := 0; shouldRetryTaskRelay(c, channelId, taskErr, retryTimes) && i < retryTimes; i++ {
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i)
+		channel, err := model.CacheGetNextSatisfiedChannel(c.GetInt("id"), group, originalModel, getUsedChannelIds(c))
 		if err != nil {
-			common.LogError(c.Request.Context(), fmt.Sprintf("CacheGetRandomSatisfiedChannel failed: %s", err.Error()))
+			common.LogError(c.Request.Context(), fmt.Sprintf("CacheGetNextSatisfiedChannel failed: %s", err.Error()))
 			break
 		}
 		channelId = channel.Id
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldFallbackModel(t *testing.T) {
+	upstreamErr := func(statusCode int, code string) *dto.OpenAIErrorWithStatusCode {
+		return service.OpenAIErrorWrapper(errors.New("upstream error"), code, statusCode)
+	}
+	localErr := func(statusCode int, code string) *dto.OpenAIErrorWithStatusCode {
+		return service.OpenAIErrorWrapperLocal(errors.New("local error"), code, statusCode)
+	}
+	for _, tc := range []struct {
+		name     string
+		err      *dto.OpenAIErrorWithStatusCode
+		fallback bool
+	}{
+		{"no error", nil, false},
+		{"upstream server error", upstreamErr(http.StatusInternalServerError, "server_error"), true},
+		{"upstream rate limited", upstreamErr(http.StatusTooManyRequests, "rate_limit_exceeded"), true},
+		{"upstream account quota", upstreamErr(http.StatusForbidden, "insufficient_quota"), true},
+		{"upstream timeout", upstreamErr(http.StatusGatewayTimeout, "timeout"), false},
+		{"bad request", upstreamErr(http.StatusBadRequest, "invalid_request_error"), false},
+		{"channel concurrency", localErr(http.StatusServiceUnavailable, "channel_concurrency_limited"), true},
+		// 本地的额度错误换模型也无法解决
+		{"user quota", localErr(http.StatusForbidden, "insufficient_user_quota"), false},
+		{"token quota", localErr(http.StatusForbidden, "pre_consume_token_quota_failed"), false},
+		{"local server error", localErr(http.StatusInternalServerError, "count_token_messages_failed"), false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		assert.Equal(t, tc.fallback, shouldFallbackModel(c, relayconstant.RelayModeChatCompletions, tc.err), tc.name)
+	}
+
+	// 非对话类请求与指定渠道的请求不回退
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.False(t, shouldFallbackModel(c, relayconstant.RelayModeEmbeddings, upstreamErr(http.StatusInternalServerError, "server_error")))
+	c.Set("specific_channel_id", "1")
+	assert.False(t, shouldFallbackModel(c, relayconstant.RelayModeChatCompletions, upstreamErr(http.StatusInternalServerError, "server_error")))
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSemanticCacheChannel 优先使用配置的语义缓存渠道，未配置时从 default 分组中选择支持该模型的渠道
+func getSemanticCacheChannel(modelName string) (*model.Channel, error) {
+	if constant.SemanticCacheChannelId != 0 {
+		channel, err := model.GetChannelById(constant.SemanticCacheChannelId, true)
+		if err != nil {
+			return nil, err
+		}
+		if channel.Status != common.ChannelStatusEnabled {
+			return nil, fmt.Errorf("semantic cache channel #%d is disabled", channel.Id)
+		}
+		return channel, nil
+	}
+	channel, err := model.CacheGetRandomSatisfiedChannel(0, "default", modelName, 0)
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, fmt.Errorf("no available channel for model %s", modelName)
+	}
+	return channel, nil
+}
+
+// GetSemanticCacheEmbedding 直接通过渠道的适配器调用 embeddings 模型计算语义缓存向量，
+// 与渠道测试一样不经过路由，不使用用户的令牌，不计入用户的速率限制与用量
+func GetSemanticCacheEmbedding(ctx context.Context, text string) ([]float64, error) {
+	modelName := constant.SemanticCacheEmbeddingModel
+	channel, err := getSemanticCacheChannel(modelName)
+	if err != nil {
+		return nil, err
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: "/v1/embeddings"},
+		Header: make(http.Header),
+	}).WithContext(ctx)
+	c.Request.Header.Set("Content-Type", "application/json")
+	middleware.SetupContextForSelectedChannelKey(c, channel, modelName, channel.SelectKey())
+
+	meta := relaycommon.GenRelayInfo(c)
+	apiType, _ := relayconstant.ChannelType2APIType(channel.Type)
+	adaptor := relay.GetAdaptor(apiType)
+	if adaptor == nil {
+		return nil, fmt.Errorf("invalid api type: %d, adaptor is nil", apiType)
+	}
+	upstreamModel := modelName
+	if modelMapping := channel.GetModelMapping(); modelMapping != "" && modelMapping != "{}" {
+		modelMap := make(map[string]string)
+		if err = json.Unmarshal([]byte(modelMapping), &modelMap); err != nil {
+			return nil, err
+		}
+		if modelMap[modelName] != "" {
+			upstreamModel = modelMap[modelName]
+		}
+	}
+	meta.UpstreamModelName = upstreamModel
+	adaptor.Init(meta)
+
+	convertedRequest, err := adaptor.ConvertRequest(c, meta, &dto.GeneralOpenAIRequest{Model: upstreamModel, Input: text})
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(jsonData))
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil && resp.StatusCode != http.StatusOK {
+		relayErr := service.RelayErrorHandler(resp)
+		return nil, fmt.Errorf("embedding request failed with status code %d: %s", resp.StatusCode, relayErr.Error.Message)
+	}
+	if _, respErr := adaptor.DoResponse(c, resp, meta); respErr != nil {
+		return nil, fmt.Errorf("%s", respErr.Error.Message)
+	}
+	var response dto.OpenAIEmbeddingResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, errors.New("embedding response is empty")
+	}
+	return response.Data[0].Embedding, nil
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/model/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSemanticCacheEmbedding(t *testing.T) {
+	asserts := assert.New(t)
+	embeddingModel, channelId := constant.SemanticCacheEmbeddingModel, constant.SemanticCacheChannelId
+	t.Cleanup(func() {
+		constant.SemanticCacheEmbeddingModel, constant.SemanticCacheChannelId = embeddingModel, channelId
+	})
+	constant.SemanticCacheEmbeddingModel = "text-embedding-3-small"
+	model.DB = testutil.SetupDB(t, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+
+	var requests []map[string]any
+	var authorizations []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asserts.Equal("/v1/embeddings", r.URL.Path)
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		var request map[string]any
+		body, _ := io.ReadAll(r.Body)
+		asserts.NoError(json.Unmarshal(body, &request))
+		requests = append(requests, request)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],"model":"text-embedding-3-small","usage":{"prompt_tokens":3,"total_tokens":3}}`))
+	}))
+	defer upstream.Close()
+	baseURL := upstream.URL
+	mapping := `{"text-embedding-3-small": "embed-small"}`
+	channel := &model.Channel{Type: common.ChannelTypeOpenAI, Name: "embedding", Key: "sk-system", Status: common.ChannelStatusEnabled,
+		BaseURL: &baseURL, Models: "text-embedding-3-small", Group: "default", ModelMapping: &mapping}
+	asserts.NoError(channel.Insert())
+
+	// 使用配置的渠道与渠道密钥，直接请求上游，不经过路由
+	constant.SemanticCacheChannelId = channel.Id
+	embedding, err := GetSemanticCacheEmbedding(context.Background(), "user: hello\n")
+	if asserts.NoError(err) {
+		asserts.Equal([]float64{0.1, 0.2}, embedding)
+	}
+	asserts.Equal([]string{"Bearer sk-system"}, authorizations)
+	if asserts.Len(requests, 1) {
+		asserts.Equal("embed-small", requests[0]["model"])
+		asserts.Equal("user: hello\n", requests[0]["input"])
+	}
+
+	// 未配置渠道时从 default 分组中选择
+	constant.SemanticCacheChannelId = 0
+	_, err = GetSemanticCacheEmbedding(context.Background(), "user: hello\n")
+	asserts.NoError(err)
+	asserts.Len(requests, 2)
+
+	// 配置的渠道被禁用时不再调用
+	constant.SemanticCacheChannelId = channel.Id
+	model.UpdateChannelStatusById(channel.Id, common.ChannelStatusManuallyDisabled, "")
+	_, err = GetSemanticCacheEmbedding(context.Background(), "user: hello\n")
+	asserts.Error(err)
+	asserts.Len(requests, 2)
+}
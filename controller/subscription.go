@@ -0,0 +1,371 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+)
+
+type SubscriptionPurchaseRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"` // balance 为使用钱包余额，其余同在线充值
+	AutoRenew     bool   `json:"auto_renew"`     // 支持周期扣款的支付渠道（Stripe）由支付渠道自动续订，其余到期时从钱包余额续订
+}
+
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func GetSelfSubscription(c *gin.Context) {
+	userId := c.GetInt("id")
+	subscription, err := model.GetActiveUserSubscription(userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"message": "",
+				"data":    nil,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan, _ := model.GetSubscriptionPlanById(subscription.PlanId)
+	usages, _ := model.GetSubscriptionModelUsages(subscription.Id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"subscription": subscription,
+			"plan":         plan,
+			"model_usages": usages,
+		},
+	})
+}
+
+func GetSelfSubscriptionHistory(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	subscriptions, err := model.GetUserSubscriptions(c.GetInt("id"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscriptions,
+	})
+}
+
+func UpdateSelfSubscriptionAutoRenew(c *gin.Context) {
+	var req struct {
+		AutoRenew bool `json:"auto_renew"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	subscription, err := model.GetActiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "当前没有生效的订阅",
+		})
+		return
+	}
+	if err = service.UpdateSubscriptionAutoRenew(subscription, req.AutoRenew); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
+
+func PurchaseSubscription(c *gin.Context) {
+	var req SubscriptionPurchaseRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	userId := c.GetInt("id")
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "套餐不存在或已下架",
+		})
+		return
+	}
+	price, credit, err := service.CalculateSubscriptionPrice(userId, plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	tradeNo := fmt.Sprintf("S%s%d", common.GetRandomString(6), time.Now().Unix())
+	order := &model.SubscriptionOrder{
+		UserId:        userId,
+		PlanId:        plan.Id,
+		Money:         price,
+		TradeNo:       tradeNo,
+		PaymentMethod: req.PaymentMethod,
+		AutoRenew:     req.AutoRenew,
+		Status:        "pending",
+		CreateTime:    time.Now().Unix(),
+	}
+	if price < 0.01 || req.PaymentMethod == "balance" {
+		// 使用钱包余额支付，或升级抵扣后无需支付
+		purchaseSubscriptionWithBalance(c, order, credit)
+		return
+	}
+	provider := service.GetPaymentProviderByMethod(req.PaymentMethod)
+	if !provider.Enabled() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "当前管理员未配置支付信息",
+		})
+		return
+	}
+	// 套餐价格以易支付币种计价，其他支付渠道按各自单价换算
+	order.Money = service.ConvertPaymentMoney(price, provider)
+	order.PaymentMethod = provider.Name()
+	paymentOrder := &service.PaymentOrder{
+		TradeNo:   tradeNo,
+		Title:     plan.Name,
+		Money:     order.Money,
+		Method:    req.PaymentMethod,
+		ReturnUrl: constant.ServerAddress + "/subscription",
+		NotifyUrl: service.GetCallbackAddress() + "/api/subscription/epay/notify",
+	}
+	if req.AutoRenew && service.GetRecurringPaymentProvider(provider.Name()) != nil {
+		// 由支付渠道按周期扣款，每期按套餐原价扣款
+		paymentOrder.Recurring = plan.Period
+		paymentOrder.RecurringMoney = service.ConvertPaymentMoney(plan.Price, provider)
+	}
+	result, err := provider.CreatePayment(paymentOrder)
+	if err != nil {
+		common.SysError("failed to create subscription payment: " + err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "拉起支付失败",
+		})
+		return
+	}
+	if err = order.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "创建订单失败",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "",
+		"data":     result.Params,
+		"url":      result.Url,
+		"price":    order.Money,
+		"currency": provider.Currency(),
+		"credit":   credit,
+	})
+}
+
+func purchaseSubscriptionWithBalance(c *gin.Context, order *model.SubscriptionOrder, credit float64) {
+	cost := service.SubscriptionMoneyToQuota(order.Money)
+	order.PaymentMethod = "balance"
+	if err := order.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "创建订单失败",
+		})
+		return
+	}
+	subscription, err := service.CompleteSubscriptionOrder(order, cost)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "开通订阅失败：" + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+		"price":   order.Money,
+		"credit":  credit,
+	})
+}
+
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	if plan.Name == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Period != model.SubscriptionPeriodWeek && plan.Period != model.SubscriptionPeriodMonth {
+		return errors.New("套餐周期只能为 week 或 month")
+	}
+	if plan.Price < 0 || plan.Quota < 0 {
+		return errors.New("套餐价格与额度不能小于 0")
+	}
+	if plan.ModelRequests != "" {
+		modelRequests := make(map[string]int)
+		if err := json.Unmarshal([]byte(plan.ModelRequests), &modelRequests); err != nil {
+			return errors.New("模型请求次数格式错误：" + err.Error())
+		}
+	}
+	if plan.UpgradeGroup != "" {
+		if _, ok := common.GroupRatio[plan.UpgradeGroup]; !ok {
+			return errors.New("升级分组不存在")
+		}
+	}
+	return nil
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan.Id = 0
+	plan.CreatedTime = common.GetTimestamp()
+	if err = plan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateSubscriptionPlan(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err = model.GetSubscriptionPlanById(plan.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteSubscriptionPlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"one-api/dto"
 	"one-api/model"
 	"one-api/relay"
+	"one-api/service"
 	"sort"
 	"strconv"
 	"time"
@@ -25,6 +26,7 @@ func UpdateTaskBulk() {
 	for {
 		time.Sleep(time.Duration(15) * time.Second)
 		common.SysLog("任务进度轮询开始")
+		startTime := time.Now()
 		ctx := context.TODO()
 		allTasks := model.GetAllUnFinishSyncTasks(500)
 		platformTask := make(map[constant.TaskPlatform][]*model.Task)
@@ -65,6 +67,7 @@ func UpdateTaskBulk() {
 			UpdateTaskByPlatform(platform, taskChannelM, taskM)
 		}
 		common.SysLog("任务进度轮询完成")
+		recordTaskPollerRun("task", startTime)
 	}
 }
 
@@ -83,7 +86,7 @@ func UpdateSunoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM
 	for channelId, taskIds := range taskChannelM {
 		err := updateSunoTaskAll(ctx, channelId, taskIds, taskM)
 		if err != nil {
-			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %d", channelId, err.Error()))
+			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %s", channelId, err.Error()))
 		}
 	}
 	return nil
@@ -111,7 +114,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 	if adaptor == nil {
 		return errors.New("adaptor not found")
 	}
-	resp, err := adaptor.FetchTask(*channel.BaseURL, channel.Key, map[string]any{
+	resp, err := adaptor.FetchTask(*channel.BaseURL, channel.SelectKey(), map[string]any{
 		"ids": taskIds,
 	})
 	if err != nil {
@@ -135,7 +138,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		return err
 	}
 	if !responseItems.IsSuccess() {
-		common.SysLog(fmt.Sprintf("渠道 #%d 未完成的任务有: %d, 成功获取到任务数: %d", channelId, len(taskIds), string(responseBody)))
+		common.SysLog(fmt.Sprintf("渠道 #%d 未完成的任务有: %d, 成功获取到任务数: %s", channelId, len(taskIds), string(responseBody)))
 		return err
 	}
 
@@ -153,6 +156,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		if responseItem.FailReason != "" || task.Status == model.TaskStatusFailure {
 			common.LogInfo(ctx, task.TaskID+" 构建失败，"+task.FailReason)
 			task.Progress = "100%"
+			service.NotifyTaskFailed(string(task.Platform), task.TaskID, task.UserId, task.FailReason)
 			err = model.CacheUpdateUserQuota(task.UserId)
 			if err != nil {
 				common.LogError(ctx, "error update user quota cache: "+err.Error())
@@ -282,3 +286,8 @@ func GetUserTask(c *gin.Context) {
 		"data":    logs,
 	})
 }
+
+func recordTaskPollerRun(task string, startTime time.Time) {
+	common.MetricTaskPollerRuns.WithLabelValues(task).Inc()
+	common.MetricTaskPollerDuration.WithLabelValues(task).Observe(time.Since(startTime).Seconds())
+}
@@ -134,6 +134,9 @@ func AddToken(c *gin.Context) {
 		UnlimitedQuota:     token.UnlimitedQuota,
 		ModelLimitsEnabled: token.ModelLimitsEnabled,
 		ModelLimits:        token.ModelLimits,
+		RpmLimit:           token.RpmLimit,
+		TpmLimit:           token.TpmLimit,
+		RpdLimit:           token.RpdLimit,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -221,6 +224,9 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.ModelLimitsEnabled = token.ModelLimitsEnabled
 		cleanToken.ModelLimits = token.ModelLimits
+		cleanToken.RpmLimit = token.RpmLimit
+		cleanToken.TpmLimit = token.TpmLimit
+		cleanToken.RpdLimit = token.RpdLimit
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -237,3 +243,38 @@ func UpdateToken(c *gin.Context) {
 	})
 	return
 }
+
+// UpdateTokenPayloadCapture 开启或关闭令牌的完整内容保存，仅限管理员操作，用户自己无法修改
+func UpdateTokenPayloadCapture(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var req struct {
+		PayloadCapture bool `json:"payload_capture"`
+	}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = model.UpdateTokenPayloadCapture(id, req.PayloadCapture)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenPayloadCaptureIsAdminOnly(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+	model.DB = testutil.SetupDB(t, &model.Token{})
+	token := &model.Token{UserId: 1, Key: "key", Name: "test", Status: common.TokenStatusEnabled, ExpiredTime: -1}
+	asserts.NoError(token.Insert())
+
+	// 用户修改令牌时提交的 payload_capture 被忽略
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("id", 1)
+	c.Request = httptest.NewRequest("PUT", "/api/token/", strings.NewReader(`{"id":1,"name":"test","expired_time":-1,"payload_capture":true}`))
+	UpdateToken(c)
+	saved, err := model.GetTokenById(token.Id)
+	asserts.NoError(err)
+	asserts.False(saved.PayloadCapture)
+
+	// 管理员接口可以开启
+	recorder := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Request = httptest.NewRequest("PUT", "/api/token/1/payload_capture", strings.NewReader(`{"payload_capture":true}`))
+	UpdateTokenPayloadCapture(c)
+	asserts.Contains(recorder.Body.String(), `"success":true`)
+	saved, err = model.GetTokenById(token.Id)
+	asserts.NoError(err)
+	asserts.True(saved.PayloadCapture)
+	asserts.Equal("test", saved.Name)
+}
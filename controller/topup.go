@@ -2,11 +2,9 @@ package controller
 
 import (
 	"fmt"
-	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
 	"log"
-	"net/url"
+	"net/http"
 	"one-api/common"
 	"one-api/constant"
 	"one-api/model"
@@ -23,25 +21,12 @@ type EpayRequest struct {
 }
 
 type AmountRequest struct {
-	Amount    int    `json:"amount"`
-	TopUpCode string `json:"top_up_code"`
-}
-
-func GetEpayClient() *epay.Client {
-	if constant.PayAddress == "" || constant.EpayId == "" || constant.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClient(&epay.Config{
-		PartnerID: constant.EpayId,
-		Key:       constant.EpayKey,
-	}, constant.PayAddress)
-	if err != nil {
-		return nil
-	}
-	return withUrl
+	Amount        int    `json:"amount"`
+	PaymentMethod string `json:"payment_method"`
+	TopUpCode     string `json:"top_up_code"`
 }
 
-func getPayMoney(amount float64, user model.User) float64 {
+func getPayMoney(amount float64, user model.User, provider service.PaymentProvider) float64 {
 	if !common.DisplayInCurrencyEnabled {
 		amount = amount / common.QuotaPerUnit
 	}
@@ -50,7 +35,7 @@ func getPayMoney(amount float64, user model.User) float64 {
 	if topupGroupRatio == 0 {
 		topupGroupRatio = 1
 	}
-	payMoney := amount * constant.Price * topupGroupRatio
+	payMoney := amount * provider.UnitPrice() * topupGroupRatio
 	return payMoney
 }
 
@@ -73,42 +58,35 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
 		return
 	}
+	provider := service.GetPaymentProviderByMethod(req.PaymentMethod)
+	if !provider.Enabled() {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
 
 	id := c.GetInt("id")
 	user, _ := model.GetUserById(id, false)
-	payMoney := getPayMoney(float64(req.Amount), *user)
+	payMoney := getPayMoney(float64(req.Amount), *user, provider)
 	if payMoney < 0.01 {
 		c.JSON(200, gin.H{"message": "error", "data": "充值金额过低"})
 		return
 	}
 
-	var payType epay.PurchaseType
-	if req.PaymentMethod == "zfb" {
-		payType = epay.Alipay
-	}
 	if req.PaymentMethod == "wx" {
 		req.PaymentMethod = "wxpay"
-		payType = epay.WechatPay
 	}
 	callBackAddress := service.GetCallbackAddress()
-	returnUrl, _ := url.Parse(constant.ServerAddress + "/log")
-	notifyUrl, _ := url.Parse(callBackAddress + "/api/user/epay/notify")
 	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
-	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           payType,
-		ServiceTradeNo: "A" + tradeNo,
-		Name:           "B" + tradeNo,
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
+	result, err := provider.CreatePayment(&service.PaymentOrder{
+		TradeNo:   "A" + tradeNo,
+		Title:     "B" + tradeNo,
+		Money:     payMoney,
+		Method:    req.PaymentMethod,
+		ReturnUrl: constant.ServerAddress + "/log",
+		NotifyUrl: callBackAddress + "/api/user/epay/notify",
 	})
 	if err != nil {
+		common.SysError("failed to create payment: " + err.Error())
 		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
 		return
 	}
@@ -117,19 +95,21 @@ func RequestEpay(c *gin.Context) {
 		amount = amount / int(common.QuotaPerUnit)
 	}
 	topUp := &model.TopUp{
-		UserId:     id,
-		Amount:     amount,
-		Money:      payMoney,
-		TradeNo:    "A" + tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
+		UserId:          id,
+		Amount:          amount,
+		Money:           payMoney,
+		TradeNo:         "A" + tradeNo,
+		CreateTime:      time.Now().Unix(),
+		Status:          "pending",
+		PaymentProvider: provider.Name(),
+		Currency:        provider.Currency(),
 	}
 	err = topUp.Insert()
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	c.JSON(200, gin.H{"message": "success", "data": result.Params, "url": result.Url})
 }
 
 // tradeNo lock
@@ -160,63 +140,54 @@ func UnlockOrder(tradeNo string) {
 }
 
 func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
+	provider := service.GetPaymentProvider(service.PaymentProviderEpay)
+	notify, err := provider.ParseNotify(c)
+	if err != nil {
+		log.Println(err.Error())
+		_, err = c.Writer.Write([]byte("fail"))
 		if err != nil {
 			log.Println("易支付回调写入失败")
-			return
 		}
+		return
 	}
-	verifyInfo, err := client.Verify(params)
-	if err == nil && verifyInfo.VerifyStatus {
-		_, err := c.Writer.Write([]byte("success"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-	} else {
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-		log.Println("易支付回调签名验证失败")
+	_, err = c.Writer.Write([]byte("success"))
+	if err != nil {
+		log.Println("易支付回调写入失败")
+	}
+	if notify.Event != service.PaymentEventPaid {
+		log.Printf("易支付异常回调: %v", notify)
 		return
 	}
+	LockOrder(notify.TradeNo)
+	defer UnlockOrder(notify.TradeNo)
+	if err = service.HandlePaymentNotify(provider, notify); err != nil {
+		log.Printf("易支付回调处理订单失败: %v, %s", notify, err.Error())
+	}
+}
 
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
+// StripeWebhook 处理 Stripe webhook，返回非 2xx 时 Stripe 会重试
+func StripeWebhook(c *gin.Context) {
+	provider := service.GetPaymentProvider(service.PaymentProviderStripe)
+	notify, err := provider.ParseNotify(c)
+	if err != nil {
+		common.SysError("invalid stripe webhook: " + err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if notify.Event != service.PaymentEventIgnored {
+		lockKey := notify.TradeNo
+		if lockKey == "" {
+			lockKey = notify.ProviderTradeNo
 		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit))
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(topUp.Amount*int(common.QuotaPerUnit)), topUp.Money))
+		LockOrder(lockKey)
+		defer UnlockOrder(lockKey)
+		if err = service.HandlePaymentNotify(provider, notify); err != nil {
+			common.SysError(fmt.Sprintf("failed to handle stripe webhook %v: %s", notify, err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
 	}
+	c.JSON(http.StatusOK, gin.H{"received": true})
 }
 
 func RequestAmount(c *gin.Context) {
@@ -233,7 +204,7 @@ func RequestAmount(c *gin.Context) {
 	}
 	id := c.GetInt("id")
 	user, _ := model.GetUserById(id, false)
-	payMoney := getPayMoney(float64(req.Amount), *user)
+	payMoney := getPayMoney(float64(req.Amount), *user, service.GetPaymentProviderByMethod(req.PaymentMethod))
 	if payMoney <= 0.01 {
 		c.JSON(200, gin.H{"message": "error", "data": "充值金额过低"})
 		return
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"strconv"
 	"sync"
 
@@ -814,6 +815,7 @@ func TopUp(c *gin.Context) {
 		})
 		return
 	}
+	service.NotifyTopUpCompleted(id, quota, "兑换码")
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+)
+
+func GetUserAlertSetting(c *gin.Context) {
+	setting, err := model.GetUserAlertSetting(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    setting,
+	})
+}
+
+func UpdateUserAlertSetting(c *gin.Context) {
+	var req model.UserAlertSetting
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.WebhookUrl != "" {
+		// 只允许公网地址，发送时还会在建立连接时再次检查
+		if err = common.ValidatePublicURL(c.Request.Context(), req.WebhookUrl); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "Webhook 地址不合法，仅支持公网的 http/https 地址",
+			})
+			return
+		}
+	}
+	if req.QuotaThreshold < 0 || req.DailyQuotaThreshold < 0 || req.TokenDailyQuotaThreshold < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "提醒阈值不能小于 0",
+		})
+		return
+	}
+	setting, err := model.GetUserAlertSetting(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	setting.EmailEnabled = req.EmailEnabled
+	setting.WebhookUrl = req.WebhookUrl
+	setting.WebhookSecret = req.WebhookSecret
+	setting.TelegramEnabled = req.TelegramEnabled
+	setting.QuotaThreshold = req.QuotaThreshold
+	setting.DailyQuotaThreshold = req.DailyQuotaThreshold
+	setting.TokenDailyQuotaThreshold = req.TokenDailyQuotaThreshold
+	setting.WeeklyDigestEnabled = req.WeeklyDigestEnabled
+	if err = setting.Save(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    setting,
+	})
+}
@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateUserAlertSettingRejectsInternalWebhook(t *testing.T) {
+	for _, webhookUrl := range []string{"http://127.0.0.1:3000/api/user/self", "http://169.254.169.254/latest/meta-data", "http://10.0.0.2/"} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Set("id", 1)
+		c.Request = httptest.NewRequest("PUT", "/api/user/self/alert", strings.NewReader(`{"webhook_url":"`+webhookUrl+`"}`))
+		UpdateUserAlertSetting(c)
+		assert.Contains(t, recorder.Body.String(), `"success":false`, webhookUrl)
+		assert.Contains(t, recorder.Body.String(), "Webhook", webhookUrl)
+	}
+}
@@ -82,7 +82,7 @@ type Message struct {
 
 type MediaMessage struct {
 	Type     string `json:"type"`
-	Text     string `json:"text"`
+	Text     string `json:"text,omitempty"`
 	ImageUrl any    `json:"image_url,omitempty"`
 }
 
@@ -96,6 +96,17 @@ const (
 	ContentTypeImageURL = "image_url"
 )
 
+func (m Message) ParseToolCalls() []ToolCall {
+	if m.ToolCalls == nil {
+		return nil
+	}
+	var toolCalls []ToolCall
+	if toolCallsBytes, err := json.Marshal(m.ToolCalls); err == nil {
+		_ = json.Unmarshal(toolCallsBytes, &toolCalls)
+	}
+	return toolCalls
+}
+
 func (m Message) StringContent() string {
 	var stringContent string
 	if err := json.Unmarshal(m.Content, &stringContent); err == nil {
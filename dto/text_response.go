@@ -130,4 +130,7 @@ type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// 提示缓存写入与读取的 token 数，不计入 PromptTokens，按各自的缓存倍率计费
+	CacheCreationTokens int `json:"-"`
+	CacheReadTokens     int `json:"-"`
 }
@@ -29,6 +29,7 @@ var buildFS embed.FS
 var indexPage []byte
 
 func main() {
+	common.InitEnv()
 	common.SetupLogger()
 	common.SysLog("New API " + common.Version + " started")
 	if os.Getenv("GIN_MODE") != "debug" {
@@ -73,6 +74,9 @@ func main() {
 		go model.SyncOptions(common.SyncFrequency)
 		go model.SyncChannelCache(common.SyncFrequency)
 	}
+	if common.RedisEnabled && constant.CircuitBreakerEnabled && constant.CircuitBreakerRedisEnabled {
+		go model.SyncCircuitBreakerFromRedis(5)
+	}
 
 	// 数据看板
 	go model.UpdateQuotaData()
@@ -99,6 +103,17 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			service.PayloadCaptureRetentionSweeper()
+		})
+		gopool.Go(func() {
+			service.UserWeeklyDigestTask()
+		})
+		gopool.Go(func() {
+			service.SubscriptionTask()
+		})
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
@@ -115,6 +130,8 @@ func main() {
 
 	service.InitTokenEncoders()
 
+	common.InitTracing()
+
 	// Initialize HTTP server
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
@@ -129,12 +146,21 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
 	middleware.SetUpLogger(server)
 	// Initialize session store
 	store := cookie.NewStore([]byte(common.SessionSecret))
 	server.Use(sessions.Sessions("session", store))
 
 	router.SetRouter(server, buildFS, indexPage)
+	// 语义缓存直接通过渠道计算向量，不经过路由
+	service.SemanticCacheEmbedder = controller.GetSemanticCacheEmbedding
+	if common.IsMasterNode {
+		// 批处理任务的请求经由完整的路由执行
+		gopool.Go(func() {
+			controller.UpdateBatchBulk(server)
+		})
+	}
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
@@ -94,6 +94,7 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
+	common.SetLogField(c, "user_id", id)
 	c.Next()
 }
 
@@ -128,7 +129,21 @@ func RootAuth() func(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := common.StartGinSpan(c, "TokenAuth", common.SpanKindInternal)
+		// 认证失败提前返回时结束 span，End 可重复调用
+		defer span.End()
 		key := c.Request.Header.Get("Authorization")
+		if key == "" {
+			// anthropic sdk
+			key = c.Request.Header.Get("x-api-key")
+		}
+		if key == "" && strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+			// google sdk
+			key = c.Request.Header.Get("x-goog-api-key")
+			if key == "" {
+				key = c.Query("key")
+			}
+		}
 		parts := make([]string, 0)
 		key = strings.TrimPrefix(key, "Bearer ")
 		if key == "" || key == "midjourney-proxy" {
@@ -159,7 +174,10 @@ func TokenAuth() func(c *gin.Context) {
 		c.Set("id", token.UserId)
 		c.Set("token_id", token.Id)
 		c.Set("token_name", token.Name)
+		c.Set("token_key", token.Key)
 		c.Set("token_unlimited_quota", token.UnlimitedQuota)
+		c.Set("token_rate_limit", token.GetRateLimit())
+		c.Set("token_payload_capture", token.PayloadCapture)
 		if !token.UnlimitedQuota {
 			c.Set("token_quota", token.RemainQuota)
 		}
@@ -177,6 +195,12 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
+		span.SetAttribute("user.id", token.UserId)
+		span.SetAttribute("token.id", token.Id)
+		common.SetLogField(c, "user_id", token.UserId)
+		common.SetLogField(c, "token_id", token.Id)
+		// 先结束 span 再处理后续请求，span 只统计认证本身的耗时
+		span.End()
 		c.Next()
 	}
 }
@@ -22,6 +22,9 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := common.StartGinSpan(c, "Distribute", common.SpanKindInternal)
+		// 请求被拒绝提前返回时结束 span，End 可重复调用
+		defer span.End()
 		userId := c.GetInt("id")
 		var channel *model.Channel
 		channelId, ok := c.Get("specific_channel_id")
@@ -32,6 +35,9 @@ func Distribute() func(c *gin.Context) {
 		}
 		userGroup, _ := model.CacheGetUserGroup(userId)
 		c.Set("group", userGroup)
+		if !checkRequestRateLimit(c) {
+			return
+		}
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -72,7 +78,7 @@ func Distribute() func(c *gin.Context) {
 			}
 
 			if shouldSelectChannel {
-				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model, 0)
+				channel, err = model.CacheGetRandomSatisfiedChannel(userId, userGroup, modelRequest.Model, 0)
 				if err != nil {
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
@@ -91,6 +97,14 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		span.SetAttribute("group", userGroup)
+		span.SetAttribute("model", modelRequest.Model)
+		if channel != nil {
+			span.SetAttribute("channel.id", channel.Id)
+			span.SetAttribute("channel.type", channel.Type)
+		}
+		// 先结束 span 再处理后续请求，span 只统计渠道选择本身的耗时
+		span.End()
 		c.Next()
 	}
 }
@@ -158,6 +172,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 			modelRequest.Model = c.Param("model")
 		}
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		// gemini 原生请求的模型在路径中
+		modelRequest.Model = strings.Split(c.Param("model"), ":")[0]
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
 		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e")
 	}
@@ -180,10 +198,21 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 }
 
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
+	key := ""
+	if channel != nil {
+		key = channel.SelectKey()
+	}
+	SetupContextForSelectedChannelKey(c, channel, modelName, key)
+}
+
+// SetupContextForSelectedChannelKey 使用指定的密钥设置渠道上下文，渠道测试需要测试并处置确定的密钥
+func SetupContextForSelectedChannelKey(c *gin.Context, channel *model.Channel, modelName string, key string) {
 	c.Set("original_model", modelName) // for retry
+	common.SetLogField(c, "model", modelName)
 	if channel == nil {
 		return
 	}
+	common.SetLogField(c, "channel_id", channel.Id)
 	c.Set("channel", channel.Type)
 	c.Set("channel_id", channel.Id)
 	c.Set("channel_name", channel.Name)
@@ -199,12 +228,17 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("auto_ban", ban)
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	c.Set("channel_max_concurrency", channel.GetMaxConcurrency())
+	c.Set("channel_model_concurrency", channel.GetModelConcurrency(modelName))
+	c.Set("channel_key", key)
+	c.Set("channel_multi_key", channel.IsMultiKey())
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set("base_url", channel.GetBaseURL())
 	// TODO: api_version统一
 	switch channel.Type {
 	case common.ChannelTypeAzure:
 		c.Set("api_version", channel.Other)
+		c.Set("channel_other_info", channel.OtherInfo)
 	case common.ChannelTypeXunfei:
 		c.Set("api_version", channel.Other)
 	case common.ChannelTypeGemini:
@@ -213,5 +247,9 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 		c.Set("plugin", channel.Other)
 	case common.ChannelCloudflare:
 		c.Set("api_version", channel.Other)
+	case common.ChannelTypeVertexAi:
+		c.Set("api_version", channel.Other)
+	case common.ChannelTypeAws:
+		c.Set("channel_other_info", channel.OtherInfo)
 	}
 }
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"one-api/common"
+	"time"
 )
 
 func SetUpLogger(server *gin.Engine) {
@@ -12,6 +14,9 @@ func SetUpLogger(server *gin.Engine) {
 		if param.Keys != nil {
 			requestID = param.Keys[common.RequestIdKey].(string)
 		}
+		if common.LogJsonFormat {
+			return formatJsonAccessLog(param, requestID)
+		}
 		return fmt.Sprintf("[GIN] %s | %s | %3d | %13v | %15s | %7s %s\n",
 			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
 			requestID,
@@ -23,3 +28,30 @@ func SetUpLogger(server *gin.Engine) {
 		)
 	}))
 }
+
+func formatJsonAccessLog(param gin.LogFormatterParams, requestID string) string {
+	entry := map[string]interface{}{
+		"time":       param.TimeStamp.Format(time.RFC3339Nano),
+		"level":      "info",
+		"source":     "gin",
+		"request_id": requestID,
+		"status":     param.StatusCode,
+		"latency_ms": param.Latency.Milliseconds(),
+		"client_ip":  param.ClientIP,
+		"method":     param.Method,
+		"path":       param.Path,
+	}
+	for key, field := range map[string]string{"id": "user_id", "token_id": "token_id", "channel_id": "channel_id", "original_model": "model"} {
+		if value, ok := param.Keys[key]; ok {
+			entry[field] = value
+		}
+	}
+	if param.ErrorMessage != "" {
+		entry["error"] = param.ErrorMessage
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return ""
+	}
+	return string(data) + "\n"
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatJsonAccessLog(t *testing.T) {
+	asserts := assert.New(t)
+	line := formatJsonAccessLog(gin.LogFormatterParams{
+		TimeStamp:    time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+		StatusCode:   502,
+		Latency:      1500 * time.Millisecond,
+		ClientIP:     "1.2.3.4",
+		Method:       "POST",
+		Path:         "/v1/chat/completions",
+		ErrorMessage: "upstream error",
+		Keys: map[string]any{
+			common.RequestIdKey: "req-1",
+			"id":                1,
+			"token_id":          2,
+			"channel_id":        3,
+			"original_model":    "gpt-4o",
+		},
+	}, "req-1")
+	asserts.Equal(byte('\n'), line[len(line)-1])
+
+	var entry map[string]interface{}
+	asserts.NoError(json.Unmarshal([]byte(line), &entry))
+	asserts.Equal(map[string]interface{}{
+		"time":       "2026-10-18T10:00:00Z",
+		"level":      "info",
+		"source":     "gin",
+		"request_id": "req-1",
+		"status":     float64(502),
+		"latency_ms": float64(1500),
+		"client_ip":  "1.2.3.4",
+		"method":     "POST",
+		"path":       "/v1/chat/completions",
+		"user_id":    float64(1),
+		"token_id":   float64(2),
+		"channel_id": float64(3),
+		"model":      "gpt-4o",
+		"error":      "upstream error",
+	}, entry)
+
+	// 未认证的请求没有用户与渠道字段
+	line = formatJsonAccessLog(gin.LogFormatterParams{StatusCode: 401, Keys: map[string]any{}}, "req-2")
+	entry = nil
+	asserts.NoError(json.Unmarshal([]byte(line), &entry))
+	asserts.NotContains(entry, "user_id")
+	asserts.NotContains(entry, "channel_id")
+	asserts.NotContains(entry, "error")
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
+	"one-api/dto"
+	"one-api/service"
+	"strconv"
 	"time"
 )
 
@@ -82,6 +85,46 @@ func rateLimitFactory(maxRequestNum int, duration int64, mark string) func(c *gi
 	}
 }
 
+func setRateLimitHeaders(c *gin.Context, result *service.RateLimitResult) {
+	if result.Requests != nil {
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(result.Requests.Limit))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(result.Requests.Remaining))
+		c.Header("x-ratelimit-reset-requests", result.Requests.Reset.String())
+	}
+	if result.Tokens != nil {
+		c.Header("x-ratelimit-limit-tokens", strconv.Itoa(result.Tokens.Limit))
+		c.Header("x-ratelimit-remaining-tokens", strconv.Itoa(result.Tokens.Remaining))
+		c.Header("x-ratelimit-reset-tokens", result.Tokens.Reset.String())
+	}
+}
+
+// checkRequestRateLimit 检查令牌、用户、分组的请求频率与 token 用量限制，超出时返回 429
+func checkRequestRateLimit(c *gin.Context) bool {
+	result := service.CheckRateLimit(c)
+	setRateLimitHeaders(c, result)
+	if result.Exceeded == "" {
+		return true
+	}
+	var reset time.Duration
+	if result.Type == "tokens" {
+		reset = result.Tokens.Reset
+	} else {
+		reset = result.Requests.Reset
+	}
+	c.Header("Retry-After", strconv.Itoa(int(reset.Seconds())))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": dto.OpenAIError{
+			Message: common.MessageWithRequestId(result.Exceeded, c.GetString(common.RequestIdKey)),
+			Type:    result.Type,
+			Param:   "",
+			Code:    "rate_limit_exceeded",
+		},
+	})
+	c.Abort()
+	common.LogWarn(c.Request.Context(), fmt.Sprintf("user %d | %s", c.GetInt("id"), result.Exceeded))
+	return false
+}
+
 func GlobalWebRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(common.GlobalWebRateLimitNum, common.GlobalWebRateLimitDuration, "GW")
 }
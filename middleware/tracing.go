@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"one-api/common"
+)
+
+// Tracing 为每个请求创建根 span，如果请求带有 traceparent 则接入调用方的链路
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if !common.TracingEnabled || route == "" {
+			// 未匹配路由的请求（如前端静态文件）不追踪
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(common.ExtractTraceContext(c.Request.Context(), c.Request.Header))
+		span := common.StartGinSpan(c, fmt.Sprintf("%s %s", c.Request.Method, route), common.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", status)
+		span.SetAttribute("user.id", c.GetInt("id"))
+		span.SetAttribute("token.id", c.GetInt("token_id"))
+		span.SetAttribute("group", c.GetString("group"))
+		if status >= 500 {
+			span.SetError(fmt.Sprintf("status code %d", status))
+		}
+	}
+}
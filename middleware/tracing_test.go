@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider, propagator, enabled := otel.GetTracerProvider(), otel.GetTextMapPropagator(), common.TracingEnabled
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	common.TracingEnabled = true
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+		common.TracingEnabled = enabled
+	})
+	return recorder
+}
+
+func TestTracingMiddlewareSpans(t *testing.T) {
+	recorder := setupTracing(t)
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.Token{}, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+	asserts := assert.New(t)
+	asserts.NoError(model.DB.Create(&model.User{Id: 1, Username: "user", Password: "password", AccessToken: "access", Status: common.UserStatusEnabled, Group: "default"}).Error)
+	asserts.NoError(model.DB.Create(&model.Token{Id: 1, UserId: 1, Key: "testtoken", Name: "token", Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}).Error)
+	channel := &model.Channel{Id: 1, Name: "channel", Key: "sk-upstream", Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default"}
+	asserts.NoError(channel.Insert())
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Tracing())
+	engine.POST("/v1/chat/completions", TokenAuth(), Distribute(), func(c *gin.Context) {
+		_, span := common.StartSpan(c, "handler", common.SpanKindInternal)
+		time.Sleep(50 * time.Millisecond)
+		span.End()
+		upstream := httptest.NewRequest(http.MethodPost, "http://upstream/v1/chat/completions", nil)
+		common.InjectTraceContext(c, upstream.Header)
+		c.String(http.StatusOK, upstream.Header.Get("traceparent"))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-testtoken")
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	asserts.Equal(http.StatusOK, w.Code)
+
+	spans := recorder.Ended()
+	if !asserts.Len(spans, 4) {
+		return
+	}
+	auth, distribute, handler, root := spans[0], spans[1], spans[2], spans[3]
+	asserts.Equal("TokenAuth", auth.Name())
+	asserts.Equal("Distribute", distribute.Name())
+	asserts.Equal("handler", handler.Name())
+	asserts.Equal("POST /v1/chat/completions", root.Name())
+
+	// 接入调用方的链路
+	asserts.Equal("0af7651916cd43dd8448eb211c80319c", root.SpanContext().TraceID().String())
+	asserts.Equal("b7ad6b7169203331", root.Parent().SpanID().String())
+	// 中间件的 span 在 c.Next() 之前结束，与后续的处理同为根 span 的子节点
+	for _, span := range []sdktrace.ReadOnlySpan{auth, distribute, handler} {
+		asserts.Equal(root.SpanContext().SpanID(), span.Parent().SpanID(), span.Name())
+	}
+	asserts.False(auth.EndTime().After(distribute.StartTime()))
+	asserts.False(distribute.EndTime().After(handler.StartTime()))
+	// 中间件的耗时不包含后续处理的耗时
+	asserts.Less(auth.EndTime().Sub(auth.StartTime()), 50*time.Millisecond)
+	asserts.Less(distribute.EndTime().Sub(distribute.StartTime()), 50*time.Millisecond)
+	asserts.GreaterOrEqual(root.EndTime().Sub(root.StartTime()), 50*time.Millisecond)
+	asserts.Equal(codes.Unset, auth.Status().Code)
+	// 传递给上游的 traceparent 指向根 span
+	asserts.Contains(w.Body.String(), root.SpanContext().TraceID().String()+"-"+root.SpanContext().SpanID().String())
+}
+
+func TestTracingMiddlewareSpansOnAbort(t *testing.T) {
+	recorder := setupTracing(t)
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.Token{}, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+	asserts := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Tracing())
+	engine.POST("/v1/chat/completions", TokenAuth(), Distribute(), func(c *gin.Context) {
+		t.Error("handler should not be called")
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Authorization", "Bearer sk-invalid")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	asserts.Equal(http.StatusUnauthorized, w.Code)
+
+	// 认证失败时 span 同样会结束，并标记为错误
+	spans := recorder.Ended()
+	if !asserts.Len(spans, 2) {
+		return
+	}
+	auth, root := spans[0], spans[1]
+	asserts.Equal("TokenAuth", auth.Name())
+	asserts.Equal(root.SpanContext().SpanID(), auth.Parent().SpanID())
+	asserts.Equal(codes.Error, auth.Status().Code)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	enabled := common.TracingEnabled
+	common.TracingEnabled = false
+	t.Cleanup(func() {
+		common.TracingEnabled = enabled
+	})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	span := common.StartGinSpan(c, "auth", common.SpanKindInternal)
+	assert.Nil(t, span)
+	// span 为 nil 时各方法都是空操作
+	span.SetAttribute("key", "value")
+	span.SetError("error")
+	span.End()
+}
@@ -36,7 +36,7 @@ func GetEnabledModels() []string {
 	return models
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+func getPriority(group string, model string, retry int, excludeChannelIds []int) (int, error) {
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -45,7 +45,7 @@ func getPriority(group string, model string, retry int) (int, error) {
 	}
 
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := excludeChannelQuery(DB.Model(&Ability{}), excludeChannelIds).
 		Select("DISTINCT(priority)").
 		Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model).
 		Order("priority DESC").              // 按优先级降序排序
@@ -72,32 +72,44 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) *gorm.DB {
+// excludeChannelQuery 排除本次请求中已经尝试过的渠道
+func excludeChannelQuery(query *gorm.DB, excludeChannelIds []int) *gorm.DB {
+	if len(excludeChannelIds) == 0 {
+		return query
+	}
+	return query.Where("channel_id not in (?)", excludeChannelIds)
+}
+
+func getChannelQuery(group string, model string, retry int, excludeChannelIds []int) *gorm.DB {
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
 		groupCol = `"group"`
 		trueVal = "true"
 	}
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-	channelQuery := DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
+	maxPrioritySubQuery := excludeChannelQuery(DB.Model(&Ability{}), excludeChannelIds).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+	channelQuery := excludeChannelQuery(DB, excludeChannelIds).Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, model, retry, excludeChannelIds)
 		if err != nil {
 			common.SysError(fmt.Sprintf("Get priority failed: %s", err.Error()))
 		} else {
-			channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = ?", group, model, priority)
+			channelQuery = excludeChannelQuery(DB, excludeChannelIds).Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = ?", group, model, priority)
 		}
 	}
 
 	return channelQuery
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func GetRandomSatisfiedChannel(userId int, group string, model string, retry int) (*Channel, error) {
+	return getSatisfiedChannel(userId, group, model, retry, nil)
+}
+
+func getSatisfiedChannel(userId int, group string, model string, retry int, excludeChannelIds []int) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery := getChannelQuery(group, model, retry)
+	channelQuery := getChannelQuery(group, model, retry, excludeChannelIds)
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -106,28 +118,35 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	if len(abilities) > 0 {
-		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilities {
-			weightSum += ability_.Weight + 10
+	// 跳过熔断中的渠道，全部熔断时仍从原列表中选择
+	availableAbilities := make([]Ability, 0, len(abilities))
+	for _, ability_ := range abilities {
+		if IsChannelCircuitAvailable(ability_.ChannelId, model) {
+			availableAbilities = append(availableAbilities, ability_)
 		}
-		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
-			weight -= int(ability_.Weight) + 10
-			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
-			if weight <= 0 {
-				channel.Id = ability_.ChannelId
-				break
-			}
-		}
-	} else {
+	}
+	if len(availableAbilities) > 0 {
+		abilities = availableAbilities
+	}
+	if len(abilities) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	channelIds := make([]int, 0, len(abilities))
+	for _, ability_ := range abilities {
+		channelIds = append(channelIds, ability_.ChannelId)
+	}
+	var channels []*Channel
+	err = DB.Where("id in (?)", channelIds).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	channels = filterUnsaturatedChannels(channels, model)
+	channel := selectChannelByStrategy(userId, group, model, channels)
+	if channel == nil {
 		return nil, errors.New("channel not found")
 	}
-	err = DB.First(&channel, "id = ?", channel.Id).Error
-	return &channel, err
+	markChannelCircuitSelected(channel.Id, model)
+	return channel, nil
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"strings"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusFailed     = "failed"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+	BatchStatusExpired    = "expired"
+)
+
+// Batch 离线批处理任务，由网关逐行执行输入文件中的请求
+type Batch struct {
+	Id               int    `json:"-"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"-" gorm:"index"`
+	TokenId          int    `json:"-"`
+	Endpoint         string `json:"endpoint"`
+	InputFileId      string `json:"input_file_id"`
+	CompletionWindow string `json:"completion_window"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	OutputFileId     string `json:"output_file_id"`
+	ErrorFileId      string `json:"error_file_id"`
+	Errors           string `json:"-"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	InProgressAt     int64  `json:"in_progress_at"`
+	CompletedAt      int64  `json:"completed_at"`
+	FailedAt         int64  `json:"failed_at"`
+	CancelledAt      int64  `json:"cancelled_at"`
+	ExpiresAt        int64  `json:"expires_at"`
+	ExpiredAt        int64  `json:"expired_at"`
+	TotalCount       int    `json:"-"`
+	CompletedCount   int    `json:"-"`
+	FailedCount      int    `json:"-"`
+	Metadata         string `json:"-"`
+	ClientIp         string `json:"-" gorm:"type:varchar(64)"`
+	ResultFileIds    string `json:"-"` // 执行时写入的结果文件，逗号分隔，执行中断时据此清理未保存的块
+}
+
+func (batch *Batch) Insert() error {
+	return DB.Create(batch).Error
+}
+
+// updateBatchIfStatus 只在任务仍处于指定状态时更新，避免覆盖并发的取消等操作
+func updateBatchIfStatus(id int, statuses []string, values map[string]any) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status in ?", id, statuses).Updates(values)
+	return result.RowsAffected > 0, result.Error
+}
+
+// StartBatch 校验通过后开始执行并记录将要写入的结果文件，任务已被取消时返回 false
+func StartBatch(id int, totalCount int, resultFileIds []string) (bool, error) {
+	return updateBatchIfStatus(id, []string{BatchStatusValidating}, map[string]any{
+		"status":          BatchStatusInProgress,
+		"in_progress_at":  common.GetTimestamp(),
+		"total_count":     totalCount,
+		"result_file_ids": strings.Join(resultFileIds, ","),
+	})
+}
+
+func (batch *Batch) IsExpired() bool {
+	return batch.ExpiresAt > 0 && common.GetTimestamp() >= batch.ExpiresAt
+}
+
+func FailBatch(id int, message string) error {
+	_, err := updateBatchIfStatus(id, []string{BatchStatusValidating, BatchStatusInProgress}, map[string]any{
+		"status":    BatchStatusFailed,
+		"failed_at": common.GetTimestamp(),
+		"errors":    message,
+	})
+	return err
+}
+
+func UpdateBatchCounts(id int, completedCount int, failedCount int) error {
+	return DB.Model(&Batch{}).Where("id = ?", id).Updates(map[string]any{
+		"completed_count": completedCount,
+		"failed_count":    failedCount,
+	}).Error
+}
+
+// FinishBatch 执行结束后写入结果文件，执行期间被取消的任务标记为已取消
+func FinishBatch(batch *Batch) error {
+	values := map[string]any{
+		"output_file_id":  batch.OutputFileId,
+		"error_file_id":   batch.ErrorFileId,
+		"completed_count": batch.CompletedCount,
+		"failed_count":    batch.FailedCount,
+		"status":          BatchStatusCompleted,
+		"completed_at":    common.GetTimestamp(),
+	}
+	ok, err := updateBatchIfStatus(batch.Id, []string{BatchStatusInProgress}, values)
+	if err != nil || ok {
+		batch.Status = BatchStatusCompleted
+		return err
+	}
+	delete(values, "completed_at")
+	values["status"] = BatchStatusCancelled
+	values["cancelled_at"] = common.GetTimestamp()
+	batch.Status = BatchStatusCancelled
+	_, err = updateBatchIfStatus(batch.Id, []string{BatchStatusCancelling}, values)
+	return err
+}
+
+// ExpireBatch 超过完成时限的任务标记为已过期，已经完成的请求结果仍然保留
+func ExpireBatch(batch *Batch) error {
+	_, err := updateBatchIfStatus(batch.Id, []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusCancelling}, map[string]any{
+		"output_file_id":  batch.OutputFileId,
+		"error_file_id":   batch.ErrorFileId,
+		"completed_count": batch.CompletedCount,
+		"failed_count":    batch.FailedCount,
+		"status":          BatchStatusExpired,
+		"expired_at":      common.GetTimestamp(),
+	})
+	batch.Status = BatchStatusExpired
+	return err
+}
+
+func GetUserBatchById(batchId string, userId int) (*Batch, error) {
+	if batchId == "" {
+		return nil, errors.New("batch id 为空！")
+	}
+	var batch Batch
+	err := DB.Where("batch_id = ? and user_id = ?", batchId, userId).First(&batch).Error
+	return &batch, err
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Find(&status).Error
+	return status, err
+}
+
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var afterBatch Batch
+		if err := DB.Where("batch_id = ? and user_id = ?", after, userId).First(&afterBatch).Error; err == nil {
+			query = query.Where("id < ?", afterBatch.Id)
+		}
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+func GetValidatingBatches(limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status = ?", BatchStatusValidating).Order("id").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// FailInterruptedBatches 进程重启时执行中的任务无法断点续跑，为避免重复计费直接标记为失败，并删除未写完的结果文件
+func FailInterruptedBatches() error {
+	var interrupted []*Batch
+	err := DB.Where("status in ?", []string{BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).Find(&interrupted).Error
+	if err != nil {
+		return err
+	}
+	now := common.GetTimestamp()
+	err = DB.Model(&Batch{}).Where("status in ?", []string{BatchStatusInProgress, BatchStatusFinalizing}).
+		Updates(map[string]any{"status": BatchStatusFailed, "failed_at": now, "errors": "batch interrupted by server restart"}).Error
+	if err != nil {
+		return err
+	}
+	err = DB.Model(&Batch{}).Where("status = ?", BatchStatusCancelling).
+		Updates(map[string]any{"status": BatchStatusCancelled, "cancelled_at": now}).Error
+	if err != nil {
+		return err
+	}
+	var fileIds []string
+	for _, batch := range interrupted {
+		if batch.ResultFileIds != "" {
+			fileIds = append(fileIds, strings.Split(batch.ResultFileIds, ",")...)
+		}
+	}
+	return DeleteOrphanedFileChunks(fileIds)
+}
+
+var ErrBatchNotCancellable = errors.New("batch is not cancellable")
+
+func CancelUserBatch(batch *Batch) error {
+	// 尚未开始执行的任务直接取消，执行中的任务由执行方在处理完当前请求后结束
+	now := common.GetTimestamp()
+	ok, err := updateBatchIfStatus(batch.Id, []string{BatchStatusValidating}, map[string]any{
+		"status":       BatchStatusCancelled,
+		"cancelled_at": now,
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		batch.Status = BatchStatusCancelled
+		batch.CancelledAt = now
+		return nil
+	}
+	ok, err = updateBatchIfStatus(batch.Id, []string{BatchStatusInProgress}, map[string]any{
+		"status": BatchStatusCancelling,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBatchNotCancellable
+	}
+	batch.Status = BatchStatusCancelling
+	return nil
+}
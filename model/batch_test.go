@@ -0,0 +1,128 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/model/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestBatch(t *testing.T) *Batch {
+	batch := &Batch{BatchId: "batch_" + t.Name(), UserId: 1, Status: BatchStatusValidating}
+	assert.NoError(t, batch.Insert())
+	return batch
+}
+
+func getTestBatch(t *testing.T, batch *Batch) *Batch {
+	saved, err := GetUserBatchById(batch.BatchId, batch.UserId)
+	assert.NoError(t, err)
+	return saved
+}
+
+func TestCancelBeforeStart(t *testing.T) {
+	DB = testutil.SetupDB(t, &Batch{})
+	asserts := assert.New(t)
+	batch := createTestBatch(t)
+
+	asserts.NoError(CancelUserBatch(batch))
+	asserts.Equal(BatchStatusCancelled, batch.Status)
+	started, err := StartBatch(batch.Id, 10, nil)
+	asserts.NoError(err)
+	asserts.False(started)
+	asserts.Equal(BatchStatusCancelled, getTestBatch(t, batch).Status)
+}
+
+func TestCancelDuringExecution(t *testing.T) {
+	DB = testutil.SetupDB(t, &Batch{})
+	asserts := assert.New(t)
+	batch := createTestBatch(t)
+
+	started, err := StartBatch(batch.Id, 3, nil)
+	asserts.NoError(err)
+	asserts.True(started)
+	// 执行方持有的是开始前读到的对象，取消不应被其结果覆盖
+	cancelled := getTestBatch(t, batch)
+	asserts.NoError(CancelUserBatch(cancelled))
+	asserts.Equal(BatchStatusCancelling, cancelled.Status)
+
+	batch.CompletedCount = 1
+	batch.OutputFileId = "file-out"
+	asserts.NoError(FinishBatch(batch))
+	asserts.Equal(BatchStatusCancelled, batch.Status)
+	saved := getTestBatch(t, batch)
+	asserts.Equal(BatchStatusCancelled, saved.Status)
+	asserts.Equal("file-out", saved.OutputFileId)
+	asserts.Equal(1, saved.CompletedCount)
+	asserts.NotZero(saved.CancelledAt)
+	asserts.Zero(saved.CompletedAt)
+
+	asserts.ErrorIs(CancelUserBatch(saved), ErrBatchNotCancellable)
+}
+
+func TestFinishBatch(t *testing.T) {
+	DB = testutil.SetupDB(t, &Batch{})
+	asserts := assert.New(t)
+	batch := createTestBatch(t)
+
+	_, err := StartBatch(batch.Id, 2, nil)
+	asserts.NoError(err)
+	asserts.NoError(UpdateBatchCounts(batch.Id, 1, 1))
+	asserts.Equal(1, getTestBatch(t, batch).FailedCount)
+	batch.CompletedCount, batch.FailedCount = 1, 1
+	asserts.NoError(FinishBatch(batch))
+	saved := getTestBatch(t, batch)
+	asserts.Equal(BatchStatusCompleted, saved.Status)
+	asserts.Equal(2, saved.TotalCount)
+	asserts.NotZero(saved.CompletedAt)
+
+	// 已结束的任务不会再被标记为失败
+	asserts.NoError(FailBatch(batch.Id, "late failure"))
+	asserts.Equal(BatchStatusCompleted, getTestBatch(t, batch).Status)
+}
+
+func TestExpireBatch(t *testing.T) {
+	DB = testutil.SetupDB(t, &Batch{})
+	asserts := assert.New(t)
+	batch := createTestBatch(t)
+	asserts.False(batch.IsExpired())
+	batch.ExpiresAt = common.GetTimestamp() - 1
+	asserts.True(batch.IsExpired())
+
+	_, err := StartBatch(batch.Id, 2, nil)
+	asserts.NoError(err)
+	batch.CompletedCount = 1
+	batch.OutputFileId = "file-out"
+	asserts.NoError(ExpireBatch(batch))
+	saved := getTestBatch(t, batch)
+	asserts.Equal(BatchStatusExpired, saved.Status)
+	asserts.Equal("file-out", saved.OutputFileId)
+	asserts.Equal(1, saved.CompletedCount)
+	asserts.NotZero(saved.ExpiredAt)
+}
+
+func TestFailInterruptedBatches(t *testing.T) {
+	DB = testutil.SetupDB(t, &Batch{}, &File{}, &FileChunk{})
+	asserts := assert.New(t)
+	running := createTestBatch(t)
+	_, err := StartBatch(running.Id, 2, []string{"file-output", "file-error"})
+	asserts.NoError(err)
+	cancelling := &Batch{BatchId: "batch_cancelling", UserId: 1, Status: BatchStatusValidating}
+	asserts.NoError(cancelling.Insert())
+	_, err = StartBatch(cancelling.Id, 1, []string{"file-cancelled"})
+	asserts.NoError(err)
+	asserts.NoError(CancelUserBatch(cancelling))
+
+	// 重启前写了一半的结果文件只有块没有文件记录，已保存的文件不受影响
+	for _, fileId := range []string{"file-output", "file-error", "file-cancelled", "file-saved"} {
+		asserts.NoError(DB.Create(&FileChunk{FileId: fileId, Data: []byte("data")}).Error)
+	}
+	asserts.NoError((&File{FileId: "file-error", UserId: 1}).Insert())
+
+	asserts.NoError(FailInterruptedBatches())
+	asserts.Equal(BatchStatusFailed, getTestBatch(t, running).Status)
+	asserts.Equal(BatchStatusCancelled, getTestBatch(t, cancelling).Status)
+	var fileIds []string
+	DB.Model(&FileChunk{}).Order("file_id").Pluck("file_id", &fileIds)
+	asserts.Equal([]string{"file-error", "file-saved"}, fileIds)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"one-api/common"
 	"sort"
 	"strconv"
@@ -119,6 +118,26 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+func CacheGetUserRateLimit(id int) (rateLimit common.RateLimitConfig, err error) {
+	if !common.RedisEnabled {
+		return GetUserRateLimit(id)
+	}
+	rateLimitString, err := common.RedisGet(fmt.Sprintf("user_rate_limit:%d", id))
+	if err == nil && json.Unmarshal([]byte(rateLimitString), &rateLimit) == nil {
+		return rateLimit, nil
+	}
+	rateLimit, err = GetUserRateLimit(id)
+	if err != nil {
+		return rateLimit, err
+	}
+	jsonBytes, _ := json.Marshal(rateLimit)
+	err = common.RedisSet(fmt.Sprintf("user_rate_limit:%d", id), string(jsonBytes), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		common.SysError("Redis set user rate limit error: " + err.Error())
+	}
+	return rateLimit, nil
+}
+
 func CacheGetUsername(id int) (username string, err error) {
 	if !common.RedisEnabled {
 		return GetUsernameById(id)
@@ -266,21 +285,35 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func CacheGetRandomSatisfiedChannel(userId int, group string, model string, retry int) (*Channel, error) {
+	return cacheGetSatisfiedChannel(userId, group, model, retry, nil)
+}
+
+// CacheGetNextSatisfiedChannel 用于同一请求内的重试，排除已经尝试过的渠道，
+// 并总是从剩余渠道中优先级最高的一档选择，高优先级渠道全部失败后才会降级
+func CacheGetNextSatisfiedChannel(userId int, group string, model string, excludeChannelIds []int) (*Channel, error) {
+	return cacheGetSatisfiedChannel(userId, group, model, 0, excludeChannelIds)
+}
+
+func cacheGetSatisfiedChannel(userId int, group string, model string, retry int, excludeChannelIds []int) (*Channel, error) {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return getSatisfiedChannel(userId, group, model, retry, excludeChannelIds)
 	}
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
+	channels := excludeChannels(group2model2channels[group][model], excludeChannelIds)
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
+	// 跳过熔断中的渠道
+	channels = filterCircuitAvailableChannels(channels, model)
+	// 优先选择并发未满的渠道
+	channels = filterUnsaturatedChannels(channels, model)
 
 	uniquePriorities := make(map[int]bool)
 	for _, channel := range channels {
@@ -305,25 +338,12 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 		}
 	}
 
-	// 平滑系数
-	smoothingFactor := 10
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := 0
-	for _, channel := range targetChannels {
-		totalWeight += channel.GetWeight() + smoothingFactor
-	}
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
-
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight() + smoothingFactor
-		if randomWeight < 0 {
-			return channel, nil
-		}
+	channel := selectChannelByStrategy(userId, group, model, targetChannels)
+	if channel == nil {
+		return nil, errors.New("channel not found")
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	markChannelCircuitSelected(channel.Id, model)
+	return channel, nil
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
@@ -28,10 +28,14 @@ type Channel struct {
 	UsedQuota          int64   `json:"used_quota" gorm:"bigint;default:0"`
 	ModelMapping       *string `json:"model_mapping" gorm:"type:varchar(1024);default:''"`
 	//MaxInputTokens     *int    `json:"max_input_tokens" gorm:"default:0"`
-	StatusCodeMapping *string `json:"status_code_mapping" gorm:"type:varchar(1024);default:''"`
-	Priority          *int64  `json:"priority" gorm:"bigint;default:0"`
-	AutoBan           *int    `json:"auto_ban" gorm:"default:1"`
-	OtherInfo         string  `json:"other_info"`
+	StatusCodeMapping *string  `json:"status_code_mapping" gorm:"type:varchar(1024);default:''"`
+	Priority          *int64   `json:"priority" gorm:"bigint;default:0"`
+	AutoBan           *int     `json:"auto_ban" gorm:"default:1"`
+	OtherInfo         string   `json:"other_info"`
+	MultiKeyMode      string   `json:"multi_key_mode" gorm:"type:varchar(16);default:''"`      // 为空时为单密钥渠道，否则 Key 中每行一个密钥
+	CostMultiplier    *float64 `json:"cost_multiplier" gorm:"default:1"`                       // 渠道成本系数，用于 cheapest 路由策略
+	MaxConcurrency    *int     `json:"max_concurrency" gorm:"default:0"`                       // 渠道最大并发请求数，0 为不限制
+	ModelConcurrency  *string  `json:"model_concurrency" gorm:"type:varchar(1024);default:''"` // 按模型的最大并发，json 格式 {"模型": 并发数}
 }
 
 func (channel *Channel) GetModels() []string {
@@ -41,6 +45,25 @@ func (channel *Channel) GetModels() []string {
 	return strings.Split(strings.Trim(channel.Models, ","), ",")
 }
 
+func (channel *Channel) IsMultiKey() bool {
+	return channel.MultiKeyMode != ""
+}
+
+// GetKeys 返回渠道的密钥池，单密钥渠道的密钥可能包含换行（如 json 凭证），不做拆分
+func (channel *Channel) GetKeys() []string {
+	if !channel.IsMultiKey() {
+		return []string{channel.Key}
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 func (channel *Channel) GetOtherInfo() map[string]interface{} {
 	otherInfo := make(map[string]interface{})
 	if channel.OtherInfo != "" {
@@ -61,6 +84,18 @@ func (channel *Channel) SetOtherInfo(otherInfo map[string]interface{}) {
 	channel.OtherInfo = string(otherInfoBytes)
 }
 
+// UpdateChannelOtherInfo 只更新 OtherInfo 中的单个字段，不覆盖渠道的其他字段
+func UpdateChannelOtherInfo(id int, key string, value interface{}) error {
+	channel, err := GetChannelById(id, false)
+	if err != nil {
+		return err
+	}
+	info := channel.GetOtherInfo()
+	info[key] = value
+	channel.SetOtherInfo(info)
+	return DB.Model(&Channel{}).Where("id = ?", id).Update("other_info", channel.OtherInfo).Error
+}
+
 func (channel *Channel) Save() error {
 	return DB.Save(channel).Error
 }
@@ -137,6 +172,10 @@ func BatchInsertChannels(channels []Channel) error {
 		if err != nil {
 			return err
 		}
+		err = SyncChannelKeys(&channel_)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -158,6 +197,7 @@ func BatchDeleteChannels(ids []int) error {
 	}
 	// 提交事务
 	tx.Commit()
+	err = DeleteChannelKeys(ids)
 	return err
 }
 
@@ -175,6 +215,13 @@ func (channel *Channel) GetWeight() int {
 	return int(*channel.Weight)
 }
 
+func (channel *Channel) GetCostMultiplier() float64 {
+	if channel.CostMultiplier == nil || *channel.CostMultiplier <= 0 {
+		return 1
+	}
+	return *channel.CostMultiplier
+}
+
 func (channel *Channel) GetBaseURL() string {
 	if channel.BaseURL == nil {
 		return ""
@@ -182,6 +229,21 @@ func (channel *Channel) GetBaseURL() string {
 	return *channel.BaseURL
 }
 
+func (channel *Channel) GetMaxConcurrency() int {
+	if channel.MaxConcurrency == nil {
+		return 0
+	}
+	return *channel.MaxConcurrency
+}
+
+// GetModelConcurrency 返回渠道对该模型单独设置的最大并发，未设置时为 0
+func (channel *Channel) GetModelConcurrency(model string) int {
+	if channel.ModelConcurrency == nil || *channel.ModelConcurrency == "" {
+		return 0
+	}
+	return getChannelModelConcurrency(channel.Id, *channel.ModelConcurrency)[model]
+}
+
 func (channel *Channel) GetModelMapping() string {
 	if channel.ModelMapping == nil {
 		return ""
@@ -203,6 +265,10 @@ func (channel *Channel) Insert() error {
 		return err
 	}
 	err = channel.AddAbilities()
+	if err != nil {
+		return err
+	}
+	err = SyncChannelKeys(channel)
 	return err
 }
 
@@ -214,6 +280,10 @@ func (channel *Channel) Update() error {
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
 	err = channel.UpdateAbilities()
+	if err != nil {
+		return err
+	}
+	err = SyncChannelKeys(channel)
 	return err
 }
 
@@ -244,6 +314,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
+	if err != nil {
+		return err
+	}
+	err = DeleteChannelKeys([]int{channel.Id})
 	return err
 }
 
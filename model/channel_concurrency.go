@@ -0,0 +1,397 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"one-api/common"
+	"one-api/constant"
+	"sync"
+	"time"
+)
+
+var ErrChannelQueueFull = errors.New("channel is busy, too many requests are waiting")
+var ErrChannelQueueTimeout = errors.New("channel is busy, timed out waiting for a free slot")
+
+type concurrencyWaiter struct {
+	model        string
+	channelLimit int
+	modelLimit   int
+	ready        chan struct{}
+}
+
+// channelConcurrency 渠道的并发占用情况，等待者按到达顺序排队；
+// 开启 Redis 时占用情况记录在 Redis 中，这里只统计本节点等待的请求数，Redis 不可用时退回本节点的限制
+type channelConcurrency struct {
+	inFlight         int
+	modelInFlight    map[string]int
+	waiters          []*concurrencyWaiter
+	redisWaiting     int
+	redisUnavailable bool
+}
+
+var channelConcurrencies = make(map[int]*channelConcurrency)
+var channelConcurrencyLock sync.Mutex
+
+// channelModelConcurrency 缓存解析后的模型并发配置，配置变更时重新解析
+type channelModelConcurrency struct {
+	raw    string
+	limits map[string]int
+}
+
+var channelModelConcurrencyCache = make(map[int]*channelModelConcurrency)
+var channelModelConcurrencyLock sync.RWMutex
+
+// Redis 中的并发名额以有序集合记录，分数为租约到期时间，请求处理期间定时续租，节点异常退出时名额在租约到期后自动回收
+var channelConcurrencyLease = 30 * time.Second
+
+const channelConcurrencyPollInterval = 200 * time.Millisecond
+
+// 检查全部并发集合均未满后在每个集合中占用名额；ARGV 依次为 当前毫秒时间、租约毫秒数、持有者、每个集合的上限
+var concurrencyAcquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+for i = 1, #KEYS do
+	redis.call('ZREMRANGEBYSCORE', KEYS[i], 0, now)
+	if redis.call('ZCARD', KEYS[i]) >= tonumber(ARGV[i + 3]) then
+		return 0
+	end
+end
+for i = 1, #KEYS do
+	redis.call('ZADD', KEYS[i], now + tonumber(ARGV[2]), ARGV[3])
+	redis.call('PEXPIRE', KEYS[i], ARGV[2])
+end
+return 1
+`)
+
+// 为仍持有的名额续租，已被回收的名额不再加回；ARGV 依次为 当前毫秒时间、租约毫秒数、持有者
+var concurrencyRenewScript = redis.NewScript(`
+local expire = tonumber(ARGV[1]) + tonumber(ARGV[2])
+for i = 1, #KEYS do
+	if redis.call('ZADD', KEYS[i], 'XX', 'CH', expire, ARGV[3]) == 1 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[2])
+	end
+end
+return 1
+`)
+
+func getChannelModelConcurrency(channelId int, raw string) map[string]int {
+	channelModelConcurrencyLock.RLock()
+	cached, ok := channelModelConcurrencyCache[channelId]
+	channelModelConcurrencyLock.RUnlock()
+	if ok && cached.raw == raw {
+		return cached.limits
+	}
+	limits := make(map[string]int)
+	err := json.Unmarshal([]byte(raw), &limits)
+	if err != nil {
+		common.SysError("failed to unmarshal model concurrency: " + err.Error())
+	}
+	channelModelConcurrencyLock.Lock()
+	channelModelConcurrencyCache[channelId] = &channelModelConcurrency{raw: raw, limits: limits}
+	channelModelConcurrencyLock.Unlock()
+	return limits
+}
+
+// concurrencyRedisKeys 返回需要限制的并发集合及其上限
+func concurrencyRedisKeys(channelId int, model string, channelLimit int, modelLimit int) ([]string, []int) {
+	var keys []string
+	var limits []int
+	if channelLimit > 0 {
+		keys = append(keys, fmt.Sprintf("channel_concurrency:%d", channelId))
+		limits = append(limits, channelLimit)
+	}
+	if modelLimit > 0 {
+		keys = append(keys, fmt.Sprintf("channel_concurrency:%d:%s", channelId, model))
+		limits = append(limits, modelLimit)
+	}
+	return keys, limits
+}
+
+func getChannelConcurrency(channelId int) *channelConcurrency {
+	concurrency, ok := channelConcurrencies[channelId]
+	if !ok {
+		concurrency = &channelConcurrency{modelInFlight: make(map[string]int)}
+		channelConcurrencies[channelId] = concurrency
+	}
+	return concurrency
+}
+
+func (cc *channelConcurrency) available(model string, channelLimit int, modelLimit int) bool {
+	if channelLimit > 0 && cc.inFlight >= channelLimit {
+		return false
+	}
+	if modelLimit > 0 && cc.modelInFlight[model] >= modelLimit {
+		return false
+	}
+	return true
+}
+
+func (cc *channelConcurrency) acquire(model string) {
+	cc.inFlight++
+	cc.modelInFlight[model]++
+}
+
+// wakeWaiters 按排队顺序把空出的名额交给等待者，被模型并发挡住的等待者不阻塞后面其他模型的请求
+func (cc *channelConcurrency) wakeWaiters() {
+	remaining := cc.waiters[:0]
+	for _, waiter := range cc.waiters {
+		if cc.available(waiter.model, waiter.channelLimit, waiter.modelLimit) {
+			cc.acquire(waiter.model)
+			close(waiter.ready)
+			continue
+		}
+		remaining = append(remaining, waiter)
+	}
+	cc.waiters = remaining
+}
+
+func (cc *channelConcurrency) release(model string) {
+	cc.inFlight--
+	cc.modelInFlight[model]--
+	if cc.modelInFlight[model] <= 0 {
+		delete(cc.modelInFlight, model)
+	}
+	cc.wakeWaiters()
+}
+
+// IsChannelSaturated 渠道或该模型的并发是否已满
+func IsChannelSaturated(channel *Channel, model string) bool {
+	channelLimit := channel.GetMaxConcurrency()
+	modelLimit := channel.GetModelConcurrency(model)
+	if channelLimit <= 0 && modelLimit <= 0 {
+		return false
+	}
+	if common.RedisEnabled {
+		saturated, err := isRedisChannelSaturated(channel.Id, model, channelLimit, modelLimit)
+		if err == nil {
+			return saturated
+		}
+		warnRedisChannelConcurrency(channel.Id, err)
+	}
+	return isLocalChannelSaturated(channel.Id, model, channelLimit, modelLimit)
+}
+
+func isLocalChannelSaturated(channelId int, model string, channelLimit int, modelLimit int) bool {
+	channelConcurrencyLock.Lock()
+	defer channelConcurrencyLock.Unlock()
+	concurrency, ok := channelConcurrencies[channelId]
+	if !ok {
+		return false
+	}
+	return len(concurrency.waiters) > 0 || !concurrency.available(model, channelLimit, modelLimit)
+}
+
+// filterUnsaturatedChannels 过滤掉并发已满的渠道，全部已满时返回原列表，由请求在渠道队列中等待
+func filterUnsaturatedChannels(channels []*Channel, model string) []*Channel {
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !IsChannelSaturated(channel, model) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// AcquireChannelConcurrency 占用渠道并发名额，已满时排队等待，超过队列长度或等待超时返回错误；
+// 成功时返回的函数用于在请求结束后释放名额
+func AcquireChannelConcurrency(ctx context.Context, channelId int, model string, channelLimit int, modelLimit int) (func(), error) {
+	if channelLimit <= 0 && modelLimit <= 0 {
+		return func() {}, nil
+	}
+	if common.RedisEnabled {
+		return acquireRedisChannelConcurrency(ctx, channelId, model, channelLimit, modelLimit)
+	}
+	return acquireLocalChannelConcurrency(ctx, channelId, model, channelLimit, modelLimit)
+}
+
+// acquireLocalChannelConcurrency 本节点内的并发名额，等待者按到达顺序获得名额
+func acquireLocalChannelConcurrency(ctx context.Context, channelId int, model string, channelLimit int, modelLimit int) (func(), error) {
+	release := func() {
+		channelConcurrencyLock.Lock()
+		getChannelConcurrency(channelId).release(model)
+		channelConcurrencyLock.Unlock()
+	}
+
+	channelConcurrencyLock.Lock()
+	concurrency := getChannelConcurrency(channelId)
+	if len(concurrency.waiters) == 0 && concurrency.available(model, channelLimit, modelLimit) {
+		concurrency.acquire(model)
+		channelConcurrencyLock.Unlock()
+		return release, nil
+	}
+	if len(concurrency.waiters) >= constant.ChannelQueueSize {
+		channelConcurrencyLock.Unlock()
+		return nil, ErrChannelQueueFull
+	}
+	waiter := &concurrencyWaiter{
+		model:        model,
+		channelLimit: channelLimit,
+		modelLimit:   modelLimit,
+		ready:        make(chan struct{}),
+	}
+	concurrency.waiters = append(concurrency.waiters, waiter)
+	channelConcurrencyLock.Unlock()
+
+	timer := time.NewTimer(time.Duration(constant.ChannelQueueTimeoutSeconds) * time.Second)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-waiter.ready:
+		return release, nil
+	case <-timer.C:
+		err = ErrChannelQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	channelConcurrencyLock.Lock()
+	defer channelConcurrencyLock.Unlock()
+	select {
+	case <-waiter.ready:
+		// 超时的同时拿到了名额，直接归还
+		concurrency.release(model)
+	default:
+		for i, w := range concurrency.waiters {
+			if w == waiter {
+				concurrency.waiters = append(concurrency.waiters[:i], concurrency.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil, err
+}
+
+// warnRedisChannelConcurrency Redis 出错时退回本节点的并发限制，每个渠道只在首次出错时记录警告，恢复后重新计
+func warnRedisChannelConcurrency(channelId int, err error) {
+	channelConcurrencyLock.Lock()
+	concurrency := getChannelConcurrency(channelId)
+	warned := concurrency.redisUnavailable
+	concurrency.redisUnavailable = true
+	channelConcurrencyLock.Unlock()
+	if !warned {
+		common.SysWarn(fmt.Sprintf("failed to use redis for channel #%d concurrency, falling back to the local limit: %s", channelId, err.Error()))
+	}
+}
+
+func recoverRedisChannelConcurrency(channelId int) {
+	channelConcurrencyLock.Lock()
+	if concurrency, ok := channelConcurrencies[channelId]; ok && concurrency.redisUnavailable {
+		concurrency.redisUnavailable = false
+		common.SysLog(fmt.Sprintf("redis for channel #%d concurrency recovered", channelId))
+	}
+	channelConcurrencyLock.Unlock()
+}
+
+func isRedisChannelSaturated(channelId int, model string, channelLimit int, modelLimit int) (bool, error) {
+	keys, limits := concurrencyRedisKeys(channelId, model, channelLimit, modelLimit)
+	min := fmt.Sprintf("(%d", time.Now().UnixMilli())
+	for i, key := range keys {
+		count, err := common.RDB.ZCount(context.Background(), key, min, "+inf").Result()
+		if err != nil {
+			return false, err
+		}
+		if int(count) >= limits[i] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func tryAcquireRedisChannelConcurrency(keys []string, limits []int, holder string) (bool, error) {
+	args := []interface{}{time.Now().UnixMilli(), channelConcurrencyLease.Milliseconds(), holder}
+	for _, limit := range limits {
+		args = append(args, limit)
+	}
+	acquired, err := concurrencyAcquireScript.Run(context.Background(), common.RDB, keys, args...).Int()
+	return acquired == 1, err
+}
+
+// renewRedisChannelConcurrency 在释放前每隔三分之一租约续租一次，避免长时间的请求（如长文本流式输出）名额被提前回收
+func renewRedisChannelConcurrency(keys []string, holder string, lease time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := concurrencyRenewScript.Run(context.Background(), common.RDB, keys, time.Now().UnixMilli(), lease.Milliseconds(), holder).Err()
+		if err != nil {
+			common.SysError("failed to renew channel concurrency: " + err.Error())
+		}
+	}
+}
+
+// acquireRedisChannelConcurrency 多节点共享的并发名额，已满时轮询等待，本节点等待数超过队列长度或等待超时返回错误；
+// 各节点的等待者各自轮询抢占名额，不保证按到达顺序获得名额；Redis 出错时退回本节点的并发限制
+func acquireRedisChannelConcurrency(ctx context.Context, channelId int, model string, channelLimit int, modelLimit int) (func(), error) {
+	keys, limits := concurrencyRedisKeys(channelId, model, channelLimit, modelLimit)
+	holder := common.GetUUID()
+	acquiredRelease := func() func() {
+		recoverRedisChannelConcurrency(channelId)
+		done := make(chan struct{})
+		go renewRedisChannelConcurrency(keys, holder, channelConcurrencyLease, done)
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				close(done)
+				for _, key := range keys {
+					err := common.RDB.ZRem(context.Background(), key, holder).Err()
+					if err != nil {
+						common.SysError("failed to release channel concurrency: " + err.Error())
+					}
+				}
+			})
+		}
+	}
+	acquired, err := tryAcquireRedisChannelConcurrency(keys, limits, holder)
+	if err != nil {
+		warnRedisChannelConcurrency(channelId, err)
+		return acquireLocalChannelConcurrency(ctx, channelId, model, channelLimit, modelLimit)
+	}
+	if acquired {
+		return acquiredRelease(), nil
+	}
+
+	channelConcurrencyLock.Lock()
+	concurrency := getChannelConcurrency(channelId)
+	if concurrency.redisWaiting >= constant.ChannelQueueSize {
+		channelConcurrencyLock.Unlock()
+		return nil, ErrChannelQueueFull
+	}
+	concurrency.redisWaiting++
+	channelConcurrencyLock.Unlock()
+	defer func() {
+		channelConcurrencyLock.Lock()
+		concurrency.redisWaiting--
+		channelConcurrencyLock.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Duration(constant.ChannelQueueTimeoutSeconds) * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(channelConcurrencyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return nil, ErrChannelQueueTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		acquired, err = tryAcquireRedisChannelConcurrency(keys, limits, holder)
+		if err != nil {
+			warnRedisChannelConcurrency(channelId, err)
+			return acquireLocalChannelConcurrency(ctx, channelId, model, channelLimit, modelLimit)
+		}
+		if acquired {
+			return acquiredRelease(), nil
+		}
+	}
+}
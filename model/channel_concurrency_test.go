@@ -0,0 +1,169 @@
+package model
+
+import (
+	"context"
+	"one-api/common"
+	"one-api/constant"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetModelConcurrency(t *testing.T) {
+	asserts := assert.New(t)
+	raw := `{"gpt-4o": 2}`
+	channel := &Channel{Id: 301, ModelConcurrency: &raw}
+	asserts.Equal(2, channel.GetModelConcurrency("gpt-4o"))
+	asserts.Equal(0, channel.GetModelConcurrency("gpt-4o-mini"))
+
+	// 配置变更后重新解析
+	raw2 := `{"gpt-4o-mini": 3}`
+	channel.ModelConcurrency = &raw2
+	asserts.Equal(0, channel.GetModelConcurrency("gpt-4o"))
+	asserts.Equal(3, channel.GetModelConcurrency("gpt-4o-mini"))
+}
+
+func TestAcquireChannelConcurrency(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+
+	maxConcurrency := 1
+	release, err := AcquireChannelConcurrency(context.Background(), 302, "gpt-4o", 1, 0)
+	asserts.NoError(err)
+	asserts.True(IsChannelSaturated(&Channel{Id: 302, MaxConcurrency: &maxConcurrency}, "gpt-4o"))
+
+	acquired := make(chan func())
+	go func() {
+		next, err := AcquireChannelConcurrency(context.Background(), 302, "gpt-4o", 1, 0)
+		asserts.NoError(err)
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot while the channel is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 释放后排队的请求拿到名额
+	release()
+	(<-acquired)()
+	asserts.False(IsChannelSaturated(&Channel{Id: 302, MaxConcurrency: &maxConcurrency}, "gpt-4o"))
+}
+
+func TestAcquireRedisChannelConcurrency(t *testing.T) {
+	asserts := assert.New(t)
+	server := miniredis.RunT(t)
+	rdb, redisEnabled, timeout := common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds
+	common.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	common.RedisEnabled = true
+	constant.ChannelQueueTimeoutSeconds = 1
+	t.Cleanup(func() {
+		common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds = rdb, redisEnabled, timeout
+	})
+	raw := `{"gpt-4o": 1}`
+	maxConcurrency := 2
+	channel := &Channel{Id: 303, MaxConcurrency: &maxConcurrency, ModelConcurrency: &raw}
+
+	release, err := AcquireChannelConcurrency(context.Background(), 303, "gpt-4o", 2, 1)
+	asserts.NoError(err)
+	asserts.True(IsChannelSaturated(channel, "gpt-4o"))
+	asserts.False(IsChannelSaturated(channel, "gpt-4o-mini"))
+
+	// 模型名额已满时不占用渠道名额，其他模型仍可使用
+	_, err = AcquireChannelConcurrency(context.Background(), 303, "gpt-4o", 2, 1)
+	asserts.ErrorIs(err, ErrChannelQueueTimeout)
+	other, err := AcquireChannelConcurrency(context.Background(), 303, "gpt-4o-mini", 2, 0)
+	asserts.NoError(err)
+	asserts.True(IsChannelSaturated(channel, "gpt-4o-mini"))
+
+	acquired := make(chan error)
+	go func() {
+		next, err := AcquireChannelConcurrency(context.Background(), 303, "gpt-4o-mini", 2, 0)
+		if err == nil {
+			next()
+		}
+		acquired <- err
+	}()
+	release()
+	asserts.NoError(<-acquired)
+	other()
+	count, _ := common.RDB.ZCard(context.Background(), "channel_concurrency:303").Result()
+	asserts.Zero(count)
+}
+
+func TestRenewRedisChannelConcurrency(t *testing.T) {
+	asserts := assert.New(t)
+	server := miniredis.RunT(t)
+	rdb, redisEnabled, timeout, lease := common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds, channelConcurrencyLease
+	common.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	common.RedisEnabled = true
+	constant.ChannelQueueTimeoutSeconds = 1
+	channelConcurrencyLease = 300 * time.Millisecond
+	t.Cleanup(func() {
+		common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds, channelConcurrencyLease = rdb, redisEnabled, timeout, lease
+	})
+	maxConcurrency := 1
+	channel := &Channel{Id: 304, MaxConcurrency: &maxConcurrency}
+
+	release, err := AcquireChannelConcurrency(context.Background(), 304, "gpt-4o", 1, 0)
+	asserts.NoError(err)
+	// 请求处理期间租约持续续期，超过租约时长后名额仍被占用
+	_, err = AcquireChannelConcurrency(context.Background(), 304, "gpt-4o", 1, 0)
+	asserts.ErrorIs(err, ErrChannelQueueTimeout)
+	asserts.True(IsChannelSaturated(channel, "gpt-4o"))
+
+	// 释放后停止续租，重复释放无影响
+	release()
+	release()
+	asserts.False(IsChannelSaturated(channel, "gpt-4o"))
+	time.Sleep(channelConcurrencyLease / 2)
+	count, _ := common.RDB.ZCard(context.Background(), "channel_concurrency:304").Result()
+	asserts.Zero(count)
+}
+
+func TestRedisChannelConcurrencyFallback(t *testing.T) {
+	asserts := assert.New(t)
+	server := miniredis.RunT(t)
+	rdb, redisEnabled, timeout := common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds
+	common.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	common.RedisEnabled = true
+	constant.ChannelQueueTimeoutSeconds = 1
+	t.Cleanup(func() {
+		common.RDB, common.RedisEnabled, constant.ChannelQueueTimeoutSeconds = rdb, redisEnabled, timeout
+	})
+	maxConcurrency := 1
+	channel := &Channel{Id: 305, MaxConcurrency: &maxConcurrency}
+
+	// Redis 不可用时退回本节点的并发限制
+	server.Close()
+	release, err := AcquireChannelConcurrency(context.Background(), 305, "gpt-4o", 1, 0)
+	asserts.NoError(err)
+	asserts.True(IsChannelSaturated(channel, "gpt-4o"))
+	_, err = AcquireChannelConcurrency(context.Background(), 305, "gpt-4o", 1, 0)
+	asserts.ErrorIs(err, ErrChannelQueueTimeout)
+	channelConcurrencyLock.Lock()
+	asserts.True(channelConcurrencies[305].redisUnavailable)
+	channelConcurrencyLock.Unlock()
+	release()
+	asserts.False(IsChannelSaturated(channel, "gpt-4o"))
+
+	// Redis 恢复后重新使用共享的名额
+	asserts.NoError(server.Restart())
+	asserts.Eventually(func() bool {
+		return common.RDB.Ping(context.Background()).Err() == nil
+	}, 3*time.Second, 100*time.Millisecond)
+	release, err = AcquireChannelConcurrency(context.Background(), 305, "gpt-4o", 1, 0)
+	asserts.NoError(err)
+	channelConcurrencyLock.Lock()
+	asserts.False(channelConcurrencies[305].redisUnavailable)
+	channelConcurrencyLock.Unlock()
+	count, _ := common.RDB.ZCard(context.Background(), "channel_concurrency:305").Result()
+	asserts.Equal(int64(1), count)
+	release()
+}
@@ -0,0 +1,292 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gorm.io/gorm"
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ChannelKeyModeRoundRobin = "round_robin"
+	ChannelKeyModeRandom     = "random"
+)
+
+// ChannelKey 多密钥渠道中单个密钥的状态与用量，密钥本身仍保存在 Channel.Key 中
+type ChannelKey struct {
+	Id           int    `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_hash,priority:1"`
+	KeyHash      string `json:"key_hash" gorm:"type:char(64);uniqueIndex:idx_channel_key_hash,priority:2"`
+	Status       int    `json:"status" gorm:"default:1"`
+	StatusReason string `json:"status_reason"`
+	StatusTime   int64  `json:"status_time" gorm:"bigint"`
+	UsedQuota    int64  `json:"used_quota" gorm:"bigint;default:0"`
+	RequestCount int    `json:"request_count" gorm:"default:0"`
+	CooldownTo   int64  `json:"cooldown_to" gorm:"-"`
+	Key          string `json:"key" gorm:"-"`
+}
+
+// HashChannelKey 返回密钥的 sha256，用于区分密钥池中的密钥，脱敏后的密钥可能相同，只用于展示
+func HashChannelKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type channelKeyState struct {
+	keys     map[string]*ChannelKey
+	loadedAt int64
+}
+
+// channelKeyHashes 缓存渠道密钥池及其哈希，密钥变更时重新计算
+type channelKeyHashes struct {
+	raw    string
+	keys   []string
+	hashes []string
+}
+
+var channelKeyStates = make(map[int]*channelKeyState)
+var channelKeyCooldowns = make(map[string]int64)
+var channelKeyCounters = make(map[int]*uint64)
+var channelKeyHashCache = make(map[int]*channelKeyHashes)
+var channelKeyLock sync.RWMutex
+
+// getChannelKeyHashes 返回渠道的密钥池与对应的哈希，避免每次选择密钥都重新计算 sha256
+func getChannelKeyHashes(channel *Channel) ([]string, []string) {
+	channelKeyLock.RLock()
+	cached, ok := channelKeyHashCache[channel.Id]
+	channelKeyLock.RUnlock()
+	if ok && cached.raw == channel.Key {
+		return cached.keys, cached.hashes
+	}
+	keys := channel.GetKeys()
+	hashes := make([]string, len(keys))
+	for i, key := range keys {
+		hashes[i] = HashChannelKey(key)
+	}
+	channelKeyLock.Lock()
+	channelKeyHashCache[channel.Id] = &channelKeyHashes{raw: channel.Key, keys: keys, hashes: hashes}
+	channelKeyLock.Unlock()
+	return keys, hashes
+}
+
+// getChannelKeyStates 读取渠道的密钥状态，内存中缓存 SyncFrequency 秒
+func getChannelKeyStates(channelId int) map[string]*ChannelKey {
+	channelKeyLock.RLock()
+	state, ok := channelKeyStates[channelId]
+	channelKeyLock.RUnlock()
+	if ok && common.GetTimestamp()-state.loadedAt < int64(common.SyncFrequency) {
+		return state.keys
+	}
+	var channelKeys []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Find(&channelKeys).Error
+	if err != nil {
+		common.SysError("failed to load channel keys: " + err.Error())
+		if ok {
+			return state.keys
+		}
+	}
+	keys := make(map[string]*ChannelKey, len(channelKeys))
+	for _, channelKey := range channelKeys {
+		keys[channelKey.KeyHash] = channelKey
+	}
+	channelKeyLock.Lock()
+	channelKeyStates[channelId] = &channelKeyState{keys: keys, loadedAt: common.GetTimestamp()}
+	channelKeyLock.Unlock()
+	return keys
+}
+
+func invalidateChannelKeyStates(channelId int) {
+	channelKeyLock.Lock()
+	delete(channelKeyStates, channelId)
+	delete(channelKeyHashCache, channelId)
+	channelKeyLock.Unlock()
+}
+
+// SyncChannelKeys 保证多密钥渠道的每个密钥都有对应的状态记录，并清理已移除的密钥
+func SyncChannelKeys(channel *Channel) error {
+	if !channel.IsMultiKey() {
+		return DB.Where("channel_id = ?", channel.Id).Delete(&ChannelKey{}).Error
+	}
+	_, hashes := getChannelKeyHashes(channel)
+	for _, hash := range hashes {
+		var channelKey ChannelKey
+		err := DB.Where("channel_id = ? and key_hash = ?", channel.Id, hash).First(&channelKey).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = DB.Create(&ChannelKey{
+				ChannelId: channel.Id,
+				KeyHash:   hash,
+				Status:    common.ChannelStatusEnabled,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := DB.Where("channel_id = ? and key_hash not in ?", channel.Id, hashes).Delete(&ChannelKey{}).Error
+	invalidateChannelKeyStates(channel.Id)
+	return err
+}
+
+func DeleteChannelKeys(channelIds []int) error {
+	for _, id := range channelIds {
+		invalidateChannelKeyStates(id)
+	}
+	return DB.Where("channel_id in (?)", channelIds).Delete(&ChannelKey{}).Error
+}
+
+// GetChannelKeys 返回渠道每个密钥的状态与用量，用于管理界面展示，密钥会被脱敏
+func GetChannelKeys(channel *Channel) []*ChannelKey {
+	states := getChannelKeyStates(channel.Id)
+	keys, hashes := getChannelKeyHashes(channel)
+	now := common.GetTimestamp()
+	channelKeyLock.RLock()
+	defer channelKeyLock.RUnlock()
+	result := make([]*ChannelKey, 0)
+	for i, key := range keys {
+		hash := hashes[i]
+		channelKey := ChannelKey{ChannelId: channel.Id, KeyHash: hash, Status: common.ChannelStatusEnabled}
+		if state, ok := states[hash]; ok {
+			channelKey = *state
+		}
+		if cooldownTo := channelKeyCooldowns[hash]; cooldownTo > now {
+			channelKey.CooldownTo = cooldownTo
+		}
+		channelKey.Key = common.MaskKey(key)
+		result = append(result, &channelKey)
+	}
+	return result
+}
+
+// SelectKey 按渠道的密钥选择方式选出一个可用密钥，跳过已禁用和冷却中的密钥；
+// 全部不可用时退回到冷却中的启用密钥，仍没有则使用第一个密钥
+func (channel *Channel) SelectKey() string {
+	if !channel.IsMultiKey() {
+		return channel.Key
+	}
+	keys, hashes := getChannelKeyHashes(channel)
+	if len(keys) <= 1 {
+		return channel.FirstKey()
+	}
+	states := getChannelKeyStates(channel.Id)
+	now := common.GetTimestamp()
+	available := make([]string, 0, len(keys))
+	enabled := make([]string, 0, len(keys))
+	channelKeyLock.RLock()
+	for i, key := range keys {
+		hash := hashes[i]
+		if state, ok := states[hash]; ok && state.Status != common.ChannelStatusEnabled {
+			continue
+		}
+		enabled = append(enabled, key)
+		if channelKeyCooldowns[hash] <= now {
+			available = append(available, key)
+		}
+	}
+	channelKeyLock.RUnlock()
+	if len(available) == 0 {
+		available = enabled
+	}
+	if len(available) == 0 {
+		return keys[0]
+	}
+	if channel.MultiKeyMode == ChannelKeyModeRandom {
+		return available[rand.Intn(len(available))]
+	}
+	channelKeyLock.Lock()
+	counter, ok := channelKeyCounters[channel.Id]
+	if !ok {
+		counter = new(uint64)
+		channelKeyCounters[channel.Id] = counter
+	}
+	channelKeyLock.Unlock()
+	index := atomic.AddUint64(counter, 1) - 1
+	return available[index%uint64(len(available))]
+}
+
+// GetKeyStatus 返回多密钥渠道中单个密钥的状态，没有状态记录的密钥视为启用
+func (channel *Channel) GetKeyStatus(key string) int {
+	if state, ok := getChannelKeyStates(channel.Id)[HashChannelKey(key)]; ok {
+		return state.Status
+	}
+	return common.ChannelStatusEnabled
+}
+
+// FirstKey 返回密钥池中的第一个密钥，用于查询余额等需要固定密钥的场景
+func (channel *Channel) FirstKey() string {
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return channel.Key
+	}
+	return keys[0]
+}
+
+// CooldownChannelKey 密钥被上游限流后在一段时间内不再被选中，同时清理已经过期的冷却记录
+func CooldownChannelKey(key string) {
+	now := time.Now()
+	channelKeyLock.Lock()
+	for hash, cooldownTo := range channelKeyCooldowns {
+		if cooldownTo <= now.Unix() {
+			delete(channelKeyCooldowns, hash)
+		}
+	}
+	channelKeyCooldowns[HashChannelKey(key)] = now.Add(time.Duration(constant.ChannelKeyCooldownSeconds) * time.Second).Unix()
+	channelKeyLock.Unlock()
+}
+
+// UpdateChannelKeyStatus 更新单个密钥的状态，返回渠道中仍启用的密钥数量
+func UpdateChannelKeyStatus(channelId int, key string, status int, reason string) (int64, error) {
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ? and key_hash = ?", channelId, HashChannelKey(key)).Updates(map[string]interface{}{
+		"status":        status,
+		"status_reason": reason,
+		"status_time":   common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	invalidateChannelKeyStates(channelId)
+	var enabledCount int64
+	err = DB.Model(&ChannelKey{}).Where("channel_id = ? and status = ?", channelId, common.ChannelStatusEnabled).Count(&enabledCount).Error
+	return enabledCount, err
+}
+
+// EnableAllChannelKeys 管理员手动启用渠道时恢复其全部密钥
+func EnableAllChannelKeys(channelId int) {
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ?", channelId).Updates(map[string]interface{}{
+		"status":        common.ChannelStatusEnabled,
+		"status_reason": "",
+		"status_time":   common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		common.SysError("failed to enable channel keys: " + err.Error())
+	}
+	invalidateChannelKeyStates(channelId)
+}
+
+func UpdateChannelKeyUsedQuota(channelId int, key string, quota int) {
+	channelKey, ok := getChannelKeyStates(channelId)[HashChannelKey(key)]
+	if !ok {
+		return
+	}
+	if common.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelKeyUsedQuota, channelKey.Id, quota)
+		addNewRecord(BatchUpdateTypeChannelKeyRequestCount, channelKey.Id, 1)
+		return
+	}
+	updateChannelKeyUsedQuotaAndRequestCount(channelKey.Id, quota, 1)
+}
+
+func updateChannelKeyUsedQuotaAndRequestCount(id int, quota int, count int) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"used_quota":    gorm.Expr("used_quota + ?", quota),
+		"request_count": gorm.Expr("request_count + ?", count),
+	}).Error
+	if err != nil {
+		common.SysError("failed to update channel key used quota: " + err.Error())
+	}
+}
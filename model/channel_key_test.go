@@ -0,0 +1,58 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectKey(t *testing.T) {
+	DB = testutil.SetupDB(t, &ChannelKey{})
+	asserts := assert.New(t)
+
+	channel := &Channel{Id: 101, Key: "k1\nk2\n\nk3", MultiKeyMode: ChannelKeyModeRoundRobin}
+	asserts.NoError(SyncChannelKeys(channel))
+	asserts.Equal("k1", channel.FirstKey())
+	asserts.Equal([]string{"k1", "k2", "k3"}, []string{channel.SelectKey(), channel.SelectKey(), channel.SelectKey()})
+
+	// 禁用与冷却中的密钥不会被选中
+	_, err := UpdateChannelKeyStatus(channel.Id, "k2", common.ChannelStatusAutoDisabled, "invalid key")
+	asserts.NoError(err)
+	CooldownChannelKey("k3")
+	for i := 0; i < 3; i++ {
+		asserts.Equal("k1", channel.SelectKey())
+	}
+
+	// 密钥变更后重新计算哈希
+	channel.Key = "k4"
+	asserts.Equal("k4", channel.SelectKey())
+	keys, hashes := getChannelKeyHashes(channel)
+	asserts.Equal([]string{"k4"}, keys)
+	asserts.Equal([]string{HashChannelKey("k4")}, hashes)
+}
+
+func TestSelectKeySingleKeyChannel(t *testing.T) {
+	// 单密钥渠道的密钥可能包含换行，原样返回
+	channel := &Channel{Id: 102, Key: "{\n\"type\": \"service_account\"\n}"}
+	assert.Equal(t, channel.Key, channel.SelectKey())
+	assert.Equal(t, channel.Key, channel.FirstKey())
+}
+
+func TestCooldownChannelKeyPrunesExpired(t *testing.T) {
+	asserts := assert.New(t)
+	channelKeyLock.Lock()
+	channelKeyCooldowns = map[string]int64{
+		"expired": common.GetTimestamp() - 1,
+	}
+	channelKeyLock.Unlock()
+	constant.ChannelKeyCooldownSeconds = 60
+
+	CooldownChannelKey("key")
+	channelKeyLock.RLock()
+	defer channelKeyLock.RUnlock()
+	asserts.Len(channelKeyCooldowns, 1)
+	asserts.Greater(channelKeyCooldowns[HashChannelKey("key")], common.GetTimestamp())
+}
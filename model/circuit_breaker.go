@@ -0,0 +1,311 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+const circuitBucketSeconds = 10
+
+type circuitBucket struct {
+	start    int64
+	total    int
+	failures int
+	slow     int // 成功但超过慢请求阈值的请求，失败的请求不重复计入
+}
+
+// circuitBreaker 记录某个渠道某个模型最近一段时间的真实请求结果
+type circuitBreaker struct {
+	buckets    []circuitBucket
+	state      int
+	openUntil  int64
+	probing    bool
+	probeStart int64
+}
+
+// ChannelHealth 渠道在某个模型上的被动健康信息，ErrorRate 与熔断使用的错误率一致，包含慢请求
+type ChannelHealth struct {
+	ChannelId int     `json:"channel_id"`
+	Model     string  `json:"model"`
+	State     int     `json:"state"`
+	OpenUntil int64   `json:"open_until"`
+	Total     int     `json:"total"`
+	Failures  int     `json:"failures"`
+	Slow      int     `json:"slow"`
+	ErrorRate float64 `json:"error_rate"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+var circuitBreakers = make(map[string]*circuitBreaker)
+var circuitBreakerLock sync.Mutex
+
+func circuitKey(channelId int, model string) string {
+	return fmt.Sprintf("%d:%s", channelId, model)
+}
+
+func circuitRedisKey(channelId int, model string) string {
+	return "circuit_breaker:" + circuitKey(channelId, model)
+}
+
+func getCircuitBreaker(channelId int, model string) *circuitBreaker {
+	key := circuitKey(channelId, model)
+	breaker, ok := circuitBreakers[key]
+	if !ok {
+		breaker = &circuitBreaker{}
+		circuitBreakers[key] = breaker
+	}
+	return breaker
+}
+
+// stats 汇总窗口内的请求数、失败数与慢请求数
+func (b *circuitBreaker) stats(now int64) (int, int, int) {
+	total, failures, slow := 0, 0, 0
+	windowStart := now - int64(constant.CircuitBreakerWindowSeconds)
+	for _, bucket := range b.buckets {
+		if bucket.start+circuitBucketSeconds <= windowStart {
+			continue
+		}
+		total += bucket.total
+		failures += bucket.failures
+		slow += bucket.slow
+	}
+	return total, failures, slow
+}
+
+// circuitErrorRate 失败与慢请求占全部请求的比例，熔断、健康分与健康信息都使用该值
+func circuitErrorRate(total int, failures int, slow int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(failures+slow) / float64(total)
+}
+
+func (b *circuitBreaker) add(now int64, failed bool, slow bool) {
+	bucketStart := now - now%circuitBucketSeconds
+	if len(b.buckets) == 0 || b.buckets[len(b.buckets)-1].start != bucketStart {
+		b.buckets = append(b.buckets, circuitBucket{start: bucketStart})
+		// 丢弃窗口外的桶
+		windowStart := now - int64(constant.CircuitBreakerWindowSeconds)
+		for len(b.buckets) > 0 && b.buckets[0].start+circuitBucketSeconds <= windowStart {
+			b.buckets = b.buckets[1:]
+		}
+	}
+	bucket := &b.buckets[len(b.buckets)-1]
+	bucket.total++
+	if failed {
+		bucket.failures++
+	} else if slow {
+		bucket.slow++
+	}
+}
+
+// currentState 根据时间推进熔断状态，打开的熔断器到期后进入半开
+func (b *circuitBreaker) currentState(now int64) int {
+	if b.state == CircuitOpen && now >= b.openUntil {
+		b.state = CircuitHalfOpen
+		b.probing = false
+	}
+	if b.state == CircuitHalfOpen && b.probing && now-b.probeStart > int64(constant.CircuitBreakerOpenSeconds) {
+		// 探测请求没有返回结果，允许重新探测
+		b.probing = false
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) open(now int64) {
+	b.state = CircuitOpen
+	b.openUntil = now + int64(constant.CircuitBreakerOpenSeconds)
+	b.probing = false
+	b.buckets = nil
+}
+
+// RecordChannelResult 记录一次真实转发的结果，用于计算错误率并驱动熔断，
+// 失败率同时用于 least_latency 路由，熔断关闭时也会记录
+func RecordChannelResult(channelId int, model string, failed bool, latency time.Duration) {
+	recordChannelFailureRate(channelId, model, failed)
+	if !constant.CircuitBreakerEnabled || channelId == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	slow := constant.CircuitBreakerSlowSeconds > 0 && latency > time.Duration(constant.CircuitBreakerSlowSeconds)*time.Second
+
+	circuitBreakerLock.Lock()
+	breaker := getCircuitBreaker(channelId, model)
+	opened := false
+	switch breaker.currentState(now) {
+	case CircuitHalfOpen:
+		if failed {
+			breaker.open(now)
+			opened = true
+		} else {
+			breaker.state = CircuitClosed
+			breaker.probing = false
+			breaker.buckets = nil
+			breaker.add(now, false, slow)
+		}
+	case CircuitClosed:
+		breaker.add(now, failed, slow)
+		total, failures, slowCount := breaker.stats(now)
+		if total >= constant.CircuitBreakerMinRequests &&
+			circuitErrorRate(total, failures, slowCount) >= constant.CircuitBreakerErrorRate {
+			breaker.open(now)
+			opened = true
+		}
+	}
+	circuitBreakerLock.Unlock()
+
+	if opened {
+		common.SysLog(fmt.Sprintf("circuit breaker opened for channel #%d model %s", channelId, model))
+		if common.RedisEnabled && constant.CircuitBreakerRedisEnabled {
+			err := common.RedisSet(circuitRedisKey(channelId, model), "1", time.Duration(constant.CircuitBreakerOpenSeconds)*time.Second)
+			if err != nil {
+				common.SysError("failed to share circuit breaker state: " + err.Error())
+			}
+		}
+	}
+}
+
+// IsChannelCircuitAvailable 判断渠道在该模型上是否可以接收请求，半开状态同一时间只放行一个探测请求
+func IsChannelCircuitAvailable(channelId int, model string) bool {
+	if !constant.CircuitBreakerEnabled {
+		return true
+	}
+	now := time.Now().Unix()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	breaker, ok := circuitBreakers[circuitKey(channelId, model)]
+	if !ok {
+		return true
+	}
+	switch breaker.currentState(now) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return !breaker.probing
+	}
+	return true
+}
+
+// markChannelCircuitSelected 选中半开状态的渠道时占用探测名额
+func markChannelCircuitSelected(channelId int, model string) {
+	if !constant.CircuitBreakerEnabled {
+		return
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	breaker, ok := circuitBreakers[circuitKey(channelId, model)]
+	if ok && breaker.state == CircuitHalfOpen {
+		breaker.probing = true
+		breaker.probeStart = time.Now().Unix()
+	}
+}
+
+// channelHealthScore 返回 0.1~1 之间的健康分，用于按错误率降低权重
+func channelHealthScore(channelId int, model string) float64 {
+	if !constant.CircuitBreakerEnabled {
+		return 1
+	}
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	breaker, ok := circuitBreakers[circuitKey(channelId, model)]
+	if !ok {
+		return 1
+	}
+	total, failures, slow := breaker.stats(time.Now().Unix())
+	score := 1 - circuitErrorRate(total, failures, slow)
+	if score < 0.1 {
+		score = 0.1
+	}
+	return score
+}
+
+// filterCircuitAvailableChannels 过滤掉熔断中的渠道，全部熔断时返回原列表以免无渠道可用
+func filterCircuitAvailableChannels(channels []*Channel, model string) []*Channel {
+	if !constant.CircuitBreakerEnabled {
+		return channels
+	}
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if IsChannelCircuitAvailable(channel.Id, model) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// GetChannelHealth 返回所有有记录的渠道健康信息
+func GetChannelHealth() []*ChannelHealth {
+	now := time.Now().Unix()
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	healths := make([]*ChannelHealth, 0, len(circuitBreakers))
+	for key, breaker := range circuitBreakers {
+		idStr, model, _ := strings.Cut(key, ":")
+		total, failures, slow := breaker.stats(now)
+		channelId := common.String2Int(idStr)
+		latencyMs, _ := getChannelLatency(channelId, model)
+		health := &ChannelHealth{
+			ChannelId: channelId,
+			Model:     model,
+			State:     breaker.currentState(now),
+			Total:     total,
+			Failures:  failures,
+			Slow:      slow,
+			ErrorRate: circuitErrorRate(total, failures, slow),
+			LatencyMs: latencyMs,
+		}
+		if breaker.state == CircuitOpen {
+			health.OpenUntil = breaker.openUntil
+		}
+		healths = append(healths, health)
+	}
+	return healths
+}
+
+// SyncCircuitBreakerFromRedis 多节点部署时从 Redis 同步其他节点打开的熔断器
+func SyncCircuitBreakerFromRedis(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		ctx := context.Background()
+		iter := common.RDB.Scan(ctx, 0, "circuit_breaker:*", 100).Iterator()
+		for iter.Next(ctx) {
+			redisKey := iter.Val()
+			ttl, err := common.RDB.TTL(ctx, redisKey).Result()
+			if err != nil || ttl <= 0 {
+				continue
+			}
+			idStr, model, found := strings.Cut(strings.TrimPrefix(redisKey, "circuit_breaker:"), ":")
+			channelId, err := strconv.Atoi(idStr)
+			if !found || err != nil {
+				continue
+			}
+			openUntil := time.Now().Add(ttl).Unix()
+			circuitBreakerLock.Lock()
+			breaker := getCircuitBreaker(channelId, model)
+			if breaker.state != CircuitOpen || breaker.openUntil < openUntil {
+				breaker.state = CircuitOpen
+				breaker.openUntil = openUntil
+				breaker.probing = false
+			}
+			circuitBreakerLock.Unlock()
+		}
+		if err := iter.Err(); err != nil {
+			common.SysError("failed to sync circuit breaker from redis: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCircuitBreaker(t *testing.T) {
+	enabled, minRequests, errorRate, openSeconds := constant.CircuitBreakerEnabled, constant.CircuitBreakerMinRequests, constant.CircuitBreakerErrorRate, constant.CircuitBreakerOpenSeconds
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	constant.CircuitBreakerEnabled = true
+	constant.CircuitBreakerMinRequests = 4
+	constant.CircuitBreakerErrorRate = 0.5
+	constant.CircuitBreakerOpenSeconds = 30
+	circuitBreakerLock.Lock()
+	circuitBreakers = make(map[string]*circuitBreaker)
+	circuitBreakerLock.Unlock()
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+		constant.CircuitBreakerEnabled, constant.CircuitBreakerMinRequests, constant.CircuitBreakerErrorRate, constant.CircuitBreakerOpenSeconds = enabled, minRequests, errorRate, openSeconds
+	})
+}
+
+func TestCircuitBreakerDisabledByDefault(t *testing.T) {
+	assert.False(t, constant.CircuitBreakerEnabled)
+	for i := 0; i < 20; i++ {
+		RecordChannelResult(1, "gpt-4o", true, time.Second)
+	}
+	assert.True(t, IsChannelCircuitAvailable(1, "gpt-4o"))
+}
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	setupCircuitBreaker(t)
+	asserts := assert.New(t)
+
+	RecordChannelResult(1, "gpt-4o", false, time.Second)
+	RecordChannelResult(1, "gpt-4o", true, time.Second)
+	RecordChannelResult(1, "gpt-4o", false, time.Second)
+	asserts.True(IsChannelCircuitAvailable(1, "gpt-4o"))
+	// 第 4 个请求达到最小请求数，错误率 50% 触发熔断
+	RecordChannelResult(1, "gpt-4o", true, time.Second)
+	asserts.False(IsChannelCircuitAvailable(1, "gpt-4o"))
+	// 其他模型不受影响
+	asserts.True(IsChannelCircuitAvailable(1, "gpt-4o-mini"))
+
+	// 到期后进入半开，只放行一个探测请求
+	circuitBreakerLock.Lock()
+	circuitBreakers[circuitKey(1, "gpt-4o")].openUntil = time.Now().Unix() - 1
+	circuitBreakerLock.Unlock()
+	asserts.True(IsChannelCircuitAvailable(1, "gpt-4o"))
+	markChannelCircuitSelected(1, "gpt-4o")
+	asserts.False(IsChannelCircuitAvailable(1, "gpt-4o"))
+
+	// 探测成功后关闭
+	RecordChannelResult(1, "gpt-4o", false, time.Second)
+	asserts.True(IsChannelCircuitAvailable(1, "gpt-4o"))
+	asserts.Equal(CircuitClosed, circuitBreakers[circuitKey(1, "gpt-4o")].state)
+}
+
+func TestFilterCircuitAvailableChannels(t *testing.T) {
+	setupCircuitBreaker(t)
+	asserts := assert.New(t)
+	channels := []*Channel{{Id: 1}, {Id: 2}}
+	for i := 0; i < 4; i++ {
+		RecordChannelResult(1, "gpt-4o", true, time.Second)
+	}
+	asserts.Equal([]*Channel{{Id: 2}}, filterCircuitAvailableChannels(channels, "gpt-4o"))
+	for i := 0; i < 4; i++ {
+		RecordChannelResult(2, "gpt-4o", true, time.Second)
+	}
+	// 全部熔断时返回原列表
+	asserts.Equal(channels, filterCircuitAvailableChannels(channels, "gpt-4o"))
+}
+
+func TestChannelHealthErrorRateIncludesSlow(t *testing.T) {
+	setupCircuitBreaker(t)
+	slowSeconds := constant.CircuitBreakerSlowSeconds
+	constant.CircuitBreakerSlowSeconds = 10
+	t.Cleanup(func() {
+		constant.CircuitBreakerSlowSeconds = slowSeconds
+	})
+	asserts := assert.New(t)
+
+	RecordChannelResult(1, "gpt-4o", false, time.Second)
+	RecordChannelResult(1, "gpt-4o", false, 20*time.Second)
+	// 失败的慢请求只计一次
+	RecordChannelResult(1, "gpt-4o", true, 20*time.Second)
+	healths := GetChannelHealth()
+	if asserts.Len(healths, 1) {
+		asserts.Equal(3, healths[0].Total)
+		asserts.Equal(1, healths[0].Failures)
+		asserts.Equal(1, healths[0].Slow)
+		// 健康信息的错误率与熔断使用的错误率一致
+		asserts.InDelta(2.0/3, healths[0].ErrorRate, 1e-9)
+	}
+	asserts.True(IsChannelCircuitAvailable(1, "gpt-4o"))
+	// 第 4 个请求达到最小请求数，3/4 超过阈值，与健康信息展示的错误率一致
+	RecordChannelResult(1, "gpt-4o", false, 20*time.Second)
+	asserts.False(IsChannelCircuitAvailable(1, "gpt-4o"))
+}
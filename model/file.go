@@ -0,0 +1,175 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"gorm.io/gorm"
+	"io"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// fileChunkSize 文件内容分块保存到数据库，读写时每次只在内存中保留一块
+const fileChunkSize = 1 << 20
+
+// File 用户通过 /v1/files 上传的文件，内容分块保存在数据库中，多个节点共享
+type File struct {
+	Id        int            `json:"-"`
+	FileId    string         `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int            `json:"-" gorm:"index"`
+	Filename  string         `json:"filename"`
+	Purpose   string         `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes     int64          `json:"bytes"`
+	CreatedAt int64          `json:"created_at" gorm:"bigint"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+type FileChunk struct {
+	Id     int    `json:"-"`
+	FileId string `json:"-" gorm:"type:varchar(64);index"`
+	Data   []byte `json:"-"`
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("file_id = ?", file.FileId).Delete(&FileChunk{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(file).Error
+	})
+}
+
+// DeleteOrphanedFileChunks 删除这些文件中没有文件记录的块，用于清理写入中断（如进程重启）后遗留的内容
+func DeleteOrphanedFileChunks(fileIds []string) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
+	savedFileIds := DB.Unscoped().Model(&File{}).Select("file_id").Where("file_id in ?", fileIds)
+	return DB.Where("file_id in ? and file_id not in (?)", fileIds, savedFileIds).Delete(&FileChunk{}).Error
+}
+
+func GetUserFileById(fileId string, userId int) (*File, error) {
+	if fileId == "" {
+		return nil, errors.New("file id 为空！")
+	}
+	var file File
+	err := DB.Where("file_id = ? and user_id = ?", fileId, userId).First(&file).Error
+	return &file, err
+}
+
+func GetUserFiles(userId int, purpose string, startIdx int, num int) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&files).Error
+	return files, err
+}
+
+// FileWriter 把写入的内容按块追加到数据库，Close 时才创建文件记录，未完成的文件对用户不可见；
+// Close 失败时已保存的块会被删除
+type FileWriter struct {
+	file   *File
+	buffer bytes.Buffer
+	closed bool
+}
+
+func NewFileWriter(file *File) *FileWriter {
+	file.Bytes = 0
+	return &FileWriter{file: file}
+}
+
+func (w *FileWriter) Write(data []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("file writer is closed")
+	}
+	w.buffer.Write(data)
+	for w.buffer.Len() >= fileChunkSize {
+		if err := w.flush(w.buffer.Next(fileChunkSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *FileWriter) flush(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	chunk := &FileChunk{
+		FileId: w.file.FileId,
+		Data:   append([]byte(nil), data...),
+	}
+	err := DB.Create(chunk).Error
+	if err != nil {
+		return err
+	}
+	w.file.Bytes += int64(len(data))
+	return nil
+}
+
+func (w *FileWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.flush(w.buffer.Bytes())
+	w.buffer.Reset()
+	if err == nil {
+		err = w.file.Insert()
+	}
+	if err != nil {
+		w.Abort()
+	}
+	return err
+}
+
+// Abort 写入失败时删除已经保存的块
+func (w *FileWriter) Abort() {
+	w.closed = true
+	w.buffer.Reset()
+	DB.Where("file_id = ?", w.file.FileId).Delete(&FileChunk{})
+}
+
+// fileReader 按顺序逐块读取文件内容
+type fileReader struct {
+	fileId string
+	lastId int
+	data   []byte
+	done   bool
+}
+
+func NewFileReader(fileId string) io.Reader {
+	return &fileReader{fileId: fileId}
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		var chunks []FileChunk
+		err := DB.Where("file_id = ? and id > ?", r.fileId, r.lastId).Order("id").Limit(1).Find(&chunks).Error
+		if err != nil {
+			return 0, err
+		}
+		if len(chunks) == 0 {
+			r.done = true
+			continue
+		}
+		r.lastId = chunks[0].Id
+		r.data = chunks[0].Data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
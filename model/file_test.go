@@ -0,0 +1,79 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"one-api/model/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWriterAndReader(t *testing.T) {
+	DB = testutil.SetupDB(t, &File{}, &FileChunk{})
+	asserts := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), fileChunkSize/16*2+100)
+	file := &File{FileId: "file-1", UserId: 1, Filename: "input.jsonl", Purpose: FilePurposeBatch}
+	writer := NewFileWriter(file)
+	// 分多次写入，跨越块边界
+	for offset := 0; offset < len(content); offset += 300000 {
+		end := offset + 300000
+		if end > len(content) {
+			end = len(content)
+		}
+		_, err := writer.Write(content[offset:end])
+		asserts.NoError(err)
+	}
+	// Close 之前文件不可见
+	_, err := GetUserFileById("file-1", 1)
+	asserts.Error(err)
+	asserts.NoError(writer.Close())
+
+	saved, err := GetUserFileById("file-1", 1)
+	asserts.NoError(err)
+	asserts.EqualValues(len(content), saved.Bytes)
+	var chunkCount int64
+	DB.Model(&FileChunk{}).Where("file_id = ?", "file-1").Count(&chunkCount)
+	asserts.EqualValues(3, chunkCount)
+
+	data, err := io.ReadAll(NewFileReader("file-1"))
+	asserts.NoError(err)
+	asserts.Equal(content, data)
+
+	asserts.NoError(saved.Delete())
+	DB.Model(&FileChunk{}).Where("file_id = ?", "file-1").Count(&chunkCount)
+	asserts.EqualValues(0, chunkCount)
+}
+
+func TestFileWriterAbort(t *testing.T) {
+	DB = testutil.SetupDB(t, &File{}, &FileChunk{})
+	asserts := assert.New(t)
+
+	writer := NewFileWriter(&File{FileId: "file-2", UserId: 1})
+	_, err := writer.Write(make([]byte, fileChunkSize+1))
+	asserts.NoError(err)
+	writer.Abort()
+
+	var chunkCount int64
+	DB.Model(&FileChunk{}).Where("file_id = ?", "file-2").Count(&chunkCount)
+	asserts.EqualValues(0, chunkCount)
+	_, err = writer.Write([]byte("x"))
+	asserts.Error(err)
+}
+
+func TestFileWriterCloseFailure(t *testing.T) {
+	// 没有文件表，创建文件记录失败
+	DB = testutil.SetupDB(t, &FileChunk{})
+	asserts := assert.New(t)
+
+	writer := NewFileWriter(&File{FileId: "file-3", UserId: 1})
+	_, err := writer.Write(make([]byte, fileChunkSize+1))
+	asserts.NoError(err)
+	asserts.Error(writer.Close())
+
+	// 创建失败时删除已经保存的块
+	var chunkCount int64
+	DB.Model(&FileChunk{}).Where("file_id = ?", "file-3").Count(&chunkCount)
+	asserts.EqualValues(0, chunkCount)
+}
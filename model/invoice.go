@@ -0,0 +1,63 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+// StatementItem 月度账单中按模型与令牌汇总的用量
+type StatementItem struct {
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	Count            int    `json:"count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	Quota            int    `json:"quota"`
+}
+
+// GetUserInvoiceTopUps 获取用户已支付（含已退款）的在线充值订单
+func GetUserInvoiceTopUps(userId int, startTime int64, endTime int64) (topUps []*TopUp, err error) {
+	tx := DB.Where("user_id = ? and status in ?", userId, []string{"success", "refunded"})
+	if startTime != 0 {
+		tx = tx.Where("create_time >= ?", startTime)
+	}
+	if endTime != 0 {
+		tx = tx.Where("create_time < ?", endTime)
+	}
+	err = tx.Order("id desc").Find(&topUps).Error
+	return topUps, err
+}
+
+// GetUserRedeemedRedemptions 获取用户已使用的兑换码
+func GetUserRedeemedRedemptions(userId int, startTime int64, endTime int64) (redemptions []*Redemption, err error) {
+	tx := DB.Where("used_user_id = ? and status = ?", userId, common.RedemptionCodeStatusUsed)
+	if startTime != 0 {
+		tx = tx.Where("redeemed_time >= ?", startTime)
+	}
+	if endTime != 0 {
+		tx = tx.Where("redeemed_time < ?", endTime)
+	}
+	err = tx.Omit("key").Order("id desc").Find(&redemptions).Error
+	return redemptions, err
+}
+
+// GetUserStatementItems 按模型与令牌汇总用户在时间段内的消费日志
+// 未开启消费日志时日志为空，此时退回到数据看板的按模型统计数据
+func GetUserStatementItems(userId int, startTime int64, endTime int64) (items []*StatementItem, err error) {
+	err = DB.Table("logs").
+		Select("model_name, token_name, count(*) as count, coalesce(sum(prompt_tokens), 0) as prompt_tokens, coalesce(sum(completion_tokens), 0) as completion_tokens, coalesce(sum(prompt_tokens + completion_tokens), 0) as total_tokens, coalesce(sum(quota), 0) as quota").
+		Where("user_id = ? and type = ? and created_at >= ? and created_at < ?", userId, LogTypeConsume, startTime, endTime).
+		Group("model_name, token_name").
+		Order("model_name, token_name").
+		Scan(&items).Error
+	if err != nil || len(items) > 0 {
+		return items, err
+	}
+	err = DB.Table("quota_data").
+		Select("model_name, '' as token_name, coalesce(sum(count), 0) as count, 0 as prompt_tokens, 0 as completion_tokens, coalesce(sum(token_used), 0) as total_tokens, coalesce(sum(quota), 0) as quota").
+		Where("user_id = ? and created_at >= ? and created_at < ?", userId, startTime, endTime).
+		Group("model_name").
+		Order("model_name").
+		Scan(&items).Error
+	return items, err
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -75,6 +76,24 @@ func chooseDB() (*gorm.DB, error) {
 	})
 }
 
+// registerDBMetricsCallbacks 统计数据库错误，记录不存在不计为错误
+func registerDBMetricsCallbacks(db *gorm.DB) {
+	record := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				common.MetricDBErrors.WithLabelValues(operation).Inc()
+			}
+		}
+	}
+	callback := db.Callback()
+	_ = callback.Create().After("gorm:create").Register("metrics:create", record("create"))
+	_ = callback.Query().After("gorm:query").Register("metrics:query", record("query"))
+	_ = callback.Update().After("gorm:update").Register("metrics:update", record("update"))
+	_ = callback.Delete().After("gorm:delete").Register("metrics:delete", record("delete"))
+	_ = callback.Row().After("gorm:row").Register("metrics:row", record("row"))
+	_ = callback.Raw().After("gorm:raw").Register("metrics:raw", record("raw"))
+}
+
 func InitDB() (err error) {
 	db, err := chooseDB()
 	if err == nil {
@@ -82,6 +101,7 @@ func InitDB() (err error) {
 			db = db.Debug()
 		}
 		DB = db
+		registerDBMetricsCallbacks(DB)
 		sqlDB, err := DB.DB()
 		if err != nil {
 			return err
@@ -140,10 +160,38 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&UserAlertSetting{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&SubscriptionPlan{}, &UserSubscription{}, &SubscriptionModelUsage{}, &SubscriptionOrder{})
+		if err != nil {
+			return err
+		}
 		err = db.AutoMigrate(&Task{})
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&ChannelKey{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&File{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&FileChunk{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Batch{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&PayloadCapture{})
+		if err != nil {
+			return err
+		}
 		common.SysLog("database migrated")
 		err = createRootAccountIfNeed()
 		return err
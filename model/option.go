@@ -68,6 +68,16 @@ func InitOptionMap() {
 	common.OptionMap["EpayKey"] = ""
 	common.OptionMap["Price"] = strconv.FormatFloat(constant.Price, 'f', -1, 64)
 	common.OptionMap["MinTopUp"] = strconv.Itoa(constant.MinTopUp)
+	common.OptionMap["StripeApiSecret"] = ""
+	common.OptionMap["StripeWebhookSecret"] = ""
+	common.OptionMap["StripeCurrency"] = constant.StripeCurrency
+	common.OptionMap["StripePrice"] = strconv.FormatFloat(constant.StripePrice, 'f', -1, 64)
+	common.OptionMap["StripeApiAddress"] = constant.StripeApiAddress
+	common.OptionMap["InvoiceCompanyName"] = constant.InvoiceCompanyName
+	common.OptionMap["InvoiceCompanyAddress"] = constant.InvoiceCompanyAddress
+	common.OptionMap["InvoiceCompanyTaxId"] = constant.InvoiceCompanyTaxId
+	common.OptionMap["InvoiceCompanyEmail"] = constant.InvoiceCompanyEmail
+	common.OptionMap["InvoiceTaxRate"] = strconv.FormatFloat(constant.InvoiceTaxRate, 'f', -1, 64)
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
 	common.OptionMap["GitHubClientId"] = ""
 	common.OptionMap["GitHubClientSecret"] = ""
@@ -87,11 +97,17 @@ func InitOptionMap() {
 	common.OptionMap["ModelPrice"] = common.ModelPrice2JSONString()
 	common.OptionMap["GroupRatio"] = common.GroupRatio2JSONString()
 	common.OptionMap["CompletionRatio"] = common.CompletionRatio2JSONString()
+	common.OptionMap["CacheReadRatio"] = strconv.FormatFloat(common.CacheReadRatio, 'f', -1, 64)
+	common.OptionMap["CacheCreationRatio"] = strconv.FormatFloat(common.CacheCreationRatio, 'f', -1, 64)
+	common.OptionMap["RoutingStrategy"] = common.RoutingStrategy2JSONString()
+	common.OptionMap["ModelFallback"] = common.ModelFallback2JSONString()
+	common.OptionMap["GroupRateLimit"] = common.GroupRateLimit2JSONString()
 	common.OptionMap["TopUpLink"] = common.TopUpLink
 	common.OptionMap["ChatLink"] = common.ChatLink
 	common.OptionMap["ChatLink2"] = common.ChatLink2
 	common.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(common.QuotaPerUnit, 'f', -1, 64)
 	common.OptionMap["RetryTimes"] = strconv.Itoa(common.RetryTimes)
+	common.OptionMap["RetryTimeout"] = strconv.Itoa(common.RetryTimeout)
 	common.OptionMap["DataExportInterval"] = strconv.Itoa(common.DataExportInterval)
 	common.OptionMap["DataExportDefaultTime"] = common.DataExportDefaultTime
 	common.OptionMap["DefaultCollapseSidebar"] = strconv.FormatBool(common.DefaultCollapseSidebar)
@@ -106,6 +122,20 @@ func InitOptionMap() {
 	common.OptionMap["StopOnSensitiveEnabled"] = strconv.FormatBool(constant.StopOnSensitiveEnabled)
 	common.OptionMap["SensitiveWords"] = constant.SensitiveWordsToString()
 	common.OptionMap["StreamCacheQueueLength"] = strconv.Itoa(constant.StreamCacheQueueLength)
+	common.OptionMap["ResponseCacheEnabled"] = strconv.FormatBool(constant.ResponseCacheEnabled)
+	common.OptionMap["ResponseCacheTTL"] = strconv.Itoa(constant.ResponseCacheTTL)
+	common.OptionMap["ResponseCacheQuotaRatio"] = strconv.FormatFloat(constant.ResponseCacheQuotaRatio, 'f', -1, 64)
+	common.OptionMap["SemanticCacheEnabled"] = strconv.FormatBool(constant.SemanticCacheEnabled)
+	common.OptionMap["SemanticCacheThreshold"] = strconv.FormatFloat(constant.SemanticCacheThreshold, 'f', -1, 64)
+	common.OptionMap["SemanticCacheEmbeddingModel"] = constant.SemanticCacheEmbeddingModel
+	common.OptionMap["SemanticCacheChannelId"] = strconv.Itoa(constant.SemanticCacheChannelId)
+	common.OptionMap["PayloadCaptureGroups"] = constant.PayloadCaptureGroups2JSONString()
+	common.OptionMap["PayloadCaptureRedactPatterns"] = constant.PayloadCaptureRedactPatterns2JSONString()
+	common.OptionMap["PayloadCaptureMaxBytes"] = strconv.Itoa(constant.PayloadCaptureMaxBytes)
+	common.OptionMap["PayloadCaptureRetentionDays"] = strconv.Itoa(constant.PayloadCaptureRetentionDays)
+	common.OptionMap["NotificationSinks"] = common.NotificationSinks2JSONString()
+	common.OptionMap["NotificationDedupeSeconds"] = strconv.Itoa(common.NotificationDedupeSeconds)
+	common.OptionMap["ChannelBalanceLowThreshold"] = strconv.FormatFloat(common.ChannelBalanceLowThreshold, 'f', -1, 64)
 
 	common.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
@@ -221,6 +251,10 @@ func updateOptionMap(key string, value string) (err error) {
 		//	constant.CheckSensitiveOnCompletionEnabled = boolValue
 		case "StopOnSensitiveEnabled":
 			constant.StopOnSensitiveEnabled = boolValue
+		case "ResponseCacheEnabled":
+			constant.ResponseCacheEnabled = boolValue
+		case "SemanticCacheEnabled":
+			constant.SemanticCacheEnabled = boolValue
 		case "SMTPSSLEnabled":
 			common.SMTPSSLEnabled = boolValue
 		}
@@ -257,6 +291,26 @@ func updateOptionMap(key string, value string) (err error) {
 		constant.Price, _ = strconv.ParseFloat(value, 64)
 	case "MinTopUp":
 		constant.MinTopUp, _ = strconv.Atoi(value)
+	case "StripeApiSecret":
+		constant.StripeApiSecret = value
+	case "StripeWebhookSecret":
+		constant.StripeWebhookSecret = value
+	case "StripeCurrency":
+		constant.StripeCurrency = strings.ToLower(value)
+	case "StripePrice":
+		constant.StripePrice, _ = strconv.ParseFloat(value, 64)
+	case "StripeApiAddress":
+		constant.StripeApiAddress = strings.TrimSuffix(value, "/")
+	case "InvoiceCompanyName":
+		constant.InvoiceCompanyName = value
+	case "InvoiceCompanyAddress":
+		constant.InvoiceCompanyAddress = value
+	case "InvoiceCompanyTaxId":
+		constant.InvoiceCompanyTaxId = value
+	case "InvoiceCompanyEmail":
+		constant.InvoiceCompanyEmail = value
+	case "InvoiceTaxRate":
+		constant.InvoiceTaxRate, _ = strconv.ParseFloat(value, 64)
 	case "TopupGroupRatio":
 		err = common.UpdateTopupGroupRatioByJSONString(value)
 	case "GitHubClientId":
@@ -295,6 +349,8 @@ func updateOptionMap(key string, value string) (err error) {
 		common.PreConsumedQuota, _ = strconv.Atoi(value)
 	case "RetryTimes":
 		common.RetryTimes, _ = strconv.Atoi(value)
+	case "RetryTimeout":
+		common.RetryTimeout, _ = strconv.Atoi(value)
 	case "DataExportInterval":
 		common.DataExportInterval, _ = strconv.Atoi(value)
 	case "DataExportDefaultTime":
@@ -307,6 +363,12 @@ func updateOptionMap(key string, value string) (err error) {
 		err = common.UpdateCompletionRatioByJSONString(value)
 	case "ModelPrice":
 		err = common.UpdateModelPriceByJSONString(value)
+	case "RoutingStrategy":
+		err = common.UpdateRoutingStrategyByJSONString(value)
+	case "ModelFallback":
+		err = common.UpdateModelFallbackByJSONString(value)
+	case "GroupRateLimit":
+		err = common.UpdateGroupRateLimitByJSONString(value)
 	case "TopUpLink":
 		common.TopUpLink = value
 	case "ChatLink":
@@ -315,12 +377,40 @@ func updateOptionMap(key string, value string) (err error) {
 		common.ChatLink2 = value
 	case "ChannelDisableThreshold":
 		common.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
+	case "CacheReadRatio":
+		common.CacheReadRatio, _ = strconv.ParseFloat(value, 64)
+	case "CacheCreationRatio":
+		common.CacheCreationRatio, _ = strconv.ParseFloat(value, 64)
 	case "QuotaPerUnit":
 		common.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
 	case "SensitiveWords":
 		constant.SensitiveWordsFromString(value)
 	case "StreamCacheQueueLength":
 		constant.StreamCacheQueueLength, _ = strconv.Atoi(value)
+	case "ResponseCacheTTL":
+		constant.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "ResponseCacheQuotaRatio":
+		constant.ResponseCacheQuotaRatio, _ = strconv.ParseFloat(value, 64)
+	case "SemanticCacheThreshold":
+		constant.SemanticCacheThreshold, _ = strconv.ParseFloat(value, 64)
+	case "SemanticCacheEmbeddingModel":
+		constant.SemanticCacheEmbeddingModel = value
+	case "SemanticCacheChannelId":
+		constant.SemanticCacheChannelId, _ = strconv.Atoi(value)
+	case "PayloadCaptureGroups":
+		err = constant.UpdatePayloadCaptureGroupsByJSONString(value)
+	case "PayloadCaptureRedactPatterns":
+		err = constant.UpdatePayloadCaptureRedactPatternsByJSONString(value)
+	case "PayloadCaptureMaxBytes":
+		constant.PayloadCaptureMaxBytes, _ = strconv.Atoi(value)
+	case "PayloadCaptureRetentionDays":
+		constant.PayloadCaptureRetentionDays, _ = strconv.Atoi(value)
+	case "NotificationSinks":
+		err = common.UpdateNotificationSinksByJSONString(value)
+	case "NotificationDedupeSeconds":
+		common.NotificationDedupeSeconds, _ = strconv.Atoi(value)
+	case "ChannelBalanceLowThreshold":
+		common.ChannelBalanceLowThreshold, _ = strconv.ParseFloat(value, 64)
 	}
 	return err
 }
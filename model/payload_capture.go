@@ -0,0 +1,40 @@
+package model
+
+// PayloadCapture 用于审计的完整请求与响应内容，通过 LogId 关联消费日志
+type PayloadCapture struct {
+	Id              int    `json:"id"`
+	LogId           int    `json:"log_id" gorm:"index"`
+	RequestId       string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId          int    `json:"user_id" gorm:"index"`
+	TokenId         int    `json:"token_id" gorm:"default:0"`
+	ChannelId       int    `json:"channel_id" gorm:"default:0"`
+	ModelName       string `json:"model_name" gorm:"default:''"`
+	StatusCode      int    `json:"status_code" gorm:"default:0"`
+	IsStream        bool   `json:"is_stream" gorm:"default:false"`
+	Encrypted       bool   `json:"encrypted" gorm:"default:false"`
+	Request         string `json:"request"`
+	UpstreamRequest string `json:"upstream_request"`
+	Response        string `json:"response"`
+	CreatedAt       int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (capture *PayloadCapture) Insert() error {
+	return DB.Create(capture).Error
+}
+
+func GetPayloadCaptureByLogId(logId int) (*PayloadCapture, error) {
+	capture := &PayloadCapture{}
+	err := DB.Where("log_id = ?", logId).Order("id desc").First(capture).Error
+	return capture, err
+}
+
+func GetPayloadCaptureByRequestId(requestId string) (*PayloadCapture, error) {
+	capture := &PayloadCapture{}
+	err := DB.Where("request_id = ?", requestId).Order("id desc").First(capture).Error
+	return capture, err
+}
+
+func DeleteOldPayloadCaptures(targetTimestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", targetTimestamp).Delete(&PayloadCapture{})
+	return result.RowsAffected, result.Error
+}
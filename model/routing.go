@@ -0,0 +1,227 @@
+package model
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"hash/fnv"
+	"math/rand"
+	"one-api/common"
+	"sync"
+	"sync/atomic"
+)
+
+// channelLatencyStat 渠道在某个模型上的首字响应时间与失败率的指数加权平均
+type channelLatencyStat struct {
+	latencyMs   float64
+	failureRate float64
+}
+
+var channelLatencies = make(map[string]*channelLatencyStat)
+var channelLatencyLock sync.RWMutex
+
+var channelInFlights = make(map[int]*int64)
+var channelInFlightLock sync.Mutex
+
+const latencySmoothingFactor = 0.2
+
+// latencyFailurePenalty 失败率为 100% 时延迟得分放大的倍数
+const latencyFailurePenalty = 4
+
+func getChannelLatencyStat(channelId int, model string) *channelLatencyStat {
+	key := circuitKey(channelId, model)
+	stat, ok := channelLatencies[key]
+	if !ok {
+		stat = &channelLatencyStat{}
+		channelLatencies[key] = stat
+	}
+	return stat
+}
+
+// RecordChannelFirstResponseTime 更新渠道在该模型上的首字响应时间 EWMA
+func RecordChannelFirstResponseTime(channelId int, model string, frtMs float64) {
+	if channelId == 0 || frtMs <= 0 {
+		return
+	}
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	stat := getChannelLatencyStat(channelId, model)
+	if stat.latencyMs == 0 {
+		stat.latencyMs = frtMs
+		return
+	}
+	stat.latencyMs += latencySmoothingFactor * (frtMs - stat.latencyMs)
+}
+
+// recordChannelFailureRate 更新渠道在该模型上的失败率 EWMA，与熔断是否开启无关
+func recordChannelFailureRate(channelId int, model string, failed bool) {
+	if channelId == 0 {
+		return
+	}
+	result := 0.0
+	if failed {
+		result = 1
+	}
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	stat := getChannelLatencyStat(channelId, model)
+	stat.failureRate += latencySmoothingFactor * (result - stat.failureRate)
+}
+
+func getChannelLatency(channelId int, model string) (latencyMs float64, failureRate float64) {
+	channelLatencyLock.RLock()
+	defer channelLatencyLock.RUnlock()
+	stat, ok := channelLatencies[circuitKey(channelId, model)]
+	if !ok {
+		return 0, 0
+	}
+	return stat.latencyMs, stat.failureRate
+}
+
+// channelLatencyScores 计算 least_latency 的得分，失败率越高得分越高；
+// 没有延迟数据的渠道使用其他候选渠道的平均延迟作为先验，既不会被一直优先也不会被饿死
+func channelLatencyScores(channels []*Channel, model string) map[int]float64 {
+	latencies := make(map[int]float64, len(channels))
+	failureRates := make(map[int]float64, len(channels))
+	sum, known := 0.0, 0
+	for _, channel := range channels {
+		latencyMs, failureRate := getChannelLatency(channel.Id, model)
+		latencies[channel.Id], failureRates[channel.Id] = latencyMs, failureRate
+		if latencyMs > 0 {
+			sum += latencyMs
+			known++
+		}
+	}
+	prior := 0.0
+	if known > 0 {
+		prior = sum / float64(known)
+	}
+	scores := make(map[int]float64, len(channels))
+	for _, channel := range channels {
+		latencyMs := latencies[channel.Id]
+		if latencyMs == 0 {
+			latencyMs = prior
+		}
+		scores[channel.Id] = latencyMs * (1 + latencyFailurePenalty*failureRates[channel.Id])
+	}
+	return scores
+}
+
+func getChannelInFlightCounter(channelId int) *int64 {
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	counter, ok := channelInFlights[channelId]
+	if !ok {
+		counter = new(int64)
+		channelInFlights[channelId] = counter
+	}
+	return counter
+}
+
+func IncreaseChannelInFlight(channelId int) {
+	atomic.AddInt64(getChannelInFlightCounter(channelId), 1)
+}
+
+func DecreaseChannelInFlight(channelId int) {
+	atomic.AddInt64(getChannelInFlightCounter(channelId), -1)
+}
+
+func GetChannelInFlight(channelId int) int64 {
+	return atomic.LoadInt64(getChannelInFlightCounter(channelId))
+}
+
+// excludeChannels 去掉本次请求中已经尝试过的渠道，返回新的切片，不修改缓存中的列表
+func excludeChannels(channels []*Channel, excludeChannelIds []int) []*Channel {
+	if len(excludeChannelIds) == 0 {
+		return channels
+	}
+	result := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !lo.Contains(excludeChannelIds, channel.Id) {
+			result = append(result, channel)
+		}
+	}
+	return result
+}
+
+// weightedRandomChannel 按权重随机选择，权重按被动健康分折算，错误率越高被选中的概率越低
+func weightedRandomChannel(channels []*Channel, model string) *Channel {
+	// 平滑系数
+	smoothingFactor := 10
+	totalWeight := 0
+	weights := make([]int, len(channels))
+	for i, channel := range channels {
+		weights[i] = int(float64(channel.GetWeight()+smoothingFactor) * channelHealthScore(channel.Id, model))
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+		totalWeight += weights[i]
+	}
+	// Generate a random value in the range [0, totalWeight)
+	randomWeight := rand.Intn(totalWeight)
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// minScoreChannel 选出得分最低的渠道，得分相同的按权重随机
+func minScoreChannel(channels []*Channel, model string, score func(channel *Channel) float64) *Channel {
+	var candidates []*Channel
+	minScore := 0.0
+	for _, channel := range channels {
+		channelScore := score(channel)
+		if len(candidates) == 0 || channelScore < minScore {
+			minScore = channelScore
+			candidates = []*Channel{channel}
+		} else if channelScore == minScore {
+			candidates = append(candidates, channel)
+		}
+	}
+	return weightedRandomChannel(candidates, model)
+}
+
+// stickyChannel 使用最高随机权重哈希，同一用户固定落在同一渠道，渠道增减时只影响少量用户
+func stickyChannel(channels []*Channel, userId int) *Channel {
+	var selected *Channel
+	var maxHash uint64
+	for _, channel := range channels {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(fmt.Sprintf("%d:%d", userId, channel.Id)))
+		hash := h.Sum64()
+		if selected == nil || hash > maxHash {
+			selected = channel
+			maxHash = hash
+		}
+	}
+	return selected
+}
+
+// selectChannelByStrategy 在同一优先级的候选渠道中按分组/模型配置的路由策略选出一个渠道
+func selectChannelByStrategy(userId int, group string, model string, channels []*Channel) *Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	switch common.GetRoutingStrategy(group, model) {
+	case common.RoutingStrategyLeastLatency:
+		scores := channelLatencyScores(channels, model)
+		return minScoreChannel(channels, model, func(channel *Channel) float64 {
+			return scores[channel.Id]
+		})
+	case common.RoutingStrategyCheapest:
+		return minScoreChannel(channels, model, func(channel *Channel) float64 {
+			return channel.GetCostMultiplier()
+		})
+	case common.RoutingStrategyLeastInFlight:
+		return minScoreChannel(channels, model, func(channel *Channel) float64 {
+			return float64(GetChannelInFlight(channel.Id))
+		})
+	case common.RoutingStrategySticky:
+		if userId != 0 {
+			return stickyChannel(channels, userId)
+		}
+	}
+	return weightedRandomChannel(channels, model)
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelLatencyScores(t *testing.T) {
+	asserts := assert.New(t)
+	common.RoutingStrategy = map[string]string{"default": common.RoutingStrategyLeastLatency}
+	t.Cleanup(func() {
+		common.RoutingStrategy = map[string]string{}
+	})
+	channelLatencyLock.Lock()
+	channelLatencies = make(map[string]*channelLatencyStat)
+	channelLatencyLock.Unlock()
+	channels := []*Channel{{Id: 1}, {Id: 2}, {Id: 3}}
+
+	RecordChannelFirstResponseTime(1, "gpt-4o", 100)
+	RecordChannelFirstResponseTime(2, "gpt-4o", 300)
+	scores := channelLatencyScores(channels, "gpt-4o")
+	asserts.Equal(100.0, scores[1])
+	asserts.Equal(300.0, scores[2])
+	// 没有数据的渠道使用平均延迟作为先验，而不是 0
+	asserts.Equal(200.0, scores[3])
+
+	// 熔断关闭时也记录失败率，失败会抬高得分
+	RecordChannelResult(1, "gpt-4o", true, time.Second)
+	latencyMs, failureRate := getChannelLatency(1, "gpt-4o")
+	asserts.Equal(100.0, latencyMs)
+	asserts.InDelta(0.2, failureRate, 1e-9)
+	scores = channelLatencyScores(channels, "gpt-4o")
+	asserts.InDelta(180.0, scores[1], 1e-9)
+	asserts.Equal(1, selectChannelByStrategy(0, "default", "gpt-4o", channels[:2]).Id)
+	asserts.Equal(3, selectChannelByStrategy(0, "default", "gpt-4o", []*Channel{channels[0], channels[2]}).Id)
+}
@@ -0,0 +1,467 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SubscriptionPeriodWeek  = "week"
+	SubscriptionPeriodMonth = "month"
+)
+
+const (
+	SubscriptionStatusActive    = "active"
+	SubscriptionStatusExpired   = "expired"
+	SubscriptionStatusUpgraded  = "upgraded"
+	SubscriptionStatusCancelled = "cancelled"
+)
+
+// SubscriptionPlan 订阅套餐，每个周期发放 Quota 额度，ModelRequests 中的模型在次数内不消耗额度
+type SubscriptionPlan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"type:varchar(64)"`
+	Description   string  `json:"description"`
+	Period        string  `json:"period" gorm:"type:varchar(16);default:'month'"`
+	Price         float64 `json:"price" gorm:"default:0"` // 每周期价格，单位与充值一致
+	Quota         int     `json:"quota" gorm:"default:0"`
+	ModelRequests string  `json:"model_requests" gorm:"type:text"` // {"gpt-4o": 100}
+	UpgradeGroup  string  `json:"upgrade_group" gorm:"type:varchar(64);default:''"`
+	Enabled       bool    `json:"enabled" gorm:"default:true"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+}
+
+// UserSubscription 用户订阅，同一用户同时只有一个生效的订阅
+// 开启自动续订时，有支付渠道周期订阅（ProviderSubscriptionId）的由支付渠道按周期扣款续订，否则到期时从钱包余额续订
+type UserSubscription struct {
+	Id                     int    `json:"id"`
+	UserId                 int    `json:"user_id" gorm:"index"`
+	PlanId                 int    `json:"plan_id" gorm:"index"`
+	Status                 string `json:"status" gorm:"type:varchar(16);index"`
+	StartTime              int64  `json:"start_time" gorm:"bigint"`
+	ExpireTime             int64  `json:"expire_time" gorm:"bigint;index"`
+	RemainQuota            int    `json:"remain_quota" gorm:"default:0"`
+	UsedQuota              int    `json:"used_quota" gorm:"default:0"`
+	AutoRenew              bool   `json:"auto_renew" gorm:"default:false"`
+	PreviousGroup          string `json:"previous_group" gorm:"type:varchar(64);default:''"`
+	PaymentProvider        string `json:"payment_provider" gorm:"type:varchar(32);default:''"`     // 周期订阅所在的支付渠道
+	ProviderSubscriptionId string `json:"provider_subscription_id" gorm:"type:varchar(128);index"` // 支付渠道的周期订阅 id
+	CreatedTime            int64  `json:"created_time" gorm:"bigint"`
+}
+
+// SubscriptionModelUsage 订阅当前周期内各模型已使用的请求次数
+type SubscriptionModelUsage struct {
+	Id             int    `json:"id"`
+	SubscriptionId int    `json:"subscription_id" gorm:"uniqueIndex:idx_sub_model,priority:1"`
+	ModelName      string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_sub_model,priority:2"`
+	Used           int    `json:"used" gorm:"default:0"`
+}
+
+// SubscriptionOrder 订阅购买订单
+type SubscriptionOrder struct {
+	Id                     int     `json:"id"`
+	UserId                 int     `json:"user_id" gorm:"index"`
+	PlanId                 int     `json:"plan_id"`
+	SubscriptionId         int     `json:"subscription_id" gorm:"default:0"`
+	Money                  float64 `json:"money"`
+	TradeNo                string  `json:"trade_no" gorm:"type:varchar(64);uniqueIndex"`
+	PaymentMethod          string  `json:"payment_method" gorm:"type:varchar(32)"`
+	ProviderTradeNo        string  `json:"provider_trade_no" gorm:"type:varchar(128);index"`
+	ProviderSubscriptionId string  `json:"provider_subscription_id" gorm:"type:varchar(128);default:''"` // 开通时写入订阅，后续周期按它续订
+	AutoRenew              bool    `json:"auto_renew" gorm:"default:false"`
+	Status                 string  `json:"status" gorm:"type:varchar(16)"`
+	RefundedMoney          float64 `json:"refunded_money" gorm:"default:0"` // 支付渠道返回的累计退款金额
+	CreateTime             int64   `json:"create_time" gorm:"bigint"`
+}
+
+func (plan *SubscriptionPlan) GetModelRequests() map[string]int {
+	modelRequests := make(map[string]int)
+	if plan.ModelRequests == "" {
+		return modelRequests
+	}
+	err := json.Unmarshal([]byte(plan.ModelRequests), &modelRequests)
+	if err != nil {
+		common.SysError("error unmarshalling plan model requests: " + err.Error())
+	}
+	return modelRequests
+}
+
+// PeriodEnd 返回从 start 开始的一个周期的结束时间
+func (plan *SubscriptionPlan) PeriodEnd(start int64) int64 {
+	startTime := time.Unix(start, 0)
+	if plan.Period == SubscriptionPeriodWeek {
+		return startTime.AddDate(0, 0, 7).Unix()
+	}
+	return startTime.AddDate(0, 1, 0).Unix()
+}
+
+func GetAllSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	tx := DB.Order("id asc")
+	if enabledOnly {
+		tx = tx.Where("enabled = ?", true)
+	}
+	err = tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "period", "price", "quota", "model_requests", "upgrade_group", "enabled").Updates(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetActiveUserSubscription(userId int) (*UserSubscription, error) {
+	subscription := &UserSubscription{}
+	err := DB.Where("user_id = ? and status = ? and expire_time > ?", userId, SubscriptionStatusActive, common.GetTimestamp()).
+		Order("id desc").First(subscription).Error
+	return subscription, err
+}
+
+func GetUserSubscriptionById(id int) (*UserSubscription, error) {
+	subscription := &UserSubscription{}
+	err := DB.First(subscription, "id = ?", id).Error
+	return subscription, err
+}
+
+func GetUserSubscriptions(userId int, startIdx int, num int) (subscriptions []*UserSubscription, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// GetDueUserSubscriptions 获取已到期但仍为生效状态的订阅，用于续订或过期处理；
+// 由支付渠道自动续订的订阅在到期后 providerGrace 秒内等待支付渠道扣款，不会返回
+func GetDueUserSubscriptions(limit int, providerGrace int64) (subscriptions []*UserSubscription, err error) {
+	now := common.GetTimestamp()
+	err = DB.Where("status = ? and expire_time <= ?", SubscriptionStatusActive, now).
+		Where("provider_subscription_id = ? or auto_renew = ? or expire_time <= ?", "", false, now-providerGrace).
+		Limit(limit).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func GetUserSubscriptionByProviderSubscriptionId(providerSubscriptionId string) (*UserSubscription, error) {
+	subscription := &UserSubscription{}
+	err := DB.Where("provider_subscription_id = ?", providerSubscriptionId).Order("id desc").First(subscription).Error
+	return subscription, err
+}
+
+func (subscription *UserSubscription) UpdateAutoRenew(autoRenew bool) error {
+	subscription.AutoRenew = autoRenew
+	return DB.Model(subscription).Update("auto_renew", autoRenew).Error
+}
+
+func GetSubscriptionModelUsages(subscriptionId int) (usages []*SubscriptionModelUsage, err error) {
+	err = DB.Where("subscription_id = ?", subscriptionId).Find(&usages).Error
+	return usages, err
+}
+
+func GetSubscriptionModelUsed(subscriptionId int, modelName string) int {
+	usage := &SubscriptionModelUsage{}
+	err := DB.Where("subscription_id = ? and model_name = ?", subscriptionId, modelName).First(usage).Error
+	if err != nil {
+		return 0
+	}
+	return usage.Used
+}
+
+// ConsumeSubscriptionModelRequest 占用一次模型请求次数，次数已用完时返回 false
+func ConsumeSubscriptionModelRequest(subscriptionId int, modelName string, limit int) (bool, error) {
+	usage := &SubscriptionModelUsage{SubscriptionId: subscriptionId, ModelName: modelName}
+	err := DB.Where("subscription_id = ? and model_name = ?", subscriptionId, modelName).FirstOrCreate(usage).Error
+	if err != nil {
+		return false, err
+	}
+	result := DB.Model(&SubscriptionModelUsage{}).Where("id = ? and used < ?", usage.Id, limit).
+		Update("used", gorm.Expr("used + ?", 1))
+	return result.RowsAffected == 1, result.Error
+}
+
+// ConsumeSubscriptionQuota 从订阅额度中扣减，额度不足时扣完剩余部分，返回实际扣减的额度
+func ConsumeSubscriptionQuota(subscriptionId int, quota int) (int, error) {
+	if quota <= 0 {
+		return 0, nil
+	}
+	for i := 0; i < 3; i++ {
+		subscription, err := GetUserSubscriptionById(subscriptionId)
+		if err != nil {
+			return 0, err
+		}
+		consumed := quota
+		if subscription.RemainQuota < consumed {
+			consumed = subscription.RemainQuota
+		}
+		if consumed <= 0 {
+			return 0, nil
+		}
+		result := DB.Model(&UserSubscription{}).Where("id = ? and remain_quota = ?", subscriptionId, subscription.RemainQuota).Updates(map[string]interface{}{
+			"remain_quota": gorm.Expr("remain_quota - ?", consumed),
+			"used_quota":   gorm.Expr("used_quota + ?", consumed),
+		})
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return consumed, nil
+		}
+	}
+	return 0, errors.New("订阅额度扣减冲突，请稍后重试")
+}
+
+// RefundSubscriptionQuota 退回预扣费时从订阅中预留但没有用掉的额度
+func RefundSubscriptionQuota(subscriptionId int, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	return DB.Model(&UserSubscription{}).Where("id = ?", subscriptionId).Updates(map[string]interface{}{
+		"remain_quota": gorm.Expr("remain_quota + ?", quota),
+		"used_quota":   gorm.Expr("used_quota - ?", quota),
+	}).Error
+}
+
+// RefundSubscriptionModelRequest 请求失败时退回预扣费占用的一次模型请求次数
+func RefundSubscriptionModelRequest(subscriptionId int, modelName string) error {
+	return DB.Model(&SubscriptionModelUsage{}).Where("subscription_id = ? and model_name = ? and used > 0", subscriptionId, modelName).
+		Update("used", gorm.Expr("used - ?", 1)).Error
+}
+
+// ErrInsufficientUserQuota 钱包余额不足以支付订阅
+var ErrInsufficientUserQuota = errors.New("insufficient user quota")
+
+// debitUserQuota 在事务中扣除钱包额度，余额不足时不扣除，并发扣费不会同时通过余额检查
+func debitUserQuota(tx *gorm.DB, userId int, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrInsufficientUserQuota
+	}
+	return nil
+}
+
+// ActivateUserSubscription 按订单为用户开通订阅，替换当前生效的订阅，并按套餐升级用户分组；
+// walletCost 大于 0 时在同一事务中从钱包扣除，余额不足或开通失败都不会扣费
+func ActivateUserSubscription(order *SubscriptionOrder, plan *SubscriptionPlan, walletCost int) (*UserSubscription, error) {
+	userId := order.UserId
+	now := common.GetTimestamp()
+	subscription := &UserSubscription{
+		UserId:      userId,
+		PlanId:      plan.Id,
+		Status:      SubscriptionStatusActive,
+		StartTime:   now,
+		ExpireTime:  plan.PeriodEnd(now),
+		RemainQuota: plan.Quota,
+		AutoRenew:   order.AutoRenew,
+		CreatedTime: now,
+	}
+	if order.ProviderSubscriptionId != "" {
+		subscription.PaymentProvider = order.PaymentMethod
+		subscription.ProviderSubscriptionId = order.ProviderSubscriptionId
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		user := &User{}
+		err := tx.Set("gorm:query_option", "FOR UPDATE").First(user, "id = ?", userId).Error
+		if err != nil {
+			return err
+		}
+		if err = debitUserQuota(tx, userId, walletCost); err != nil {
+			return err
+		}
+		subscription.PreviousGroup = user.Group
+		current := &UserSubscription{}
+		err = tx.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Order("id desc").First(current).Error
+		if err == nil {
+			// 升级时保留订阅前的原始分组，过期后恢复
+			subscription.PreviousGroup = current.PreviousGroup
+			err = tx.Model(&UserSubscription{}).Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).
+				Update("status", SubscriptionStatusUpgraded).Error
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err = tx.Create(subscription).Error; err != nil {
+			return err
+		}
+		group := subscription.PreviousGroup
+		if plan.UpgradeGroup != "" {
+			group = plan.UpgradeGroup
+		}
+		if group != user.Group {
+			return tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if walletCost > 0 {
+		_ = CacheUpdateUserQuota(userId)
+	}
+	invalidateUserSubscriptionCache(userId)
+	return subscription, nil
+}
+
+// RenewUserSubscription 续订一个周期，重置周期额度与模型请求次数；
+// walletCost 大于 0 时在同一事务中从钱包扣除，余额不足或续订失败都不会扣费
+func RenewUserSubscription(subscription *UserSubscription, plan *SubscriptionPlan, walletCost int) error {
+	start := subscription.ExpireTime
+	if now := common.GetTimestamp(); start < now {
+		start = now
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := debitUserQuota(tx, subscription.UserId, walletCost); err != nil {
+			return err
+		}
+		err := tx.Model(subscription).Updates(map[string]interface{}{
+			"start_time":   start,
+			"expire_time":  plan.PeriodEnd(start),
+			"remain_quota": plan.Quota,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("subscription_id = ?", subscription.Id).Delete(&SubscriptionModelUsage{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if walletCost > 0 {
+		_ = CacheUpdateUserQuota(subscription.UserId)
+	}
+	invalidateUserSubscriptionCache(subscription.UserId)
+	return nil
+}
+
+// ExpireUserSubscription 将订阅标记为过期或取消，并恢复订阅前的用户分组
+func ExpireUserSubscription(subscription *UserSubscription, status string, upgradeGroup string) error {
+	groupCol := "`group`"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&UserSubscription{}).Where("id = ? and status = ?", subscription.Id, SubscriptionStatusActive).Update("status", status)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if upgradeGroup == "" || subscription.PreviousGroup == "" {
+			return nil
+		}
+		// 管理员在订阅期间手动调整过分组时不再恢复
+		return tx.Model(&User{}).Where("id = ? and "+groupCol+" = ?", subscription.UserId, upgradeGroup).Update("group", subscription.PreviousGroup).Error
+	})
+	if err != nil {
+		return err
+	}
+	subscription.Status = status
+	invalidateUserSubscriptionCache(subscription.UserId)
+	return nil
+}
+
+func invalidateUserSubscriptionCache(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	_ = common.RedisDel(fmt.Sprintf("user_subscription:%d", userId))
+	_ = common.RedisDel(fmt.Sprintf("user_group:%d", userId))
+}
+
+// CacheGetActiveUserSubscriptionId 返回用户当前生效订阅的 id，没有订阅时返回 0
+func CacheGetActiveUserSubscriptionId(userId int) (int, error) {
+	key := fmt.Sprintf("user_subscription:%d", userId)
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err == nil {
+			parts := strings.SplitN(value, ":", 2)
+			if len(parts) == 2 {
+				id, _ := strconv.Atoi(parts[0])
+				expireTime, _ := strconv.ParseInt(parts[1], 10, 64)
+				if id == 0 || expireTime > common.GetTimestamp() {
+					return id, nil
+				}
+			}
+		}
+	}
+	subscription, err := GetActiveUserSubscription(userId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	value := "0:0"
+	if err == nil {
+		value = fmt.Sprintf("%d:%d", subscription.Id, subscription.ExpireTime)
+	}
+	if common.RedisEnabled {
+		err = common.RedisSet(key, value, time.Duration(UserId2GroupCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("Redis set user subscription error: " + err.Error())
+		}
+	}
+	if subscription.Status != SubscriptionStatusActive {
+		return 0, nil
+	}
+	return subscription.Id, nil
+}
+
+func (order *SubscriptionOrder) Insert() error {
+	return DB.Create(order).Error
+}
+
+func (order *SubscriptionOrder) Update() error {
+	return DB.Save(order).Error
+}
+
+// Claim 将待处理订单标记为成功，返回是否由本次调用完成标记
+func (order *SubscriptionOrder) Claim() (bool, error) {
+	result := DB.Model(&SubscriptionOrder{}).Where("id = ? and status = ?", order.Id, "pending").Updates(map[string]interface{}{
+		"status":                   "success",
+		"provider_trade_no":        order.ProviderTradeNo,
+		"provider_subscription_id": order.ProviderSubscriptionId,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	order.Status = "success"
+	return result.RowsAffected == 1, nil
+}
+
+func GetSubscriptionOrderByTradeNo(tradeNo string) *SubscriptionOrder {
+	order := &SubscriptionOrder{}
+	err := DB.Where("trade_no = ?", tradeNo).First(order).Error
+	if err != nil {
+		return nil
+	}
+	return order
+}
+
+func GetSubscriptionOrderByProviderTradeNo(providerTradeNo string) *SubscriptionOrder {
+	order := &SubscriptionOrder{}
+	err := DB.Where("provider_trade_no = ?", providerTradeNo).First(order).Error
+	if err != nil {
+		return nil
+	}
+	return order
+}
@@ -0,0 +1,32 @@
+// Package testutil 提供各包测试共用的辅助函数，只能在测试中使用
+package testutil
+
+import (
+	"one-api/common"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// SetupDB 创建测试独享的内存数据库并迁移需要的表，测试结束时关闭。
+// 测试期间关闭 Redis 与内存缓存，读写都直接走数据库。
+// 不引用 model 包，以便 model 包内的测试也能使用，调用方自行将返回值赋给 model.DB
+func SetupDB(t testing.TB, models ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	redisEnabled, memoryCacheEnabled := common.RedisEnabled, common.MemoryCacheEnabled
+	common.RedisEnabled, common.MemoryCacheEnabled = false, false
+	t.Cleanup(func() {
+		common.RedisEnabled, common.MemoryCacheEnabled = redisEnabled, memoryCacheEnabled
+		sqlDB, _ := db.DB()
+		_ = sqlDB.Close()
+	})
+	return db
+}
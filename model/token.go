@@ -24,6 +24,10 @@ type Token struct {
 	ModelLimitsEnabled bool           `json:"model_limits_enabled" gorm:"default:false"`
 	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
+	RpmLimit           int            `json:"rpm_limit" gorm:"default:0"`
+	TpmLimit           int            `json:"tpm_limit" gorm:"default:0"`
+	RpdLimit           int            `json:"rpd_limit" gorm:"default:0"`
+	PayloadCapture     bool           `json:"payload_capture" gorm:"default:false"` // 保存该令牌请求与响应的完整内容
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -130,10 +134,36 @@ func (token *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() error {
 	var err error
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "model_limits_enabled", "model_limits").Updates(token).Error
+	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "model_limits_enabled", "model_limits",
+		"rpm_limit", "tpm_limit", "rpd_limit").Updates(token).Error
 	return err
 }
 
+// UpdateTokenPayloadCapture 单独更新完整内容保存开关，不随用户修改令牌时提交的字段变化
+func UpdateTokenPayloadCapture(id int, enabled bool) error {
+	token, err := GetTokenById(id)
+	if err != nil {
+		return err
+	}
+	token.PayloadCapture = enabled
+	err = DB.Model(token).Select("payload_capture").Updates(token).Error
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		_ = cacheSetToken(token)
+	}
+	return nil
+}
+
+func (token *Token) GetRateLimit() common.RateLimitConfig {
+	return common.RateLimitConfig{
+		RPM: token.RpmLimit,
+		TPM: token.TpmLimit,
+		RPD: token.RpdLimit,
+	}
+}
+
 func (token *Token) SelectUpdate() error {
 	// This can update zero values
 	return DB.Model(token).Select("accessed_time", "status").Updates(token).Error
@@ -293,6 +323,13 @@ func PostConsumeTokenQuota(tokenId int, userQuota int, quota int, preConsumedQuo
 					if noMoreQuota {
 						prompt = "您的额度已用尽"
 					}
+					common.Notify(common.NotifyEvent{
+						Type:      common.NotifyEventQuotaLow,
+						Subject:   fmt.Sprintf("用户 #%d %s", token.UserId, prompt),
+						Content:   fmt.Sprintf("用户 #%d %s，当前剩余额度为 %d", token.UserId, prompt, userQuota),
+						Fields:    map[string]interface{}{"user_id": token.UserId, "quota": userQuota},
+						DedupeKey: fmt.Sprintf("%d:%t", token.UserId, noMoreQuota),
+					})
 					if email != "" {
 						topUpLink := fmt.Sprintf("%s/topup", constant.ServerAddress)
 						err = common.SendEmail(prompt, email,
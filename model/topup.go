@@ -1,13 +1,24 @@
 package model
 
+import (
+	"errors"
+	"gorm.io/gorm"
+	"math"
+	"one-api/common"
+)
+
 type TopUp struct {
-	Id         int     `json:"id"`
-	UserId     int     `json:"user_id" gorm:"index"`
-	Amount     int     `json:"amount"`
-	Money      float64 `json:"money"`
-	TradeNo    string  `json:"trade_no"`
-	CreateTime int64   `json:"create_time"`
-	Status     string  `json:"status"`
+	Id              int     `json:"id"`
+	UserId          int     `json:"user_id" gorm:"index"`
+	Amount          int     `json:"amount"`
+	Money           float64 `json:"money"`
+	TradeNo         string  `json:"trade_no"`
+	CreateTime      int64   `json:"create_time"`
+	Status          string  `json:"status"`
+	PaymentProvider string  `json:"payment_provider" gorm:"type:varchar(32);default:'epay'"`
+	Currency        string  `json:"currency" gorm:"type:varchar(16);default:'cny'"`
+	ProviderTradeNo string  `json:"provider_trade_no" gorm:"type:varchar(128);index"`
+	RefundedMoney   float64 `json:"refunded_money" gorm:"default:0"`
 }
 
 func (topUp *TopUp) Insert() error {
@@ -41,3 +52,72 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	}
 	return topUp
 }
+
+// CompleteTopUp 将待支付订单标记为成功并增加用户额度，重复回调时不会重复入账，返回是否本次入账
+func CompleteTopUp(tradeNo string, providerTradeNo string) (*TopUp, bool, error) {
+	topUp := &TopUp{}
+	completed := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("trade_no = ?", tradeNo).First(topUp).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? and status = ?", topUp.Id, "pending").Updates(map[string]interface{}{
+			"status":            "success",
+			"provider_trade_no": providerTradeNo,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		completed = true
+		topUp.Status = "success"
+		topUp.ProviderTradeNo = providerTradeNo
+		return tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", topUp.Amount*int(common.QuotaPerUnit))).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if completed {
+		_ = CacheUpdateUserQuota(topUp.UserId)
+	}
+	return topUp, completed, nil
+}
+
+// RefundTopUp 按累计退款金额扣回对应额度，refundedMoney 为支付渠道返回的累计退款金额，重复回调时只扣除差额
+func RefundTopUp(providerTradeNo string, refundedMoney float64) (*TopUp, int, error) {
+	topUp := &TopUp{}
+	deducted := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").Where("provider_trade_no = ?", providerTradeNo).First(topUp).Error
+		if err != nil {
+			return err
+		}
+		if topUp.Status != "success" && topUp.Status != "refunded" {
+			return errors.New("订单未支付，无法退款")
+		}
+		delta := refundedMoney - topUp.RefundedMoney
+		if delta <= 0 || topUp.Money <= 0 {
+			return nil
+		}
+		deducted = int(math.Round(float64(topUp.Amount) * common.QuotaPerUnit * delta / topUp.Money))
+		topUp.RefundedMoney = refundedMoney
+		if refundedMoney >= topUp.Money {
+			topUp.Status = "refunded"
+		}
+		err = tx.Model(&TopUp{}).Where("id = ?", topUp.Id).Updates(map[string]interface{}{
+			"refunded_money": topUp.RefundedMoney,
+			"status":         topUp.Status,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota - ?", deducted)).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if deducted > 0 {
+		_ = CacheUpdateUserQuota(topUp.UserId)
+	}
+	return topUp, deducted, nil
+}
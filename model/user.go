@@ -35,6 +35,9 @@ type User struct {
 	AffQuota         int            `json:"aff_quota" gorm:"type:int;default:0;column:aff_quota"`           // 邀请剩余额度
 	AffHistoryQuota  int            `json:"aff_history_quota" gorm:"type:int;default:0;column:aff_history"` // 邀请历史额度
 	InviterId        int            `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	RpmLimit         int            `json:"rpm_limit" gorm:"type:int;default:0"`
+	TpmLimit         int            `json:"tpm_limit" gorm:"type:int;default:0"`
+	RpdLimit         int            `json:"rpd_limit" gorm:"type:int;default:0"`
 	DeletedAt        gorm.DeletedAt `gorm:"index"`
 }
 
@@ -258,6 +261,9 @@ func (user *User) Edit(updatePassword bool) error {
 		"display_name": newUser.DisplayName,
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
+		"rpm_limit":    newUser.RpmLimit,
+		"tpm_limit":    newUser.TpmLimit,
+		"rpd_limit":    newUser.RpdLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
@@ -268,6 +274,7 @@ func (user *User) Edit(updatePassword bool) error {
 		if common.RedisEnabled {
 			_ = common.RedisSet(fmt.Sprintf("user_group:%d", user.Id), user.Group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
 			_ = common.RedisSet(fmt.Sprintf("user_quota:%d", user.Id), strconv.Itoa(user.Quota), time.Duration(UserId2QuotaCacheSeconds)*time.Second)
+			_ = common.RedisDel(fmt.Sprintf("user_rate_limit:%d", user.Id))
 		}
 	}
 	return err
@@ -457,6 +464,12 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+func GetUserRateLimit(id int) (rateLimit common.RateLimitConfig, err error) {
+	user := User{}
+	err = DB.Model(&User{}).Where("id = ?", id).Select("rpm_limit", "tpm_limit", "rpd_limit").Find(&user).Error
+	return common.RateLimitConfig{RPM: user.RpmLimit, TPM: user.TpmLimit, RPD: user.RpdLimit}, err
+}
+
 func IncreaseUserQuota(id int, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"errors"
+	"gorm.io/gorm"
+)
+
+// UserAlertSetting 用户自定义的余额与用量提醒，阈值为 0 表示不提醒
+type UserAlertSetting struct {
+	Id                       int    `json:"id"`
+	UserId                   int    `json:"user_id" gorm:"uniqueIndex"`
+	EmailEnabled             bool   `json:"email_enabled" gorm:"default:false"`
+	WebhookUrl               string `json:"webhook_url" gorm:"default:''"`
+	WebhookSecret            string `json:"webhook_secret" gorm:"default:''"`
+	TelegramEnabled          bool   `json:"telegram_enabled" gorm:"default:false"`
+	QuotaThreshold           int    `json:"quota_threshold" gorm:"default:0"`             // 剩余额度低于该值时提醒
+	DailyQuotaThreshold      int    `json:"daily_quota_threshold" gorm:"default:0"`       // 当日消耗超过该值时提醒
+	TokenDailyQuotaThreshold int    `json:"token_daily_quota_threshold" gorm:"default:0"` // 单个令牌当日消耗超过该值时提醒
+	WeeklyDigestEnabled      bool   `json:"weekly_digest_enabled" gorm:"default:false"`
+	LastDigestTime           int64  `json:"last_digest_time" gorm:"bigint;default:0"`
+}
+
+// GetUserAlertSetting 获取用户提醒设置，未设置时返回空设置
+func GetUserAlertSetting(userId int) (*UserAlertSetting, error) {
+	setting := &UserAlertSetting{}
+	err := DB.Where("user_id = ?", userId).First(setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &UserAlertSetting{UserId: userId}, nil
+	}
+	return setting, err
+}
+
+func (setting *UserAlertSetting) Save() error {
+	if setting.Id == 0 {
+		return DB.Create(setting).Error
+	}
+	return DB.Save(setting).Error
+}
+
+func (setting *UserAlertSetting) HasChannel() bool {
+	return setting.EmailEnabled || setting.WebhookUrl != "" || setting.TelegramEnabled
+}
+
+func (setting *UserAlertSetting) UpdateLastDigestTime(timestamp int64) error {
+	setting.LastDigestTime = timestamp
+	return DB.Model(setting).Update("last_digest_time", timestamp).Error
+}
+
+func GetWeeklyDigestAlertSettings(beforeTimestamp int64) (settings []*UserAlertSetting, err error) {
+	err = DB.Where("weekly_digest_enabled = ? and last_digest_time < ?", true, beforeTimestamp).Find(&settings).Error
+	return settings, err
+}
+
+// SumUserConsumeQuota 统计用户（tokenId 不为 0 时为单个令牌）自 startTimestamp 以来的消耗额度
+func SumUserConsumeQuota(userId int, tokenId int, startTimestamp int64) (quota int, err error) {
+	tx := DB.Table("logs").Select("coalesce(sum(quota),0)").Where("user_id = ? and type = ? and created_at >= ?", userId, LogTypeConsume, startTimestamp)
+	if tokenId != 0 {
+		tx = tx.Where("token_id = ?", tokenId)
+	}
+	err = tx.Scan(&quota).Error
+	return quota, err
+}
@@ -67,13 +67,17 @@ func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBod
 }
 
 func doRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
+	span := service.StartUpstreamSpan(c, req)
+	defer span.End()
 	resp, err := service.GetHttpClient().Do(req)
 	if err != nil {
+		span.SetError(err.Error())
 		return nil, err
 	}
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
 	return resp, nil
@@ -1,23 +1,33 @@
 package aws
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
+	"net/url"
 	"one-api/dto"
-	"one-api/relay/channel/claude"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
+	"one-api/relay/constant"
 	"strings"
 )
 
 const (
-	RequestModeCompletion = 1
-	RequestModeMessage    = 2
+	RequestModeConverse     = 1
+	RequestModeClaudeNative = 2
+	RequestModeEmbedding    = 3
+	RequestModeImage        = 4
 )
 
 type Adaptor struct {
 	RequestMode int
+	ModelId     string
+	Region      string
+	ImageN      int
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -26,41 +36,88 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	a.ImageN = request.N
+	return convertImageRequest(a.ModelId, request)
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
-	if strings.HasPrefix(info.UpstreamModelName, "claude-3") {
-		a.RequestMode = RequestModeMessage
-	} else {
-		a.RequestMode = RequestModeCompletion
+	switch info.RelayMode {
+	case constant.RelayModeClaudeMessages:
+		a.RequestMode = RequestModeClaudeNative
+	case constant.RelayModeEmbeddings:
+		a.RequestMode = RequestModeEmbedding
+	case constant.RelayModeImagesGenerations:
+		a.RequestMode = RequestModeImage
+	default:
+		a.RequestMode = RequestModeConverse
+	}
+	_, a.Region, _ = parseAwsKey(info.ApiKey)
+	a.ModelId = getAwsModelId(info.UpstreamModelName)
+	// 嵌入与图片模型没有跨区域推理配置文件
+	if a.RequestMode != RequestModeEmbedding && a.RequestMode != RequestModeImage {
+		a.ModelId = withInferenceProfile(a.ModelId, getAwsConfig(info).InferenceProfile, a.Region)
 	}
 }
 
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
-	return "", nil
+	if a.Region == "" {
+		return "", errors.New("invalid aws secret key")
+	}
+	baseUrl := strings.TrimSuffix(info.BaseUrl, "/")
+	if baseUrl == "" {
+		baseUrl = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", a.Region)
+	}
+	var action string
+	switch a.RequestMode {
+	case RequestModeConverse:
+		action = "converse"
+		if info.IsStream {
+			action = "converse-stream"
+		}
+	default:
+		action = "invoke"
+		if info.IsStream {
+			action = "invoke-with-response-stream"
+		}
+	}
+	// 模型 ID 与推理配置文件 ARN 中的 : 和 / 需要转义
+	modelId := strings.ReplaceAll(url.PathEscape(a.ModelId), ":", "%3A")
+	return fmt.Sprintf("%s/model/%s/%s", baseUrl, modelId, action), nil
 }
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
-	return nil
+	req.Header.Set("Content-Type", "application/json")
+	if info.IsStream {
+		req.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	credentials, region, err := getAwsCredentials(info)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if req.GetBody != nil {
+		bodyReader, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(bodyReader)
+		if err != nil {
+			return err
+		}
+	}
+	return signRequest(req, body, credentials, "bedrock", region)
 }
 
 func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
-
-	var claudeReq *claude.ClaudeRequest
-	var err error
-	if a.RequestMode == RequestModeCompletion {
-		claudeReq = claude.RequestOpenAI2ClaudeComplete(*request)
-	} else {
-		claudeReq, err = claude.RequestOpenAI2ClaudeMessage(*request)
+	if a.RequestMode == RequestModeEmbedding {
+		return convertEmbeddingRequest(a.ModelId, *request)
 	}
-	c.Set("request_model", request.Model)
-	c.Set("converted_request", claudeReq)
-	return claudeReq, err
+	return requestOpenAI2Converse(*request)
 }
 
 func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
@@ -68,14 +125,90 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
-	return nil, nil
+	switch {
+	case a.RequestMode == RequestModeClaudeNative:
+		requestData, err := io.ReadAll(requestBody)
+		if err != nil {
+			return nil, err
+		}
+		requestData, err = awsClaudeNativeRequestBody(requestData)
+		if err != nil {
+			return nil, err
+		}
+		return channel.DoApiRequest(a, c, info, bytes.NewReader(requestData))
+	case a.RequestMode == RequestModeEmbedding && isTitanEmbeddingModel(a.ModelId):
+		return a.doTitanEmbeddingRequest(c, info, requestBody)
+	case a.RequestMode == RequestModeImage && isStabilityImageModel(a.ModelId):
+		// Stability 模型每次请求只生成一张图片
+		return channel.DoImageRequests(a, c, info, requestBody, a.ImageN, parseImageResponse)
+	}
+	return channel.DoApiRequest(a, c, info, requestBody)
+}
+
+// doTitanEmbeddingRequest Titan 嵌入模型逐条请求，成功后把结果汇总为一个响应
+func (a *Adaptor) doTitanEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	var titanRequests []TitanEmbeddingRequest
+	err := json.NewDecoder(requestBody).Decode(&titanRequests)
+	if err != nil {
+		return nil, err
+	}
+	embeddingResponse := EmbeddingResponse{
+		Embeddings: make([][]float64, 0, len(titanRequests)),
+	}
+	for _, titanRequest := range titanRequests {
+		jsonData, err := json.Marshal(titanRequest)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := channel.DoApiRequest(a, c, info, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var titanResponse TitanEmbeddingResponse
+		err = json.NewDecoder(resp.Body).Decode(&titanResponse)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode titan embedding response failed: %w", err)
+		}
+		embeddingResponse.Embeddings = append(embeddingResponse.Embeddings, titanResponse.Embedding)
+		embeddingResponse.InputTextTokenCount += titanResponse.InputTextTokenCount
+	}
+	jsonResponse, err := json.Marshal(embeddingResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(jsonResponse)),
+	}, nil
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
-	if info.IsStream {
-		err, usage = awsStreamHandler(c, resp, info, a.RequestMode)
-	} else {
-		err, usage = awsHandler(c, info, a.RequestMode)
+	switch a.RequestMode {
+	case RequestModeClaudeNative:
+		if info.IsStream {
+			err, usage = awsClaudeNativeStreamHandler(c, resp, info)
+		} else {
+			err, usage = awsClaudeNativeHandler(c, resp)
+		}
+	case RequestModeEmbedding:
+		err, usage = awsEmbeddingHandler(c, resp, info)
+	case RequestModeImage:
+		if isStabilityImageModel(a.ModelId) {
+			err, usage = channel.ImageHandler(c, resp, info)
+		} else {
+			err, usage = awsImageHandler(c, resp, info)
+		}
+	default:
+		if info.IsStream {
+			err, usage = converseStreamHandler(c, resp, info)
+		} else {
+			err, usage = converseHandler(c, resp, info)
+		}
 	}
 	return
 }
@@ -84,7 +217,7 @@ func (a *Adaptor) GetModelList() (models []string) {
 	for n := range awsModelIDMap {
 		models = append(models, n)
 	}
-
+	models = append(models, awsModelList...)
 	return
 }
 
@@ -1,13 +1,43 @@
 package aws
 
 var awsModelIDMap = map[string]string{
-	"claude-instant-1.2":       "anthropic.claude-instant-v1",
-	"claude-2.0":               "anthropic.claude-v2",
-	"claude-2.1":               "anthropic.claude-v2:1",
-	"claude-3-sonnet-20240229": "anthropic.claude-3-sonnet-20240229-v1:0",
-	"claude-3-opus-20240229":   "anthropic.claude-3-opus-20240229-v1:0",
-	"claude-3-haiku-20240307":  "anthropic.claude-3-haiku-20240307-v1:0",
+	"claude-instant-1.2":         "anthropic.claude-instant-v1",
+	"claude-2.0":                 "anthropic.claude-v2",
+	"claude-2.1":                 "anthropic.claude-v2:1",
+	"claude-3-sonnet-20240229":   "anthropic.claude-3-sonnet-20240229-v1:0",
+	"claude-3-opus-20240229":     "anthropic.claude-3-opus-20240229-v1:0",
+	"claude-3-haiku-20240307":    "anthropic.claude-3-haiku-20240307-v1:0",
 	"claude-3-5-sonnet-20240620": "anthropic.claude-3-5-sonnet-20240620-v1:0",
 }
 
+// 其他模型直接使用 Bedrock 模型 ID
+var awsModelList = []string{
+	"meta.llama3-8b-instruct-v1:0",
+	"meta.llama3-70b-instruct-v1:0",
+	"meta.llama3-1-8b-instruct-v1:0",
+	"meta.llama3-1-70b-instruct-v1:0",
+	"meta.llama3-1-405b-instruct-v1:0",
+	"mistral.mistral-7b-instruct-v0:2",
+	"mistral.mixtral-8x7b-instruct-v0:1",
+	"mistral.mistral-large-2402-v1:0",
+	"mistral.mistral-large-2407-v1:0",
+	"amazon.titan-text-express-v1",
+	"amazon.titan-text-premier-v1:0",
+	"cohere.command-r-v1:0",
+	"cohere.command-r-plus-v1:0",
+	"amazon.titan-embed-text-v1",
+	"amazon.titan-embed-text-v2:0",
+	"cohere.embed-english-v3",
+	"cohere.embed-multilingual-v3",
+	"amazon.titan-image-generator-v1",
+	"amazon.titan-image-generator-v2:0",
+	"amazon.nova-canvas-v1:0",
+	"stability.stable-image-core-v1:1",
+	"stability.stable-image-ultra-v1:1",
+	"stability.sd3-5-large-v1:0",
+}
+
+// 跨区域推理配置文件 ID 的前缀，已带前缀的模型 ID 不再处理
+var awsInferenceProfilePrefixes = []string{"us.", "us-gov.", "eu.", "apac.", "global."}
+
 var ChannelName = "aws"
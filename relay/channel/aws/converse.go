@@ -0,0 +1,383 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+)
+
+func stopReasonConverse2OpenAI(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "content_filtered", "guardrail_intervened":
+		return "content_filter"
+	default:
+		return reason
+	}
+}
+
+func converseImage(imageUrl string) (*ConverseImage, error) {
+	var mimeType, data string
+	if strings.HasPrefix(imageUrl, "http") {
+		var err error
+		mimeType, data, err = service.GetImageFromUrl(imageUrl)
+		if err != nil {
+			return nil, err
+		}
+		if mimeType == "" {
+			return nil, fmt.Errorf("failed to get image from %s", imageUrl)
+		}
+	} else {
+		_, format, base64String, err := service.DecodeBase64ImageData(imageUrl)
+		if err != nil {
+			return nil, err
+		}
+		mimeType = "image/" + format
+		data = base64String
+	}
+	image := &ConverseImage{
+		Format: strings.TrimPrefix(mimeType, "image/"),
+	}
+	if image.Format == "jpg" {
+		image.Format = "jpeg"
+	}
+	image.Source.Bytes = data
+	return image, nil
+}
+
+func converseContentBlocks(message dto.Message) ([]ConverseContentBlock, error) {
+	blocks := make([]ConverseContentBlock, 0)
+	for _, content := range message.ParseContent() {
+		switch content.Type {
+		case dto.ContentTypeText:
+			// Converse 不接受空的文本块
+			if content.Text != "" {
+				blocks = append(blocks, ConverseContentBlock{Text: content.Text})
+			}
+		case dto.ContentTypeImageURL:
+			image, err := converseImage(content.ImageUrl.(dto.MessageImageUrl).Url)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, ConverseContentBlock{Image: image})
+		}
+	}
+	return blocks, nil
+}
+
+func converseToolChoice(toolChoice any) *ConverseToolChoice {
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			return &ConverseToolChoice{Auto: &struct{}{}}
+		case "required":
+			return &ConverseToolChoice{Any: &struct{}{}}
+		}
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				converseChoice := &ConverseToolChoice{Tool: &struct {
+					Name string `json:"name"`
+				}{}}
+				converseChoice.Tool.Name = name
+				return converseChoice
+			}
+		}
+	}
+	return nil
+}
+
+func requestOpenAI2Converse(request dto.GeneralOpenAIRequest) (*ConverseRequest, error) {
+	converseRequest := &ConverseRequest{
+		Messages: make([]ConverseMessage, 0, len(request.Messages)),
+	}
+	inferenceConfig := &ConverseInferenceConfig{
+		MaxTokens:   int(request.MaxTokens),
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+	}
+	// stop maybe string/array string, convert to array string
+	switch stop := request.Stop.(type) {
+	case string:
+		inferenceConfig.StopSequences = []string{stop}
+	case []any:
+		for _, s := range stop {
+			if str, ok := s.(string); ok {
+				inferenceConfig.StopSequences = append(inferenceConfig.StopSequences, str)
+			}
+		}
+	}
+	converseRequest.InferenceConfig = inferenceConfig
+	if request.TopK > 0 {
+		converseRequest.AdditionalModelRequestFields = map[string]any{"top_k": request.TopK}
+	}
+
+	if len(request.Tools) > 0 {
+		toolConfig := &ConverseToolConfig{
+			Tools: make([]ConverseTool, 0, len(request.Tools)),
+		}
+		for _, tool := range request.Tools {
+			spec := ConverseToolSpec{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+			}
+			spec.InputSchema.Json = tool.Function.Parameters
+			if spec.InputSchema.Json == nil {
+				spec.InputSchema.Json = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			toolConfig.Tools = append(toolConfig.Tools, ConverseTool{ToolSpec: spec})
+		}
+		toolConfig.ToolChoice = converseToolChoice(request.ToolChoice)
+		converseRequest.ToolConfig = toolConfig
+	}
+
+	for _, message := range request.Messages {
+		var role string
+		var blocks []ConverseContentBlock
+		switch message.Role {
+		case "system":
+			systemBlocks, err := converseContentBlocks(message)
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range systemBlocks {
+				if block.Text != "" {
+					converseRequest.System = append(converseRequest.System, block)
+				}
+			}
+			continue
+		case "tool":
+			// 工具调用结果以 toolResult 块放在 user 消息中
+			role = "user"
+			result := &ConverseToolResult{
+				ToolUseId: message.ToolCallId,
+			}
+			text := message.StringContent()
+			if !message.IsStringContent() {
+				text = ""
+				for _, content := range message.ParseContent() {
+					text += content.Text
+				}
+			}
+			result.Content = []ConverseToolResultItem{{Text: text}}
+			blocks = []ConverseContentBlock{{ToolResult: result}}
+		case "assistant":
+			role = "assistant"
+			var err error
+			blocks, err = converseContentBlocks(message)
+			if err != nil {
+				return nil, err
+			}
+			for _, toolCall := range message.ParseToolCalls() {
+				var input any
+				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil || input == nil {
+					input = map[string]any{}
+				}
+				blocks = append(blocks, ConverseContentBlock{ToolUse: &ConverseToolUse{
+					ToolUseId: toolCall.ID,
+					Name:      toolCall.Function.Name,
+					Input:     input,
+				}})
+			}
+		default:
+			role = "user"
+			var err error
+			blocks, err = converseContentBlocks(message)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// Converse 要求 user 与 assistant 交替出现，合并连续的同角色消息
+		if last := len(converseRequest.Messages) - 1; last >= 0 && converseRequest.Messages[last].Role == role {
+			converseRequest.Messages[last].Content = append(converseRequest.Messages[last].Content, blocks...)
+			continue
+		}
+		converseRequest.Messages = append(converseRequest.Messages, ConverseMessage{
+			Role:    role,
+			Content: blocks,
+		})
+	}
+	if len(converseRequest.Messages) == 0 {
+		return nil, errors.New("messages is empty")
+	}
+	return converseRequest, nil
+}
+
+func responseConverse2OpenAI(response *ConverseResponse) *dto.OpenAITextResponse {
+	var responseText string
+	tools := make([]dto.ToolCall, 0)
+	for _, block := range response.Output.Message.Content {
+		if block.ToolUse != nil {
+			args, _ := json.Marshal(block.ToolUse.Input)
+			tools = append(tools, dto.ToolCall{
+				ID:   block.ToolUse.ToolUseId,
+				Type: "function",
+				Function: dto.FunctionCall{
+					Name:      block.ToolUse.Name,
+					Arguments: string(args),
+				},
+			})
+			continue
+		}
+		responseText += block.Text
+	}
+	choice := dto.OpenAITextResponseChoice{
+		Index: 0,
+		Message: dto.Message{
+			Role: "assistant",
+		},
+		FinishReason: stopReasonConverse2OpenAI(response.StopReason),
+	}
+	choice.SetStringContent(responseText)
+	if len(tools) > 0 {
+		choice.Message.ToolCalls = tools
+	}
+	return &dto.OpenAITextResponse{
+		Id:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+		Object:  "chat.completion",
+		Created: common.GetTimestamp(),
+		Choices: []dto.OpenAITextResponseChoice{choice},
+	}
+}
+
+func converseHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var converseResponse ConverseResponse
+	err = json.Unmarshal(responseBody, &converseResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	fullTextResponse := responseConverse2OpenAI(&converseResponse)
+	usage := dto.Usage{
+		PromptTokens:     converseResponse.Usage.InputTokens,
+		CompletionTokens: converseResponse.Usage.OutputTokens,
+		TotalTokens:      converseResponse.Usage.InputTokens + converseResponse.Usage.OutputTokens,
+	}
+	fullTextResponse.Usage = usage
+	jsonResponse, err := json.Marshal(fullTextResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(jsonResponse)
+	return nil, &usage
+}
+
+func converseStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	defer resp.Body.Close()
+	responseId := fmt.Sprintf("chatcmpl-%s", common.GetUUID())
+	createdTime := common.GetTimestamp()
+	usage := &dto.Usage{}
+	responseText := ""
+	// Converse 的内容块序号 -> OpenAI tool_calls 序号
+	toolIndexes := make(map[int]int)
+	service.SetEventStreamHeaders(c)
+	err := readEventStream(resp.Body, func(eventType string, payload []byte) bool {
+		info.SetFirstResponseTime()
+		var event ConverseStreamEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			return true
+		}
+		var choice dto.ChatCompletionsStreamResponseChoice
+		switch eventType {
+		case "messageStart":
+			choice.Delta.Role = "assistant"
+			choice.Delta.SetContentString("")
+		case "contentBlockStart":
+			if event.Start == nil || event.Start.ToolUse == nil {
+				return true
+			}
+			index := len(toolIndexes)
+			toolIndexes[event.ContentBlockIndex] = index
+			choice.Delta.ToolCalls = []dto.ToolCall{{
+				Index: &index,
+				ID:    event.Start.ToolUse.ToolUseId,
+				Type:  "function",
+				Function: dto.FunctionCall{
+					Name:      event.Start.ToolUse.Name,
+					Arguments: "",
+				},
+			}}
+		case "contentBlockDelta":
+			if event.Delta == nil {
+				return true
+			}
+			if event.Delta.ToolUse != nil {
+				index := toolIndexes[event.ContentBlockIndex]
+				choice.Delta.ToolCalls = []dto.ToolCall{{
+					Index: &index,
+					Function: dto.FunctionCall{
+						Arguments: event.Delta.ToolUse.Input,
+					},
+				}}
+			} else {
+				responseText += event.Delta.Text
+				choice.Delta.SetContentString(event.Delta.Text)
+			}
+		case "messageStop":
+			finishReason := stopReasonConverse2OpenAI(event.StopReason)
+			choice.FinishReason = &finishReason
+		case "metadata":
+			if event.Usage != nil {
+				usage.PromptTokens = event.Usage.InputTokens
+				usage.CompletionTokens = event.Usage.OutputTokens
+				usage.TotalTokens = event.Usage.InputTokens + event.Usage.OutputTokens
+			}
+			return true
+		default:
+			return true
+		}
+		response := dto.ChatCompletionsStreamResponse{
+			Id:      responseId,
+			Object:  "chat.completion.chunk",
+			Created: createdTime,
+			Model:   info.UpstreamModelName,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{choice},
+		}
+		if err := service.ObjectData(c, response); err != nil {
+			common.SysError("error sending stream response: " + err.Error())
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		common.SysError("error reading bedrock stream: " + err.Error())
+	}
+	if usage.TotalTokens == 0 {
+		usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, info.PromptTokens)
+	}
+	if info.ShouldIncludeUsage {
+		response := service.GenerateFinalUsageResponse(responseId, createdTime, info.UpstreamModelName, *usage)
+		if err := service.ObjectData(c, response); err != nil {
+			common.SysError("send final response failed: " + err.Error())
+		}
+	}
+	service.Done(c)
+	return nil, usage
+}
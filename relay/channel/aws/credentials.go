@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awscredentials "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// 扮演角色获得的临时凭证在过期前提前刷新
+	credentialsExpiryDelta = 5 * time.Minute
+	assumeRoleDuration     = time.Hour
+)
+
+// assumeRoleProviders 每组密钥与角色配置对应一个带缓存的凭证提供者，临时凭证由 SDK 负责缓存与刷新
+var assumeRoleProviders sync.Map
+
+var signer = v4.NewSigner()
+
+// parseAwsKey 渠道密钥格式为 AccessKey|SecretKey|Region
+func parseAwsKey(key string) (aws.Credentials, string, error) {
+	awsSecret := strings.Split(key, "|")
+	if len(awsSecret) != 3 {
+		return aws.Credentials{}, "", errors.New("invalid aws secret key")
+	}
+	credentials := aws.Credentials{
+		AccessKeyID:     awsSecret[0],
+		SecretAccessKey: awsSecret[1],
+	}
+	return credentials, awsSecret[2], nil
+}
+
+func getAwsConfig(info *relaycommon.RelayInfo) *relaycommon.AwsConfig {
+	if info.AwsConfig == nil {
+		info.AwsConfig = relaycommon.ParseAwsConfig("")
+	}
+	return info.AwsConfig
+}
+
+// getAwsCredentials 获取请求使用的凭证和区域，配置了 role_arn 时返回扮演角色后的临时凭证
+func getAwsCredentials(info *relaycommon.RelayInfo) (aws.Credentials, string, error) {
+	credentials, region, err := parseAwsKey(info.ApiKey)
+	if err != nil {
+		return credentials, region, err
+	}
+	config := getAwsConfig(info)
+	if config.RoleArn == "" {
+		return credentials, region, nil
+	}
+	credentials, err = assumeRole(credentials, region, config)
+	return credentials, region, err
+}
+
+func newAssumeRoleProvider(credentials aws.Credentials, region string, config *relaycommon.AwsConfig) *aws.CredentialsCache {
+	options := sts.Options{
+		Region:      region,
+		Credentials: awscredentials.NewStaticCredentialsProvider(credentials.AccessKeyID, credentials.SecretAccessKey, ""),
+		HTTPClient:  service.GetImpatientHttpClient(),
+	}
+	if config.StsEndpoint != "" {
+		options.BaseEndpoint = aws.String(strings.TrimSuffix(config.StsEndpoint, "/"))
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.New(options), config.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = config.RoleSessionName
+		o.Duration = assumeRoleDuration
+		if config.ExternalId != "" {
+			o.ExternalID = aws.String(config.ExternalId)
+		}
+	})
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryDelta
+	})
+}
+
+func assumeRole(credentials aws.Credentials, region string, config *relaycommon.AwsConfig) (aws.Credentials, error) {
+	secretHash := sha256.Sum256([]byte(credentials.SecretAccessKey))
+	cacheKey := strings.Join([]string{credentials.AccessKeyID, hex.EncodeToString(secretHash[:8]), region,
+		config.RoleArn, config.ExternalId, config.RoleSessionName, config.StsEndpoint}, "|")
+	provider, ok := assumeRoleProviders.Load(cacheKey)
+	if !ok {
+		provider, _ = assumeRoleProviders.LoadOrStore(cacheKey, newAssumeRoleProvider(credentials, region, config))
+	}
+	assumed, err := provider.(*aws.CredentialsCache).Retrieve(context.Background())
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("sts assume role failed: %w", err)
+	}
+	return assumed, nil
+}
+
+// signRequest 使用 SigV4 为请求签名
+func signRequest(req *http.Request, body []byte, credentials aws.Credentials, signingName string, region string) error {
+	payloadHash := sha256.Sum256(body)
+	return signer.SignHTTP(context.Background(), credentials, req, hex.EncodeToString(payloadHash[:]), signingName, region, time.Now())
+}
@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const assumeRoleResponseXML = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIATEMP%d</AccessKeyId>
+      <SecretAccessKey>temp-secret</SecretAccessKey>
+      <SessionToken>session-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/bedrock/one-api</Arn>
+      <AssumedRoleId>AROATEST:one-api</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+
+const stsErrorResponseXML = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized to perform sts:AssumeRole</Message></Error>
+  <RequestId>request-id</RequestId>
+</ErrorResponse>`
+
+// newStsServer 模拟 STS AssumeRole 接口，每次返回不同的临时凭证
+func newStsServer(t *testing.T) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "AssumeRole", r.PostForm.Get("Action"))
+		assert.Equal(t, "3600", r.PostForm.Get("DurationSeconds"))
+		assert.Equal(t, "one-api", r.PostForm.Get("RoleSessionName"))
+		// 使用渠道的长期密钥签名
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/sts/aws4_request")
+		w.Header().Set("Content-Type", "text/xml")
+		if r.PostForm.Get("RoleArn") != "arn:aws:iam::123456789012:role/bedrock" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(stsErrorResponseXML))
+			return
+		}
+		assert.Equal(t, "external", r.PostForm.Get("ExternalId"))
+		n := atomic.AddInt32(&count, 1)
+		_, _ = fmt.Fprintf(w, assumeRoleResponseXML, n, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func newAwsRoleInfo(stsEndpoint string, roleArn string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		ApiKey: "AKIDEXAMPLE|secret|us-east-1",
+		AwsConfig: relaycommon.ParseAwsConfig(`{"aws":{"role_arn":"` + roleArn + `","external_id":"external","sts_endpoint":"` +
+			stsEndpoint + `"}}`),
+	}
+}
+
+func TestParseAwsKey(t *testing.T) {
+	asserts := assert.New(t)
+	credentials, region, err := parseAwsKey("AKIDEXAMPLE|secret|us-west-2")
+	asserts.NoError(err)
+	asserts.Equal("AKIDEXAMPLE", credentials.AccessKeyID)
+	asserts.Equal("secret", credentials.SecretAccessKey)
+	asserts.Equal("us-west-2", region)
+	_, _, err = parseAwsKey("AKIDEXAMPLE|secret")
+	asserts.Error(err)
+}
+
+func TestGetAwsCredentialsWithoutRole(t *testing.T) {
+	asserts := assert.New(t)
+	credentials, region, err := getAwsCredentials(&relaycommon.RelayInfo{ApiKey: "AKIDEXAMPLE|secret|us-east-1"})
+	asserts.NoError(err)
+	asserts.Equal("AKIDEXAMPLE", credentials.AccessKeyID)
+	asserts.Empty(credentials.SessionToken)
+	asserts.Equal("us-east-1", region)
+}
+
+func TestAssumeRole(t *testing.T) {
+	asserts := assert.New(t)
+	server, count := newStsServer(t)
+	info := newAwsRoleInfo(server.URL, "arn:aws:iam::123456789012:role/bedrock")
+
+	credentials, region, err := getAwsCredentials(info)
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.Equal("us-east-1", region)
+	asserts.Equal("ASIATEMP1", credentials.AccessKeyID)
+	asserts.Equal("temp-secret", credentials.SecretAccessKey)
+	asserts.Equal("session-token", credentials.SessionToken)
+	asserts.True(credentials.CanExpire)
+
+	// 临时凭证缓存至过期前
+	credentials, _, err = getAwsCredentials(info)
+	asserts.NoError(err)
+	asserts.Equal("ASIATEMP1", credentials.AccessKeyID)
+	asserts.Equal(int32(1), atomic.LoadInt32(count))
+
+	// 请求 Bedrock 时使用临时凭证签名
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req := httptest.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/test/converse", strings.NewReader("{}"))
+	asserts.NoError((&Adaptor{}).SetupRequestHeader(c, req, info))
+	asserts.Contains(req.Header.Get("Authorization"), "Credential=ASIATEMP1/")
+	asserts.Equal("session-token", req.Header.Get("X-Amz-Security-Token"))
+}
+
+func TestAssumeRoleError(t *testing.T) {
+	server, _ := newStsServer(t)
+	info := newAwsRoleInfo(server.URL, "arn:aws:iam::123456789012:role/denied")
+
+	_, _, err := getAwsCredentials(info)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "AccessDenied")
+	}
+}
@@ -1,15 +1,160 @@
 package aws
 
-import "one-api/relay/channel/claude"
-
-type AwsClaudeRequest struct {
-	// AnthropicVersion should be "bedrock-2023-05-31"
-	AnthropicVersion string                 `json:"anthropic_version"`
-	System           string                 `json:"system"`
-	Messages         []claude.ClaudeMessage `json:"messages"`
-	MaxTokens        int                    `json:"max_tokens,omitempty"`
-	Temperature      float64                `json:"temperature,omitempty"`
-	TopP             float64                `json:"top_p,omitempty"`
-	TopK             int                    `json:"top_k,omitempty"`
-	StopSequences    []string               `json:"stop_sequences,omitempty"`
+// https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_Converse.html
+
+type ConverseRequest struct {
+	Messages                     []ConverseMessage        `json:"messages"`
+	System                       []ConverseContentBlock   `json:"system,omitempty"`
+	InferenceConfig              *ConverseInferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig                   *ConverseToolConfig      `json:"toolConfig,omitempty"`
+	AdditionalModelRequestFields map[string]any           `json:"additionalModelRequestFields,omitempty"`
+}
+
+type ConverseMessage struct {
+	Role    string                 `json:"role"`
+	Content []ConverseContentBlock `json:"content"`
+}
+
+type ConverseContentBlock struct {
+	Text       string              `json:"text,omitempty"`
+	Image      *ConverseImage      `json:"image,omitempty"`
+	ToolUse    *ConverseToolUse    `json:"toolUse,omitempty"`
+	ToolResult *ConverseToolResult `json:"toolResult,omitempty"`
+}
+
+type ConverseImage struct {
+	// Format png | jpeg | gif | webp
+	Format string `json:"format"`
+	Source struct {
+		Bytes string `json:"bytes"`
+	} `json:"source"`
+}
+
+type ConverseToolUse struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input"`
+}
+
+type ConverseToolResult struct {
+	ToolUseId string                   `json:"toolUseId"`
+	Content   []ConverseToolResultItem `json:"content"`
+}
+
+type ConverseToolResultItem struct {
+	Text string `json:"text"`
+}
+
+type ConverseInferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   float64  `json:"temperature,omitempty"`
+	TopP          float64  `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type ConverseToolConfig struct {
+	Tools      []ConverseTool      `json:"tools"`
+	ToolChoice *ConverseToolChoice `json:"toolChoice,omitempty"`
+}
+
+type ConverseTool struct {
+	ToolSpec ConverseToolSpec `json:"toolSpec"`
+}
+
+type ConverseToolSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema struct {
+		Json any `json:"json"`
+	} `json:"inputSchema"`
+}
+
+type ConverseToolChoice struct {
+	Auto *struct{} `json:"auto,omitempty"`
+	Any  *struct{} `json:"any,omitempty"`
+	Tool *struct {
+		Name string `json:"name"`
+	} `json:"tool,omitempty"`
+}
+
+type ConverseUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+	TotalTokens  int `json:"totalTokens"`
+}
+
+type ConverseResponse struct {
+	Output struct {
+		Message ConverseMessage `json:"message"`
+	} `json:"output"`
+	StopReason string        `json:"stopReason"`
+	Usage      ConverseUsage `json:"usage"`
+}
+
+// ConverseStreamEvent ConverseStream 各事件的内容，事件类型由 :event-type 头给出
+type ConverseStreamEvent struct {
+	Role              string `json:"role"`
+	ContentBlockIndex int    `json:"contentBlockIndex"`
+	Start             *struct {
+		ToolUse *struct {
+			ToolUseId string `json:"toolUseId"`
+			Name      string `json:"name"`
+		} `json:"toolUse"`
+	} `json:"start"`
+	Delta *struct {
+		Text    string `json:"text"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse"`
+	} `json:"delta"`
+	StopReason string         `json:"stopReason"`
+	Usage      *ConverseUsage `json:"usage"`
+}
+
+type TitanEmbeddingRequest struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+}
+
+type TitanEmbeddingResponse struct {
+	Embedding           []float64 `json:"embedding"`
+	InputTextTokenCount int       `json:"inputTextTokenCount"`
+}
+
+type CohereEmbeddingRequest struct {
+	Texts     []string `json:"texts"`
+	InputType string   `json:"input_type"`
+}
+
+// EmbeddingResponse Cohere 的返回格式，Titan 的多次请求结果也汇总为该格式
+type EmbeddingResponse struct {
+	Embeddings          [][]float64 `json:"embeddings"`
+	InputTextTokenCount int         `json:"inputTextTokenCount,omitempty"`
+}
+
+// TitanImageRequest Titan Image Generator 与 Nova Canvas 使用相同的请求格式
+type TitanImageRequest struct {
+	TaskType          string `json:"taskType"`
+	TextToImageParams struct {
+		Text string `json:"text"`
+	} `json:"textToImageParams"`
+	ImageGenerationConfig struct {
+		NumberOfImages int    `json:"numberOfImages"`
+		Width          int    `json:"width,omitempty"`
+		Height         int    `json:"height,omitempty"`
+		Quality        string `json:"quality,omitempty"`
+	} `json:"imageGenerationConfig"`
+}
+
+type StabilityImageRequest struct {
+	Prompt       string `json:"prompt"`
+	AspectRatio  string `json:"aspect_ratio,omitempty"`
+	OutputFormat string `json:"output_format"`
+}
+
+// ImageResponse Titan、Nova Canvas 与 Stability 模型都以 images 返回 base64 图片
+type ImageResponse struct {
+	Images        []string  `json:"images"`
+	Error         string    `json:"error,omitempty"`
+	FinishReasons []*string `json:"finish_reasons,omitempty"`
 }
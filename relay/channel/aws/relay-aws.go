@@ -1,232 +1,342 @@
 package aws
 
 import (
-	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/jinzhu/copier"
-	"github.com/pkg/errors"
 	"io"
 	"net/http"
 	"one-api/common"
-	relaymodel "one-api/dto"
+	"one-api/dto"
+	"one-api/relay/channel"
 	"one-api/relay/channel/claude"
 	relaycommon "one-api/relay/common"
 	"one-api/service"
 	"strings"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
-	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
 )
 
-func newAwsClient(c *gin.Context, info *relaycommon.RelayInfo) (*bedrockruntime.Client, error) {
-	awsSecret := strings.Split(info.ApiKey, "|")
-	if len(awsSecret) != 3 {
-		return nil, errors.New("invalid aws secret key")
-	}
-	ak := awsSecret[0]
-	sk := awsSecret[1]
-	region := awsSecret[2]
-	client := bedrockruntime.New(bedrockruntime.Options{
-		Region:      region,
-		Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(ak, sk, "")),
-	})
-
-	return client, nil
+// IsClaudeModel Claude 模型可以直接透传 Anthropic Messages 请求
+func IsClaudeModel(model string) bool {
+	if strings.HasPrefix(model, "claude") {
+		return true
+	}
+	return strings.Contains(getAwsModelId(model), "anthropic.claude")
 }
 
-func wrapErr(err error) *relaymodel.OpenAIErrorWithStatusCode {
-	return &relaymodel.OpenAIErrorWithStatusCode{
-		StatusCode: http.StatusInternalServerError,
-		Error: relaymodel.OpenAIError{
-			Message: fmt.Sprintf("%s", err.Error()),
-		},
+// getAwsModelId 获取 Bedrock 模型 ID，未在映射表中的模型名直接作为模型 ID 使用
+func getAwsModelId(model string) string {
+	if awsModelId, ok := awsModelIDMap[model]; ok {
+		return awsModelId
 	}
+	return model
 }
 
-func awsModelID(requestModel string) (string, error) {
-	if awsModelID, ok := awsModelIDMap[requestModel]; ok {
-		return awsModelID, nil
+// getInferenceProfilePrefix 根据配置与区域获取跨区域推理配置文件前缀
+func getInferenceProfilePrefix(inferenceProfile string, region string) string {
+	if inferenceProfile != relaycommon.AwsInferenceProfileAuto {
+		return inferenceProfile
 	}
-
-	return "", errors.Errorf("model %s not found", requestModel)
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "us-gov"
+	case strings.HasPrefix(region, "us-"):
+		return "us"
+	case strings.HasPrefix(region, "eu-"):
+		return "eu"
+	case strings.HasPrefix(region, "ap-"):
+		return "apac"
+	}
+	return ""
 }
 
-func awsHandler(c *gin.Context, info *relaycommon.RelayInfo, requestMode int) (*relaymodel.OpenAIErrorWithStatusCode, *relaymodel.Usage) {
-	awsCli, err := newAwsClient(c, info)
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
+// withInferenceProfile 为模型 ID 加上跨区域推理配置文件前缀，如 us.anthropic.claude-3-5-sonnet-20240620-v1:0
+func withInferenceProfile(modelId string, inferenceProfile string, region string) string {
+	if strings.HasPrefix(modelId, "arn:") {
+		return modelId
 	}
-
-	awsModelId, err := awsModelID(c.GetString("request_model"))
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "awsModelID")), nil
+	for _, prefix := range awsInferenceProfilePrefixes {
+		if strings.HasPrefix(modelId, prefix) {
+			return modelId
+		}
 	}
-
-	awsReq := &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(awsModelId),
-		Accept:      aws.String("application/json"),
-		ContentType: aws.String("application/json"),
+	prefix := getInferenceProfilePrefix(inferenceProfile, region)
+	if prefix == "" {
+		return modelId
 	}
+	return prefix + "." + modelId
+}
 
-	claudeReq_, ok := c.Get("converted_request")
-	if !ok {
-		return wrapErr(errors.New("request not found")), nil
-	}
-	claudeReq := claudeReq_.(*claude.ClaudeRequest)
-	awsClaudeReq := &AwsClaudeRequest{
-		AnthropicVersion: "bedrock-2023-05-31",
+type eventStreamError struct {
+	Message string `json:"message"`
+}
+
+// readEventStream 逐条读取 application/vnd.amazon.eventstream 格式的响应，handler 返回 false 时停止读取
+func readEventStream(body io.Reader, handler func(eventType string, payload []byte) bool) error {
+	decoder := eventstream.NewDecoder()
+	var payloadBuf []byte
+	for {
+		message, err := decoder.Decode(body, payloadBuf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		payloadBuf = message.Payload
+		switch getEventStreamHeader(message, ":message-type") {
+		case "event":
+			if !handler(getEventStreamHeader(message, ":event-type"), message.Payload) {
+				return nil
+			}
+		case "exception":
+			var streamErr eventStreamError
+			_ = json.Unmarshal(message.Payload, &streamErr)
+			return fmt.Errorf("%s: %s", getEventStreamHeader(message, ":exception-type"), streamErr.Message)
+		case "error":
+			return fmt.Errorf("%s: %s", getEventStreamHeader(message, ":error-code"), getEventStreamHeader(message, ":error-message"))
+		}
 	}
-	if err = copier.Copy(awsClaudeReq, claudeReq); err != nil {
-		return wrapErr(errors.Wrap(err, "copy request")), nil
+}
+
+func getEventStreamHeader(message eventstream.Message, name string) string {
+	value := message.Headers.Get(name)
+	if value == nil {
+		return ""
 	}
+	return value.String()
+}
 
-	awsReq.Body, err = json.Marshal(awsClaudeReq)
+// awsClaudeNativeRequestBody reuses the inbound Anthropic Messages body, bedrock takes the model from the url instead
+func awsClaudeNativeRequestBody(requestBody []byte) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	err := json.Unmarshal(requestBody, &payload)
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "marshal request")), nil
+		return nil, err
 	}
+	delete(payload, "model")
+	delete(payload, "stream")
+	payload["anthropic_version"] = json.RawMessage(`"bedrock-2023-05-31"`)
+	return json.Marshal(payload)
+}
 
-	awsResp, err := awsCli.InvokeModel(c.Request.Context(), awsReq)
+func awsClaudeNativeHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "InvokeModel")), nil
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
 	}
-
-	claudeResponse := new(claude.ClaudeResponse)
-	err = json.Unmarshal(awsResp.Body, claudeResponse)
+	err = resp.Body.Close()
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "unmarshal response")), nil
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
-
-	openaiResp := claude.ResponseClaude2OpenAI(requestMode, claudeResponse)
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
+	claudeResponse := new(claude.ClaudeResponse)
+	err = json.Unmarshal(responseBody, claudeResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
 	}
-	openaiResp.Usage = usage
-
-	c.JSON(http.StatusOK, openaiResp)
+	usage := claude.ClaudeUsage2OpenAI(claudeResponse.Usage)
+	c.Data(http.StatusOK, "application/json", responseBody)
 	return nil, &usage
 }
 
-func awsStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, requestMode int) (*relaymodel.OpenAIErrorWithStatusCode, *relaymodel.Usage) {
-	awsCli, err := newAwsClient(c, info)
+func awsClaudeNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	defer resp.Body.Close()
+	service.SetEventStreamHeaders(c)
+	var usage dto.Usage
+	err := readEventStream(resp.Body, func(eventType string, payload []byte) bool {
+		if eventType != "chunk" {
+			return true
+		}
+		info.SetFirstResponseTime()
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			return false
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+		if err != nil {
+			common.SysError("error decoding stream response: " + err.Error())
+			return false
+		}
+		claudeResp := new(claude.ClaudeResponse)
+		err = json.Unmarshal(data, claudeResp)
+		if err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			return false
+		}
+		switch claudeResp.Type {
+		case "message_start":
+			if claudeResp.Message != nil {
+				usage = claude.ClaudeUsage2OpenAI(claudeResp.Message.Usage)
+			}
+		case "message_delta":
+			usage.CompletionTokens = claudeResp.Usage.OutputTokens
+		}
+		_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", claudeResp.Type, data)
+		if err != nil {
+			common.SysError("error writing stream response: " + err.Error())
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	})
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
+		common.SysError("error reading bedrock stream: " + err.Error())
 	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return nil, &usage
+}
 
-	awsModelId, err := awsModelID(c.GetString("request_model"))
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "awsModelID")), nil
-	}
+func isTitanEmbeddingModel(modelId string) bool {
+	return strings.HasPrefix(modelId, "amazon.titan-embed")
+}
 
-	awsReq := &bedrockruntime.InvokeModelWithResponseStreamInput{
-		ModelId:     aws.String(awsModelId),
-		Accept:      aws.String("application/json"),
-		ContentType: aws.String("application/json"),
-	}
+func isCohereEmbeddingModel(modelId string) bool {
+	return strings.HasPrefix(modelId, "cohere.embed")
+}
 
-	claudeReq_, ok := c.Get("converted_request")
-	if !ok {
-		return wrapErr(errors.New("request not found")), nil
+// convertEmbeddingRequest Titan 每次只接受一段文本，返回请求列表由 DoRequest 逐条请求；Cohere 一次接受多段文本
+func convertEmbeddingRequest(modelId string, request dto.GeneralOpenAIRequest) (any, error) {
+	input := request.ParseInput()
+	if len(input) == 0 {
+		return nil, errors.New("field input is required")
 	}
-	claudeReq := claudeReq_.(*claude.ClaudeRequest)
+	switch {
+	case isTitanEmbeddingModel(modelId):
+		titanRequests := make([]TitanEmbeddingRequest, 0, len(input))
+		for _, text := range input {
+			titanRequests = append(titanRequests, TitanEmbeddingRequest{
+				InputText:  text,
+				Dimensions: request.Dimensions,
+			})
+		}
+		return titanRequests, nil
+	case isCohereEmbeddingModel(modelId):
+		return &CohereEmbeddingRequest{
+			Texts:     input,
+			InputType: "search_document",
+		}, nil
+	}
+	return nil, fmt.Errorf("model %s does not support embeddings", modelId)
+}
 
-	awsClaudeReq := &AwsClaudeRequest{
-		AnthropicVersion: "bedrock-2023-05-31",
+func awsEmbeddingHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
 	}
-	if err = copier.Copy(awsClaudeReq, claudeReq); err != nil {
-		return wrapErr(errors.Wrap(err, "copy request")), nil
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
-	awsReq.Body, err = json.Marshal(awsClaudeReq)
+	var embeddingResponse EmbeddingResponse
+	err = json.Unmarshal(responseBody, &embeddingResponse)
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "marshal request")), nil
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
 	}
-
-	awsResp, err := awsCli.InvokeModelWithResponseStream(c.Request.Context(), awsReq)
+	openAIEmbeddingResponse := dto.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0, len(embeddingResponse.Embeddings)),
+		Model:  info.UpstreamModelName,
+	}
+	for i, embedding := range embeddingResponse.Embeddings {
+		openAIEmbeddingResponse.Data = append(openAIEmbeddingResponse.Data, dto.OpenAIEmbeddingResponseItem{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: embedding,
+		})
+	}
+	// Cohere 不返回用量，使用预估的输入 token 数
+	promptTokens := embeddingResponse.InputTextTokenCount
+	if promptTokens == 0 {
+		promptTokens = info.PromptTokens
+	}
+	openAIEmbeddingResponse.Usage = dto.Usage{
+		PromptTokens: promptTokens,
+		TotalTokens:  promptTokens,
+	}
+	jsonResponse, err := json.Marshal(openAIEmbeddingResponse)
 	if err != nil {
-		return wrapErr(errors.Wrap(err, "InvokeModelWithResponseStream")), nil
-	}
-	stream := awsResp.GetStream()
-	defer stream.Close()
-
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	var usage relaymodel.Usage
-	var id string
-	var model string
-	isFirst := true
-	createdTime := common.GetTimestamp()
-	c.Stream(func(w io.Writer) bool {
-		event, ok := <-stream.Events()
-		if !ok {
-			return false
-		}
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(jsonResponse)
+	return nil, &openAIEmbeddingResponse.Usage
+}
 
-		switch v := event.(type) {
-		case *types.ResponseStreamMemberChunk:
-			if isFirst {
-				isFirst = false
-				info.FirstResponseTime = time.Now()
-			}
-			claudeResp := new(claude.ClaudeResponse)
-			err := json.NewDecoder(bytes.NewReader(v.Value.Bytes)).Decode(claudeResp)
-			if err != nil {
-				common.SysError("error unmarshalling stream response: " + err.Error())
-				return false
-			}
+var stabilityAspectRatios = []string{"1:1", "16:9", "21:9", "2:3", "3:2", "4:5", "5:4", "9:16", "9:21"}
 
-			response, claudeUsage := claude.StreamResponseClaude2OpenAI(requestMode, claudeResp)
-			if claudeUsage != nil {
-				usage.PromptTokens += claudeUsage.InputTokens
-				usage.CompletionTokens += claudeUsage.OutputTokens
-			}
+func isTitanImageModel(modelId string) bool {
+	return strings.HasPrefix(modelId, "amazon.titan-image-generator") || strings.HasPrefix(modelId, "amazon.nova-canvas")
+}
 
-			if response == nil {
-				return true
-			}
+func isStabilityImageModel(modelId string) bool {
+	return strings.HasPrefix(modelId, "stability.")
+}
 
-			if response.Id != "" {
-				id = response.Id
-			}
-			if response.Model != "" {
-				model = response.Model
-			}
-			response.Created = createdTime
-			response.Id = id
-			response.Model = model
-
-			jsonStr, err := json.Marshal(response)
-			if err != nil {
-				common.SysError("error marshalling stream response: " + err.Error())
-				return true
-			}
-			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
-			return true
-		case *types.UnknownUnionMember:
-			fmt.Println("unknown tag:", v.Tag)
-			return false
-		default:
-			fmt.Println("union is nil or unknown type")
-			return false
+func convertImageRequest(modelId string, request dto.ImageRequest) (any, error) {
+	switch {
+	case isTitanImageModel(modelId):
+		// Titan 与 Nova Canvas 每次最多生成 5 张图片
+		if request.N > 5 {
+			return nil, errors.New("n must be between 1 and 5")
 		}
-	})
-	if info.ShouldIncludeUsage {
-		response := service.GenerateFinalUsageResponse(id, createdTime, info.UpstreamModelName, usage)
-		err := service.ObjectData(c, response)
-		if err != nil {
-			common.SysError("send final response failed: " + err.Error())
+		titanRequest := TitanImageRequest{
+			TaskType: "TEXT_IMAGE",
+		}
+		titanRequest.TextToImageParams.Text = request.Prompt
+		titanRequest.ImageGenerationConfig.NumberOfImages = request.N
+		titanRequest.ImageGenerationConfig.Width, titanRequest.ImageGenerationConfig.Height, _ = channel.ParseImageSize(request.Size)
+		titanRequest.ImageGenerationConfig.Quality = "standard"
+		if request.Quality == "hd" {
+			titanRequest.ImageGenerationConfig.Quality = "premium"
 		}
+		return &titanRequest, nil
+	case isStabilityImageModel(modelId):
+		return &StabilityImageRequest{
+			Prompt:       request.Prompt,
+			AspectRatio:  channel.ImageAspectRatio(request.Size, stabilityAspectRatios),
+			OutputFormat: "png",
+		}, nil
 	}
-	service.Done(c)
-	if resp != nil {
-		err = resp.Body.Close()
-		if err != nil {
-			return service.OpenAIErrorWrapperLocal(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	return nil, fmt.Errorf("model %s does not support image generation", modelId)
+}
+
+func parseImageResponse(resp *http.Response) ([]dto.ImageData, error) {
+	var imageResponse ImageResponse
+	err := json.NewDecoder(resp.Body).Decode(&imageResponse)
+	if err != nil {
+		return nil, err
+	}
+	if imageResponse.Error != "" {
+		return nil, errors.New(imageResponse.Error)
+	}
+	// Stability 模型的图片被过滤时 finish_reasons 给出原因
+	for _, reason := range imageResponse.FinishReasons {
+		if reason != nil {
+			return nil, fmt.Errorf("image generation stopped: %s", *reason)
 		}
 	}
-	return nil, &usage
+	images := make([]dto.ImageData, 0, len(imageResponse.Images))
+	for _, image := range imageResponse.Images {
+		images = append(images, dto.ImageData{
+			B64Json: image,
+		})
+	}
+	return images, nil
+}
+
+func awsImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	images, err := parseImageResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return channel.WriteImageResponse(c, info, images)
 }
@@ -0,0 +1,489 @@
+package claude
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+)
+
+func (m ClaudeMessage) ParseContent() ([]ClaudeMediaMessage, error) {
+	if text, ok := m.Content.(string); ok {
+		return []ClaudeMediaMessage{{Type: "text", Text: text}}, nil
+	}
+	contentBytes, err := json.Marshal(m.Content)
+	if err != nil {
+		return nil, err
+	}
+	var contents []ClaudeMediaMessage
+	err = json.Unmarshal(contentBytes, &contents)
+	return contents, err
+}
+
+// claudeContentText joins the text blocks of a system prompt or tool result, which may be a string or a block list
+func claudeContentText(content any) string {
+	if content == nil {
+		return ""
+	}
+	message := ClaudeMessage{Content: content}
+	contents, err := message.ParseContent()
+	if err != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(contents))
+	for _, item := range contents {
+		if item.Type == "text" {
+			texts = append(texts, item.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func toolChoiceClaude2OpenAI(toolChoice any) any {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		return nil
+	}
+	switch choice["type"] {
+	case "auto":
+		return "auto"
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choice["name"],
+			},
+		}
+	}
+	return nil
+}
+
+// RequestClaude2OpenAI converts a native Anthropic Messages request into the OpenAI chat format
+func RequestClaude2OpenAI(claudeRequest ClaudeRequest) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:       claudeRequest.Model,
+		MaxTokens:   claudeRequest.MaxTokens,
+		Temperature: claudeRequest.Temperature,
+		TopP:        claudeRequest.TopP,
+		TopK:        claudeRequest.TopK,
+		Stream:      claudeRequest.Stream,
+		ToolChoice:  toolChoiceClaude2OpenAI(claudeRequest.ToolChoice),
+	}
+	if len(claudeRequest.StopSequences) > 0 {
+		stop := make([]interface{}, 0, len(claudeRequest.StopSequences))
+		for _, stopSequence := range claudeRequest.StopSequences {
+			stop = append(stop, stopSequence)
+		}
+		openAIRequest.Stop = stop
+	}
+	if claudeRequest.Metadata != nil {
+		openAIRequest.User = claudeRequest.Metadata.UserId
+	}
+	for _, tool := range claudeRequest.Tools {
+		parameters := map[string]any{
+			"type": tool.InputSchema.Type,
+		}
+		if tool.InputSchema.Properties != nil {
+			parameters["properties"] = tool.InputSchema.Properties
+		}
+		if tool.InputSchema.Required != nil {
+			parameters["required"] = tool.InputSchema.Required
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCall{
+			Type: "function",
+			Function: dto.FunctionCall{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	messages := make([]dto.Message, 0, len(claudeRequest.Messages)+1)
+	if system := claudeContentText(claudeRequest.System); system != "" {
+		systemMessage := dto.Message{Role: "system"}
+		systemMessage.SetStringContent(system)
+		messages = append(messages, systemMessage)
+	}
+	for _, claudeMessage := range claudeRequest.Messages {
+		contents, err := claudeMessage.ParseContent()
+		if err != nil {
+			return nil, fmt.Errorf("invalid content of %s message: %w", claudeMessage.Role, err)
+		}
+		parts := make([]dto.MediaMessage, 0, len(contents))
+		toolCalls := make([]dto.ToolCall, 0)
+		for _, content := range contents {
+			switch content.Type {
+			case "text":
+				parts = append(parts, dto.MediaMessage{
+					Type: dto.ContentTypeText,
+					Text: content.Text,
+				})
+			case "image":
+				if content.Source == nil {
+					continue
+				}
+				imageUrl := content.Source.Url
+				if content.Source.Type == "base64" {
+					imageUrl = fmt.Sprintf("data:%s;base64,%s", content.Source.MediaType, content.Source.Data)
+				}
+				parts = append(parts, dto.MediaMessage{
+					Type: dto.ContentTypeImageURL,
+					ImageUrl: dto.MessageImageUrl{
+						Url:    imageUrl,
+						Detail: "auto",
+					},
+				})
+			case "tool_use":
+				args, _ := json.Marshal(content.Input)
+				toolCalls = append(toolCalls, dto.ToolCall{
+					ID:   content.Id,
+					Type: "function",
+					Function: dto.FunctionCall{
+						Name:      content.Name,
+						Arguments: string(args),
+					},
+				})
+			case "tool_result":
+				// tool results become standalone tool messages in the OpenAI format
+				toolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: content.ToolUseId,
+				}
+				toolMessage.SetStringContent(claudeContentText(content.Content))
+				messages = append(messages, toolMessage)
+			}
+		}
+		if len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		message := dto.Message{
+			Role: claudeMessage.Role,
+		}
+		if len(parts) == 1 && parts[0].Type == dto.ContentTypeText {
+			message.SetStringContent(parts[0].Text)
+		} else if len(parts) > 0 {
+			content, err := json.Marshal(parts)
+			if err != nil {
+				return nil, err
+			}
+			message.Content = content
+		}
+		if len(toolCalls) > 0 {
+			message.ToolCalls = toolCalls
+		}
+		messages = append(messages, message)
+	}
+	openAIRequest.Messages = messages
+	return &openAIRequest, nil
+}
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "length", "max_tokens":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+func usageOpenAI2Claude(usage *dto.Usage) gin.H {
+	if usage == nil {
+		usage = &dto.Usage{}
+	}
+	return gin.H{
+		"input_tokens":  usage.PromptTokens,
+		"output_tokens": usage.CompletionTokens,
+	}
+}
+
+// ResponseOpenAI2Claude converts an OpenAI chat completion into a native Anthropic Messages response
+func ResponseOpenAI2Claude(response *dto.OpenAITextResponse, model string, usage *dto.Usage) gin.H {
+	contents := make([]gin.H, 0)
+	stopReason := "end_turn"
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
+		if text := choice.Message.StringContent(); text != "" {
+			contents = append(contents, gin.H{
+				"type": "text",
+				"text": text,
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			var input any
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil || input == nil {
+				input = map[string]any{}
+			}
+			contents = append(contents, gin.H{
+				"type":  "tool_use",
+				"id":    toolCall.ID,
+				"name":  toolCall.Function.Name,
+				"input": input,
+			})
+		}
+	}
+	id := response.Id
+	if id == "" {
+		id = fmt.Sprintf("msg_%s", common.GetUUID())
+	}
+	return gin.H{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       contents,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         usageOpenAI2Claude(usage),
+	}
+}
+
+// OpenAI2ClaudeStreamConverter turns OpenAI chat completion chunks into Anthropic Messages stream events
+type OpenAI2ClaudeStreamConverter struct {
+	Id           string
+	Model        string
+	PromptTokens int
+
+	started    bool
+	blockIndex int
+	blockType  string
+	toolIndex  int
+	stopReason string
+}
+
+func (s *OpenAI2ClaudeStreamConverter) start() []gin.H {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.blockIndex = -1
+	s.toolIndex = -1
+	return []gin.H{{
+		"type": "message_start",
+		"message": gin.H{
+			"id":            s.Id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         s.Model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": gin.H{
+				"input_tokens":  s.PromptTokens,
+				"output_tokens": 0,
+			},
+		},
+	}}
+}
+
+func (s *OpenAI2ClaudeStreamConverter) stopBlock() []gin.H {
+	if s.blockType == "" {
+		return nil
+	}
+	s.blockType = ""
+	return []gin.H{{
+		"type":  "content_block_stop",
+		"index": s.blockIndex,
+	}}
+}
+
+func (s *OpenAI2ClaudeStreamConverter) startBlock(contentBlock gin.H) []gin.H {
+	events := s.stopBlock()
+	s.blockIndex++
+	s.blockType = contentBlock["type"].(string)
+	return append(events, gin.H{
+		"type":          "content_block_start",
+		"index":         s.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (s *OpenAI2ClaudeStreamConverter) Convert(chunk *dto.ChatCompletionsStreamResponse) []gin.H {
+	events := s.start()
+	for _, choice := range chunk.Choices {
+		if text := choice.Delta.GetContentString(); text != "" {
+			if s.blockType != "text" {
+				events = append(events, s.startBlock(gin.H{"type": "text", "text": ""})...)
+			}
+			events = append(events, gin.H{
+				"type":  "content_block_delta",
+				"index": s.blockIndex,
+				"delta": gin.H{"type": "text_delta", "text": text},
+			})
+		}
+		for i, toolCall := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			if s.blockType != "tool_use" || (toolIndex != s.toolIndex && (toolCall.ID != "" || toolCall.Function.Name != "")) {
+				s.toolIndex = toolIndex
+				id := toolCall.ID
+				if id == "" {
+					id = fmt.Sprintf("toolu_%s", common.GetUUID())
+				}
+				events = append(events, s.startBlock(gin.H{
+					"type":  "tool_use",
+					"id":    id,
+					"name":  toolCall.Function.Name,
+					"input": gin.H{},
+				})...)
+			}
+			if toolCall.Function.Arguments != "" {
+				events = append(events, gin.H{
+					"type":  "content_block_delta",
+					"index": s.blockIndex,
+					"delta": gin.H{"type": "input_json_delta", "partial_json": toolCall.Function.Arguments},
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.stopReason = stopReasonOpenAI2Claude(*choice.FinishReason)
+		}
+	}
+	return events
+}
+
+func (s *OpenAI2ClaudeStreamConverter) Finish(usage *dto.Usage) []gin.H {
+	events := s.start()
+	events = append(events, s.stopBlock()...)
+	stopReason := s.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	return append(events, gin.H{
+		"type": "message_delta",
+		"delta": gin.H{
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+		},
+		"usage": usageOpenAI2Claude(usage),
+	}, gin.H{
+		"type": "message_stop",
+	})
+}
+
+// ClaudeUsage2OpenAI input_tokens 不包含缓存的 token，缓存写入与读取单独记录，按各自的倍率计费
+func ClaudeUsage2OpenAI(claudeUsage ClaudeUsage) dto.Usage {
+	return dto.Usage{
+		PromptTokens:        claudeUsage.InputTokens,
+		CompletionTokens:    claudeUsage.OutputTokens,
+		TotalTokens:         claudeUsage.InputTokens + claudeUsage.OutputTokens,
+		CacheCreationTokens: claudeUsage.CacheCreationInputTokens,
+		CacheReadTokens:     claudeUsage.CacheReadInputTokens,
+	}
+}
+
+// ClaudeNativeStreamHandler relays an Anthropic Messages event stream unchanged and collects usage from it
+func ClaudeNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	usage := &dto.Usage{}
+	responseText := ""
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Split(bufio.ScanLines)
+	service.SetEventStreamHeaders(c)
+
+	for scanner.Scan() {
+		data := scanner.Text()
+		info.SetFirstResponseTime()
+		_, err := c.Writer.WriteString(data + "\n")
+		if err != nil {
+			common.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+		if data == "" {
+			c.Writer.Flush()
+			continue
+		}
+		if !strings.HasPrefix(data, "data:") {
+			continue
+		}
+		data = strings.TrimSpace(strings.TrimPrefix(data, "data:"))
+		var claudeResponse ClaudeResponse
+		err = json.Unmarshal([]byte(data), &claudeResponse)
+		if err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		switch claudeResponse.Type {
+		case "message_start":
+			if claudeResponse.Message != nil {
+				*usage = ClaudeUsage2OpenAI(claudeResponse.Message.Usage)
+			}
+		case "content_block_delta":
+			if claudeResponse.Delta != nil {
+				responseText += claudeResponse.Delta.Text + claudeResponse.Delta.PartialJson
+			}
+		case "message_delta":
+			usage.CompletionTokens = claudeResponse.Usage.OutputTokens
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		}
+	}
+	c.Writer.Flush()
+	resp.Body.Close()
+
+	if usage.PromptTokens == 0 && usage.CacheCreationTokens == 0 && usage.CacheReadTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		textUsage, _ := service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+		textUsage.CacheCreationTokens = usage.CacheCreationTokens
+		textUsage.CacheReadTokens = usage.CacheReadTokens
+		usage = textUsage
+	}
+	return nil, usage
+}
+
+// ClaudeNativeHandler relays an Anthropic Messages response unchanged and bills by its usage block
+func ClaudeNativeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var claudeResponse ClaudeResponse
+	err = json.Unmarshal(responseBody, &claudeResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if claudeResponse.Error.Type != "" {
+		return &dto.OpenAIErrorWithStatusCode{
+			Error: dto.OpenAIError{
+				Message: claudeResponse.Error.Message,
+				Type:    claudeResponse.Error.Type,
+				Param:   "",
+				Code:    claudeResponse.Error.Type,
+			},
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+	usage := ClaudeUsage2OpenAI(claudeResponse.Usage)
+	if usage.PromptTokens == 0 && usage.CacheCreationTokens == 0 && usage.CacheReadTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	for k, v := range resp.Header {
+		c.Writer.Header().Set(k, v[0])
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return nil, &usage
+}
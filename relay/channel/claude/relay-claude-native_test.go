@@ -0,0 +1,195 @@
+package claude
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestClaude2OpenAI(t *testing.T) {
+	asserts := assert.New(t)
+	var claudeRequest ClaudeRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "claude-3-5-sonnet",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "be brief"}],
+		"stop_sequences": ["END"],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"},
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]}]}
+		]
+	}`), &claudeRequest)
+	asserts.NoError(err)
+
+	openAIRequest, err := RequestClaude2OpenAI(claudeRequest)
+	asserts.NoError(err)
+	asserts.Equal("claude-3-5-sonnet", openAIRequest.Model)
+	asserts.EqualValues(1024, openAIRequest.MaxTokens)
+	asserts.Equal([]interface{}{"END"}, openAIRequest.Stop)
+	asserts.Equal(map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}, openAIRequest.ToolChoice)
+	asserts.Len(openAIRequest.Tools, 1)
+	asserts.Equal("get_weather", openAIRequest.Tools[0].Function.Name)
+
+	messages := openAIRequest.Messages
+	asserts.Len(messages, 4)
+	asserts.Equal("system", messages[0].Role)
+	asserts.Equal("be brief", messages[0].StringContent())
+	asserts.Equal("user", messages[1].Role)
+	asserts.Equal("weather in Paris?", messages[1].StringContent())
+	asserts.Equal("assistant", messages[2].Role)
+	toolCalls := messages[2].ParseToolCalls()
+	asserts.Len(toolCalls, 1)
+	asserts.Equal("toolu_1", toolCalls[0].ID)
+	asserts.JSONEq(`{"city": "Paris"}`, toolCalls[0].Function.Arguments)
+	asserts.Equal("tool", messages[3].Role)
+	asserts.Equal("toolu_1", messages[3].ToolCallId)
+	asserts.Equal("sunny", messages[3].StringContent())
+}
+
+func TestClaudeUsage2OpenAI(t *testing.T) {
+	asserts := assert.New(t)
+	usage := ClaudeUsage2OpenAI(ClaudeUsage{
+		InputTokens:              10,
+		OutputTokens:             20,
+		CacheCreationInputTokens: 300,
+		CacheReadInputTokens:     4000,
+	})
+	// 缓存的 token 不计入 PromptTokens，按各自的倍率单独计费
+	asserts.Equal(10, usage.PromptTokens)
+	asserts.Equal(20, usage.CompletionTokens)
+	asserts.Equal(30, usage.TotalTokens)
+	asserts.Equal(300, usage.CacheCreationTokens)
+	asserts.Equal(4000, usage.CacheReadTokens)
+}
+
+func TestResponseOpenAI2Claude(t *testing.T) {
+	asserts := assert.New(t)
+	var response dto.OpenAITextResponse
+	err := json.Unmarshal([]byte(`{
+		"id": "chatcmpl-1",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": "checking",
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}}]
+	}`), &response)
+	asserts.NoError(err)
+
+	claudeResponse := ResponseOpenAI2Claude(&response, "claude-3-5-sonnet", &dto.Usage{PromptTokens: 5, CompletionTokens: 7})
+	jsonData, err := json.Marshal(claudeResponse)
+	asserts.NoError(err)
+	asserts.JSONEq(`{
+		"id": "chatcmpl-1",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-3-5-sonnet",
+		"content": [
+			{"type": "text", "text": "checking"},
+			{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 5, "output_tokens": 7}
+	}`, string(jsonData))
+}
+
+func TestOpenAI2ClaudeStreamConverter(t *testing.T) {
+	asserts := assert.New(t)
+	converter := OpenAI2ClaudeStreamConverter{Id: "msg_1", Model: "claude-3-5-sonnet", PromptTokens: 5}
+	chunks := []string{
+		`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": "Hel"}}]}`,
+		`{"choices": [{"index": 0, "delta": {"content": "lo"}}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": ""}}]}}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"city\":"}}]}}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"Paris\"}"}}]}}]}`,
+		`{"choices": [{"index": 0, "delta": {}, "finish_reason": "tool_calls"}]}`,
+	}
+	var events []gin.H
+	for _, chunk := range chunks {
+		var streamResponse dto.ChatCompletionsStreamResponse
+		asserts.NoError(json.Unmarshal([]byte(chunk), &streamResponse))
+		events = append(events, converter.Convert(&streamResponse)...)
+	}
+	events = append(events, converter.Finish(&dto.Usage{PromptTokens: 5, CompletionTokens: 9})...)
+
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event["type"].(string))
+	}
+	asserts.Equal([]string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop",
+		"message_delta", "message_stop",
+	}, types)
+	asserts.Equal(gin.H{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": gin.H{}}, events[5]["content_block"])
+	asserts.Equal(1, events[5]["index"])
+	asserts.Equal("tool_use", events[9]["delta"].(gin.H)["stop_reason"])
+	asserts.Equal(gin.H{"input_tokens": 5, "output_tokens": 9}, events[9]["usage"])
+}
+
+func TestClaudeNativeStreamHandler(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader("{}"))
+
+	upstream := strings.Join([]string{
+		`event: message_start`,
+		`data: {"type": "message_start", "message": {"id": "msg_1", "usage": {"input_tokens": 12, "output_tokens": 1, "cache_creation_input_tokens": 100, "cache_read_input_tokens": 2000}}}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hi"}}`,
+		``,
+		`event: message_delta`,
+		`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 8}}`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+	info := &relaycommon.RelayInfo{PromptTokens: 50, UpstreamModelName: "claude-3-5-sonnet"}
+	openaiErr, usage := ClaudeNativeStreamHandler(c, resp, info)
+	asserts.Nil(openaiErr)
+	asserts.Equal(12, usage.PromptTokens)
+	asserts.Equal(8, usage.CompletionTokens)
+	asserts.Equal(100, usage.CacheCreationTokens)
+	asserts.Equal(2000, usage.CacheReadTokens)
+	// 原生渠道的事件流原样透传
+	asserts.Equal(upstream, recorder.Body.String())
+}
+
+func TestClaudeNativeHandler(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader("{}"))
+
+	body := `{"id": "msg_1", "type": "message", "content": [{"type": "text", "text": "Hi"}], "usage": {"input_tokens": 0, "output_tokens": 3, "cache_read_input_tokens": 900}}`
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	info := &relaycommon.RelayInfo{PromptTokens: 50}
+	openaiErr, usage := ClaudeNativeHandler(c, resp, info)
+	asserts.Nil(openaiErr)
+	// 全部命中缓存时 input_tokens 为 0，不应回退到预估的提示 token 数
+	asserts.Equal(0, usage.PromptTokens)
+	asserts.Equal(900, usage.CacheReadTokens)
+	asserts.Equal(3, usage.CompletionTokens)
+	asserts.Equal(body, recorder.Body.String())
+}
@@ -14,6 +14,7 @@ import (
 )
 
 type Adaptor struct {
+	ImageN int
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
@@ -49,6 +50,10 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		// 每次请求只生成一张图片
+		return channel.DoImageRequests(a, c, info, requestBody, a.ImageN, parseCfImageResponse)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
@@ -74,8 +79,8 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	a.ImageN = request.N
+	return convertOpenAIImage2Cf(request), nil
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
@@ -92,6 +97,8 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		fallthrough
 	case constant.RelayModeAudioTranscription:
 		err, usage = cfSTTHandler(c, resp, info)
+	case constant.RelayModeImagesGenerations:
+		err, usage = channel.ImageHandler(c, resp, info)
 	}
 	return
 }
@@ -34,6 +34,10 @@ var ModelList = []string{
 	"@hf/nexusflow/starling-lm-7b-beta",
 	"@cf/tinyllama/tinyllama-1.1b-chat-v1.0",
 	"@hf/thebloke/zephyr-7b-beta-awq",
+	"@cf/stabilityai/stable-diffusion-xl-base-1.0",
+	"@cf/bytedance/stable-diffusion-xl-lightning",
+	"@cf/lykon/dreamshaper-8-lcm",
+	"@cf/black-forest-labs/flux-1-schnell",
 }
 
 var ChannelName = "cloudflare"
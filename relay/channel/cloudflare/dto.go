@@ -19,3 +19,16 @@ type CfAudioResponse struct {
 type CfSTTResult struct {
 	Text string `json:"text"`
 }
+
+type CfImageRequest struct {
+	Prompt string `json:"prompt"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// CfImageResponse flux 等模型以 json 返回 base64 图片，stable diffusion 模型直接返回图片内容
+type CfImageResponse struct {
+	Result struct {
+		Image string `json:"image"`
+	} `json:"result"`
+}
@@ -2,12 +2,15 @@ package cloudflare
 
 import (
 	"bufio"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
 	"one-api/service"
 	"strings"
@@ -154,3 +157,41 @@ func cfSTTHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayIn
 
 	return nil, usage
 }
+
+func convertOpenAIImage2Cf(request dto.ImageRequest) *CfImageRequest {
+	cfRequest := &CfImageRequest{
+		Prompt: request.Prompt,
+	}
+	// flux 模型不支持指定尺寸
+	if !strings.Contains(request.Model, "flux") {
+		cfRequest.Width, cfRequest.Height, _ = channel.ParseImageSize(request.Size)
+	}
+	return cfRequest
+}
+
+func parseCfImageResponse(resp *http.Response) ([]dto.ImageData, error) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return []dto.ImageData{
+			{
+				B64Json: base64.StdEncoding.EncodeToString(responseBody),
+			},
+		}, nil
+	}
+	var cfResp CfImageResponse
+	err = json.Unmarshal(responseBody, &cfResp)
+	if err != nil {
+		return nil, err
+	}
+	if cfResp.Result.Image == "" {
+		return nil, errors.New("no image in cloudflare response")
+	}
+	return []dto.ImageData{
+		{
+			B64Json: cfResp.Result.Image,
+		},
+	}, nil
+}
@@ -3,16 +3,45 @@ package cohere
 import "one-api/dto"
 
 type CohereRequest struct {
-	Model       string        `json:"model"`
-	ChatHistory []ChatHistory `json:"chat_history"`
-	Message     string        `json:"message"`
-	Stream      bool          `json:"stream"`
-	MaxTokens   int           `json:"max_tokens"`
+	Model       string             `json:"model"`
+	ChatHistory []ChatHistory      `json:"chat_history"`
+	Message     string             `json:"message"`
+	Stream      bool               `json:"stream"`
+	MaxTokens   int                `json:"max_tokens"`
+	Tools       []CohereTool       `json:"tools,omitempty"`
+	ToolResults []CohereToolResult `json:"tool_results,omitempty"`
+	ToolChoice  string             `json:"tool_choice,omitempty"`
 }
 
 type ChatHistory struct {
-	Role    string `json:"role"`
-	Message string `json:"message"`
+	Role        string             `json:"role"`
+	Message     string             `json:"message,omitempty"`
+	ToolCalls   []CohereToolCall   `json:"tool_calls,omitempty"`
+	ToolResults []CohereToolResult `json:"tool_results,omitempty"`
+}
+
+// https://docs.cohere.com/docs/tool-use
+
+type CohereTool struct {
+	Name                 string                               `json:"name"`
+	Description          string                               `json:"description"`
+	ParameterDefinitions map[string]CohereParameterDefinition `json:"parameter_definitions,omitempty"`
+}
+
+type CohereParameterDefinition struct {
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+}
+
+type CohereToolCall struct {
+	Name       string         `json:"name"`
+	Parameters map[string]any `json:"parameters"`
+}
+
+type CohereToolResult struct {
+	Call    CohereToolCall   `json:"call"`
+	Outputs []map[string]any `json:"outputs"`
 }
 
 type CohereResponse struct {
@@ -20,14 +49,16 @@ type CohereResponse struct {
 	EventType    string                `json:"event_type"`
 	Text         string                `json:"text,omitempty"`
 	FinishReason string                `json:"finish_reason,omitempty"`
+	ToolCalls    []CohereToolCall      `json:"tool_calls,omitempty"`
 	Response     *CohereResponseResult `json:"response"`
 }
 
 type CohereResponseResult struct {
-	ResponseId   string     `json:"response_id"`
-	FinishReason string     `json:"finish_reason,omitempty"`
-	Text         string     `json:"text"`
-	Meta         CohereMeta `json:"meta"`
+	ResponseId   string           `json:"response_id"`
+	FinishReason string           `json:"finish_reason,omitempty"`
+	Text         string           `json:"text"`
+	ToolCalls    []CohereToolCall `json:"tool_calls,omitempty"`
+	Meta         CohereMeta       `json:"meta"`
 }
 
 type CohereRerankRequest struct {
@@ -26,20 +26,57 @@ func requestOpenAI2Cohere(textRequest dto.GeneralOpenAIRequest) *CohereRequest {
 	if cohereReq.MaxTokens == 0 {
 		cohereReq.MaxTokens = 4000
 	}
+	cohereReq.Tools, cohereReq.ToolChoice = toolsOpenAI2Cohere(textRequest.Tools, textRequest.ToolChoice)
+	// Cohere 的函数调用没有 id，tool 消息需要带上对应的调用
+	toolCalls := make(map[string]CohereToolCall)
 	for _, msg := range textRequest.Messages {
-		if msg.Role == "user" {
-			cohereReq.Message = msg.StringContent()
-		} else {
-			var role string
-			if msg.Role == "assistant" {
-				role = "CHATBOT"
-			} else if msg.Role == "system" {
-				role = "SYSTEM"
+		for _, toolCall := range msg.ParseToolCalls() {
+			toolCalls[toolCall.ID] = toolCallOpenAI2Cohere(toolCall)
+		}
+	}
+	// 末尾的 tool 消息作为本轮的 tool_results，否则最后一条 user 消息作为 message
+	messages := textRequest.Messages
+	end := len(messages)
+	for end > 0 && messages[end-1].Role == "tool" {
+		end--
+	}
+	for _, msg := range messages[end:] {
+		cohereReq.ToolResults = append(cohereReq.ToolResults, toolResultOpenAI2Cohere(msg, toolCalls))
+	}
+	history := messages[:end]
+	if len(cohereReq.ToolResults) == 0 && end > 0 && messages[end-1].Role == "user" {
+		cohereReq.Message = messages[end-1].StringContent()
+		history = messages[:end-1]
+	}
+	for _, msg := range history {
+		switch msg.Role {
+		case "assistant":
+			chatHistory := ChatHistory{
+				Role:    "CHATBOT",
+				Message: msg.StringContent(),
+			}
+			for _, toolCall := range msg.ParseToolCalls() {
+				chatHistory.ToolCalls = append(chatHistory.ToolCalls, toolCalls[toolCall.ID])
+			}
+			cohereReq.ChatHistory = append(cohereReq.ChatHistory, chatHistory)
+		case "tool":
+			toolResult := toolResultOpenAI2Cohere(msg, toolCalls)
+			if last := len(cohereReq.ChatHistory) - 1; last >= 0 && cohereReq.ChatHistory[last].Role == "TOOL" {
+				cohereReq.ChatHistory[last].ToolResults = append(cohereReq.ChatHistory[last].ToolResults, toolResult)
 			} else {
-				role = "USER"
+				cohereReq.ChatHistory = append(cohereReq.ChatHistory, ChatHistory{
+					Role:        "TOOL",
+					ToolResults: []CohereToolResult{toolResult},
+				})
 			}
+		case "system":
+			cohereReq.ChatHistory = append(cohereReq.ChatHistory, ChatHistory{
+				Role:    "SYSTEM",
+				Message: msg.StringContent(),
+			})
+		default:
 			cohereReq.ChatHistory = append(cohereReq.ChatHistory, ChatHistory{
-				Role:    role,
+				Role:    "USER",
 				Message: msg.StringContent(),
 			})
 		}
@@ -47,6 +84,133 @@ func requestOpenAI2Cohere(textRequest dto.GeneralOpenAIRequest) *CohereRequest {
 	return &cohereReq
 }
 
+func toolsOpenAI2Cohere(tools []dto.ToolCall, toolChoice any) ([]CohereTool, string) {
+	if len(tools) == 0 {
+		return nil, ""
+	}
+	cohereChoice := ""
+	forcedName := ""
+	switch choice := toolChoice.(type) {
+	case string:
+		if choice == "required" {
+			cohereChoice = "REQUIRED"
+		} else if choice == "none" {
+			cohereChoice = "NONE"
+		}
+	case map[string]any:
+		// Cohere 不能指定函数，只保留该函数并要求调用
+		if function, ok := choice["function"].(map[string]any); ok {
+			forcedName, _ = function["name"].(string)
+			cohereChoice = "REQUIRED"
+		}
+	}
+	cohereTools := make([]CohereTool, 0, len(tools))
+	for _, tool := range tools {
+		if forcedName != "" && tool.Function.Name != forcedName {
+			continue
+		}
+		cohereTools = append(cohereTools, CohereTool{
+			Name:                 tool.Function.Name,
+			Description:          tool.Function.Description,
+			ParameterDefinitions: parametersOpenAI2Cohere(tool.Function.Parameters),
+		})
+	}
+	return cohereTools, cohereChoice
+}
+
+// parametersOpenAI2Cohere 把 JSON Schema 的参数定义转换为 Cohere 的 parameter_definitions
+func parametersOpenAI2Cohere(parameters any) map[string]CohereParameterDefinition {
+	params, ok := parameters.(map[string]any)
+	if !ok {
+		return nil
+	}
+	properties, _ := params["properties"].(map[string]any)
+	if len(properties) == 0 {
+		return nil
+	}
+	required := make(map[string]bool)
+	if requiredList, ok := params["required"].([]any); ok {
+		for _, name := range requiredList {
+			if str, ok := name.(string); ok {
+				required[str] = true
+			}
+		}
+	}
+	definitions := make(map[string]CohereParameterDefinition, len(properties))
+	for name, property := range properties {
+		schema, _ := property.(map[string]any)
+		description, _ := schema["description"].(string)
+		definitions[name] = CohereParameterDefinition{
+			Description: description,
+			Type:        schemaTypeOpenAI2Cohere(schema),
+			Required:    required[name],
+		}
+	}
+	return definitions
+}
+
+func schemaTypeOpenAI2Cohere(schema map[string]any) string {
+	switch schema["type"] {
+	case "integer":
+		return "int"
+	case "number":
+		return "float"
+	case "boolean":
+		return "bool"
+	case "object":
+		return "dict"
+	case "array":
+		if items, ok := schema["items"].(map[string]any); ok {
+			return "List[" + schemaTypeOpenAI2Cohere(items) + "]"
+		}
+		return "list"
+	default:
+		return "str"
+	}
+}
+
+func toolCallOpenAI2Cohere(toolCall dto.ToolCall) CohereToolCall {
+	parameters := make(map[string]any)
+	_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &parameters)
+	return CohereToolCall{
+		Name:       toolCall.Function.Name,
+		Parameters: parameters,
+	}
+}
+
+func toolResultOpenAI2Cohere(msg dto.Message, toolCalls map[string]CohereToolCall) CohereToolResult {
+	output := make(map[string]any)
+	content := msg.StringContent()
+	if err := json.Unmarshal([]byte(content), &output); err != nil {
+		output = map[string]any{"result": content}
+	}
+	return CohereToolResult{
+		Call:    toolCalls[msg.ToolCallId],
+		Outputs: []map[string]any{output},
+	}
+}
+
+func toolCallsCohere2OpenAI(cohereToolCalls []CohereToolCall, withIndex bool) []dto.ToolCall {
+	toolCalls := make([]dto.ToolCall, 0, len(cohereToolCalls))
+	for i, toolCall := range cohereToolCalls {
+		arguments, _ := json.Marshal(toolCall.Parameters)
+		openaiToolCall := dto.ToolCall{
+			ID:   fmt.Sprintf("call_%s", common.GetUUID()),
+			Type: "function",
+			Function: dto.FunctionCall{
+				Name:      toolCall.Name,
+				Arguments: string(arguments),
+			},
+		}
+		if withIndex {
+			index := i
+			openaiToolCall.Index = &index
+		}
+		toolCalls = append(toolCalls, openaiToolCall)
+	}
+	return toolCalls
+}
+
 func requestConvertRerank2Cohere(rerankRequest dto.RerankRequest) *CohereRerankRequest {
 	if rerankRequest.TopN == 0 {
 		rerankRequest.TopN = 1
@@ -101,6 +265,7 @@ func cohereStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 	}()
 	service.SetEventStreamHeaders(c)
 	isFirst := true
+	hasToolCalls := false
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -122,6 +287,9 @@ func cohereStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 			openaiResp.Model = info.UpstreamModelName
 			if cohereResp.IsFinished {
 				finishReason := stopReasonCohere2OpenAI(cohereResp.FinishReason)
+				if hasToolCalls {
+					finishReason = "tool_calls"
+				}
 				openaiResp.Choices = []dto.ChatCompletionsStreamResponseChoice{
 					{
 						Delta:        dto.ChatCompletionsStreamResponseChoiceDelta{},
@@ -133,6 +301,20 @@ func cohereStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 					usage.PromptTokens = cohereResp.Response.Meta.BilledUnits.InputTokens
 					usage.CompletionTokens = cohereResp.Response.Meta.BilledUnits.OutputTokens
 				}
+			} else if cohereResp.EventType == "tool-calls-generation" {
+				// 函数调用在 tool-calls-generation 事件中完整给出，不处理 tool-calls-chunk
+				hasToolCalls = true
+				openaiResp.Choices = []dto.ChatCompletionsStreamResponseChoice{
+					{
+						Delta: dto.ChatCompletionsStreamResponseChoiceDelta{
+							Role:      "assistant",
+							ToolCalls: toolCallsCohere2OpenAI(cohereResp.ToolCalls, true),
+						},
+						Index: 0,
+					},
+				}
+			} else if cohereResp.EventType == "tool-calls-chunk" {
+				return true
 			} else {
 				openaiResp.Choices = []dto.ChatCompletionsStreamResponseChoice{
 					{
@@ -198,6 +380,10 @@ func cohereHandler(c *gin.Context, resp *http.Response, modelName string, prompt
 			FinishReason: stopReasonCohere2OpenAI(cohereResp.FinishReason),
 		},
 	}
+	if len(cohereResp.ToolCalls) > 0 {
+		openaiResp.Choices[0].Message.ToolCalls = toolCallsCohere2OpenAI(cohereResp.ToolCalls, false)
+		openaiResp.Choices[0].FinishReason = "tool_calls"
+	}
 
 	jsonResponse, err := json.Marshal(openaiResp)
 	if err != nil {
@@ -0,0 +1,164 @@
+package cohere
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	"one-api/relay/channel/testutil"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestOpenAI2CohereTools(t *testing.T) {
+	asserts := assert.New(t)
+	var request dto.GeneralOpenAIRequest
+	asserts.NoError(json.Unmarshal([]byte(`{
+		"model": "command-r",
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {
+				"type": "object",
+				"properties": {
+					"city": {"type": "string", "description": "City name"},
+					"days": {"type": "integer"},
+					"hours": {"type": "array", "items": {"type": "number"}}
+				},
+				"required": ["city"]
+			}}},
+			{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object"}}}
+		],
+		"tool_choice": "required",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "weather in Paris and Rome?"},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "{\"weather\":\"sunny\"}"},
+			{"role": "tool", "tool_call_id": "call_2", "content": "rainy"}
+		]
+	}`), &request))
+
+	cohereRequest := requestOpenAI2Cohere(request)
+	asserts.Equal("REQUIRED", cohereRequest.ToolChoice)
+	if asserts.Len(cohereRequest.Tools, 2) {
+		asserts.Equal("get_weather", cohereRequest.Tools[0].Name)
+		asserts.Equal(map[string]CohereParameterDefinition{
+			"city":  {Description: "City name", Type: "str", Required: true},
+			"days":  {Type: "int"},
+			"hours": {Type: "List[float]"},
+		}, cohereRequest.Tools[0].ParameterDefinitions)
+		asserts.Nil(cohereRequest.Tools[1].ParameterDefinitions)
+	}
+
+	// 末尾的 tool 消息作为本轮的 tool_results，并带上对应的调用
+	asserts.Equal("", cohereRequest.Message)
+	if asserts.Len(cohereRequest.ToolResults, 2) {
+		asserts.Equal(CohereToolCall{Name: "get_weather", Parameters: map[string]any{"city": "Paris"}}, cohereRequest.ToolResults[0].Call)
+		asserts.Equal([]map[string]any{{"weather": "sunny"}}, cohereRequest.ToolResults[0].Outputs)
+		asserts.Equal(CohereToolCall{Name: "get_weather", Parameters: map[string]any{"city": "Rome"}}, cohereRequest.ToolResults[1].Call)
+		asserts.Equal([]map[string]any{{"result": "rainy"}}, cohereRequest.ToolResults[1].Outputs)
+	}
+	if asserts.Len(cohereRequest.ChatHistory, 3) {
+		asserts.Equal(ChatHistory{Role: "SYSTEM", Message: "be brief"}, cohereRequest.ChatHistory[0])
+		asserts.Equal(ChatHistory{Role: "USER", Message: "weather in Paris and Rome?"}, cohereRequest.ChatHistory[1])
+		asserts.Equal("CHATBOT", cohereRequest.ChatHistory[2].Role)
+		asserts.Len(cohereRequest.ChatHistory[2].ToolCalls, 2)
+	}
+
+	// 之后的 user 消息作为 message，tool 结果进入历史
+	userContent, _ := json.Marshal("thanks")
+	request.Messages = append(request.Messages, dto.Message{Role: "user", Content: userContent})
+	cohereRequest = requestOpenAI2Cohere(request)
+	asserts.Equal("thanks", cohereRequest.Message)
+	asserts.Nil(cohereRequest.ToolResults)
+	if asserts.Len(cohereRequest.ChatHistory, 4) {
+		asserts.Equal("TOOL", cohereRequest.ChatHistory[3].Role)
+		asserts.Len(cohereRequest.ChatHistory[3].ToolResults, 2)
+	}
+
+	// 指定函数时只保留该函数
+	request.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}}
+	cohereRequest = requestOpenAI2Cohere(request)
+	asserts.Equal("REQUIRED", cohereRequest.ToolChoice)
+	if asserts.Len(cohereRequest.Tools, 1) {
+		asserts.Equal("get_time", cohereRequest.Tools[0].Name)
+	}
+}
+
+func TestCohereHandlerToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{"response_id":"r","text":"","finish_reason":"COMPLETE",
+			"tool_calls":[{"name":"get_weather","parameters":{"city":"Paris"}}],
+			"meta":{"billed_units":{"input_tokens":10,"output_tokens":5}}}`)),
+	}
+	openaiErr, usage := cohereHandler(c, resp, "command-r", 0)
+	asserts.Nil(openaiErr)
+	asserts.Equal(15, usage.TotalTokens)
+
+	var response dto.TextResponse
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	choice := response.Choices[0]
+	asserts.Equal("tool_calls", choice.FinishReason)
+	toolCalls := choice.Message.ParseToolCalls()
+	if asserts.Len(toolCalls, 1) {
+		asserts.Nil(toolCalls[0].Index)
+		asserts.NotEmpty(toolCalls[0].ID)
+		asserts.Equal("get_weather", toolCalls[0].Function.Name)
+		asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+	}
+}
+
+func TestCohereStreamHandlerToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(testutil.CloseNotifyRecorder{ResponseRecorder: recorder})
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(strings.Join([]string{
+			`{"is_finished":false,"event_type":"stream-start"}`,
+			`{"is_finished":false,"event_type":"text-generation","text":"Let me check."}`,
+			`{"is_finished":false,"event_type":"tool-calls-chunk","tool_call_delta":{"index":0,"name":"get_weather"}}`,
+			`{"is_finished":false,"event_type":"tool-calls-generation","tool_calls":[{"name":"get_weather","parameters":{"city":"Paris"}},{"name":"get_weather","parameters":{"city":"Rome"}}]}`,
+			`{"is_finished":true,"event_type":"stream-end","finish_reason":"COMPLETE","response":{"meta":{"billed_units":{"input_tokens":10,"output_tokens":5}}}}`,
+		}, "\n"))),
+	}
+	openaiErr, usage := cohereStreamHandler(c, resp, &relaycommon.RelayInfo{UpstreamModelName: "command-r"})
+	asserts.Nil(openaiErr)
+	asserts.Equal(10, usage.PromptTokens)
+	asserts.Equal(5, usage.CompletionTokens)
+
+	var toolCalls []dto.ToolCall
+	var finishReason string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line || data == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		asserts.NoError(json.Unmarshal([]byte(data), &chunk))
+		toolCalls = append(toolCalls, chunk.Choices[0].Delta.ToolCalls...)
+		if chunk.Choices[0].FinishReason != nil {
+			finishReason = *chunk.Choices[0].FinishReason
+		}
+	}
+	// tool-calls-chunk 不输出，流式的 tool_calls 带上序号
+	if asserts.Len(toolCalls, 2) {
+		asserts.Equal(0, *toolCalls[0].Index)
+		asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+		asserts.Equal(1, *toolCalls[1].Index)
+		asserts.Equal(`{"city":"Rome"}`, toolCalls[1].Function.Arguments)
+	}
+	asserts.Equal("tool_calls", finishReason)
+}
@@ -12,6 +12,7 @@ import (
 )
 
 type Adaptor struct {
+	ToolsEnabled bool
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -41,6 +42,7 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	a.ToolsEnabled = channel.ToolPrompt(request.Tools, request.ToolChoice) != ""
 	return requestOpenAI2Dify(*request), nil
 }
 
@@ -54,9 +56,9 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
 	if info.IsStream {
-		err, usage = difyStreamHandler(c, resp, info)
+		err, usage = difyStreamHandler(c, resp, info, a.ToolsEnabled)
 	} else {
-		err, usage = difyHandler(c, resp, info)
+		err, usage = difyHandler(c, resp, info, a.ToolsEnabled)
 	}
 	return
 }
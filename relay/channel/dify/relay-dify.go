@@ -9,6 +9,7 @@ import (
 	"one-api/common"
 	"one-api/constant"
 	"one-api/dto"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
 	"one-api/service"
 	"strings"
@@ -16,11 +17,20 @@ import (
 
 func requestOpenAI2Dify(request dto.GeneralOpenAIRequest) *DifyChatRequest {
 	content := ""
+	// dify 应用接口不支持函数调用，工具说明以提示词的形式传入
+	if toolPrompt := channel.ToolPrompt(request.Tools, request.ToolChoice); toolPrompt != "" {
+		content += "SYSTEM: \n" + toolPrompt + "\n"
+	}
 	for _, message := range request.Messages {
 		if message.Role == "system" {
 			content += "SYSTEM: \n" + message.StringContent() + "\n"
 		} else if message.Role == "assistant" {
 			content += "ASSISTANT: \n" + message.StringContent() + "\n"
+			if toolCalls := message.ParseToolCalls(); len(toolCalls) > 0 {
+				content += channel.ToolCallsText(toolCalls) + "\n"
+			}
+		} else if message.Role == "tool" {
+			content += "TOOL: \n" + channel.ToolResultText(request.Messages, message) + "\n"
 		} else {
 			content += "USER: \n" + message.StringContent() + "\n"
 		}
@@ -60,11 +70,15 @@ func streamResponseDify2OpenAI(difyResponse DifyChunkChatCompletionResponse) *dt
 	return &response
 }
 
-func difyStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+func difyStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, toolsEnabled bool) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
 	var responseText string
 	usage := &dto.Usage{}
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
+	var toolBuffer *channel.ToolCallStreamBuffer
+	if toolsEnabled {
+		toolBuffer = &channel.ToolCallStreamBuffer{}
+	}
 
 	service.SetEventStreamHeaders(c)
 
@@ -88,6 +102,13 @@ func difyStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Re
 			break
 		} else {
 			openaiResponse = *streamResponseDify2OpenAI(difyResponse)
+			if toolBuffer != nil && (difyResponse.Event == "message" || difyResponse.Event == "agent_message") {
+				text := toolBuffer.Write(difyResponse.Answer)
+				if text == "" {
+					continue
+				}
+				openaiResponse.Choices[0].Delta.SetContentString(text)
+			}
 			if len(openaiResponse.Choices) != 0 {
 				responseText += openaiResponse.Choices[0].Delta.GetContentString()
 			}
@@ -100,6 +121,31 @@ func difyStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Re
 	if err := scanner.Err(); err != nil {
 		common.SysError("error reading stream: " + err.Error())
 	}
+	if toolBuffer != nil {
+		text, toolCalls := toolBuffer.Finish()
+		var choice dto.ChatCompletionsStreamResponseChoice
+		if len(toolCalls) > 0 {
+			choice.Delta.ToolCalls = toolCalls
+			finishReason := "tool_calls"
+			choice.FinishReason = &finishReason
+			toolCallsText, _ := json.Marshal(toolCalls)
+			responseText += string(toolCallsText)
+		} else {
+			choice.Delta.SetContentString(text)
+			responseText += text
+		}
+		if len(toolCalls) > 0 || text != "" {
+			err := service.ObjectData(c, dto.ChatCompletionsStreamResponse{
+				Object:  "chat.completion.chunk",
+				Created: common.GetTimestamp(),
+				Model:   "dify",
+				Choices: []dto.ChatCompletionsStreamResponseChoice{choice},
+			})
+			if err != nil {
+				common.SysError(err.Error())
+			}
+		}
+	}
 	service.Done(c)
 	err := resp.Body.Close()
 	if err != nil {
@@ -114,7 +160,7 @@ func difyStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Re
 	return nil, usage
 }
 
-func difyHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+func difyHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, toolsEnabled bool) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
 	var difyResponse DifyChatCompletionResponse
 	responseBody, err := io.ReadAll(resp.Body)
 
@@ -144,6 +190,13 @@ func difyHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInf
 		},
 		FinishReason: "stop",
 	}
+	if toolsEnabled {
+		if toolCalls := channel.ParseToolCallsText(difyResponse.Answer); toolCalls != nil {
+			choice.Message.Content = nil
+			choice.Message.ToolCalls = toolCalls
+			choice.FinishReason = "tool_calls"
+		}
+	}
 	fullTextResponse.Choices = append(fullTextResponse.Choices, choice)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
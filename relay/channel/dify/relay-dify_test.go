@@ -0,0 +1,140 @@
+package dify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestOpenAI2DifyTools(t *testing.T) {
+	asserts := assert.New(t)
+	var request dto.GeneralOpenAIRequest
+	asserts.NoError(json.Unmarshal([]byte(`{
+		"model": "dify",
+		"stream": true,
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": "", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+		]
+	}`), &request))
+
+	difyRequest := requestOpenAI2Dify(request)
+	asserts.Equal("streaming", difyRequest.ResponseMode)
+	asserts.Equal("api-user", difyRequest.User)
+	// 工具说明在最前面，历史中的函数调用与结果按约定格式写入对话
+	asserts.True(strings.HasPrefix(difyRequest.Query, "SYSTEM: \nYou can call the following functions:"))
+	asserts.True(strings.HasSuffix(difyRequest.Query, "USER: \nweather in Paris?\n"+
+		"ASSISTANT: \n\n"+`{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`+"\n"+
+		"TOOL: \nFunction get_weather returned:\nsunny\n"), difyRequest.Query)
+
+	request.Tools = nil
+	asserts.Equal("USER: \nweather in Paris?\nASSISTANT: \n\n"+`{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`+"\n"+
+		"TOOL: \nFunction get_weather returned:\nsunny\n", requestOpenAI2Dify(request).Query)
+}
+
+func TestDifyHandlerToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	for _, tc := range []struct {
+		answer       string
+		toolsEnabled bool
+		finishReason string
+	}{
+		{"```json\n" + `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}` + "\n```", true, "tool_calls"},
+		{`{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`, false, "stop"},
+		{`{"city":"Paris"}`, true, "stop"},
+		{"It is sunny.", true, "stop"},
+	} {
+		answer, _ := json.Marshal(tc.answer)
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"conversation_id":"c","answer":` + string(answer) + `,"metadata":{"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}}`)),
+		}
+		openaiErr, usage := difyHandler(c, resp, &relaycommon.RelayInfo{}, tc.toolsEnabled)
+		asserts.Nil(openaiErr)
+		asserts.Equal(3, usage.TotalTokens)
+
+		var response dto.OpenAITextResponse
+		asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+		choice := response.Choices[0]
+		asserts.Equal(tc.finishReason, choice.FinishReason, tc.answer)
+		if tc.finishReason == "tool_calls" {
+			toolCalls := choice.Message.ParseToolCalls()
+			if asserts.Len(toolCalls, 1) {
+				asserts.Equal("get_weather", toolCalls[0].Function.Name)
+				asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+			}
+		} else {
+			asserts.Equal(tc.answer, choice.Message.StringContent())
+			asserts.Nil(choice.Message.ToolCalls)
+		}
+	}
+}
+
+func difyStreamChunks(t *testing.T, body string, toolsEnabled bool) []dto.ChatCompletionsStreamResponse {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+	openaiErr, usage := difyStreamHandler(c, resp, &relaycommon.RelayInfo{}, toolsEnabled)
+	assert.Nil(t, openaiErr)
+	assert.Equal(t, 3, usage.TotalTokens)
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(recorder.Body.String()), "data: [DONE]"))
+
+	var chunks []dto.ChatCompletionsStreamResponse
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line || data == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestDifyStreamHandlerToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	messageEnd := `data: {"event":"message_end","metadata":{"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}}` + "\n"
+
+	// 函数调用缓存到结束，输出带序号的 tool_calls
+	chunks := difyStreamChunks(t, `data: {"event":"message","answer":"{\"tool_calls\":[{\"name\":\"get_weather\","}`+"\n"+
+		`data: {"event":"message","answer":"\"arguments\":{\"city\":\"Paris\"}}]}"}`+"\n"+messageEnd, true)
+	if asserts.Len(chunks, 1) {
+		choice := chunks[0].Choices[0]
+		asserts.Equal("tool_calls", *choice.FinishReason)
+		if asserts.Len(choice.Delta.ToolCalls, 1) {
+			asserts.Equal(0, *choice.Delta.ToolCalls[0].Index)
+			asserts.Equal("get_weather", choice.Delta.ToolCalls[0].Function.Name)
+			asserts.Equal(`{"city":"Paris"}`, choice.Delta.ToolCalls[0].Function.Arguments)
+		}
+	}
+
+	// 普通回复照常逐段输出
+	chunks = difyStreamChunks(t, `data: {"event":"message","answer":"It is "}`+"\n"+
+		`data: {"event":"message","answer":"sunny."}`+"\n"+messageEnd, true)
+	if asserts.Len(chunks, 2) {
+		asserts.Equal("It is ", chunks[0].Choices[0].Delta.GetContentString())
+		asserts.Equal("sunny.", chunks[1].Choices[0].Delta.GetContentString())
+		asserts.Empty(chunks[1].Choices[0].Delta.ToolCalls)
+	}
+
+	// 以 { 开头但不是函数调用的回复在结束时一次输出
+	chunks = difyStreamChunks(t, `data: {"event":"message","answer":"{\"city\":"}`+"\n"+
+		`data: {"event":"message","answer":"\"Paris\"}"}`+"\n"+messageEnd, true)
+	if asserts.Len(chunks, 1) {
+		asserts.Equal(`{"city":"Paris"}`, chunks[0].Choices[0].Delta.GetContentString())
+		asserts.Nil(chunks[0].Choices[0].FinishReason)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"one-api/dto"
 	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
+	"one-api/relay/constant"
 )
 
 type Adaptor struct {
@@ -20,8 +21,10 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if !IsImagenModel(info.UpstreamModelName) {
+		return nil, errors.New("not implemented")
+	}
+	return ConvertOpenAIImage2Imagen(request)
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
@@ -37,7 +40,10 @@ var modelVersionMap = map[string]string{
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	// 从映射中获取模型名称对应的版本，如果找不到就使用 info.ApiVersion 或默认的版本 "v1"
 	version, beta := modelVersionMap[info.UpstreamModelName]
-	if !beta {
+	if info.RelayMode == constant.RelayModeGemini {
+		// 原生请求保持客户端使用的 v1beta 接口
+		version = "v1beta"
+	} else if !beta {
 		if info.ApiVersion != "" {
 			version = info.ApiVersion
 		} else {
@@ -45,6 +51,10 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		}
 	}
 
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		return fmt.Sprintf("%s/v1beta/models/%s:predict", info.BaseUrl, info.UpstreamModelName), nil
+	}
+
 	action := "generateContent"
 	if info.IsStream {
 		action = "streamGenerateContent?alt=sse"
@@ -74,10 +84,22 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		err, usage = ImagenHandler(c, resp, info)
+		return
+	}
+	if info.RelayMode == constant.RelayModeGemini {
+		if info.IsStream {
+			err, usage = GeminiNativeStreamHandler(c, resp, info)
+		} else {
+			err, usage = GeminiNativeHandler(c, resp, info)
+		}
+		return
+	}
 	if info.IsStream {
-		err, usage = geminiChatStreamHandler(c, resp, info)
+		err, usage = GeminiChatStreamHandler(c, resp, info)
 	} else {
-		err, usage = geminiChatHandler(c, resp, info.PromptTokens, info.UpstreamModelName)
+		err, usage = GeminiChatHandler(c, resp, info.PromptTokens, info.UpstreamModelName)
 	}
 	return
 }
@@ -7,6 +7,7 @@ const (
 var ModelList = []string{
 	"gemini-1.0-pro-latest", "gemini-1.0-pro-001", "gemini-1.5-pro-latest", "gemini-1.5-flash-latest", "gemini-ultra",
 	"gemini-1.0-pro-vision-latest", "gemini-1.0-pro-vision-001",
+	"imagen-3.0-generate-002",
 }
 
 var ChannelName = "google gemini"
@@ -7,20 +7,37 @@ type GeminiChatRequest struct {
 	Tools            []GeminiChatTools          `json:"tools,omitempty"`
 }
 
+// GeminiNativeRequest 原生 generateContent 接口的请求，客户端 SDK 使用 camelCase 字段
+type GeminiNativeRequest struct {
+	Contents          []GeminiChatContent        `json:"contents"`
+	SafetySettings    []GeminiChatSafetySettings `json:"safetySettings,omitempty"`
+	GenerationConfig  GeminiChatGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []GeminiChatTools          `json:"tools,omitempty"`
+	SystemInstruction *GeminiChatContent         `json:"systemInstruction,omitempty"`
+}
+
 type GeminiInlineData struct {
 	MimeType string `json:"mimeType"`
 	Data     string `json:"data"`
 }
 
 type FunctionCall struct {
+	Id           string `json:"id,omitempty"`
 	FunctionName string `json:"name"`
 	Arguments    any    `json:"args"`
 }
 
+type FunctionResponse struct {
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
 type GeminiPart struct {
-	Text         string            `json:"text,omitempty"`
-	InlineData   *GeminiInlineData `json:"inlineData,omitempty"`
-	FunctionCall *FunctionCall     `json:"functionCall,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *GeminiInlineData `json:"inlineData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
 }
 
 type GeminiChatContent struct {
@@ -48,7 +65,7 @@ type GeminiChatGenerationConfig struct {
 
 type GeminiChatCandidate struct {
 	Content       GeminiChatContent        `json:"content"`
-	FinishReason  string                   `json:"finishReason"`
+	FinishReason  string                   `json:"finishReason,omitempty"`
 	Index         int64                    `json:"index"`
 	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings"`
 }
@@ -73,3 +90,29 @@ type GeminiUsageMetadata struct {
 	CandidatesTokenCount int `json:"candidatesTokenCount"`
 	TotalTokenCount      int `json:"totalTokenCount"`
 }
+
+// Imagen 模型通过 predict 接口生成图片
+
+type ImagenRequest struct {
+	Instances  []ImagenInstance `json:"instances"`
+	Parameters ImagenParameters `json:"parameters"`
+}
+
+type ImagenInstance struct {
+	Prompt string `json:"prompt"`
+}
+
+type ImagenParameters struct {
+	SampleCount int    `json:"sampleCount"`
+	AspectRatio string `json:"aspectRatio,omitempty"`
+}
+
+type ImagenResponse struct {
+	Predictions []ImagenPrediction `json:"predictions"`
+}
+
+type ImagenPrediction struct {
+	BytesBase64Encoded string `json:"bytesBase64Encoded"`
+	MimeType           string `json:"mimeType"`
+	RaiFilteredReason  string `json:"raiFilteredReason,omitempty"`
+}
@@ -0,0 +1,65 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+)
+
+var imagenAspectRatios = []string{"1:1", "3:4", "4:3", "9:16", "16:9"}
+
+func IsImagenModel(model string) bool {
+	return strings.HasPrefix(model, "imagen")
+}
+
+func ConvertOpenAIImage2Imagen(request dto.ImageRequest) (*ImagenRequest, error) {
+	// Imagen 每次最多生成 4 张图片
+	if request.N > 4 {
+		return nil, errors.New("n must be between 1 and 4")
+	}
+	return &ImagenRequest{
+		Instances: []ImagenInstance{
+			{
+				Prompt: request.Prompt,
+			},
+		},
+		Parameters: ImagenParameters{
+			SampleCount: request.N,
+			AspectRatio: channel.ImageAspectRatio(request.Size, imagenAspectRatios),
+		},
+	}, nil
+}
+
+func ImagenHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var imagenResponse ImagenResponse
+	err = json.Unmarshal(responseBody, &imagenResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	images := make([]dto.ImageData, 0, len(imagenResponse.Predictions))
+	for _, prediction := range imagenResponse.Predictions {
+		// 被安全策略过滤的图片没有内容
+		if prediction.BytesBase64Encoded == "" {
+			continue
+		}
+		images = append(images, dto.ImageData{
+			B64Json: prediction.BytesBase64Encoded,
+		})
+	}
+	return channel.WriteImageResponse(c, info, images)
+}
@@ -0,0 +1,409 @@
+package gemini
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+)
+
+func geminiPartsText(parts []GeminiPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// RequestGemini2OpenAI converts a native Gemini generateContent request into the OpenAI chat format
+func RequestGemini2OpenAI(geminiRequest GeminiNativeRequest, model string, stream bool) (*dto.GeneralOpenAIRequest, error) {
+	config := geminiRequest.GenerationConfig
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:       model,
+		Stream:      stream,
+		MaxTokens:   config.MaxOutputTokens,
+		Temperature: config.Temperature,
+		TopP:        config.TopP,
+		TopK:        int(config.TopK),
+		N:           config.CandidateCount,
+	}
+	if len(config.StopSequences) > 0 {
+		openAIRequest.Stop = config.StopSequences
+	}
+	if geminiRequest.SystemInstruction != nil {
+		message := dto.Message{Role: "system"}
+		message.SetStringContent(geminiPartsText(geminiRequest.SystemInstruction.Parts))
+		openAIRequest.Messages = append(openAIRequest.Messages, message)
+	}
+	for _, tool := range geminiRequest.Tools {
+		declarationsBytes, err := json.Marshal(tool.FunctionDeclarations)
+		if err != nil {
+			return nil, err
+		}
+		var declarations []dto.FunctionCall
+		if err := json.Unmarshal(declarationsBytes, &declarations); err != nil {
+			continue
+		}
+		for _, declaration := range declarations {
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCall{
+				Type:     "function",
+				Function: declaration,
+			})
+		}
+	}
+
+	// gemini 的调用通常没有 id，按函数名把工具结果依次对应到之前生成的调用 id
+	pendingCalls := make(map[string][]string)
+	for _, content := range geminiRequest.Contents {
+		role := content.Role
+		if role == "model" {
+			role = "assistant"
+		}
+		if role == "" {
+			role = "user"
+		}
+		var mediaMessages []dto.MediaMessage
+		var toolCalls []dto.ToolCall
+		var toolMessages []dto.Message
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				argsBytes, err := json.Marshal(part.FunctionCall.Arguments)
+				if err != nil {
+					return nil, err
+				}
+				id := part.FunctionCall.Id
+				if id == "" {
+					id = fmt.Sprintf("call_%s", common.GetUUID())
+				}
+				pendingCalls[part.FunctionCall.FunctionName] = append(pendingCalls[part.FunctionCall.FunctionName], id)
+				toolCalls = append(toolCalls, dto.ToolCall{
+					ID:   id,
+					Type: "function",
+					Function: dto.FunctionCall{
+						Name:      part.FunctionCall.FunctionName,
+						Arguments: string(argsBytes),
+					},
+				})
+			case part.FunctionResponse != nil:
+				responseBytes, err := json.Marshal(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, err
+				}
+				name := part.FunctionResponse.Name
+				id := part.FunctionResponse.Id
+				if id != "" {
+					pendingCalls[name] = removeCallId(pendingCalls[name], id)
+				} else if len(pendingCalls[name]) > 0 {
+					id = pendingCalls[name][0]
+					pendingCalls[name] = pendingCalls[name][1:]
+				} else {
+					id = fmt.Sprintf("call_%s", common.GetUUID())
+				}
+				toolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: id,
+				}
+				toolMessage.SetStringContent(string(responseBytes))
+				toolMessages = append(toolMessages, toolMessage)
+			case part.InlineData != nil:
+				mediaMessages = append(mediaMessages, dto.MediaMessage{
+					Type: dto.ContentTypeImageURL,
+					ImageUrl: dto.MessageImageUrl{
+						Url:    fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
+						Detail: "high",
+					},
+				})
+			default:
+				mediaMessages = append(mediaMessages, dto.MediaMessage{
+					Type: dto.ContentTypeText,
+					Text: part.Text,
+				})
+			}
+		}
+		openAIRequest.Messages = append(openAIRequest.Messages, toolMessages...)
+		if len(mediaMessages) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		message := dto.Message{Role: role}
+		if len(mediaMessages) == 1 && mediaMessages[0].Type == dto.ContentTypeText {
+			message.SetStringContent(mediaMessages[0].Text)
+		} else if len(mediaMessages) > 0 {
+			contentBytes, err := json.Marshal(mediaMessages)
+			if err != nil {
+				return nil, err
+			}
+			message.Content = contentBytes
+		} else {
+			message.SetStringContent("")
+		}
+		if len(toolCalls) > 0 {
+			message.ToolCalls = toolCalls
+		}
+		openAIRequest.Messages = append(openAIRequest.Messages, message)
+	}
+	return &openAIRequest, nil
+}
+
+func removeCallId(ids []string, id string) []string {
+	for i, pending := range ids {
+		if pending == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func usageOpenAI2Gemini(usage *dto.Usage) GeminiUsageMetadata {
+	if usage == nil {
+		return GeminiUsageMetadata{}
+	}
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+func toolCalls2GeminiParts(toolCalls []dto.ToolCall) []GeminiPart {
+	parts := make([]GeminiPart, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		var args any
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+			args = map[string]any{}
+		}
+		parts = append(parts, GeminiPart{
+			FunctionCall: &FunctionCall{
+				Id:           toolCall.ID,
+				FunctionName: toolCall.Function.Name,
+				Arguments:    args,
+			},
+		})
+	}
+	return parts
+}
+
+// ResponseOpenAI2Gemini converts an OpenAI chat completion into a native Gemini generateContent response
+func ResponseOpenAI2Gemini(response *dto.OpenAITextResponse, usage *dto.Usage) *GeminiChatResponse {
+	geminiResponse := GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(response.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(usage),
+	}
+	for _, choice := range response.Choices {
+		var parts []GeminiPart
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		parts = append(parts, toolCalls2GeminiParts(choice.Message.ParseToolCalls())...)
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        int64(choice.Index),
+		})
+	}
+	return &geminiResponse
+}
+
+// OpenAI2GeminiStreamConverter turns OpenAI chat completion chunks into Gemini streamGenerateContent chunks,
+// tool call arguments arrive in fragments so they are only emitted once the call is complete
+type OpenAI2GeminiStreamConverter struct {
+	toolCalls    []dto.ToolCall
+	finishReason string
+}
+
+func (s *OpenAI2GeminiStreamConverter) Convert(chunk *dto.ChatCompletionsStreamResponse) []*GeminiChatResponse {
+	var responses []*GeminiChatResponse
+	for _, choice := range chunk.Choices {
+		if text := choice.Delta.GetContentString(); text != "" {
+			responses = append(responses, &GeminiChatResponse{
+				Candidates: []GeminiChatCandidate{
+					{
+						Content: GeminiChatContent{
+							Role:  "model",
+							Parts: []GeminiPart{{Text: text}},
+						},
+						Index: int64(choice.Index),
+					},
+				},
+			})
+		}
+		for i, toolCall := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			for len(s.toolCalls) <= toolIndex {
+				s.toolCalls = append(s.toolCalls, dto.ToolCall{})
+			}
+			if toolCall.ID != "" {
+				s.toolCalls[toolIndex].ID = toolCall.ID
+			}
+			if toolCall.Function.Name != "" {
+				s.toolCalls[toolIndex].Function.Name = toolCall.Function.Name
+			}
+			s.toolCalls[toolIndex].Function.Arguments += toolCall.Function.Arguments
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.finishReason = *choice.FinishReason
+		}
+	}
+	return responses
+}
+
+func (s *OpenAI2GeminiStreamConverter) Finish(usage *dto.Usage) []*GeminiChatResponse {
+	return []*GeminiChatResponse{
+		{
+			Candidates: []GeminiChatCandidate{
+				{
+					Content: GeminiChatContent{
+						Role:  "model",
+						Parts: toolCalls2GeminiParts(s.toolCalls),
+					},
+					FinishReason: finishReasonOpenAI2Gemini(s.finishReason),
+				},
+			},
+			UsageMetadata: usageOpenAI2Gemini(usage),
+		},
+	}
+}
+
+func geminiUsage2OpenAI(usageMetadata GeminiUsageMetadata) dto.Usage {
+	return dto.Usage{
+		PromptTokens:     usageMetadata.PromptTokenCount,
+		CompletionTokens: usageMetadata.CandidatesTokenCount,
+		TotalTokens:      usageMetadata.PromptTokenCount + usageMetadata.CandidatesTokenCount,
+	}
+}
+
+// JSONStreamSeparator 不带 alt=sse 时流式响应是一个逐步输出的 JSON 数组，返回写入下一个元素前的分隔符
+func JSONStreamSeparator(started bool) string {
+	if started {
+		return ",\r\n"
+	}
+	return "["
+}
+
+// GeminiNativeStreamHandler relays a Gemini stream and collects usage metadata from it, the upstream is always
+// requested with alt=sse and is rewritten into a JSON array when the client did not ask for sse
+func GeminiNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	usage := &dto.Usage{}
+	responseText := ""
+	sse := c.Query("alt") == "sse"
+	started := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	scanner.Split(bufio.ScanLines)
+	if sse {
+		service.SetEventStreamHeaders(c)
+	} else {
+		c.Writer.Header().Set("Content-Type", "application/json")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+	}
+
+	for scanner.Scan() {
+		data := scanner.Text()
+		info.SetFirstResponseTime()
+		if sse {
+			_, err := c.Writer.WriteString(data + "\n")
+			if err != nil {
+				common.LogError(c, "send_stream_response_failed: "+err.Error())
+			}
+			if data == "" {
+				c.Writer.Flush()
+				continue
+			}
+		}
+		if !strings.HasPrefix(data, "data:") {
+			continue
+		}
+		data = strings.TrimSpace(strings.TrimPrefix(data, "data:"))
+		if !sse {
+			_, err := c.Writer.WriteString(JSONStreamSeparator(started) + data)
+			if err != nil {
+				common.LogError(c, "send_stream_response_failed: "+err.Error())
+			}
+			started = true
+			c.Writer.Flush()
+		}
+		var geminiResponse GeminiChatResponse
+		err := json.Unmarshal([]byte(data), &geminiResponse)
+		if err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		responseText += geminiResponse.GetResponseText()
+		if geminiResponse.UsageMetadata.TotalTokenCount != 0 {
+			*usage = geminiUsage2OpenAI(geminiResponse.UsageMetadata)
+		}
+	}
+	if !sse {
+		if !started {
+			_, _ = c.Writer.WriteString("[")
+		}
+		_, _ = c.Writer.WriteString("]")
+	}
+	c.Writer.Flush()
+	resp.Body.Close()
+
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+	}
+	return nil, usage
+}
+
+// GeminiNativeHandler relays a Gemini generateContent response unchanged and bills by its usage metadata
+func GeminiNativeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var geminiResponse GeminiChatResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	usage := geminiUsage2OpenAI(geminiResponse.UsageMetadata)
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	for k, v := range resp.Header {
+		c.Writer.Header().Set(k, v[0])
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return nil, &usage
+}
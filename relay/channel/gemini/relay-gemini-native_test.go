@@ -0,0 +1,161 @@
+package gemini
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeminiChatRequestFieldNames(t *testing.T) {
+	asserts := assert.New(t)
+	// 发往上游的请求保持原有的字段名
+	jsonData, err := json.Marshal(GeminiChatRequest{
+		SafetySettings:   []GeminiChatSafetySettings{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"}},
+		GenerationConfig: GeminiChatGenerationConfig{Temperature: 0.5},
+	})
+	asserts.NoError(err)
+	asserts.Contains(string(jsonData), `"safety_settings"`)
+	asserts.Contains(string(jsonData), `"generation_config"`)
+}
+
+func TestRequestGemini2OpenAI(t *testing.T) {
+	asserts := assert.New(t)
+	var geminiRequest GeminiNativeRequest
+	err := json.Unmarshal([]byte(`{
+		"systemInstruction": {"parts": [{"text": "be brief"}]},
+		"generationConfig": {"temperature": 0.2, "maxOutputTokens": 64, "stopSequences": ["END"]},
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "parameters": {"type": "object"}}]}],
+		"contents": [
+			{"role": "user", "parts": [{"text": "weather in Paris and Rome?"}]},
+			{"role": "model", "parts": [
+				{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Rome"}}}
+			]},
+			{"role": "user", "parts": [
+				{"functionResponse": {"name": "get_weather", "response": {"weather": "sunny"}}},
+				{"functionResponse": {"name": "get_weather", "response": {"weather": "rainy"}}}
+			]}
+		]
+	}`), &geminiRequest)
+	asserts.NoError(err)
+
+	openAIRequest, err := RequestGemini2OpenAI(geminiRequest, "gemini-1.5-pro", true)
+	asserts.NoError(err)
+	asserts.Equal("gemini-1.5-pro", openAIRequest.Model)
+	asserts.True(openAIRequest.Stream)
+	asserts.EqualValues(64, openAIRequest.MaxTokens)
+	asserts.Equal(0.2, openAIRequest.Temperature)
+	asserts.Len(openAIRequest.Tools, 1)
+
+	messages := openAIRequest.Messages
+	asserts.Len(messages, 5)
+	asserts.Equal("system", messages[0].Role)
+	asserts.Equal("be brief", messages[0].StringContent())
+	asserts.Equal("assistant", messages[2].Role)
+	toolCalls := messages[2].ParseToolCalls()
+	asserts.Len(toolCalls, 2)
+	// 同名的多次调用需要不同的 id，工具结果按顺序对应
+	asserts.True(strings.HasPrefix(toolCalls[0].ID, "call_"))
+	asserts.NotEqual(toolCalls[0].ID, toolCalls[1].ID)
+	asserts.Equal("tool", messages[3].Role)
+	asserts.Equal(toolCalls[0].ID, messages[3].ToolCallId)
+	asserts.Equal(toolCalls[1].ID, messages[4].ToolCallId)
+	asserts.JSONEq(`{"weather": "rainy"}`, messages[4].StringContent())
+}
+
+func TestRequestGemini2OpenAIWithCallIds(t *testing.T) {
+	asserts := assert.New(t)
+	geminiRequest := GeminiNativeRequest{
+		Contents: []GeminiChatContent{
+			{Role: "model", Parts: []GeminiPart{
+				{FunctionCall: &FunctionCall{Id: "a", FunctionName: "f", Arguments: map[string]any{}}},
+				{FunctionCall: &FunctionCall{Id: "b", FunctionName: "f", Arguments: map[string]any{}}},
+			}},
+			{Role: "user", Parts: []GeminiPart{
+				{FunctionResponse: &FunctionResponse{Id: "b", Name: "f", Response: map[string]any{}}},
+				{FunctionResponse: &FunctionResponse{Name: "f", Response: map[string]any{}}},
+			}},
+		},
+	}
+	openAIRequest, err := RequestGemini2OpenAI(geminiRequest, "gemini-1.5-pro", false)
+	asserts.NoError(err)
+	messages := openAIRequest.Messages
+	asserts.Len(messages, 3)
+	toolCalls := messages[0].ParseToolCalls()
+	asserts.Equal("a", toolCalls[0].ID)
+	asserts.Equal("b", toolCalls[1].ID)
+	asserts.Equal("b", messages[1].ToolCallId)
+	// 没有 id 的结果对应到剩下的调用
+	asserts.Equal("a", messages[2].ToolCallId)
+}
+
+func TestOpenAI2GeminiStreamConverter(t *testing.T) {
+	asserts := assert.New(t)
+	converter := OpenAI2GeminiStreamConverter{}
+	chunks := []string{
+		`{"choices": [{"index": 0, "delta": {"content": "Hi"}}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":"}}]}}]}`,
+		`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"Paris\"}"}}]}, "finish_reason": "tool_calls"}]}`,
+	}
+	var responses []*GeminiChatResponse
+	for _, chunk := range chunks {
+		var streamResponse dto.ChatCompletionsStreamResponse
+		asserts.NoError(json.Unmarshal([]byte(chunk), &streamResponse))
+		responses = append(responses, converter.Convert(&streamResponse)...)
+	}
+	responses = append(responses, converter.Finish(&dto.Usage{PromptTokens: 3, CompletionTokens: 4})...)
+
+	asserts.Len(responses, 2)
+	asserts.Equal("Hi", responses[0].Candidates[0].Content.Parts[0].Text)
+	last := responses[1]
+	functionCall := last.Candidates[0].Content.Parts[0].FunctionCall
+	asserts.Equal("call_1", functionCall.Id)
+	asserts.Equal("get_weather", functionCall.FunctionName)
+	asserts.Equal(map[string]any{"city": "Paris"}, functionCall.Arguments)
+	asserts.Equal(7, last.UsageMetadata.TotalTokenCount)
+}
+
+func runGeminiNativeStream(t *testing.T, target string) (*httptest.ResponseRecorder, *dto.Usage) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader("{}"))
+	upstream := "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hel\"}]}, \"index\": 0}]}\r\n\r\n" +
+		"data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"lo\"}]}, \"index\": 0}], \"usageMetadata\": {\"promptTokenCount\": 4, \"candidatesTokenCount\": 2, \"totalTokenCount\": 6}}\r\n\r\n"
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+	openaiErr, usage := GeminiNativeStreamHandler(c, resp, &relaycommon.RelayInfo{PromptTokens: 10})
+	assert.Nil(t, openaiErr)
+	return recorder, usage
+}
+
+func TestGeminiNativeStreamHandlerSSE(t *testing.T) {
+	asserts := assert.New(t)
+	recorder, usage := runGeminiNativeStream(t, "/v1beta/models/gemini-1.5-pro:streamGenerateContent?alt=sse")
+	asserts.Equal(4, usage.PromptTokens)
+	asserts.Equal(2, usage.CompletionTokens)
+	asserts.Equal("text/event-stream", recorder.Header().Get("Content-Type"))
+	asserts.Equal(2, strings.Count(recorder.Body.String(), "data: "))
+}
+
+func TestGeminiNativeStreamHandlerJSONArray(t *testing.T) {
+	asserts := assert.New(t)
+	recorder, usage := runGeminiNativeStream(t, "/v1beta/models/gemini-1.5-pro:streamGenerateContent")
+	asserts.Equal(6, usage.TotalTokens)
+	asserts.Equal("application/json", recorder.Header().Get("Content-Type"))
+	// 不带 alt=sse 时返回 JSON 数组
+	var responses []GeminiChatResponse
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &responses))
+	asserts.Len(responses, 2)
+	asserts.Equal("lo", responses[1].GetResponseText())
+}
@@ -0,0 +1,184 @@
+package channel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strconv"
+	"strings"
+)
+
+// ParseImageSize 解析 1024x1024 格式的图片尺寸
+func ParseImageSize(size string) (width int, height int, ok bool) {
+	parts := strings.Split(size, "x")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err = strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// ImageAspectRatio 上游只接受宽高比时，从支持的宽高比中选择与尺寸最接近的一个，如 16:9
+func ImageAspectRatio(size string, supported []string) string {
+	width, height, ok := ParseImageSize(size)
+	if !ok || len(supported) == 0 {
+		return ""
+	}
+	ratio := float64(width) / float64(height)
+	best := ""
+	bestDiff := math.MaxFloat64
+	for _, aspectRatio := range supported {
+		var w, h float64
+		if _, err := fmt.Sscanf(aspectRatio, "%g:%g", &w, &h); err != nil || h == 0 {
+			continue
+		}
+		diff := math.Abs(math.Log(ratio / (w / h)))
+		if diff < bestDiff {
+			best, bestDiff = aspectRatio, diff
+		}
+	}
+	return best
+}
+
+// MaxImageRequests 上游每次只生成一张图片时，单个请求最多重复请求的次数
+const MaxImageRequests = 10
+
+// DoImageRequests 上游每次只生成一张图片时重复请求 n 次，parse 从每次的响应中取出图片，汇总为 OpenAI 格式的响应
+// 部分请求失败时返回已生成的图片，只有全部失败时才返回错误
+func DoImageRequests(a Adaptor, c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader, n int, parse func(resp *http.Response) ([]dto.ImageData, error)) (*http.Response, error) {
+	requestData, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		n = 1
+	} else if n > MaxImageRequests {
+		n = MaxImageRequests
+	}
+	imageResponse := dto.ImageResponse{
+		Data:    make([]dto.ImageData, 0, n),
+		Created: info.StartTime.Unix(),
+	}
+	for i := 0; i < n; i++ {
+		resp, err := DoApiRequest(a, c, info, bytes.NewReader(requestData))
+		if err == nil && resp.StatusCode != http.StatusOK {
+			if len(imageResponse.Data) == 0 {
+				return resp, nil
+			}
+			resp.Body.Close()
+			err = fmt.Errorf("upstream returned status code %d", resp.StatusCode)
+		}
+		var data []dto.ImageData
+		if err == nil {
+			data, err = parse(resp)
+			resp.Body.Close()
+		}
+		if err != nil {
+			if len(imageResponse.Data) == 0 {
+				return nil, err
+			}
+			common.LogError(c, fmt.Sprintf("image request %d/%d failed, returning %d images: %s", i+1, n, len(imageResponse.Data), err.Error()))
+			break
+		}
+		imageResponse.Data = append(imageResponse.Data, data...)
+	}
+	jsonResponse, err := json.Marshal(imageResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(jsonResponse)),
+	}, nil
+}
+
+// ImageHandler 处理 OpenAI 格式的图片响应，按 response_format 转换后返回
+func ImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var imageResponse dto.ImageResponse
+	err = json.Unmarshal(responseBody, &imageResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return WriteImageResponse(c, info, imageResponse.Data)
+}
+
+// WriteImageResponse 按 response_format 返回图片：b64_json 时下载 url 中的图片，url 时把图片内容转换为 data URL
+// 返回的 usage 中 PromptTokens 为实际返回的图片数量，用于计费
+func WriteImageResponse(c *gin.Context, info *relaycommon.RelayInfo, images []dto.ImageData) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	if len(images) == 0 {
+		return service.OpenAIErrorWrapper(fmt.Errorf("no image generated"), "empty_image_response", http.StatusInternalServerError), nil
+	}
+	responseFormat := c.GetString("response_format")
+	imageResponse := dto.ImageResponse{
+		Data:    make([]dto.ImageData, 0, len(images)),
+		Created: info.StartTime.Unix(),
+	}
+	for _, image := range images {
+		if responseFormat == "b64_json" {
+			if image.B64Json == "" && image.Url != "" {
+				_, b64, err := service.GetImageFromUrl(image.Url)
+				if err != nil {
+					common.LogError(c, "get_image_data_failed: "+err.Error())
+					continue
+				}
+				image.B64Json = b64
+			}
+			image.Url = ""
+		} else if image.Url == "" && image.B64Json != "" {
+			image.Url = fmt.Sprintf("data:%s;base64,%s", detectImageMimeType(image.B64Json), image.B64Json)
+			image.B64Json = ""
+		}
+		imageResponse.Data = append(imageResponse.Data, image)
+	}
+	if len(imageResponse.Data) == 0 {
+		return service.OpenAIErrorWrapper(fmt.Errorf("no image generated"), "empty_image_response", http.StatusInternalServerError), nil
+	}
+	jsonResponse, err := json.Marshal(imageResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, _ = c.Writer.Write(jsonResponse)
+	count := len(imageResponse.Data)
+	return nil, &dto.Usage{PromptTokens: count, TotalTokens: count}
+}
+
+func detectImageMimeType(b64 string) string {
+	// 只需解码开头部分即可判断图片类型
+	head := b64
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	data, _ := base64.StdEncoding.DecodeString(head)
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "image/png"
+	}
+	return mimeType
+}
@@ -0,0 +1,156 @@
+package channel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testImageAdaptor 只实现 DoApiRequest 用到的方法
+type testImageAdaptor struct {
+	Adaptor
+	url string
+}
+
+func (a *testImageAdaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	return a.url, nil
+}
+
+func (a *testImageAdaptor) SetupRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
+	return nil
+}
+
+func parseTestImage(resp *http.Response) ([]dto.ImageData, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	return []dto.ImageData{{B64Json: base64.StdEncoding.EncodeToString(body)}}, nil
+}
+
+// newImageServer 模拟每次只生成一张图片的上游，failAfter 次之后的请求返回 failStatus
+func newImageServer(t *testing.T, failAfter int32, failStatus int) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		if failAfter >= 0 && n > failAfter {
+			w.WriteHeader(failStatus)
+			_, _ = w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, "image-%d", n)
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func doTestImageRequests(t *testing.T, server *httptest.Server, n int) (*http.Response, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{StartTime: time.Now()}
+	return DoImageRequests(&testImageAdaptor{url: server.URL}, c, info, strings.NewReader(`{}`), n, parseTestImage)
+}
+
+func readImageResponse(t *testing.T, resp *http.Response) dto.ImageResponse {
+	var imageResponse dto.ImageResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&imageResponse))
+	return imageResponse
+}
+
+func TestDoImageRequests(t *testing.T) {
+	asserts := assert.New(t)
+	server, count := newImageServer(t, -1, 0)
+
+	resp, err := doTestImageRequests(t, server, 3)
+	if asserts.NoError(err) {
+		asserts.Len(readImageResponse(t, resp).Data, 3)
+	}
+	asserts.Equal(int32(3), atomic.LoadInt32(count))
+
+	// 请求次数有上限
+	atomic.StoreInt32(count, 0)
+	resp, err = doTestImageRequests(t, server, 100)
+	if asserts.NoError(err) {
+		asserts.Len(readImageResponse(t, resp).Data, MaxImageRequests)
+	}
+	asserts.Equal(int32(MaxImageRequests), atomic.LoadInt32(count))
+}
+
+func TestDoImageRequestsPartialFailure(t *testing.T) {
+	asserts := assert.New(t)
+	// 第三次请求失败时返回已生成的两张图片
+	server, count := newImageServer(t, 2, http.StatusTooManyRequests)
+	resp, err := doTestImageRequests(t, server, 4)
+	if asserts.NoError(err) {
+		asserts.Equal(http.StatusOK, resp.StatusCode)
+		data := readImageResponse(t, resp).Data
+		if asserts.Len(data, 2) {
+			asserts.Equal(base64.StdEncoding.EncodeToString([]byte("image-2")), data[1].B64Json)
+		}
+	}
+	asserts.Equal(int32(3), atomic.LoadInt32(count))
+
+	// 第一次请求就失败时返回上游的错误响应
+	server, _ = newImageServer(t, 0, http.StatusTooManyRequests)
+	resp, err = doTestImageRequests(t, server, 4)
+	if asserts.NoError(err) {
+		asserts.Equal(http.StatusTooManyRequests, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
+
+func TestWriteImageResponse(t *testing.T) {
+	asserts := assert.New(t)
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n0000"))
+	info := &relaycommon.RelayInfo{StartTime: time.Now()}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	openaiErr, usage := WriteImageResponse(c, info, []dto.ImageData{{B64Json: png}, {B64Json: png}})
+	asserts.Nil(openaiErr)
+	// 按实际返回的图片数量计费
+	if asserts.NotNil(usage) {
+		asserts.Equal(2, usage.PromptTokens)
+	}
+	var imageResponse dto.ImageResponse
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &imageResponse))
+	if asserts.Len(imageResponse.Data, 2) {
+		asserts.Equal("data:image/png;base64,"+png, imageResponse.Data[0].Url)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	openaiErr, usage = WriteImageResponse(c, info, nil)
+	asserts.NotNil(openaiErr)
+	asserts.Nil(usage)
+}
+
+func TestParseImageSize(t *testing.T) {
+	asserts := assert.New(t)
+	width, height, ok := ParseImageSize("1536x1024")
+	asserts.True(ok)
+	asserts.Equal(1536, width)
+	asserts.Equal(1024, height)
+	_, _, ok = ParseImageSize("1024")
+	asserts.False(ok)
+	_, _, ok = ParseImageSize("0x1024")
+	asserts.False(ok)
+
+	asserts.Equal("16:9", ImageAspectRatio("1792x1024", []string{"1:1", "3:4", "4:3", "9:16", "16:9"}))
+	asserts.Equal("1:1", ImageAspectRatio("1024x1024", []string{"1:1", "16:9"}))
+	asserts.Equal("", ImageAspectRatio("auto", []string{"1:1"}))
+}
@@ -12,6 +12,7 @@ type OllamaRequest struct {
 	TopK             int                 `json:"top_k,omitempty"`
 	Stop             any                 `json:"stop,omitempty"`
 	Tools            []dto.ToolCall      `json:"tools,omitempty"`
+	ToolChoice       any                 `json:"tool_choice,omitempty"`
 	ResponseFormat   *dto.ResponseFormat `json:"response_format,omitempty"`
 	FrequencyPenalty float64             `json:"frequency_penalty,omitempty"`
 	PresencePenalty  float64             `json:"presence_penalty,omitempty"`
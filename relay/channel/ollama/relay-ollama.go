@@ -16,16 +16,23 @@ func requestOpenAI2Ollama(request dto.GeneralOpenAIRequest) *OllamaRequest {
 	messages := make([]dto.Message, 0, len(request.Messages))
 	for _, message := range request.Messages {
 		messages = append(messages, dto.Message{
-			Role:    message.Role,
-			Content: message.Content,
+			Role:       message.Role,
+			Content:    message.Content,
+			Name:       message.Name,
+			ToolCalls:  message.ToolCalls,
+			ToolCallId: message.ToolCallId,
 		})
 	}
-	str, ok := request.Stop.(string)
 	var Stop []string
-	if ok {
-		Stop = []string{str}
-	} else {
-		Stop, _ = request.Stop.([]string)
+	switch stop := request.Stop.(type) {
+	case string:
+		Stop = []string{stop}
+	case []any:
+		for _, s := range stop {
+			if str, ok := s.(string); ok {
+				Stop = append(Stop, str)
+			}
+		}
 	}
 	return &OllamaRequest{
 		Model:            request.Model,
@@ -37,6 +44,7 @@ func requestOpenAI2Ollama(request dto.GeneralOpenAIRequest) *OllamaRequest {
 		TopK:             request.TopK,
 		Stop:             Stop,
 		Tools:            request.Tools,
+		ToolChoice:       request.ToolChoice,
 		ResponseFormat:   request.ResponseFormat,
 		FrequencyPenalty: request.FrequencyPenalty,
 		PresencePenalty:  request.PresencePenalty,
@@ -0,0 +1,79 @@
+package ollama
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestOpenAI2OllamaTools(t *testing.T) {
+	asserts := assert.New(t)
+	var request dto.GeneralOpenAIRequest
+	asserts.NoError(json.Unmarshal([]byte(`{
+		"model": "llama3.1",
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"tool_choice": "auto",
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": "", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+		]
+	}`), &request))
+
+	// Ollama 的 OpenAI 兼容接口原生支持函数调用，工具与历史中的调用原样传递
+	ollamaRequest := requestOpenAI2Ollama(request)
+	jsonData, err := json.Marshal(ollamaRequest)
+	asserts.NoError(err)
+	var body map[string]any
+	asserts.NoError(json.Unmarshal(jsonData, &body))
+	asserts.Equal("auto", body["tool_choice"])
+	asserts.Len(body["tools"], 1)
+
+	messages := ollamaRequest.Messages
+	if asserts.Len(messages, 3) {
+		toolCalls := messages[1].ParseToolCalls()
+		if asserts.Len(toolCalls, 1) {
+			asserts.Equal("call_1", toolCalls[0].ID)
+			asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+		}
+		asserts.Equal("tool", messages[2].Role)
+		asserts.Equal("call_1", messages[2].ToolCallId)
+		asserts.Equal("sunny", messages[2].StringContent())
+	}
+}
+
+func TestOllamaResponseToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body: io.NopCloser(strings.NewReader(`{"id":"chatcmpl-1","object":"chat.completion","model":"llama3.1","choices":[{"index":0,
+			"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_abc","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+			"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)),
+	}
+
+	// 响应按 OpenAI 格式原样返回，tool_calls 与 finish_reason 不变
+	adaptor := &Adaptor{}
+	usage, openaiErr := adaptor.DoResponse(c, resp, &relaycommon.RelayInfo{RelayMode: relayconstant.RelayModeChatCompletions, UpstreamModelName: "llama3.1"})
+	asserts.Nil(openaiErr)
+	asserts.Equal(15, usage.TotalTokens)
+	var response dto.OpenAITextResponse
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	asserts.Equal("tool_calls", response.Choices[0].FinishReason)
+	toolCalls := response.Choices[0].Message.ParseToolCalls()
+	if asserts.Len(toolCalls, 1) {
+		asserts.Equal("call_abc", toolCalls[0].ID)
+		asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+	}
+}
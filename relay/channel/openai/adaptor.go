@@ -33,16 +33,7 @@ func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	switch info.ChannelType {
 	case common.ChannelTypeAzure:
-		// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
-		requestURL := strings.Split(info.RequestURLPath, "?")[0]
-		requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, info.ApiVersion)
-		task := strings.TrimPrefix(requestURL, "/v1/")
-		model_ := info.UpstreamModelName
-		model_ = strings.Replace(model_, ".", "", -1)
-		// https://github.com/songquanpeng/one-api/issues/67
-
-		requestURL = fmt.Sprintf("/openai/deployments/%s/%s", model_, task)
-		return relaycommon.GetFullRequestURL(info.BaseUrl, requestURL, info.ChannelType), nil
+		return getAzureRequestURL(info), nil
 	case common.ChannelTypeMiniMax:
 		return minimax.GetRequestURL(info)
 	case common.ChannelTypeCustom:
@@ -57,8 +48,7 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
 	if info.ChannelType == common.ChannelTypeAzure {
-		req.Header.Set("api-key", info.ApiKey)
-		return nil
+		return setupAzureRequestHeader(req, info)
 	}
 	if info.ChannelType == common.ChannelTypeOpenAI && "" != info.Organization {
 		req.Header.Set("OpenAI-Organization", info.Organization)
@@ -78,6 +68,12 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	if info.ChannelType != common.ChannelTypeOpenAI {
 		request.StreamOptions = nil
 	}
+	if model := getAzureRequestModel(info, request.Model); model != request.Model {
+		// 复制一份请求，避免计费与日志中的模型名被替换为部署名
+		azureRequest := *request
+		azureRequest.Model = model
+		return &azureRequest, nil
+	}
 	return request, nil
 }
 
@@ -87,6 +83,7 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
 	a.ResponseFormat = request.ResponseFormat
+	request.Model = getAzureRequestModel(info, request.Model)
 	if info.RelayMode == constant.RelayModeAudioSpeech {
 		jsonData, err := json.Marshal(request)
 		if err != nil {
@@ -122,6 +119,7 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	request.Model = getAzureRequestModel(info, request.Model)
 	return request, nil
 }
 
@@ -0,0 +1,241 @@
+package openai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"golang.org/x/sync/singleflight"
+)
+
+// Entra ID 令牌在过期前 azureTokenRefreshWindow 内后台刷新，过期前 azureTokenExpiryDelta 内视为已过期
+const (
+	azureTokenRefreshWindow = 10 * time.Minute
+	azureTokenExpiryDelta   = time.Minute
+)
+
+// azureTokenOtherInfoKey 令牌缓存在渠道 OtherInfo 的 azure_tokens 字段中，重启后与多实例之间都可复用
+const azureTokenOtherInfoKey = "azure_tokens"
+
+type azureAccessToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+func (token azureAccessToken) valid(now time.Time) bool {
+	return token.AccessToken != "" && now.Add(azureTokenExpiryDelta).Unix() < token.ExpiresAt
+}
+
+func (token azureAccessToken) fresh(now time.Time) bool {
+	return token.AccessToken != "" && now.Add(azureTokenRefreshWindow).Unix() < token.ExpiresAt
+}
+
+type azureTokenResponse struct {
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	AccessToken      string      `json:"access_token"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// azureTokenGroup 合并同一令牌的并发请求
+var azureTokenGroup singleflight.Group
+
+// azureTokenCacheKey 同一渠道的多个密钥与配置变更后的令牌分别缓存
+func azureTokenCacheKey(config *relaycommon.AzureConfig, clientSecret string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{config.AuthorityHost, config.TenantId, config.ClientId, config.Scope, clientSecret}, "|")))
+	return hex.EncodeToString(hash[:8])
+}
+
+func parseAzureTokens(otherInfo string) map[string]azureAccessToken {
+	var info struct {
+		Tokens map[string]azureAccessToken `json:"azure_tokens"`
+	}
+	if otherInfo != "" {
+		if err := json.Unmarshal([]byte(otherInfo), &info); err != nil {
+			common.SysError("failed to unmarshal azure tokens: " + err.Error())
+		}
+	}
+	if info.Tokens == nil {
+		info.Tokens = make(map[string]azureAccessToken)
+	}
+	return info.Tokens
+}
+
+// loadAzureToken 读取渠道缓存的令牌，fromCache 为 true 时读取内存缓存的渠道
+func loadAzureToken(channelId int, key string, fromCache bool) azureAccessToken {
+	var channel *model.Channel
+	var err error
+	if fromCache {
+		channel, err = model.CacheGetChannel(channelId)
+	} else {
+		channel, err = model.GetChannelById(channelId, false)
+	}
+	if err != nil {
+		return azureAccessToken{}
+	}
+	return parseAzureTokens(channel.OtherInfo)[key]
+}
+
+// getAzureEntraToken 获取 Entra ID client credentials 令牌，缓存至过期前，临近过期时后台刷新
+func getAzureEntraToken(channelId int, config *relaycommon.AzureConfig, clientSecret string) (string, error) {
+	key := azureTokenCacheKey(config, clientSecret)
+	now := time.Now()
+	token := loadAzureToken(channelId, key, true)
+	if !token.fresh(now) {
+		// 内存缓存的渠道可能尚未同步，令牌可能已被其他请求或实例刷新
+		if stored := loadAzureToken(channelId, key, false); stored.ExpiresAt > token.ExpiresAt {
+			token = stored
+		}
+	}
+	if token.valid(now) {
+		if !token.fresh(now) {
+			gopool.Go(func() {
+				if _, err := refreshAzureEntraToken(channelId, config, clientSecret, key); err != nil {
+					common.SysError("failed to refresh azure entra token: " + err.Error())
+				}
+			})
+		}
+		return token.AccessToken, nil
+	}
+	refreshed, err := refreshAzureEntraToken(channelId, config, clientSecret, key)
+	if err != nil {
+		return "", err
+	}
+	return refreshed.AccessToken, nil
+}
+
+// refreshAzureEntraToken 请求新令牌并保存到渠道 OtherInfo，同时清理已过期的令牌
+func refreshAzureEntraToken(channelId int, config *relaycommon.AzureConfig, clientSecret string, key string) (*azureAccessToken, error) {
+	val, err, _ := azureTokenGroup.Do(key, func() (interface{}, error) {
+		token, err := requestAzureEntraToken(config, clientSecret)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := model.GetChannelById(channelId, false)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to save azure entra token for channel #%d: %s", channelId, err.Error()))
+			return token, nil
+		}
+		now := time.Now()
+		tokens := parseAzureTokens(channel.OtherInfo)
+		for k, t := range tokens {
+			if !t.valid(now) {
+				delete(tokens, k)
+			}
+		}
+		tokens[key] = *token
+		if err = model.UpdateChannelOtherInfo(channelId, azureTokenOtherInfoKey, tokens); err != nil {
+			common.SysError(fmt.Sprintf("failed to save azure entra token for channel #%d: %s", channelId, err.Error()))
+		}
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*azureAccessToken), nil
+}
+
+func requestAzureEntraToken(config *relaycommon.AzureConfig, clientSecret string) (*azureAccessToken, error) {
+	if config.TenantId == "" || config.ClientId == "" || clientSecret == "" {
+		return nil, errors.New("azure entra auth requires tenant_id, client_id and client secret")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", config.ClientId)
+	form.Set("client_secret", clientSecret)
+	form.Set("scope", config.Scope)
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", config.AuthorityHost, url.PathEscape(config.TenantId))
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := service.GetImpatientHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var tokenResponse azureTokenResponse
+	err = json.NewDecoder(res.Body).Decode(&tokenResponse)
+	if err != nil {
+		return nil, fmt.Errorf("decode azure token response failed: %w", err)
+	}
+	if tokenResponse.Error != "" {
+		return nil, errors.New(tokenResponse.Error + ": " + tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, fmt.Errorf("azure token endpoint returned status code %d without access token", res.StatusCode)
+	}
+	expiresIn, err := tokenResponse.ExpiresIn.Int64()
+	if err != nil || expiresIn <= 0 {
+		expiresIn = 3600
+	}
+	return &azureAccessToken{
+		AccessToken: tokenResponse.AccessToken,
+		ExpiresAt:   time.Now().Add(time.Duration(expiresIn) * time.Second).Unix(),
+	}, nil
+}
+
+func getAzureConfig(info *relaycommon.RelayInfo) *relaycommon.AzureConfig {
+	if info.AzureConfig == nil {
+		info.AzureConfig = relaycommon.ParseAzureConfig("")
+	}
+	return info.AzureConfig
+}
+
+func getAzureRequestURL(info *relaycommon.RelayInfo) string {
+	// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
+	config := getAzureConfig(info)
+	task := strings.TrimPrefix(strings.Split(info.RequestURLPath, "?")[0], "/v1/")
+	var requestURL string
+	if config.ApiStyle == relaycommon.AzureApiStyleV1 {
+		// /openai/v1 接口不再需要部署路径，部署名通过请求体中的 model 传递
+		requestURL = "/openai/v1/" + task
+		// /openai/v1 接口不接受带日期的版本号，只传递 preview 与 latest
+		if info.ApiVersion == "preview" || info.ApiVersion == "latest" {
+			requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, info.ApiVersion)
+		}
+	} else {
+		requestURL = fmt.Sprintf("/openai/deployments/%s/%s?api-version=%s", config.GetDeployment(info.UpstreamModelName), task, info.ApiVersion)
+	}
+	return relaycommon.GetFullRequestURL(info.BaseUrl, requestURL, info.ChannelType)
+}
+
+// getAzureRequestModel /openai/v1 接口的请求体 model 需为部署名
+func getAzureRequestModel(info *relaycommon.RelayInfo, model string) string {
+	if info.ChannelType != common.ChannelTypeAzure {
+		return model
+	}
+	config := getAzureConfig(info)
+	if config.ApiStyle != relaycommon.AzureApiStyleV1 {
+		return model
+	}
+	return config.GetDeployment(model)
+}
+
+func setupAzureRequestHeader(req *http.Request, info *relaycommon.RelayInfo) error {
+	config := getAzureConfig(info)
+	if config.AuthType == relaycommon.AzureAuthTypeEntra {
+		token, err := getAzureEntraToken(info.ChannelId, config, info.ApiKey)
+		if err != nil {
+			return fmt.Errorf("get azure entra token failed: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	req.Header.Set("api-key", info.ApiKey)
+	return nil
+}
@@ -0,0 +1,148 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	relaycommon "one-api/relay/common"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAzureTestDB(t *testing.T, channel *model.Channel) {
+	db := testutil.SetupDB(t, &model.Channel{})
+	model.DB = db
+	assert.NoError(t, db.Create(channel).Error)
+}
+
+// newAzureTokenServer 模拟 Entra ID 令牌接口，每次返回不同的令牌
+func newAzureTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "client", r.PostForm.Get("client_id"))
+		if r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+			return
+		}
+		n := atomic.AddInt32(&count, 1)
+		_, _ = fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":%d,"access_token":"token-%d"}`, expiresIn, n)
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func newAzureEntraInfo(authorityHost string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		ChannelId:   1,
+		ChannelType: common.ChannelTypeAzure,
+		ApiKey:      "secret",
+		AzureConfig: relaycommon.ParseAzureConfig(`{"azure":{"auth_type":"entra","tenant_id":"tenant","client_id":"client","authority_host":"` + authorityHost + `"}}`),
+	}
+}
+
+func TestAzureEntraTokenCachedInOtherInfo(t *testing.T) {
+	asserts := assert.New(t)
+	server, count := newAzureTokenServer(t, 3600)
+	setupAzureTestDB(t, &model.Channel{Id: 1, Type: common.ChannelTypeAzure, OtherInfo: `{"status_reason":"test"}`})
+	info := newAzureEntraInfo(server.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	asserts.NoError(setupAzureRequestHeader(req, info))
+	asserts.Equal("Bearer token-1", req.Header.Get("Authorization"))
+	asserts.Empty(req.Header.Get("api-key"))
+
+	// 令牌保存在渠道 OtherInfo 中，不影响已有字段
+	channel, err := model.GetChannelById(1, false)
+	if asserts.NoError(err) {
+		otherInfo := channel.GetOtherInfo()
+		asserts.Equal("test", otherInfo["status_reason"])
+		token := parseAzureTokens(channel.OtherInfo)[azureTokenCacheKey(info.AzureConfig, "secret")]
+		asserts.Equal("token-1", token.AccessToken)
+		asserts.Greater(token.ExpiresAt, time.Now().Add(50*time.Minute).Unix())
+	}
+
+	// 后续请求直接复用缓存的令牌
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	asserts.NoError(setupAzureRequestHeader(req, info))
+	asserts.Equal("Bearer token-1", req.Header.Get("Authorization"))
+	asserts.Equal(int32(1), atomic.LoadInt32(count))
+}
+
+func TestAzureEntraTokenRefresh(t *testing.T) {
+	asserts := assert.New(t)
+	server, count := newAzureTokenServer(t, 3600)
+	setupAzureTestDB(t, &model.Channel{Id: 1, Type: common.ChannelTypeAzure})
+	info := newAzureEntraInfo(server.URL)
+	key := azureTokenCacheKey(info.AzureConfig, "secret")
+
+	// 已过期的令牌同步刷新
+	expired := map[string]azureAccessToken{key: {AccessToken: "expired", ExpiresAt: time.Now().Add(30 * time.Second).Unix()}}
+	asserts.NoError(model.UpdateChannelOtherInfo(1, azureTokenOtherInfoKey, expired))
+	token, err := getAzureEntraToken(1, info.AzureConfig, "secret")
+	asserts.NoError(err)
+	asserts.Equal("token-1", token)
+
+	// 临近过期的令牌继续使用，同时在后台刷新
+	expiring := map[string]azureAccessToken{key: {AccessToken: "expiring", ExpiresAt: time.Now().Add(5 * time.Minute).Unix()}}
+	asserts.NoError(model.UpdateChannelOtherInfo(1, azureTokenOtherInfoKey, expiring))
+	token, err = getAzureEntraToken(1, info.AzureConfig, "secret")
+	asserts.NoError(err)
+	asserts.Equal("expiring", token)
+	asserts.Eventually(func() bool {
+		return loadAzureToken(1, key, false).AccessToken == "token-2"
+	}, time.Second, 10*time.Millisecond)
+	asserts.Equal(int32(2), atomic.LoadInt32(count))
+}
+
+func TestAzureEntraTokenError(t *testing.T) {
+	server, _ := newAzureTokenServer(t, 3600)
+	setupAzureTestDB(t, &model.Channel{Id: 1, Type: common.ChannelTypeAzure})
+	info := newAzureEntraInfo(server.URL)
+	info.ApiKey = "wrong"
+
+	err := setupAzureRequestHeader(httptest.NewRequest(http.MethodPost, "/", nil), info)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid_client")
+	}
+	assert.Empty(t, loadAzureToken(1, azureTokenCacheKey(info.AzureConfig, "wrong"), false).AccessToken)
+}
+
+func TestAzureApiKeyHeader(t *testing.T) {
+	info := &relaycommon.RelayInfo{ChannelType: common.ChannelTypeAzure, ApiKey: "key", AzureConfig: relaycommon.ParseAzureConfig("")}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NoError(t, setupAzureRequestHeader(req, info))
+	assert.Equal(t, "key", req.Header.Get("api-key"))
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestGetAzureRequestURL(t *testing.T) {
+	asserts := assert.New(t)
+	info := &relaycommon.RelayInfo{
+		ChannelType:       common.ChannelTypeAzure,
+		BaseUrl:           "https://example.openai.azure.com",
+		RequestURLPath:    "/v1/chat/completions",
+		UpstreamModelName: "gpt-3.5-turbo",
+		ApiVersion:        "2024-02-01",
+		AzureConfig:       relaycommon.ParseAzureConfig(""),
+	}
+	asserts.Equal("https://example.openai.azure.com/openai/deployments/gpt-35-turbo/chat/completions?api-version=2024-02-01", getAzureRequestURL(info))
+
+	// /openai/v1 不传递带日期的版本号
+	info.AzureConfig = relaycommon.ParseAzureConfig(`{"azure":{"api_style":"v1","deployments":{"gpt-3.5-turbo":"chat"}}}`)
+	asserts.Equal("https://example.openai.azure.com/openai/v1/chat/completions", getAzureRequestURL(info))
+	asserts.Equal("chat", getAzureRequestModel(info, "gpt-3.5-turbo"))
+	info.ApiVersion = "preview"
+	asserts.Equal("https://example.openai.azure.com/openai/v1/chat/completions?api-version=preview", getAzureRequestURL(info))
+	info.ApiVersion = "latest"
+	asserts.Equal("https://example.openai.azure.com/openai/v1/chat/completions?api-version=latest", getAzureRequestURL(info))
+}
@@ -0,0 +1,13 @@
+// Package testutil 提供渠道适配器测试共用的辅助函数，只能在测试中使用
+package testutil
+
+import "net/http/httptest"
+
+// CloseNotifyRecorder c.Stream 需要 ResponseWriter 实现 http.CloseNotifier，httptest.ResponseRecorder 没有实现
+type CloseNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r CloseNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
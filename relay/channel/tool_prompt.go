@@ -0,0 +1,175 @@
+package channel
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"strings"
+)
+
+// 上游不支持函数调用时，把工具定义写进提示词，约定模型以 JSON 回复函数调用，再转换为 OpenAI 的 tool_calls
+
+type promptToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type promptToolCalls struct {
+	ToolCalls []promptToolCall `json:"tool_calls"`
+}
+
+// ToolPrompt 生成工具说明，没有工具或 tool_choice 为 none 时返回空字符串
+func ToolPrompt(tools []dto.ToolCall, toolChoice any) string {
+	if len(tools) == 0 || toolChoice == "none" {
+		return ""
+	}
+	functions := make([]dto.FunctionCall, 0, len(tools))
+	for _, tool := range tools {
+		functions = append(functions, dto.FunctionCall{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	functionsJson, _ := json.Marshal(functions)
+	prompt := "You can call the following functions:\n" + string(functionsJson) + "\n\n" +
+		"To call functions, reply with only a JSON object in this exact format and nothing else:\n" +
+		`{"tool_calls":[{"name":"<function name>","arguments":{<arguments object>}}]}` + "\n"
+	switch choice := toolChoice.(type) {
+	case string:
+		if choice == "required" {
+			return prompt + "You must call at least one function."
+		}
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				return prompt + fmt.Sprintf("You must call the function %q.", name)
+			}
+		}
+	}
+	return prompt + "If no function is needed, answer the user directly."
+}
+
+// ToolCallsText 把历史消息中 assistant 的 tool_calls 还原为约定的 JSON 格式
+func ToolCallsText(toolCalls []dto.ToolCall) string {
+	calls := promptToolCalls{
+		ToolCalls: make([]promptToolCall, 0, len(toolCalls)),
+	}
+	for _, toolCall := range toolCalls {
+		arguments := json.RawMessage(toolCall.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments = json.RawMessage("{}")
+		}
+		calls.ToolCalls = append(calls.ToolCalls, promptToolCall{
+			Name:      toolCall.Function.Name,
+			Arguments: arguments,
+		})
+	}
+	text, _ := json.Marshal(calls)
+	return string(text)
+}
+
+// ToolResultText 把 tool 消息转换为文本，函数名从之前 assistant 消息的 tool_calls 中查找
+func ToolResultText(messages []dto.Message, message dto.Message) string {
+	name := message.ToolCallId
+	for _, m := range messages {
+		for _, toolCall := range m.ParseToolCalls() {
+			if toolCall.ID == message.ToolCallId {
+				name = toolCall.Function.Name
+			}
+		}
+	}
+	return fmt.Sprintf("Function %s returned:\n%s", name, message.StringContent())
+}
+
+// ParseToolCallsText 解析模型按约定格式回复的函数调用，不是函数调用时返回 nil
+func ParseToolCallsText(text string) []dto.ToolCall {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSpace(strings.TrimSuffix(text, "```"))
+	}
+	if !strings.HasPrefix(text, "{") {
+		return nil
+	}
+	var calls promptToolCalls
+	if err := json.Unmarshal([]byte(text), &calls); err != nil {
+		return nil
+	}
+	toolCalls := make([]dto.ToolCall, 0, len(calls.ToolCalls))
+	for _, call := range calls.ToolCalls {
+		if call.Name == "" {
+			return nil
+		}
+		arguments := string(call.Arguments)
+		// 部分模型会把参数写成 JSON 字符串
+		var argumentsString string
+		if err := json.Unmarshal(call.Arguments, &argumentsString); err == nil {
+			arguments = argumentsString
+		}
+		if arguments == "" {
+			arguments = "{}"
+		}
+		toolCalls = append(toolCalls, dto.ToolCall{
+			ID:   fmt.Sprintf("call_%s", common.GetUUID()),
+			Type: "function",
+			Function: dto.FunctionCall{
+				Name:      call.Name,
+				Arguments: arguments,
+			},
+		})
+	}
+	if len(toolCalls) == 0 {
+		return nil
+	}
+	return toolCalls
+}
+
+// ToolCallStreamBuffer 流式输出时，回复以 { 或 ``` 开头则可能是函数调用，缓存到结束再判断，否则直接输出
+type ToolCallStreamBuffer struct {
+	decided   bool
+	buffering bool
+	text      string
+}
+
+// Write 写入增量文本，返回可以立即输出的文本
+func (b *ToolCallStreamBuffer) Write(delta string) string {
+	if b.decided && !b.buffering {
+		return delta
+	}
+	b.text += delta
+	if b.buffering {
+		return ""
+	}
+	trimmed := strings.TrimLeft(b.text, " \t\r\n")
+	if trimmed == "" {
+		return ""
+	}
+	b.decided = true
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "`") {
+		b.buffering = true
+		return ""
+	}
+	text := b.text
+	b.text = ""
+	return text
+}
+
+// Finish 结束时返回仍未输出的文本，或解析出的函数调用
+func (b *ToolCallStreamBuffer) Finish() (string, []dto.ToolCall) {
+	text := b.text
+	b.text = ""
+	if b.buffering {
+		if toolCalls := ParseToolCallsText(text); toolCalls != nil {
+			// 流式响应中的 tool_calls 需要带上序号
+			for i := range toolCalls {
+				index := i
+				toolCalls[i].Index = &index
+			}
+			return "", toolCalls
+		}
+	}
+	return text, nil
+}
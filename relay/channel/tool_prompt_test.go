@@ -0,0 +1,149 @@
+package channel
+
+import (
+	"encoding/json"
+	"one-api/dto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTools() []dto.ToolCall {
+	return []dto.ToolCall{{
+		Type: "function",
+		Function: dto.FunctionCall{
+			Name:        "get_weather",
+			Description: "Get the weather",
+			Parameters:  map[string]any{"type": "object"},
+		},
+	}}
+}
+
+func TestToolPrompt(t *testing.T) {
+	asserts := assert.New(t)
+	asserts.Equal("", ToolPrompt(nil, nil))
+	asserts.Equal("", ToolPrompt(testTools(), "none"))
+
+	prompt := ToolPrompt(testTools(), nil)
+	asserts.Contains(prompt, `"name":"get_weather"`)
+	asserts.Contains(prompt, `"description":"Get the weather"`)
+	asserts.Contains(prompt, "If no function is needed")
+	asserts.Contains(ToolPrompt(testTools(), "required"), "You must call at least one function.")
+	forced := ToolPrompt(testTools(), map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}})
+	asserts.Contains(forced, `You must call the function "get_weather".`)
+}
+
+func TestToolCallsText(t *testing.T) {
+	text := ToolCallsText([]dto.ToolCall{
+		{ID: "call_1", Function: dto.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		{ID: "call_2", Function: dto.FunctionCall{Name: "get_time", Arguments: "not json"}},
+	})
+	// 还原的文本可以被重新解析为相同的函数调用
+	assert.Equal(t, `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}},{"name":"get_time","arguments":{}}]}`, text)
+	toolCalls := ParseToolCallsText(text)
+	assert.Len(t, toolCalls, 2)
+	assert.Equal(t, `{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+}
+
+func TestToolResultText(t *testing.T) {
+	messages := []dto.Message{
+		{Role: "assistant", ToolCalls: []dto.ToolCall{{ID: "call_1", Type: "function", Function: dto.FunctionCall{Name: "get_weather"}}}},
+	}
+	content, _ := json.Marshal("sunny")
+	assert.Equal(t, "Function get_weather returned:\nsunny", ToolResultText(messages, dto.Message{Role: "tool", ToolCallId: "call_1", Content: content}))
+	// 找不到对应的调用时使用 tool_call_id
+	assert.Equal(t, "Function call_2 returned:\nsunny", ToolResultText(messages, dto.Message{Role: "tool", ToolCallId: "call_2", Content: content}))
+}
+
+func TestParseToolCallsText(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		text      string
+		calls     []string
+		arguments []string
+	}{
+		{"plain", `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`, []string{"get_weather"}, []string{`{"city":"Paris"}`}},
+		{"surrounding whitespace", " \n" + `{"tool_calls":[{"name":"get_weather","arguments":{}}]}` + "\n", []string{"get_weather"}, []string{`{}`}},
+		{"fenced json", "```json\n" + `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Rome"}}]}` + "\n```", []string{"get_weather"}, []string{`{"city":"Rome"}`}},
+		{"fenced", "```\n" + `{"tool_calls":[{"name":"get_weather","arguments":{}}]}` + "\n```", []string{"get_weather"}, []string{`{}`}},
+		{"string arguments", `{"tool_calls":[{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}]}`, []string{"get_weather"}, []string{`{"city":"Paris"}`}},
+		{"missing arguments", `{"tool_calls":[{"name":"get_weather"}]}`, []string{"get_weather"}, []string{`{}`}},
+		{"multiple calls", `{"tool_calls":[{"name":"a","arguments":{}},{"name":"b","arguments":{"x":1}}]}`, []string{"a", "b"}, []string{`{}`, `{"x":1}`}},
+		{"empty name", `{"tool_calls":[{"name":"","arguments":{}}]}`, nil, nil},
+		{"one empty name", `{"tool_calls":[{"name":"a","arguments":{}},{"arguments":{}}]}`, nil, nil},
+		{"empty tool calls", `{"tool_calls":[]}`, nil, nil},
+		{"json answer", `{"city":"Paris","weather":"sunny"}`, nil, nil},
+		{"invalid json", `{"tool_calls":[{"name":"get_weather"`, nil, nil},
+		{"trailing text", `{"tool_calls":[{"name":"get_weather","arguments":{}}]} done`, nil, nil},
+		{"text answer", "The weather in Paris is sunny.", nil, nil},
+		{"code answer", "```go\nfmt.Println(1)\n```", nil, nil},
+	} {
+		toolCalls := ParseToolCallsText(tc.text)
+		if tc.calls == nil {
+			assert.Nil(t, toolCalls, tc.name)
+			continue
+		}
+		if assert.Len(t, toolCalls, len(tc.calls), tc.name) {
+			for i, toolCall := range toolCalls {
+				assert.Equal(t, tc.calls[i], toolCall.Function.Name, tc.name)
+				assert.Equal(t, tc.arguments[i], toolCall.Function.Arguments, tc.name)
+				assert.Equal(t, "function", toolCall.Type, tc.name)
+				assert.NotEmpty(t, toolCall.ID, tc.name)
+				assert.Nil(t, toolCall.Index, tc.name)
+			}
+		}
+	}
+	// 每次解析生成不同的 id
+	text := `{"tool_calls":[{"name":"a","arguments":{}},{"name":"a","arguments":{}}]}`
+	toolCalls := ParseToolCallsText(text)
+	assert.NotEqual(t, toolCalls[0].ID, toolCalls[1].ID)
+}
+
+func TestToolCallStreamBuffer(t *testing.T) {
+	asserts := assert.New(t)
+
+	// 普通文本立即输出，前导空白与首个非空白增量一起输出
+	buffer := &ToolCallStreamBuffer{}
+	asserts.Equal("", buffer.Write("\n "))
+	asserts.Equal("\n Hello", buffer.Write("Hello"))
+	asserts.Equal(", world", buffer.Write(", world"))
+	text, toolCalls := buffer.Finish()
+	asserts.Equal("", text)
+	asserts.Nil(toolCalls)
+
+	// 以 { 开头的函数调用缓存到结束，返回带序号的 tool_calls
+	buffer = &ToolCallStreamBuffer{}
+	for _, delta := range []string{`{"tool_calls":[{"name":"a",`, `"arguments":{}},`, `{"name":"b","arguments":{"x":1}}]}`} {
+		asserts.Equal("", buffer.Write(delta))
+	}
+	text, toolCalls = buffer.Finish()
+	asserts.Equal("", text)
+	if asserts.Len(toolCalls, 2) {
+		asserts.Equal("a", toolCalls[0].Function.Name)
+		asserts.Equal(0, *toolCalls[0].Index)
+		asserts.Equal("b", toolCalls[1].Function.Name)
+		asserts.Equal(1, *toolCalls[1].Index)
+	}
+
+	// 以 ``` 开头的函数调用同样会被识别
+	buffer = &ToolCallStreamBuffer{}
+	asserts.Equal("", buffer.Write("```json\n"))
+	asserts.Equal("", buffer.Write(`{"tool_calls":[{"name":"a","arguments":{}}]}`+"\n```"))
+	_, toolCalls = buffer.Finish()
+	asserts.Len(toolCalls, 1)
+
+	// 以 { 开头但不是函数调用的回复在结束时原样输出
+	buffer = &ToolCallStreamBuffer{}
+	asserts.Equal("", buffer.Write(`{"city":`))
+	asserts.Equal("", buffer.Write(`"Paris"}`))
+	text, toolCalls = buffer.Finish()
+	asserts.Equal(`{"city":"Paris"}`, text)
+	asserts.Nil(toolCalls)
+
+	// 只有空白时结束时输出
+	buffer = &ToolCallStreamBuffer{}
+	asserts.Equal("", buffer.Write("  "))
+	text, toolCalls = buffer.Finish()
+	asserts.Equal("  ", text)
+	asserts.Nil(toolCalls)
+}
@@ -0,0 +1,182 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	"one-api/relay/channel/claude"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"regexp"
+	"strings"
+)
+
+const (
+	RequestModeGemini = 1
+	RequestModeClaude = 2
+	RequestModeImagen = 3
+)
+
+var claudeModelVersionRegex = regexp.MustCompile(`-(\d{8})$`)
+
+type Adaptor struct {
+	RequestMode int
+	Account     *ServiceAccount
+	AccountErr  error
+}
+
+func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
+	//TODO implement me
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	if a.RequestMode != RequestModeImagen {
+		return nil, errors.New("not implemented")
+	}
+	return gemini.ConvertOpenAIImage2Imagen(request)
+}
+
+func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
+	if strings.HasPrefix(info.UpstreamModelName, "claude") {
+		a.RequestMode = RequestModeClaude
+	} else if gemini.IsImagenModel(info.UpstreamModelName) {
+		a.RequestMode = RequestModeImagen
+	} else {
+		a.RequestMode = RequestModeGemini
+	}
+	a.Account, a.AccountErr = parseServiceAccount(info.ApiKey)
+}
+
+// getRegion 渠道的区域配置，可以是单个区域，也可以是 {"default": "us-central1", "模型": "区域"} 格式的 json
+func getRegion(regionConfig string, model string) string {
+	regionConfig = strings.TrimSpace(regionConfig)
+	if strings.HasPrefix(regionConfig, "{") {
+		regions := make(map[string]string)
+		if err := json.Unmarshal([]byte(regionConfig), &regions); err != nil {
+			common.SysError("failed to unmarshal vertex region config: " + err.Error())
+			return defaultRegion
+		}
+		if region, ok := regions[model]; ok && region != "" {
+			return region
+		}
+		if region, ok := regions["default"]; ok && region != "" {
+			return region
+		}
+		return defaultRegion
+	}
+	if regionConfig == "" {
+		return defaultRegion
+	}
+	return regionConfig
+}
+
+// getVertexModelName Vertex AI 上 Claude 模型的版本号以 @ 分隔，如 claude-3-5-sonnet@20240620
+func getVertexModelName(model string) string {
+	if strings.HasPrefix(model, "claude") && !strings.Contains(model, "@") {
+		return claudeModelVersionRegex.ReplaceAllString(model, "@$1")
+	}
+	return model
+}
+
+func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	if a.AccountErr != nil {
+		return "", a.AccountErr
+	}
+	region := getRegion(info.ApiVersion, info.UpstreamModelName)
+	baseUrl := info.BaseUrl
+	if baseUrl == "" {
+		if region == "global" {
+			baseUrl = "https://aiplatform.googleapis.com"
+		} else {
+			baseUrl = fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+		}
+	}
+	publisher := "google"
+	action := "generateContent"
+	if info.IsStream {
+		action = "streamGenerateContent?alt=sse"
+	}
+	if a.RequestMode == RequestModeImagen {
+		action = "predict"
+	}
+	if a.RequestMode == RequestModeClaude {
+		publisher = "anthropic"
+		action = "rawPredict"
+		if info.IsStream {
+			action = "streamRawPredict"
+		}
+	}
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/%s/models/%s:%s", baseUrl, a.Account.ProjectId, region, publisher,
+		getVertexModelName(info.UpstreamModelName), action), nil
+}
+
+func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
+	channel.SetupApiRequestHeader(info, c, req)
+	if a.AccountErr != nil {
+		return a.AccountErr
+	}
+	token, err := getAccessToken(a.Account)
+	if err != nil {
+		return fmt.Errorf("get vertex access token failed: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	if a.RequestMode == RequestModeClaude {
+		claudeReq, err := claude.RequestOpenAI2ClaudeMessage(*request)
+		if err != nil {
+			return nil, err
+		}
+		return copyClaudeRequest(claudeReq), nil
+	}
+	return gemini.CovertGemini2OpenAI(*request), nil
+}
+
+func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
+	return nil, nil
+}
+
+func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	return channel.DoApiRequest(a, c, info, requestBody)
+}
+
+func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
+	if a.RequestMode == RequestModeImagen {
+		err, usage = gemini.ImagenHandler(c, resp, info)
+		return
+	}
+	if a.RequestMode == RequestModeClaude {
+		if info.IsStream {
+			err, usage = claude.ClaudeStreamHandler(c, resp, info, claude.RequestModeMessage)
+		} else {
+			err, usage = claude.ClaudeHandler(claude.RequestModeMessage, c, resp, info.PromptTokens, info.UpstreamModelName)
+		}
+		return
+	}
+	if info.IsStream {
+		err, usage = gemini.GeminiChatStreamHandler(c, resp, info)
+	} else {
+		err, usage = gemini.GeminiChatHandler(c, resp, info.PromptTokens, info.UpstreamModelName)
+	}
+	return
+}
+
+func (a *Adaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *Adaptor) GetChannelName() string {
+	return ChannelName
+}
@@ -0,0 +1,14 @@
+package vertex
+
+var ModelList = []string{
+	"gemini-1.0-pro-001", "gemini-1.0-pro-vision-001", "gemini-1.5-pro-001", "gemini-1.5-flash-001",
+	"claude-3-sonnet-20240229", "claude-3-opus-20240229", "claude-3-haiku-20240307", "claude-3-5-sonnet-20240620",
+	"imagen-3.0-generate-001", "imagen-3.0-fast-generate-001",
+}
+
+var ChannelName = "vertex-ai"
+
+// anthropicVersion Vertex AI 上的 Claude 请求体需携带的版本
+const anthropicVersion = "vertex-2023-10-16"
+
+const defaultRegion = "us-central1"
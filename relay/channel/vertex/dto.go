@@ -0,0 +1,52 @@
+package vertex
+
+import "one-api/relay/channel/claude"
+
+// ServiceAccount 服务账号 json 凭证中用到的字段
+type ServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectId    string `json:"project_id"`
+	PrivateKeyId string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenUri     string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// ClaudeRequest Vertex AI 的 Claude 请求体，模型在 URL 中指定，不能包含 model 字段
+type ClaudeRequest struct {
+	AnthropicVersion string                 `json:"anthropic_version"`
+	System           any                    `json:"system,omitempty"`
+	Messages         []claude.ClaudeMessage `json:"messages,omitempty"`
+	MaxTokens        uint                   `json:"max_tokens,omitempty"`
+	StopSequences    []string               `json:"stop_sequences,omitempty"`
+	Temperature      float64                `json:"temperature,omitempty"`
+	TopP             float64                `json:"top_p,omitempty"`
+	TopK             int                    `json:"top_k,omitempty"`
+	Stream           bool                   `json:"stream,omitempty"`
+	Tools            []claude.Tool          `json:"tools,omitempty"`
+	ToolChoice       any                    `json:"tool_choice,omitempty"`
+}
+
+func copyClaudeRequest(req *claude.ClaudeRequest) *ClaudeRequest {
+	return &ClaudeRequest{
+		AnthropicVersion: anthropicVersion,
+		System:           req.System,
+		Messages:         req.Messages,
+		MaxTokens:        req.MaxTokens,
+		StopSequences:    req.StopSequences,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		TopK:             req.TopK,
+		Stream:           req.Stream,
+		Tools:            req.Tools,
+		ToolChoice:       req.ToolChoice,
+	}
+}
@@ -0,0 +1,119 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"net/url"
+	"one-api/service"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenUri    = "https://oauth2.googleapis.com/token"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	// 令牌有效期内提前刷新，避免请求过程中过期
+	tokenExpiryDelta = 5 * time.Minute
+)
+
+type accessToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+var tokenStore sync.Map
+
+// tokenGroup 合并同一服务账号的并发刷新，不同服务账号互不阻塞
+var tokenGroup singleflight.Group
+
+func parseServiceAccount(key string) (*ServiceAccount, error) {
+	account := &ServiceAccount{}
+	if err := json.Unmarshal([]byte(key), account); err != nil {
+		return nil, fmt.Errorf("invalid service account json: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("service account json requires client_email and private_key")
+	}
+	if account.TokenUri == "" {
+		account.TokenUri = defaultTokenUri
+	}
+	return account, nil
+}
+
+// signJWT 生成用于换取访问令牌的 RS256 JWT
+func signJWT(account *ServiceAccount, now time.Time) (string, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid service account private key: %w", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   account.ClientEmail,
+		"scope": cloudPlatformScope,
+		"aud":   account.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = account.PrivateKeyId
+	return token.SignedString(privateKey)
+}
+
+// getAccessToken 使用服务账号换取 OAuth2 访问令牌，令牌缓存至过期前
+func getAccessToken(account *ServiceAccount) (string, error) {
+	cacheKey := account.ClientEmail + "|" + account.PrivateKeyId
+	if val, ok := tokenStore.Load(cacheKey); ok {
+		if token := val.(accessToken); time.Now().Add(tokenExpiryDelta).Before(token.ExpiresAt) {
+			return token.Token, nil
+		}
+	}
+	val, err, _ := tokenGroup.Do(cacheKey, func() (interface{}, error) {
+		return requestAccessToken(account, cacheKey)
+	})
+	if err != nil {
+		return "", err
+	}
+	return val.(string), nil
+}
+
+// requestAccessToken 向令牌接口请求新的访问令牌并缓存
+func requestAccessToken(account *ServiceAccount, cacheKey string) (string, error) {
+	assertion, err := signJWT(account, time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequest(http.MethodPost, account.TokenUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := service.GetImpatientHttpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var response tokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("decode google token response failed: %w", err)
+	}
+	if response.Error != "" {
+		return "", errors.New(response.Error + ": " + response.ErrorDescription)
+	}
+	if response.AccessToken == "" {
+		return "", fmt.Errorf("google token endpoint returned status code %d without access token", res.StatusCode)
+	}
+	if response.ExpiresIn <= 0 {
+		response.ExpiresIn = 3600
+	}
+	tokenStore.Store(cacheKey, accessToken{
+		Token:     response.AccessToken,
+		ExpiresAt: time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	})
+	return response.AccessToken, nil
+}
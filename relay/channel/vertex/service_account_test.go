@@ -0,0 +1,170 @@
+package vertex
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServiceAccount(t *testing.T, clientEmail string, tokenUri string) (*ServiceAccount, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   clientEmail,
+		"token_uri":      tokenUri,
+	})
+	account, err := parseServiceAccount(string(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, privateKey
+}
+
+func TestParseServiceAccount(t *testing.T) {
+	asserts := assert.New(t)
+	_, err := parseServiceAccount("not json")
+	asserts.Error(err)
+	_, err = parseServiceAccount(`{"client_email":"a@b.com"}`)
+	asserts.Error(err)
+	account, err := parseServiceAccount(`{"client_email":"a@b.com","private_key":"key"}`)
+	if asserts.NoError(err) {
+		asserts.Equal(defaultTokenUri, account.TokenUri)
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	asserts := assert.New(t)
+	account, privateKey := newTestServiceAccount(t, "sign@project.iam.gserviceaccount.com", defaultTokenUri)
+	now := time.Now()
+
+	signed, err := signJWT(account, now)
+	if !asserts.NoError(err) {
+		return
+	}
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		asserts.Equal(jwt.SigningMethodRS256, token.Method)
+		return &privateKey.PublicKey, nil
+	})
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.Equal("key-id", token.Header["kid"])
+	claims := token.Claims.(jwt.MapClaims)
+	asserts.Equal(account.ClientEmail, claims["iss"])
+	asserts.Equal(defaultTokenUri, claims["aud"])
+	asserts.Equal(cloudPlatformScope, claims["scope"])
+	asserts.Equal(float64(now.Add(time.Hour).Unix()), claims["exp"])
+
+	account.PrivateKey = "invalid"
+	_, err = signJWT(account, now)
+	asserts.Error(err)
+}
+
+func TestGetAccessToken(t *testing.T) {
+	asserts := assert.New(t)
+	var count int32
+	var privateKey *rsa.PrivateKey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asserts.NoError(r.ParseForm())
+		asserts.Equal("urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+		// 令牌接口使用服务账号的公钥校验 assertion
+		_, err := jwt.Parse(r.PostForm.Get("assertion"), func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`))
+			return
+		}
+		n := atomic.AddInt32(&count, 1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600,"token_type":"Bearer"}`, n)
+	}))
+	defer server.Close()
+
+	var account *ServiceAccount
+	account, privateKey = newTestServiceAccount(t, "token@project.iam.gserviceaccount.com", server.URL)
+	token, err := getAccessToken(account)
+	asserts.NoError(err)
+	asserts.Equal("token-1", token)
+	// 令牌缓存至过期前
+	token, err = getAccessToken(account)
+	asserts.NoError(err)
+	asserts.Equal("token-1", token)
+	asserts.Equal(int32(1), atomic.LoadInt32(&count))
+
+	// 私钥与令牌接口登记的公钥不匹配
+	other, _ := newTestServiceAccount(t, "other@project.iam.gserviceaccount.com", server.URL)
+	_, err = getAccessToken(other)
+	if asserts.Error(err) {
+		asserts.Contains(err.Error(), "invalid_grant")
+	}
+}
+
+func TestGetAccessTokenPerAccount(t *testing.T) {
+	asserts := assert.New(t)
+	release := make(chan struct{})
+	var slowCount int32
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&slowCount, 1)
+		<-release
+		_, _ = w.Write([]byte(`{"access_token":"slow-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer slowServer.Close()
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token":"fast-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer fastServer.Close()
+	slowAccount, _ := newTestServiceAccount(t, "slow@project.iam.gserviceaccount.com", slowServer.URL)
+	fastAccount, _ := newTestServiceAccount(t, "fast@project.iam.gserviceaccount.com", fastServer.URL)
+
+	// 同一服务账号的并发刷新只请求一次令牌接口
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			token, _ := getAccessToken(slowAccount)
+			results <- token
+		}()
+	}
+	asserts.Eventually(func() bool {
+		return atomic.LoadInt32(&slowCount) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 其他服务账号的刷新不会被卡住的刷新阻塞
+	done := make(chan string, 1)
+	go func() {
+		token, _ := getAccessToken(fastAccount)
+		done <- token
+	}()
+	select {
+	case token := <-done:
+		asserts.Equal("fast-token", token)
+	case <-time.After(time.Second):
+		asserts.Fail("token refresh of another service account is blocked")
+	}
+
+	close(release)
+	asserts.Equal("slow-token", <-results)
+	asserts.Equal("slow-token", <-results)
+	asserts.Equal(int32(1), atomic.LoadInt32(&slowCount))
+}
@@ -12,6 +12,7 @@ import (
 )
 
 type Adaptor struct {
+	ToolsEnabled bool
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -49,6 +50,7 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	if request.TopP >= 1 {
 		request.TopP = 0.99
 	}
+	a.ToolsEnabled = channel.ToolPrompt(request.Tools, request.ToolChoice) != ""
 	return requestOpenAI2Zhipu(*request), nil
 }
 
@@ -62,9 +64,9 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
 	if info.IsStream {
-		err, usage = zhipuStreamHandler(c, resp)
+		err, usage = zhipuStreamHandler(c, resp, a.ToolsEnabled)
 	} else {
-		err, usage = zhipuHandler(c, resp)
+		err, usage = zhipuHandler(c, resp, a.ToolsEnabled)
 	}
 	return
 }
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
 	"one-api/service"
 	"strings"
@@ -73,8 +74,29 @@ func getZhipuToken(apikey string) string {
 
 func requestOpenAI2Zhipu(request dto.GeneralOpenAIRequest) *ZhipuRequest {
 	messages := make([]ZhipuMessage, 0, len(request.Messages))
+	// v3 接口不支持函数调用，工具说明以提示词的形式传入
+	if toolPrompt := channel.ToolPrompt(request.Tools, request.ToolChoice); toolPrompt != "" {
+		messages = append(messages, ZhipuMessage{
+			Role:    "system",
+			Content: toolPrompt,
+		})
+		messages = append(messages, ZhipuMessage{
+			Role:    "user",
+			Content: "Okay",
+		})
+	}
 	for _, message := range request.Messages {
-		if message.Role == "system" {
+		if message.Role == "tool" {
+			messages = append(messages, ZhipuMessage{
+				Role:    "user",
+				Content: channel.ToolResultText(request.Messages, message),
+			})
+		} else if toolCalls := message.ParseToolCalls(); message.Role == "assistant" && len(toolCalls) > 0 {
+			messages = append(messages, ZhipuMessage{
+				Role:    "assistant",
+				Content: message.StringContent() + channel.ToolCallsText(toolCalls),
+			})
+		} else if message.Role == "system" {
 			messages = append(messages, ZhipuMessage{
 				Role:    "system",
 				Content: message.StringContent(),
@@ -98,7 +120,16 @@ func requestOpenAI2Zhipu(request dto.GeneralOpenAIRequest) *ZhipuRequest {
 	}
 }
 
-func responseZhipu2OpenAI(response *ZhipuResponse) *dto.OpenAITextResponse {
+// zhipuContentText v3 接口返回的内容是 JSON 编码的字符串，解码后才是模型的原始回复，不是字符串时原样返回
+func zhipuContentText(content string) string {
+	var text string
+	if err := json.Unmarshal([]byte(content), &text); err == nil {
+		return text
+	}
+	return content
+}
+
+func responseZhipu2OpenAI(response *ZhipuResponse, toolsEnabled bool) *dto.OpenAITextResponse {
 	fullTextResponse := dto.OpenAITextResponse{
 		Id:      response.Data.TaskId,
 		Object:  "chat.completion",
@@ -107,7 +138,8 @@ func responseZhipu2OpenAI(response *ZhipuResponse) *dto.OpenAITextResponse {
 		Usage:   response.Data.Usage,
 	}
 	for i, choice := range response.Data.Choices {
-		content, _ := json.Marshal(strings.Trim(choice.Content, "\""))
+		text := zhipuContentText(choice.Content)
+		content, _ := json.Marshal(text)
 		openaiChoice := dto.OpenAITextResponseChoice{
 			Index: i,
 			Message: dto.Message{
@@ -119,6 +151,13 @@ func responseZhipu2OpenAI(response *ZhipuResponse) *dto.OpenAITextResponse {
 		if i == len(response.Data.Choices)-1 {
 			openaiChoice.FinishReason = "stop"
 		}
+		if toolsEnabled {
+			if toolCalls := channel.ParseToolCallsText(text); toolCalls != nil {
+				openaiChoice.Message.Content = nil
+				openaiChoice.Message.ToolCalls = toolCalls
+				openaiChoice.FinishReason = "tool_calls"
+			}
+		}
 		fullTextResponse.Choices = append(fullTextResponse.Choices, openaiChoice)
 	}
 	return &fullTextResponse
@@ -150,8 +189,34 @@ func streamMetaResponseZhipu2OpenAI(zhipuResponse *ZhipuStreamMetaResponse) (*dt
 	return &response, &zhipuResponse.Usage
 }
 
-func zhipuStreamHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+func zhipuStreamHandler(c *gin.Context, resp *http.Response, toolsEnabled bool) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
 	var usage *dto.Usage
+	var toolBuffer *channel.ToolCallStreamBuffer
+	if toolsEnabled {
+		toolBuffer = &channel.ToolCallStreamBuffer{}
+	}
+	// flushToolBuffer 输出缓存的文本或函数调用，返回是否为函数调用
+	flushToolBuffer := func() bool {
+		if toolBuffer == nil {
+			return false
+		}
+		text, toolCalls := toolBuffer.Finish()
+		if len(toolCalls) == 0 && text == "" {
+			return false
+		}
+		response := streamResponseZhipu2OpenAI(text)
+		if len(toolCalls) > 0 {
+			response.Choices[0].Delta.Content = nil
+			response.Choices[0].Delta.ToolCalls = toolCalls
+		}
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			common.SysError("error marshalling stream response: " + err.Error())
+			return false
+		}
+		c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonResponse)})
+		return len(toolCalls) > 0
+	}
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 	dataChan := make(chan string)
@@ -181,6 +246,12 @@ func zhipuStreamHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWi
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
+			if toolBuffer != nil {
+				data = toolBuffer.Write(data)
+				if data == "" {
+					return true
+				}
+			}
 			response := streamResponseZhipu2OpenAI(data)
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
@@ -197,6 +268,10 @@ func zhipuStreamHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWi
 				return true
 			}
 			response, zhipuUsage := streamMetaResponseZhipu2OpenAI(&zhipuResponse)
+			if flushToolBuffer() {
+				finishReason := "tool_calls"
+				response.Choices[0].FinishReason = &finishReason
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				common.SysError("error marshalling stream response: " + err.Error())
@@ -206,6 +281,7 @@ func zhipuStreamHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWi
 			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonResponse)})
 			return true
 		case <-stopChan:
+			flushToolBuffer()
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
@@ -217,7 +293,7 @@ func zhipuStreamHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWi
 	return nil, usage
 }
 
-func zhipuHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+func zhipuHandler(c *gin.Context, resp *http.Response, toolsEnabled bool) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
 	var zhipuResponse ZhipuResponse
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -242,7 +318,7 @@ func zhipuHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWithStat
 			StatusCode: resp.StatusCode,
 		}, nil
 	}
-	fullTextResponse := responseZhipu2OpenAI(&zhipuResponse)
+	fullTextResponse := responseZhipu2OpenAI(&zhipuResponse, toolsEnabled)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -0,0 +1,124 @@
+package zhipu
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	"one-api/relay/channel/testutil"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToolRequest = `{
+	"model": "chatglm_turbo",
+	"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+	"messages": [
+		{"role": "user", "content": "weather in Paris?"},
+		{"role": "assistant", "content": "", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+	]
+}`
+
+func TestRequestOpenAI2ZhipuTools(t *testing.T) {
+	asserts := assert.New(t)
+	var request dto.GeneralOpenAIRequest
+	asserts.NoError(json.Unmarshal([]byte(testToolRequest), &request))
+
+	messages := requestOpenAI2Zhipu(request).Prompt
+	if !asserts.Len(messages, 5) {
+		return
+	}
+	// 工具说明作为系统提示词放在最前面
+	asserts.Equal("system", messages[0].Role)
+	asserts.Contains(messages[0].Content, `"name":"get_weather"`)
+	asserts.Equal(ZhipuMessage{Role: "user", Content: "Okay"}, messages[1])
+	asserts.Equal(ZhipuMessage{Role: "user", Content: "weather in Paris?"}, messages[2])
+	// assistant 的 tool_calls 还原为约定的 JSON，tool 消息作为用户消息
+	asserts.Equal(ZhipuMessage{Role: "assistant", Content: `{"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`}, messages[3])
+	asserts.Equal(ZhipuMessage{Role: "user", Content: "Function get_weather returned:\nsunny"}, messages[4])
+
+	// 没有工具时不加提示词
+	request.Tools = nil
+	asserts.Equal("user", requestOpenAI2Zhipu(request).Prompt[0].Role)
+}
+
+func TestResponseZhipu2OpenAI(t *testing.T) {
+	asserts := assert.New(t)
+	var response ZhipuResponse
+	asserts.NoError(json.Unmarshal([]byte(`{"code":200,"success":true,"data":{"task_id":"t","choices":[
+		{"role":"assistant","content":"\"He said \\\"hi\\\"\\nbye\""}
+	]}}`), &response))
+
+	// 内容按 JSON 字符串解码，保留内部的引号与换行
+	fullTextResponse := responseZhipu2OpenAI(&response, true)
+	asserts.Equal("He said \"hi\"\nbye", fullTextResponse.Choices[0].Message.StringContent())
+	asserts.Equal("stop", fullTextResponse.Choices[0].FinishReason)
+	asserts.Nil(fullTextResponse.Choices[0].Message.ToolCalls)
+
+	// 编码在字符串中的函数调用转换为 tool_calls
+	response.Data.Choices[0].Content = `"{\"tool_calls\":[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}]}"`
+	fullTextResponse = responseZhipu2OpenAI(&response, true)
+	choice := fullTextResponse.Choices[0]
+	asserts.Equal("tool_calls", choice.FinishReason)
+	asserts.Nil(choice.Message.Content)
+	toolCalls := choice.Message.ParseToolCalls()
+	if asserts.Len(toolCalls, 1) {
+		asserts.Equal("get_weather", toolCalls[0].Function.Name)
+		asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+	}
+
+	// 没有传工具时不解析
+	fullTextResponse = responseZhipu2OpenAI(&response, false)
+	asserts.Equal("stop", fullTextResponse.Choices[0].FinishReason)
+
+	// 不是 JSON 字符串的内容原样返回
+	response.Data.Choices[0].Content = "plain text"
+	asserts.Equal("plain text", responseZhipu2OpenAI(&response, true).Choices[0].Message.StringContent())
+}
+
+func TestZhipuStreamHandlerToolCalls(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(testutil.CloseNotifyRecorder{ResponseRecorder: recorder})
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader("event:add\n" +
+			`data:{"tool_calls":[{"name":"get_weather",` + "\n" +
+			`data:"arguments":{"city":"Paris"}}]}` + "\n" +
+			"event:finish\n" +
+			`meta:{"request_id":"r","task_id":"t","task_status":"SUCCESS","usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}` + "\n")),
+	}
+
+	openaiErr, usage := zhipuStreamHandler(c, resp, true)
+	asserts.Nil(openaiErr)
+	asserts.Equal(3, usage.TotalTokens)
+
+	var chunks []dto.ChatCompletionsStreamResponse
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line || data == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		asserts.NoError(json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	asserts.True(strings.HasSuffix(strings.TrimSpace(recorder.Body.String()), "data: [DONE]"))
+	// 缓存的文本不会输出，只输出带序号的 tool_calls 与结束原因
+	if asserts.Len(chunks, 2) {
+		toolCalls := chunks[0].Choices[0].Delta.ToolCalls
+		asserts.Nil(chunks[0].Choices[0].Delta.Content)
+		if asserts.Len(toolCalls, 1) {
+			asserts.Equal(0, *toolCalls[0].Index)
+			asserts.Equal("get_weather", toolCalls[0].Function.Name)
+			asserts.Equal(`{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+		}
+		asserts.Equal("tool_calls", *chunks[1].Choices[0].FinishReason)
+	}
+}
@@ -10,9 +10,11 @@ import (
 	"one-api/relay/channel"
 	"one-api/relay/channel/openai"
 	relaycommon "one-api/relay/common"
+	"one-api/relay/constant"
 )
 
 type Adaptor struct {
+	ImageN int
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -21,14 +23,26 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	a.ImageN = request.N
+	imageRequest := ZhipuImageRequest{
+		Model:  request.Model,
+		Prompt: request.Prompt,
+		UserId: request.User,
+	}
+	// cogview-3 只支持 1024x1024
+	if request.Model != "cogview-3" {
+		imageRequest.Size = request.Size
+	}
+	return imageRequest, nil
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 }
 
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		return fmt.Sprintf("%s/api/paas/v4/images/generations", info.BaseUrl), nil
+	}
 	return fmt.Sprintf("%s/api/paas/v4/chat/completions", info.BaseUrl), nil
 }
 
@@ -54,10 +68,18 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		// CogView 每次请求只生成一张图片，返回格式与 OpenAI 相同
+		return channel.DoImageRequests(a, c, info, requestBody, a.ImageN, parseZhipuImageResponse)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage *dto.Usage, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayMode == constant.RelayModeImagesGenerations {
+		err, usage = channel.ImageHandler(c, resp, info)
+		return
+	}
 	if info.IsStream {
 		err, usage = openai.OaiStreamHandler(c, resp, info)
 	} else {
@@ -57,3 +57,10 @@ type tokenData struct {
 	Token      string
 	ExpiryTime time.Time
 }
+
+type ZhipuImageRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Size   string `json:"size,omitempty"`
+	UserId string `json:"user_id,omitempty"`
+}
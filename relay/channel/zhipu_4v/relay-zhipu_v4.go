@@ -279,3 +279,12 @@ func zhipuHandler(c *gin.Context, resp *http.Response) (*dto.OpenAIErrorWithStat
 
 	return nil, &textResponse.Usage
 }
+
+func parseZhipuImageResponse(resp *http.Response) ([]dto.ImageData, error) {
+	var imageResponse dto.ImageResponse
+	err := json.NewDecoder(resp.Body).Decode(&imageResponse)
+	if err != nil {
+		return nil, err
+	}
+	return imageResponse.Data, nil
+}
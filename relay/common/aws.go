@@ -0,0 +1,40 @@
+package common
+
+import (
+	"encoding/json"
+	"one-api/common"
+)
+
+const (
+	AwsInferenceProfileAuto = "auto"
+)
+
+// AwsConfig AWS Bedrock 渠道的额外配置，保存在渠道 OtherInfo 的 aws 字段中
+// 渠道密钥仍为 AccessKey|SecretKey|Region，配置 role_arn 时使用该密钥通过 STS 扮演角色
+type AwsConfig struct {
+	RoleArn         string `json:"role_arn"`
+	ExternalId      string `json:"external_id"`
+	RoleSessionName string `json:"role_session_name"`
+	StsEndpoint     string `json:"sts_endpoint"` // 默认 https://sts.{region}.amazonaws.com
+	// 跨区域推理配置文件前缀：留空不使用，auto 根据区域推断，也可以直接填写 us、eu、apac
+	InferenceProfile string `json:"inference_profile"`
+}
+
+// ParseAwsConfig 从渠道 OtherInfo 中解析 AWS 配置，未配置时使用默认值
+func ParseAwsConfig(otherInfo string) *AwsConfig {
+	config := &AwsConfig{}
+	if otherInfo != "" {
+		var info struct {
+			Aws *AwsConfig `json:"aws"`
+		}
+		if err := json.Unmarshal([]byte(otherInfo), &info); err != nil {
+			common.SysError("failed to unmarshal aws config: " + err.Error())
+		} else if info.Aws != nil {
+			config = info.Aws
+		}
+	}
+	if config.RoleArn != "" && config.RoleSessionName == "" {
+		config.RoleSessionName = "one-api"
+	}
+	return config
+}
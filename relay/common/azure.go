@@ -0,0 +1,65 @@
+package common
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"strings"
+)
+
+const (
+	AzureAuthTypeApiKey = "api_key"
+	AzureAuthTypeEntra  = "entra"
+
+	AzureApiStyleDeployments = "deployments"
+	AzureApiStyleV1          = "v1"
+)
+
+// AzureConfig Azure 渠道的额外配置，保存在渠道 OtherInfo 的 azure 字段中
+// 使用 Entra ID 认证时渠道密钥为应用的 client secret
+type AzureConfig struct {
+	AuthType      string            `json:"auth_type"`
+	TenantId      string            `json:"tenant_id"`
+	ClientId      string            `json:"client_id"`
+	AuthorityHost string            `json:"authority_host"`
+	Scope         string            `json:"scope"`
+	ApiStyle      string            `json:"api_style"`
+	Deployments   map[string]string `json:"deployments"` // 模型名 -> 部署名
+}
+
+// ParseAzureConfig 从渠道 OtherInfo 中解析 Azure 配置，未配置时使用默认值
+func ParseAzureConfig(otherInfo string) *AzureConfig {
+	config := &AzureConfig{}
+	if otherInfo != "" {
+		var info struct {
+			Azure *AzureConfig `json:"azure"`
+		}
+		if err := json.Unmarshal([]byte(otherInfo), &info); err != nil {
+			common.SysError("failed to unmarshal azure config: " + err.Error())
+		} else if info.Azure != nil {
+			config = info.Azure
+		}
+	}
+	if config.AuthType == "" {
+		config.AuthType = AzureAuthTypeApiKey
+	}
+	if config.ApiStyle == "" {
+		config.ApiStyle = AzureApiStyleDeployments
+	}
+	if config.AuthorityHost == "" {
+		config.AuthorityHost = "https://login.microsoftonline.com"
+	}
+	config.AuthorityHost = strings.TrimSuffix(config.AuthorityHost, "/")
+	if config.Scope == "" {
+		config.Scope = "https://cognitiveservices.azure.com/.default"
+	}
+	return config
+}
+
+// GetDeployment 获取模型对应的部署名，未配置映射时去掉模型名中的点号
+// https://github.com/songquanpeng/one-api/issues/67
+func (config *AzureConfig) GetDeployment(model string) string {
+	if deployment, ok := config.Deployments[model]; ok && deployment != "" {
+		return deployment
+	}
+	return strings.Replace(model, ".", "", -1)
+}
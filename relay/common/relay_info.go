@@ -30,6 +30,8 @@ type RelayInfo struct {
 	BaseUrl              string
 	SupportStreamOptions bool
 	ShouldIncludeUsage   bool
+	AzureConfig          *AzureConfig
+	AwsConfig            *AwsConfig
 }
 
 func GenRelayInfo(c *gin.Context) *RelayInfo {
@@ -67,10 +69,14 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	}
 	if info.ChannelType == common.ChannelTypeAzure {
 		info.ApiVersion = GetAPIVersion(c)
+		info.AzureConfig = ParseAzureConfig(c.GetString("channel_other_info"))
+	}
+	if info.ChannelType == common.ChannelTypeAws {
+		info.AwsConfig = ParseAwsConfig(c.GetString("channel_other_info"))
 	}
 	if info.ChannelType == common.ChannelTypeOpenAI || info.ChannelType == common.ChannelTypeAnthropic ||
 		info.ChannelType == common.ChannelTypeAws || info.ChannelType == common.ChannelTypeGemini ||
-		info.ChannelType == common.ChannelCloudflare {
+		info.ChannelType == common.ChannelCloudflare || info.ChannelType == common.ChannelTypeVertexAi {
 		info.SupportStreamOptions = true
 	}
 	return info
@@ -23,6 +23,7 @@ const (
 	APITypeDify
 	APITypeJina
 	APITypeCloudflare
+	APITypeVertexAi
 
 	APITypeDummy // this one is only for count, do not add any channel after this
 )
@@ -66,6 +67,8 @@ func ChannelType2APIType(channelType int) (int, bool) {
 		apiType = APITypeJina
 	case common.ChannelCloudflare:
 		apiType = APITypeCloudflare
+	case common.ChannelTypeVertexAi:
+		apiType = APITypeVertexAi
 	}
 	if apiType == -1 {
 		return APITypeOpenAI, false
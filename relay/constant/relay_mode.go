@@ -37,6 +37,10 @@ const (
 	RelayModeSunoSubmit
 
 	RelayModeRerank
+
+	RelayModeClaudeMessages
+
+	RelayModeGemini
 )
 
 func Path2RelayMode(path string) int {
@@ -63,6 +67,10 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeAudioTranslation
 	} else if strings.HasPrefix(path, "/v1/rerank") {
 		relayMode = RelayModeRerank
+	} else if strings.HasPrefix(path, "/v1/messages") {
+		relayMode = RelayModeClaudeMessages
+	} else if strings.HasPrefix(path, "/v1beta/models/") {
+		relayMode = RelayModeGemini
 	}
 	return relayMode
 }
@@ -74,6 +74,9 @@ func AudioHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
+	// 先从订阅中预扣，订阅不足的部分照常检查钱包与令牌额度
+	defer service.ReleaseSubscriptionReservation(c)
+	preConsumedQuota = service.PreConsumeSubscription(c, relayInfo.UserId, c.GetString("original_model"), preConsumedQuota)
 	if userQuota-preConsumedQuota < 0 {
 		return service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
@@ -118,6 +121,13 @@ func AudioHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 
+	releaseConcurrency, openaiErr := acquireChannelConcurrency(c, relayInfo)
+	if openaiErr != nil {
+		returnPreConsumedQuota(c, relayInfo.TokenId, userQuota, preConsumedQuota)
+		return openaiErr
+	}
+	defer releaseConcurrency()
+
 	resp, err := adaptor.DoRequest(c, relayInfo, ioReader)
 	if err != nil {
 		return service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
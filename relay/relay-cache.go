@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"strings"
+)
+
+// cacheResponseWriter 在写出响应的同时保存一份，用于写入响应缓存
+type cacheResponseWriter struct {
+	gin.ResponseWriter
+	buffer   bytes.Buffer
+	overflow bool
+}
+
+func (w *cacheResponseWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.buffer.Len()+len(data) > constant.ResponseCacheMaxBytes {
+		w.overflow = true
+		w.buffer.Reset()
+		return
+	}
+	w.buffer.Write(data)
+}
+
+func (w *cacheResponseWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheResponseWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// cacheable 只缓存完整的成功响应，中途断开的流不缓存
+func (w *cacheResponseWriter) cacheable(isStream bool) bool {
+	if w.overflow || w.Status() != http.StatusOK || w.buffer.Len() == 0 {
+		return false
+	}
+	if isStream {
+		return bytes.Contains(w.buffer.Bytes(), []byte("[DONE]"))
+	}
+	return true
+}
+
+// shouldUseResponseCache 对话与 embeddings 请求在开启缓存且有效期大于 0 时使用，请求头 Cache-Control: no-cache / no-store 可跳过缓存
+func shouldUseResponseCache(c *gin.Context, relayMode int) bool {
+	if !constant.ResponseCacheEnabled || constant.ResponseCacheTTL <= 0 {
+		return false
+	}
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeEmbeddings {
+		return false
+	}
+	cacheControl := strings.ToLower(c.Request.Header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-cache") && !strings.Contains(cacheControl, "no-store")
+}
+
+func replayCachedResponse(c *gin.Context, cached *service.CachedResponse) {
+	c.Set("response_cache_hit", true)
+	c.Header("X-Cache", "HIT")
+	if strings.HasPrefix(cached.ContentType, "text/event-stream") {
+		c.Header("Cache-Control", "no-cache")
+	}
+	c.Data(http.StatusOK, cached.ContentType, cached.Body)
+}
+
+// semanticCacheLookup 精确缓存未命中时按对话文本的向量查找相似请求的缓存；
+// 未命中时返回分桶与向量，响应写入缓存时一并记录
+func semanticCacheLookup(c *gin.Context, relayInfo *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest) (*service.CachedResponse, string, []float64) {
+	if !constant.SemanticCacheEnabled || relayInfo.RelayMode != relayconstant.RelayModeChatCompletions {
+		return nil, "", nil
+	}
+	text := service.SemanticCacheText(textRequest.Messages)
+	if text == "" {
+		return nil, "", nil
+	}
+	includeUsage := textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage
+	bucket := service.SemanticCacheBucket(relayInfo.UserId, c.GetString("original_model"), relayInfo.RelayMode, includeUsage, *textRequest)
+	if bucket == "" {
+		return nil, "", nil
+	}
+	embedding, err := service.GetTextEmbedding(c.Request.Context(), text)
+	if err != nil {
+		common.LogWarn(c.Request.Context(), "semantic cache embedding failed: "+err.Error())
+		return nil, "", nil
+	}
+	cached, similarity, ok := service.FindSemanticCachedResponse(bucket, embedding)
+	if ok {
+		c.Set("response_cache_similarity", similarity)
+		return cached, "", nil
+	}
+	return nil, bucket, embedding
+}
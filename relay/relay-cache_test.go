@@ -0,0 +1,32 @@
+package relay
+
+import (
+	"net/http/httptest"
+	"one-api/constant"
+	relayconstant "one-api/relay/constant"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldUseResponseCache(t *testing.T) {
+	asserts := assert.New(t)
+	enabled, ttl := constant.ResponseCacheEnabled, constant.ResponseCacheTTL
+	t.Cleanup(func() {
+		constant.ResponseCacheEnabled, constant.ResponseCacheTTL = enabled, ttl
+	})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	constant.ResponseCacheEnabled, constant.ResponseCacheTTL = true, 60
+	asserts.True(shouldUseResponseCache(c, relayconstant.RelayModeChatCompletions))
+	asserts.False(shouldUseResponseCache(c, relayconstant.RelayModeImagesGenerations))
+	// 有效期为 0 时不缓存
+	constant.ResponseCacheTTL = 0
+	asserts.False(shouldUseResponseCache(c, relayconstant.RelayModeChatCompletions))
+
+	constant.ResponseCacheTTL = 60
+	c.Request.Header.Set("Cache-Control", "no-store")
+	asserts.False(shouldUseResponseCache(c, relayconstant.RelayModeChatCompletions))
+}
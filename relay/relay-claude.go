@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel/aws"
+	"one-api/relay/channel/claude"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+)
+
+// claudeNative Anthropic Messages 接口，Anthropic 与 Bedrock 上的 Claude 模型直接透传
+type claudeNative struct {
+	model string
+}
+
+func (n claudeNative) passthrough(relayInfo *relaycommon.RelayInfo) bool {
+	return relayInfo.ApiType == relayconstant.APITypeAnthropic ||
+		(relayInfo.ApiType == relayconstant.APITypeAws && aws.IsClaudeModel(relayInfo.UpstreamModelName))
+}
+
+func (n claudeNative) requestBody(c *gin.Context, upstreamModel string, isModelMapped bool) ([]byte, error) {
+	jsonData, err := common.GetRequestBody(c)
+	if err != nil || !isModelMapped {
+		return jsonData, err
+	}
+	var payload map[string]json.RawMessage
+	err = json.Unmarshal(jsonData, &payload)
+	if err != nil {
+		return nil, err
+	}
+	payload["model"], _ = json.Marshal(upstreamModel)
+	return json.Marshal(payload)
+}
+
+func (n claudeNative) responseConverter(promptTokens int) nativeResponseConverter {
+	return &claudeResponseConverter{
+		converter: &claude.OpenAI2ClaudeStreamConverter{
+			Id:           fmt.Sprintf("msg_%s", common.GetUUID()),
+			Model:        n.model,
+			PromptTokens: promptTokens,
+		},
+	}
+}
+
+// claudeResponseConverter 把 OpenAI 格式的响应转换为 Anthropic Messages 格式
+type claudeResponseConverter struct {
+	converter *claude.OpenAI2ClaudeStreamConverter
+}
+
+func (r *claudeResponseConverter) streamContentType() string {
+	return "text/event-stream"
+}
+
+func (r *claudeResponseConverter) convertChunk(chunk *dto.ChatCompletionsStreamResponse) ([]byte, error) {
+	return claudeEvents(r.converter.Convert(chunk))
+}
+
+func (r *claudeResponseConverter) finishStream(usage *dto.Usage) ([]byte, error) {
+	return claudeEvents(r.converter.Finish(usage))
+}
+
+func (r *claudeResponseConverter) convertResponse(response *dto.OpenAITextResponse, usage *dto.Usage) any {
+	return claude.ResponseOpenAI2Claude(response, r.converter.Model, usage)
+}
+
+func claudeEvents(events []gin.H) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling object: %w", err)
+		}
+		fmt.Fprintf(&buffer, "event: %s\ndata: %s\n\n", event["type"], jsonData)
+	}
+	return buffer.Bytes(), nil
+}
+
+func getAndValidateClaudeRequest(c *gin.Context) (*claude.ClaudeRequest, error) {
+	claudeRequest := &claude.ClaudeRequest{}
+	err := common.UnmarshalBodyReusable(c, claudeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if claudeRequest.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if len(claudeRequest.Messages) == 0 {
+		return nil, errors.New("field messages is required")
+	}
+	return claudeRequest, nil
+}
+
+// ClaudeHelper serves the native Anthropic Messages API, Anthropic compatible channels get the body unchanged
+// while every other channel goes through the OpenAI conversion of its adaptor.
+func ClaudeHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	relayInfo := relaycommon.GenRelayInfo(c)
+
+	claudeRequest, err := getAndValidateClaudeRequest(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("getAndValidateClaudeRequest failed: %s", err.Error()))
+		return service.OpenAIErrorWrapperLocal(err, "invalid_claude_request", http.StatusBadRequest)
+	}
+	if fallbackModel := c.GetString("fallback_model"); fallbackModel != "" {
+		claudeRequest.Model = fallbackModel
+	}
+	textRequest, err := claude.RequestClaude2OpenAI(*claudeRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_claude_request", http.StatusBadRequest)
+	}
+	relayInfo.IsStream = claudeRequest.Stream
+	return relayTextRequest(c, relayInfo, textRequest, claudeNative{model: claudeRequest.Model}, "")
+}
@@ -0,0 +1,139 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"strings"
+)
+
+// geminiNative Gemini generateContent 接口，Gemini 渠道直接透传
+type geminiNative struct {
+	// sse 客户端通过 alt=sse 请求 sse 格式的流，否则流式响应为 JSON 数组
+	sse bool
+}
+
+func (n geminiNative) passthrough(relayInfo *relaycommon.RelayInfo) bool {
+	return relayInfo.ApiType == relayconstant.APITypeGemini
+}
+
+func (n geminiNative) requestBody(c *gin.Context, upstreamModel string, isModelMapped bool) ([]byte, error) {
+	// 模型在请求路径中，映射后的模型由适配器写入上游地址
+	return common.GetRequestBody(c)
+}
+
+func (n geminiNative) responseConverter(promptTokens int) nativeResponseConverter {
+	return &geminiResponseConverter{
+		converter: &gemini.OpenAI2GeminiStreamConverter{},
+		sse:       n.sse,
+	}
+}
+
+// geminiResponseConverter 把 OpenAI 格式的响应转换为 Gemini generateContent 格式
+type geminiResponseConverter struct {
+	converter *gemini.OpenAI2GeminiStreamConverter
+	sse       bool
+	started   bool
+}
+
+func (r *geminiResponseConverter) streamContentType() string {
+	if r.sse {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+func (r *geminiResponseConverter) convertChunk(chunk *dto.ChatCompletionsStreamResponse) ([]byte, error) {
+	return r.chunks(r.converter.Convert(chunk))
+}
+
+func (r *geminiResponseConverter) finishStream(usage *dto.Usage) ([]byte, error) {
+	output, err := r.chunks(r.converter.Finish(usage))
+	if err != nil || r.sse {
+		return output, err
+	}
+	return append(output, ']'), nil
+}
+
+func (r *geminiResponseConverter) convertResponse(response *dto.OpenAITextResponse, usage *dto.Usage) any {
+	return gemini.ResponseOpenAI2Gemini(response, usage)
+}
+
+func (r *geminiResponseConverter) chunks(chunks []*gemini.GeminiChatResponse) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, chunk := range chunks {
+		jsonData, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling object: %w", err)
+		}
+		if r.sse {
+			fmt.Fprintf(&buffer, "data: %s\n\n", jsonData)
+			continue
+		}
+		buffer.WriteString(gemini.JSONStreamSeparator(r.started))
+		buffer.Write(jsonData)
+		r.started = true
+	}
+	return buffer.Bytes(), nil
+}
+
+// parseGeminiAction splits the "{model}:{action}" path segment of a native gemini request
+func parseGeminiAction(c *gin.Context) (string, bool, error) {
+	modelName, action, found := strings.Cut(c.Param("model"), ":")
+	if !found || modelName == "" {
+		return "", false, errors.New("invalid gemini request path")
+	}
+	switch action {
+	case "generateContent":
+		return modelName, false, nil
+	case "streamGenerateContent":
+		return modelName, true, nil
+	}
+	return "", false, fmt.Errorf("unsupported gemini action: %s", action)
+}
+
+func getAndValidateGeminiRequest(c *gin.Context) (*gemini.GeminiNativeRequest, error) {
+	geminiRequest := &gemini.GeminiNativeRequest{}
+	err := common.UnmarshalBodyReusable(c, geminiRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(geminiRequest.Contents) == 0 {
+		return nil, errors.New("field contents is required")
+	}
+	return geminiRequest, nil
+}
+
+// GeminiHelper serves the native Gemini generateContent API, gemini channels get the body unchanged
+// while every other channel goes through the OpenAI conversion of its adaptor.
+func GeminiHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	relayInfo := relaycommon.GenRelayInfo(c)
+
+	modelName, isStream, err := parseGeminiAction(c)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	if fallbackModel := c.GetString("fallback_model"); fallbackModel != "" {
+		modelName = fallbackModel
+	}
+	geminiRequest, err := getAndValidateGeminiRequest(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("getAndValidateGeminiRequest failed: %s", err.Error()))
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	textRequest, err := gemini.RequestGemini2OpenAI(*geminiRequest, modelName, isStream)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	relayInfo.IsStream = isStream
+	return relayTextRequest(c, relayInfo, textRequest, geminiNative{sse: c.Query("alt") == "sse"}, "")
+}
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	"one-api/relay/channel/gemini"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const geminiTestStream = "data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"Hel\"}}]}\n\n" +
+	"data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"lo\"}, \"finish_reason\": \"stop\"}]}\n\n" +
+	"data: [DONE]\n\n"
+
+func writeGeminiTestStream(t *testing.T, sse bool) *httptest.ResponseRecorder {
+	c, recorder := newNativeTestContext()
+	writer := newNativeResponseWriter(c.Writer, geminiNative{sse: sse}.responseConverter(3))
+	c.Writer = writer
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, err := c.Writer.WriteString(geminiTestStream)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.finish(&dto.Usage{PromptTokens: 3, CompletionTokens: 2}))
+	return recorder
+}
+
+func TestGeminiResponseConverterSSE(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := writeGeminiTestStream(t, true)
+	asserts.Equal("text/event-stream", recorder.Header().Get("Content-Type"))
+	var text string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var response gemini.GeminiChatResponse
+		asserts.NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &response))
+		text += response.GetResponseText()
+	}
+	asserts.Equal("Hello", text)
+}
+
+func TestGeminiResponseConverterJSONArray(t *testing.T) {
+	asserts := assert.New(t)
+	recorder := writeGeminiTestStream(t, false)
+	asserts.Equal("application/json", recorder.Header().Get("Content-Type"))
+	var responses []gemini.GeminiChatResponse
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &responses))
+	asserts.Len(responses, 3)
+	asserts.Equal("STOP", responses[2].Candidates[0].FinishReason)
+	asserts.Equal(5, responses[2].UsageMetadata.TotalTokenCount)
+}
+
+func TestParseGeminiAction(t *testing.T) {
+	asserts := assert.New(t)
+	c, _ := newNativeTestContext()
+	c.Params = gin.Params{{Key: "model", Value: "gemini-1.5-pro:streamGenerateContent"}}
+	model, stream, err := parseGeminiAction(c)
+	asserts.NoError(err)
+	asserts.Equal("gemini-1.5-pro", model)
+	asserts.True(stream)
+
+	c.Params = gin.Params{{Key: "model", Value: "gemini-1.5-pro:countTokens"}}
+	_, _, err = parseGeminiAction(c)
+	asserts.Error(err)
+}
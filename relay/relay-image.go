@@ -12,11 +12,42 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	"one-api/model"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"strings"
 )
 
+// convertedImageApiTypes 图片响应由本项目转换为 OpenAI 格式的渠道：支持任意尺寸，未列出的尺寸按像素数与 1024x1024 的比例计费，
+// 并按实际返回的图片数量计费；其他渠道保持原有的尺寸倍率，按请求的数量计费
+var convertedImageApiTypes = map[int]bool{
+	relayconstant.APITypeGemini:     true,
+	relayconstant.APITypeVertexAi:   true,
+	relayconstant.APITypeCloudflare: true,
+	relayconstant.APITypeZhipuV4:    true,
+	relayconstant.APITypeAws:        true,
+}
+
+func getImageSizeRatio(apiType int, size string) float64 {
+	switch size {
+	case "256x256":
+		return 0.4
+	case "512x512":
+		return 0.45
+	case "1024x1024":
+		return 1
+	case "1024x1792", "1792x1024":
+		return 2
+	}
+	if convertedImageApiTypes[apiType] {
+		if width, height, ok := channel.ParseImageSize(size); ok {
+			return float64(width*height) / (1024 * 1024)
+		}
+	}
+	return 1
+}
+
 func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.ImageRequest, error) {
 	imageRequest := &dto.ImageRequest{}
 	err := common.UnmarshalBodyReusable(c, imageRequest)
@@ -89,6 +120,7 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 	relayInfo.UpstreamModelName = imageRequest.Model
+	c.Set("response_format", imageRequest.ResponseFormat)
 
 	modelPrice, success := common.GetModelPrice(imageRequest.Model, true)
 	if !success {
@@ -101,17 +133,7 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 	groupRatio := common.GetGroupRatio(relayInfo.Group)
 	userQuota, err := model.CacheGetUserQuota(relayInfo.UserId)
 
-	sizeRatio := 1.0
-	// Size
-	if imageRequest.Size == "256x256" {
-		sizeRatio = 0.4
-	} else if imageRequest.Size == "512x512" {
-		sizeRatio = 0.45
-	} else if imageRequest.Size == "1024x1024" {
-		sizeRatio = 1
-	} else if imageRequest.Size == "1024x1792" || imageRequest.Size == "1792x1024" {
-		sizeRatio = 2
-	}
+	sizeRatio := getImageSizeRatio(relayInfo.ApiType, imageRequest.Size)
 
 	qualityRatio := 1.0
 	if imageRequest.Model == "dall-e-3" && imageRequest.Quality == "hd" {
@@ -121,10 +143,12 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 
-	imageRatio := modelPrice * sizeRatio * qualityRatio * float64(imageRequest.N)
-	quota := int(imageRatio * groupRatio * common.QuotaPerUnit)
+	imagePrice := modelPrice * sizeRatio * qualityRatio
+	quota := int(imagePrice * float64(imageRequest.N) * groupRatio * common.QuotaPerUnit)
 
-	if userQuota-quota < 0 {
+	// 先从订阅中预扣，订阅不足的部分照常检查钱包余额
+	defer service.ReleaseSubscriptionReservation(c)
+	if userQuota-service.PreConsumeSubscription(c, relayInfo.UserId, c.GetString("original_model"), quota) < 0 {
 		return service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
 
@@ -146,6 +170,13 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
 	requestBody = bytes.NewBuffer(jsonData)
+	service.CaptureUpstreamRequest(c, jsonData)
+
+	releaseConcurrency, openaiErr := acquireChannelConcurrency(c, relayInfo)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer releaseConcurrency()
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
@@ -163,17 +194,20 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 
-	_, openaiErr := adaptor.DoResponse(c, resp, relayInfo)
+	usage, openaiErr := adaptor.DoResponse(c, resp, relayInfo)
 	if openaiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
 
-	usage := &dto.Usage{
-		PromptTokens: imageRequest.N,
-		TotalTokens:  imageRequest.N,
+	if !convertedImageApiTypes[relayInfo.ApiType] || usage == nil || usage.PromptTokens <= 0 {
+		usage = &dto.Usage{
+			PromptTokens: imageRequest.N,
+			TotalTokens:  imageRequest.N,
+		}
 	}
+	imageRatio := imagePrice * float64(usage.PromptTokens)
 
 	quality := "standard"
 	if imageRequest.Quality == "hd" {
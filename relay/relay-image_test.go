@@ -0,0 +1,23 @@
+package relay
+
+import (
+	relayconstant "one-api/relay/constant"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetImageSizeRatio(t *testing.T) {
+	asserts := assert.New(t)
+	asserts.Equal(0.4, getImageSizeRatio(relayconstant.APITypeOpenAI, "256x256"))
+	asserts.Equal(2.0, getImageSizeRatio(relayconstant.APITypeOpenAI, "1792x1024"))
+	// OpenAI 渠道的其他尺寸保持原有倍率
+	asserts.Equal(1.0, getImageSizeRatio(relayconstant.APITypeOpenAI, "1536x1024"))
+	asserts.Equal(1.0, getImageSizeRatio(relayconstant.APITypeOpenAI, "auto"))
+
+	// 转换格式的渠道按像素数计费，固定尺寸不变
+	asserts.Equal(0.45, getImageSizeRatio(relayconstant.APITypeCloudflare, "512x512"))
+	asserts.Equal(0.75, getImageSizeRatio(relayconstant.APITypeCloudflare, "1024x768"))
+	asserts.Equal(1.5, getImageSizeRatio(relayconstant.APITypeGemini, "1536x1024"))
+	asserts.Equal(1.0, getImageSizeRatio(relayconstant.APITypeGemini, "auto"))
+}
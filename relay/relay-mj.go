@@ -206,6 +206,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
 				model.RecordConsumeLog(ctx, userId, channelId, 0, 0, modelName, tokenName, quota, logContent, tokenId, userQuota, 0, false, other)
+				common.MetricQuotaConsumed.WithLabelValues(modelName, c.GetString("group")).Add(float64(quota))
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
@@ -508,6 +509,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
 				model.RecordConsumeLog(ctx, userId, channelId, 0, 0, modelName, tokenName, quota, logContent, tokenId, userQuota, 0, false, other)
+				common.MetricQuotaConsumed.WithLabelValues(modelName, c.GetString("group")).Add(float64(quota))
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
@@ -0,0 +1,140 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+)
+
+// nativeRelay Claude、Gemini 等原生接口在通用文本流程中的差异部分
+type nativeRelay interface {
+	// passthrough 渠道原生支持该接口时直接透传请求与响应
+	passthrough(relayInfo *relaycommon.RelayInfo) bool
+	// requestBody 透传给原生渠道的请求体，模型被映射时需要改写请求中的模型
+	requestBody(c *gin.Context, upstreamModel string, isModelMapped bool) ([]byte, error)
+	// responseConverter 其他渠道经适配器返回 OpenAI 格式的响应，由它转换为原生格式
+	responseConverter(promptTokens int) nativeResponseConverter
+}
+
+type nativeResponseConverter interface {
+	streamContentType() string
+	convertChunk(chunk *dto.ChatCompletionsStreamResponse) ([]byte, error)
+	finishStream(usage *dto.Usage) ([]byte, error)
+	convertResponse(response *dto.OpenAITextResponse, usage *dto.Usage) any
+}
+
+// nativeResponseWriter 把适配器输出的 OpenAI 格式响应改写为原生格式：流式响应逐块转换，非流式响应缓存到结束后整体转换
+type nativeResponseWriter struct {
+	gin.ResponseWriter
+	converter nativeResponseConverter
+	buffer    bytes.Buffer
+	started   bool
+	stream    bool
+}
+
+func newNativeResponseWriter(writer gin.ResponseWriter, converter nativeResponseConverter) *nativeResponseWriter {
+	return &nativeResponseWriter{
+		ResponseWriter: writer,
+		converter:      converter,
+	}
+}
+
+// begin 首次写入时根据适配器设置的 Content-Type 判断是否为流式响应
+func (w *nativeResponseWriter) begin() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.stream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	if w.stream {
+		w.Header().Set("Content-Type", w.converter.streamContentType())
+	}
+}
+
+func (w *nativeResponseWriter) WriteHeader(code int) {
+	w.begin()
+	// 转换后的响应体与上游不同
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *nativeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *nativeResponseWriter) Write(data []byte) (int, error) {
+	w.begin()
+	w.buffer.Write(data)
+	if !w.stream {
+		return len(data), nil
+	}
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// 不完整的行留到下次写入时处理
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			break
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "[DONE]" {
+			continue
+		}
+		var streamResponse dto.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(line), &streamResponse); err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		output, err := w.converter.convertChunk(&streamResponse)
+		if err != nil {
+			return 0, err
+		}
+		if err := w.writeOutput(output); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *nativeResponseWriter) writeOutput(output []byte) error {
+	if len(output) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(output)
+	if err != nil {
+		return err
+	}
+	w.ResponseWriter.Flush()
+	return nil
+}
+
+// finish 拿到用量后输出流式响应的结尾部分，或转换缓存的非流式响应
+func (w *nativeResponseWriter) finish(usage *dto.Usage) error {
+	if w.stream {
+		output, err := w.converter.finishStream(usage)
+		if err != nil {
+			return err
+		}
+		return w.writeOutput(output)
+	}
+	var textResponse dto.OpenAITextResponse
+	err := json.Unmarshal(w.buffer.Bytes(), &textResponse)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(w.converter.convertResponse(&textResponse, usage))
+	if err != nil {
+		return err
+	}
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	_, err = w.ResponseWriter.Write(jsonData)
+	return err
+}
@@ -0,0 +1,94 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newNativeTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader("{}"))
+	return c, recorder
+}
+
+func TestNativeResponseWriterStream(t *testing.T) {
+	asserts := assert.New(t)
+	c, recorder := newNativeTestContext()
+	writer := newNativeResponseWriter(c.Writer, claudeNative{model: "claude-3-5-sonnet"}.responseConverter(5))
+	c.Writer = writer
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.WriteHeader(http.StatusOK)
+	// 分块写入，行被截断时需要等到下一次写入再处理
+	_, err := c.Writer.WriteString("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"Hel")
+	asserts.NoError(err)
+	_, err = c.Writer.WriteString("lo\"}}]}\n\ndata: {\"choices\": [{\"index\": 0, \"delta\": {}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n")
+	asserts.NoError(err)
+	asserts.NoError(writer.finish(&dto.Usage{PromptTokens: 5, CompletionTokens: 2}))
+
+	asserts.Equal("text/event-stream", recorder.Header().Get("Content-Type"))
+	var types []string
+	var text string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event map[string]any
+		asserts.NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		types = append(types, event["type"].(string))
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			text += delta["text"].(string)
+		}
+	}
+	asserts.Equal([]string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, types)
+	asserts.Equal("Hello", text)
+	asserts.Contains(recorder.Body.String(), "event: message_stop\n")
+}
+
+func TestNativeResponseWriterNonStream(t *testing.T) {
+	asserts := assert.New(t)
+	c, recorder := newNativeTestContext()
+	writer := newNativeResponseWriter(c.Writer, claudeNative{model: "claude-3-5-sonnet"}.responseConverter(5))
+	c.Writer = writer
+
+	body := `{"id": "chatcmpl-1", "choices": [{"index": 0, "finish_reason": "length", "message": {"role": "assistant", "content": "Hi"}}]}`
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Content-Length", "120")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, err := c.Writer.Write([]byte(body))
+	asserts.NoError(err)
+	// 结束前不输出任何内容
+	asserts.Empty(recorder.Body.String())
+	asserts.NoError(writer.finish(&dto.Usage{PromptTokens: 5, CompletionTokens: 1}))
+
+	asserts.Empty(recorder.Header().Get("Content-Length"))
+	var response map[string]any
+	asserts.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	asserts.Equal("message", response["type"])
+	asserts.Equal("claude-3-5-sonnet", response["model"])
+	asserts.Equal("max_tokens", response["stop_reason"])
+	asserts.Equal([]any{map[string]any{"type": "text", "text": "Hi"}}, response["content"])
+}
+
+func TestClaudeNativeRequestBody(t *testing.T) {
+	asserts := assert.New(t)
+	c, _ := newNativeTestContext()
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model": "claude-3-5-sonnet", "max_tokens": 16}`))
+
+	jsonData, err := claudeNative{}.requestBody(c, "claude-3-5-sonnet", false)
+	asserts.NoError(err)
+	asserts.JSONEq(`{"model": "claude-3-5-sonnet", "max_tokens": 16}`, string(jsonData))
+
+	jsonData, err = claudeNative{}.requestBody(c, "anthropic.claude-3-5-sonnet", true)
+	asserts.NoError(err)
+	asserts.JSONEq(`{"model": "anthropic.claude-3-5-sonnet", "max_tokens": 16}`, string(jsonData))
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
 	"one-api/common"
@@ -16,6 +15,7 @@ import (
 	relaycommon "one-api/relay/common"
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +28,10 @@ func getAndValidateTextRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo)
 	if err != nil {
 		return nil, err
 	}
+	// 模型回退时使用回退模型替换请求中的模型
+	if fallbackModel := c.GetString("fallback_model"); fallbackModel != "" {
+		textRequest.Model = fallbackModel
+	}
 	if relayInfo.RelayMode == relayconstant.RelayModeModerations && textRequest.Model == "" {
 		textRequest.Model = "text-moderation-latest"
 	}
@@ -75,9 +79,20 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		return service.OpenAIErrorWrapperLocal(err, "invalid_text_request", http.StatusBadRequest)
 	}
 
+	responseCacheKey := ""
+	if shouldUseResponseCache(c, relayInfo.RelayMode) {
+		cacheIncludeUsage := textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage
+		responseCacheKey = service.ResponseCacheKey(relayInfo.UserId, textRequest.Model, relayInfo.RelayMode, cacheIncludeUsage, *textRequest)
+	}
+	return relayTextRequest(c, relayInfo, textRequest, nil, responseCacheKey)
+}
+
+// relayTextRequest 文本请求的模型映射、计费、转发与结算，OpenAI 接口与 Claude、Gemini 原生接口共用。
+// native 不为 nil 时，原生渠道直接透传请求，其他渠道经适配器转换为 OpenAI 格式后再把响应转换回原生格式
+func relayTextRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest, native nativeRelay, responseCacheKey string) *dto.OpenAIErrorWithStatusCode {
 	// map model name
+	isModelMapped := c.GetString("fallback_model") != ""
 	modelMapping := c.GetString("model_mapping")
-	//isModelMapped := false
 	if modelMapping != "" && modelMapping != "{}" {
 		modelMap := make(map[string]string)
 		err := json.Unmarshal([]byte(modelMapping), &modelMap)
@@ -87,7 +102,7 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		if modelMap[textRequest.Model] != "" {
 			textRequest.Model = modelMap[textRequest.Model]
 			// set upstream model name
-			//isModelMapped = true
+			isModelMapped = true
 		}
 	}
 	relayInfo.UpstreamModelName = textRequest.Model
@@ -100,13 +115,16 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	//err := service.SensitiveWordsCheck(textRequest)
 
 	if constant.ShouldCheckPromptSensitive() {
-		err = checkRequestSensitive(textRequest, relayInfo)
+		err := checkRequestSensitive(textRequest, relayInfo)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "sensitive_words_detected", http.StatusBadRequest)
 		}
 	}
 
+	countSpan := common.StartGinSpan(c, "relay.count_tokens", common.SpanKindInternal)
 	promptTokens, err := getPromptTokens(textRequest, relayInfo)
+	countSpan.SetAttribute("prompt_tokens", promptTokens)
+	countSpan.End()
 	// count messages token error 计算promptTokens错误
 	if err != nil {
 		return service.OpenAIErrorWrapper(err, "count_token_messages_failed", http.StatusInternalServerError)
@@ -125,10 +143,31 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	}
 
 	// pre-consume quota 预消耗配额
+	// 请求失败或没有结算时退回订阅的预留
+	defer service.ReleaseSubscriptionReservation(c)
 	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, preConsumedQuota, relayInfo)
 	if openaiErr != nil {
 		return openaiErr
 	}
+	// 按预估 token 数预留 TPM 额度，成功时在 postConsumeQuota 中修正为实际用量，失败时释放
+	service.ReserveRateLimitTokens(c, promptTokens+int(textRequest.MaxTokens))
+	defer service.RecordRateLimitUsage(c, 0)
+
+	// 命中响应缓存时直接返回，不再请求上游
+	var semanticBucket string
+	var semanticEmbedding []float64
+	if responseCacheKey != "" {
+		cached, ok := service.GetCachedResponse(responseCacheKey)
+		if !ok {
+			cached, semanticBucket, semanticEmbedding = semanticCacheLookup(c, relayInfo, textRequest)
+			ok = cached != nil
+		}
+		if ok {
+			replayCachedResponse(c, cached)
+			postConsumeQuota(c, relayInfo, textRequest.Model, &cached.Usage, ratio, preConsumedQuota, userQuota, modelRatio, groupRatio, modelPrice, getModelPriceSuccess, "")
+			return nil
+		}
+	}
 
 	includeUsage := false
 	// 判断用户是否需要返回使用情况
@@ -136,18 +175,6 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		includeUsage = true
 	}
 
-	// 如果不支持StreamOptions，将StreamOptions设置为nil
-	if !relayInfo.SupportStreamOptions || !textRequest.Stream {
-		textRequest.StreamOptions = nil
-	} else {
-		// 如果支持StreamOptions，且请求中没有设置StreamOptions，根据配置文件设置StreamOptions
-		if constant.ForceStreamOption {
-			textRequest.StreamOptions = &dto.StreamOptions{
-				IncludeUsage: true,
-			}
-		}
-	}
-
 	if includeUsage {
 		relayInfo.ShouldIncludeUsage = true
 	}
@@ -156,18 +183,67 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	if adaptor == nil {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
-	adaptor.Init(relayInfo)
-	var requestBody io.Reader
+	var jsonData []byte
+	var nativeWriter *nativeResponseWriter
+	if native != nil && native.passthrough(relayInfo) {
+		// 原生渠道，直接透传请求
+		adaptor.Init(relayInfo)
+		jsonData, err = native.requestBody(c, textRequest.Model, isModelMapped)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "read_request_body_failed", http.StatusBadRequest)
+		}
+	} else {
+		if native != nil {
+			// 其他渠道通过适配器使用 OpenAI 格式请求
+			relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+			relayInfo.RequestURLPath = "/v1/chat/completions"
+		}
+		// 如果不支持StreamOptions，将StreamOptions设置为nil
+		if !relayInfo.SupportStreamOptions || !textRequest.Stream {
+			textRequest.StreamOptions = nil
+		} else {
+			// 如果支持StreamOptions，且请求中没有设置StreamOptions，根据配置文件设置StreamOptions
+			if constant.ForceStreamOption {
+				textRequest.StreamOptions = &dto.StreamOptions{
+					IncludeUsage: true,
+				}
+			}
+		}
+		adaptor.Init(relayInfo)
+		convertedRequest, err := adaptor.ConvertRequest(c, relayInfo, textRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err = json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		if native != nil {
+			nativeWriter = newNativeResponseWriter(c.Writer, native.responseConverter(promptTokens))
+			c.Writer = nativeWriter
+			defer func() {
+				c.Writer = nativeWriter.ResponseWriter
+			}()
+		}
+	}
+	requestBody := bytes.NewBuffer(jsonData)
+	service.CaptureUpstreamRequest(c, jsonData)
 
-	convertedRequest, err := adaptor.ConvertRequest(c, relayInfo, textRequest)
-	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+	releaseConcurrency, openaiErr := acquireChannelConcurrency(c, relayInfo)
+	if openaiErr != nil {
+		returnPreConsumedQuota(c, relayInfo.TokenId, userQuota, preConsumedQuota)
+		return openaiErr
 	}
-	jsonData, err := json.Marshal(convertedRequest)
-	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+	defer releaseConcurrency()
+
+	var cacheWriter *cacheResponseWriter
+	if responseCacheKey != "" {
+		cacheWriter = &cacheResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = cacheWriter
+		defer func() {
+			c.Writer = cacheWriter.ResponseWriter
+		}()
 	}
-	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
@@ -186,13 +262,31 @@ func TextHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		}
 	}
 
+	doneResponse := trackResponse(c, relayInfo)
 	usage, openaiErr := adaptor.DoResponse(c, resp, relayInfo)
+	doneResponse()
 	if openaiErr != nil {
 		returnPreConsumedQuota(c, relayInfo.TokenId, userQuota, preConsumedQuota)
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if nativeWriter != nil {
+		err = nativeWriter.finish(usage)
+		if err != nil {
+			common.LogError(c, "convert native response failed: "+err.Error())
+		}
+	}
+	if cacheWriter != nil && usage != nil && cacheWriter.cacheable(relayInfo.IsStream) {
+		service.SetCachedResponse(responseCacheKey, &service.CachedResponse{
+			ContentType: cacheWriter.Header().Get("Content-Type"),
+			Body:        cacheWriter.buffer.Bytes(),
+			Usage:       *usage,
+		})
+		if semanticEmbedding != nil {
+			service.AddSemanticCacheEntry(semanticBucket, responseCacheKey, semanticEmbedding)
+		}
+	}
 	postConsumeQuota(c, relayInfo, textRequest.Model, usage, ratio, preConsumedQuota, userQuota, modelRatio, groupRatio, modelPrice, getModelPriceSuccess, "")
 	return nil
 }
@@ -201,7 +295,7 @@ func getPromptTokens(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.Re
 	var promptTokens int
 	var err error
 	switch info.RelayMode {
-	case relayconstant.RelayModeChatCompletions:
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeClaudeMessages, relayconstant.RelayModeGemini:
 		promptTokens, err = service.CountTokenChatRequest(*textRequest, textRequest.Model)
 	case relayconstant.RelayModeCompletions:
 		promptTokens, err = service.CountTokenInput(textRequest.Prompt, textRequest.Model)
@@ -220,7 +314,7 @@ func getPromptTokens(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.Re
 func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) error {
 	var err error
 	switch info.RelayMode {
-	case relayconstant.RelayModeChatCompletions:
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeClaudeMessages, relayconstant.RelayModeGemini:
 		err = service.CheckSensitiveMessages(textRequest.Messages)
 	case relayconstant.RelayModeCompletions:
 		err = service.CheckSensitiveInput(textRequest.Prompt)
@@ -238,6 +332,11 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
+	// 先从订阅中预扣，订阅不足的部分照常检查钱包与令牌额度，实际扣费时先扣订阅再扣钱包
+	preConsumedQuota = service.PreConsumeSubscription(c, relayInfo.UserId, c.GetString("original_model"), preConsumedQuota)
+	if preConsumedQuota == 0 && service.SubscriptionReserved(c) {
+		return 0, userQuota, nil
+	}
 	if userQuota <= 0 || userQuota-preConsumedQuota < 0 {
 		return 0, 0, service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
@@ -283,6 +382,30 @@ func returnPreConsumedQuota(c *gin.Context, tokenId int, userQuota int, preConsu
 	}
 }
 
+// trackResponse 记录上游响应的处理过程，并统计正在输出的流式响应数量，返回的函数在响应结束后调用
+func trackResponse(c *gin.Context, relayInfo *relaycommon.RelayInfo) func() {
+	span := common.StartGinSpan(c, "relay.response", common.SpanKindInternal)
+	span.SetAttribute("stream", relayInfo.IsStream)
+	if !relayInfo.IsStream {
+		return span.End
+	}
+	common.MetricRelayStreamsInFlight.Inc()
+	return func() {
+		common.MetricRelayStreamsInFlight.Dec()
+		span.End()
+	}
+}
+
+// acquireChannelConcurrency 占用所选渠道的并发名额，渠道已满时在队列中等待空闲名额
+func acquireChannelConcurrency(c *gin.Context, relayInfo *relaycommon.RelayInfo) (func(), *dto.OpenAIErrorWithStatusCode) {
+	release, err := model.AcquireChannelConcurrency(c.Request.Context(), relayInfo.ChannelId, c.GetString("original_model"),
+		c.GetInt("channel_max_concurrency"), c.GetInt("channel_model_concurrency"))
+	if err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "channel_concurrency_limited", http.StatusServiceUnavailable)
+	}
+	return release, nil
+}
+
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string,
 	usage *dto.Usage, ratio float64, preConsumedQuota int, userQuota int, modelRatio float64, groupRatio float64,
 	modelPrice float64, usePrice bool, extraContent string) {
@@ -290,13 +413,16 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
+	cacheTokens := usage.CacheCreationTokens + usage.CacheReadTokens
 
 	tokenName := ctx.GetString("token_name")
 	completionRatio := common.GetCompletionRatio(modelName)
 
 	quota := 0
 	if !usePrice {
-		quota = promptTokens + int(math.Round(float64(completionTokens)*completionRatio))
+		// 缓存写入与读取的 token 按缓存倍率折算为输入 token
+		cachePromptTokens := float64(usage.CacheCreationTokens)*common.CacheCreationRatio + float64(usage.CacheReadTokens)*common.CacheReadRatio
+		quota = promptTokens + int(math.Round(cachePromptTokens+float64(completionTokens)*completionRatio))
 		quota = int(math.Round(float64(quota) * ratio))
 		if ratio != 0 && quota <= 0 {
 			quota = 1
@@ -304,13 +430,23 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 	} else {
 		quota = int(modelPrice * common.QuotaPerUnit * groupRatio)
 	}
-	totalTokens := promptTokens + completionTokens
+	totalTokens := promptTokens + completionTokens + cacheTokens
+	service.RecordRateLimitUsage(ctx, totalTokens)
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f", modelRatio, groupRatio, completionRatio)
+		if cacheTokens > 0 {
+			logContent += fmt.Sprintf("，缓存写入 %d tokens（倍率 %.2f），缓存读取 %d tokens（倍率 %.2f）",
+				usage.CacheCreationTokens, common.CacheCreationRatio, usage.CacheReadTokens, common.CacheReadRatio)
+		}
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	cacheHit := ctx.GetBool("response_cache_hit")
+	if cacheHit {
+		quota = int(math.Round(float64(quota) * constant.ResponseCacheQuotaRatio))
+		logContent += fmt.Sprintf("，命中缓存，缓存倍率 %.2f", constant.ResponseCacheQuotaRatio)
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
@@ -324,20 +460,36 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		//if sensitiveResp != nil {
 		//	logContent += fmt.Sprintf("，敏感词：%s", strings.Join(sensitiveResp.SensitiveWords, ", "))
 		//}
-		quotaDelta := quota - preConsumedQuota
+		subscriptionQuota, subscriptionContent := service.SettleSubscription(ctx, relayInfo.UserId, ctx.GetString("original_model"), quota)
+		logContent += subscriptionContent
+		quotaDelta := quota - subscriptionQuota - preConsumedQuota
 		if quotaDelta != 0 {
 			err := model.PostConsumeTokenQuota(relayInfo.TokenId, userQuota, quotaDelta, preConsumedQuota, true)
 			if err != nil {
 				common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 			}
 		}
+		if subscriptionQuota > 0 && !relayInfo.TokenUnlimited {
+			// 订阅支付的部分不扣钱包，但仍计入令牌额度
+			err := model.DecreaseTokenQuota(relayInfo.TokenId, subscriptionQuota)
+			if err != nil {
+				common.LogError(ctx, "error consuming token remain quota: "+err.Error())
+			}
+		}
 		err := model.CacheUpdateUserQuota(relayInfo.UserId)
 		if err != nil {
 			common.LogError(ctx, "error update user quota cache: "+err.Error())
 		}
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		// 命中缓存时没有请求上游，不计入渠道与密钥的用量
+		if !cacheHit {
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+			if ctx.GetBool("channel_multi_key") {
+				model.UpdateChannelKeyUsedQuota(relayInfo.ChannelId, relayInfo.ApiKey, quota)
+			}
+		}
 	}
+	common.MetricQuotaConsumed.WithLabelValues(modelName, relayInfo.Group).Add(float64(quota))
 
 	logModel := modelName
 	if strings.HasPrefix(logModel, "gpt-4-gizmo") {
@@ -348,8 +500,20 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		logContent += ", " + extraContent
 	}
 	other := service.GenerateTextOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio, modelPrice)
-	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel,
+	// 流式请求以日志中的首字时间、非流式请求以整体耗时作为渠道延迟，供 least_latency 路由使用
+	if !cacheHit {
+		frt := other["frt"].(float64)
+		if !relayInfo.IsStream || frt <= 0 {
+			frt = float64(time.Since(relayInfo.StartTime).Milliseconds())
+		} else {
+			common.MetricRelayFirstResponse.WithLabelValues(modelName, strconv.Itoa(relayInfo.ChannelId)).Observe(frt / 1000)
+		}
+		model.RecordChannelFirstResponseTime(relayInfo.ChannelId, ctx.GetString("original_model"), frt)
+	}
+	logId := model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel,
 		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, other)
+	ctx.Set("consume_log_id", logId)
+	service.CheckUserUsageAlerts(relayInfo.UserId, relayInfo.TokenId, tokenName)
 
 	//if quota != 0 {
 	//
@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreConsumeQuotaSubscriptionShortfall(t *testing.T) {
+	asserts := assert.New(t)
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.SubscriptionPlan{}, &model.UserSubscription{}, &model.SubscriptionModelUsage{})
+	asserts.NoError(model.DB.Create(&model.User{Id: 1, Username: "user", Password: "password", AccessToken: "token", Quota: 0}).Error)
+	plan := &model.SubscriptionPlan{Name: "basic", Quota: 1000, Enabled: true}
+	asserts.NoError(plan.Insert())
+	subscription := &model.UserSubscription{UserId: 1, PlanId: plan.Id, Status: model.SubscriptionStatusActive,
+		StartTime: common.GetTimestamp(), ExpireTime: common.GetTimestamp() + 3600, RemainQuota: 100}
+	asserts.NoError(model.DB.Create(subscription).Error)
+	relayInfo := &relaycommon.RelayInfo{UserId: 1}
+
+	// 订阅额度不足以支付预估额度，差额需要通过钱包检查，钱包为 0 时拒绝并退回订阅的预留
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("original_model", "gpt-4o")
+	_, _, openaiErr := preConsumeQuota(c, 300, relayInfo)
+	asserts.NotNil(openaiErr)
+	asserts.Equal("insufficient_user_quota", openaiErr.Error.Code)
+	service.ReleaseSubscriptionReservation(c)
+	saved, err := model.GetUserSubscriptionById(subscription.Id)
+	asserts.NoError(err)
+	asserts.Equal(100, saved.RemainQuota)
+
+	// 订阅足够支付时不检查钱包
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("original_model", "gpt-4o")
+	preConsumed, _, openaiErr := preConsumeQuota(c, 80, relayInfo)
+	asserts.Nil(openaiErr)
+	asserts.Equal(0, preConsumed)
+	saved, err = model.GetUserSubscriptionById(subscription.Id)
+	asserts.NoError(err)
+	asserts.Equal(20, saved.RemainQuota)
+}
@@ -18,6 +18,7 @@ import (
 	"one-api/relay/channel/perplexity"
 	"one-api/relay/channel/task/suno"
 	"one-api/relay/channel/tencent"
+	"one-api/relay/channel/vertex"
 	"one-api/relay/channel/xunfei"
 	"one-api/relay/channel/zhipu"
 	"one-api/relay/channel/zhipu_4v"
@@ -62,6 +63,8 @@ func GetAdaptor(apiType int) channel.Adaptor {
 		return &jina.Adaptor{}
 	case constant.APITypeCloudflare:
 		return &cloudflare.Adaptor{}
+	case constant.APITypeVertexAi:
+		return &vertex.Adaptor{}
 	}
 	return nil
 }
@@ -58,6 +58,8 @@ func RerankHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 	relayInfo.PromptTokens = promptToken
 
 	// pre-consume quota 预消耗配额
+	// 请求失败或没有结算时退回订阅的预留
+	defer service.ReleaseSubscriptionReservation(c)
 	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, preConsumedQuota, relayInfo)
 	if openaiErr != nil {
 		return openaiErr
@@ -77,6 +79,14 @@ func RerankHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
 	requestBody := bytes.NewBuffer(jsonData)
+	service.CaptureUpstreamRequest(c, jsonData)
+	releaseConcurrency, openaiErr := acquireChannelConcurrency(c, relayInfo)
+	if openaiErr != nil {
+		returnPreConsumedQuota(c, relayInfo.TokenId, userQuota, preConsumedQuota)
+		return openaiErr
+	}
+	defer releaseConcurrency()
+
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
 	if err != nil {
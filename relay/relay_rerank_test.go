@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// byteBpeLoader 以单字节为词表，测试中不需要下载 tiktoken 的词表文件
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+var initTestTokenEncoders sync.Once
+
+func setupTokenEncoders() {
+	initTestTokenEncoders.Do(func() {
+		tiktoken.SetBpeLoader(byteBpeLoader{})
+		service.InitTokenEncoders()
+	})
+}
+
+func TestRerankHelperReleasesSubscriptionOnFailure(t *testing.T) {
+	asserts := assert.New(t)
+	setupTokenEncoders()
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.SubscriptionPlan{}, &model.UserSubscription{}, &model.SubscriptionModelUsage{})
+	asserts.NoError(model.DB.Create(&model.User{Id: 1, Username: "user", Password: "password", AccessToken: "token", Quota: 0}).Error)
+	plan := &model.SubscriptionPlan{Name: "basic", Quota: 1000000, Enabled: true}
+	asserts.NoError(plan.Insert())
+	subscription := &model.UserSubscription{UserId: 1, PlanId: plan.Id, Status: model.SubscriptionStatusActive,
+		StartTime: common.GetTimestamp(), ExpireTime: common.GetTimestamp() + 3600, RemainQuota: 1000000}
+	asserts.NoError(model.DB.Create(subscription).Error)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"message":"upstream failed","type":"server_error"}}`))
+	}))
+	defer upstream.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/rerank",
+		strings.NewReader(`{"model":"rerank-test","query":"hello","documents":["foo","bar"]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("id", 1)
+	c.Set("channel", common.ChannelTypeJina)
+	c.Set("base_url", upstream.URL)
+	c.Set("original_model", "rerank-test")
+
+	// 上游返回非 200 时，预留的订阅额度需要全部退回
+	openaiErr := RerankHelper(c, relayconstant.RelayModeRerank)
+	asserts.NotNil(openaiErr)
+	asserts.Equal(http.StatusInternalServerError, openaiErr.StatusCode)
+	saved, err := model.GetUserSubscriptionById(subscription.Id)
+	asserts.NoError(err)
+	asserts.Equal(1000000, saved.RemainQuota)
+}
@@ -39,6 +39,7 @@ func SetApiRouter(router *gin.Engine) {
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.POST("/stripe/webhook", controller.StripeWebhook)
 
 			selfRoute := userRoute.Group("/")
 			selfRoute.Use(middleware.UserAuth())
@@ -53,6 +54,11 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/pay", controller.RequestEpay)
 				selfRoute.POST("/amount", controller.RequestAmount)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
+				selfRoute.GET("/alert", controller.GetUserAlertSetting)
+				selfRoute.PUT("/alert", controller.UpdateUserAlertSetting)
+				selfRoute.GET("/invoice", controller.GetSelfInvoices)
+				selfRoute.GET("/invoice/statement", controller.GetSelfStatement)
+				selfRoute.GET("/invoice/:type/:id", controller.GetSelfInvoice)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -67,6 +73,26 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
 		}
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/epay/notify", controller.EpayNotify)
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.GET("/self/history", middleware.UserAuth(), controller.GetSelfSubscriptionHistory)
+			subscriptionRoute.PUT("/self/auto_renew", middleware.UserAuth(), controller.UpdateSelfSubscriptionAutoRenew)
+			subscriptionRoute.POST("/purchase", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.PurchaseSubscription)
+			subscriptionRoute.GET("/plan", middleware.AdminAuth(), controller.GetAllSubscriptionPlans)
+			subscriptionRoute.POST("/plan", middleware.AdminAuth(), controller.AddSubscriptionPlan)
+			subscriptionRoute.PUT("/plan", middleware.AdminAuth(), controller.UpdateSubscriptionPlan)
+			subscriptionRoute.DELETE("/plan/:id", middleware.AdminAuth(), controller.DeleteSubscriptionPlan)
+		}
+		invoiceRoute := apiRouter.Group("/invoice")
+		invoiceRoute.Use(middleware.AdminAuth())
+		{
+			invoiceRoute.GET("/", controller.GetUserInvoices)
+			invoiceRoute.GET("/statement", controller.GetUserStatement)
+			invoiceRoute.GET("/:type/:id", controller.GetUserInvoice)
+		}
 		optionRoute := apiRouter.Group("/option")
 		optionRoute.Use(middleware.RootAuth())
 		{
@@ -80,6 +106,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
@@ -92,6 +119,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
+			channelRoute.GET("/keys/:id", controller.GetChannelKeys)
 
 		}
 		tokenRoute := apiRouter.Group("/token")
@@ -103,6 +131,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.PUT("/:id/payload_capture", middleware.RootAuth(), controller.UpdateTokenPayloadCapture)
 		}
 		redemptionRoute := apiRouter.Group("/redemption")
 		redemptionRoute.Use(middleware.AdminAuth())
@@ -120,6 +149,7 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/:id/payload", middleware.RootAuth(), controller.GetLogPayloadCapture)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
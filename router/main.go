@@ -6,6 +6,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
+	"one-api/controller"
 	"os"
 	"strings"
 )
@@ -14,6 +16,12 @@ func SetRouter(router *gin.Engine, buildFS embed.FS, indexPage []byte) {
 	SetApiRouter(router)
 	SetDashboardRouter(router)
 	SetRelayRouter(router)
+	if constant.MetricsEnabled {
+		if constant.MetricsToken == "" {
+			common.SysLog("METRICS_TOKEN is not set, /metrics is accessible without authentication")
+		}
+		router.GET("/metrics", controller.Metrics)
+	}
 	frontendBaseUrl := os.Getenv("FRONTEND_BASE_URL")
 	if common.IsMasterNode && frontendBaseUrl != "" {
 		frontendBaseUrl = ""
@@ -16,6 +16,19 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	filesRouter := router.Group("/v1")
+	filesRouter.Use(middleware.TokenAuth())
+	{
+		filesRouter.GET("/files", controller.ListFiles)
+		filesRouter.POST("/files", controller.UploadFile)
+		filesRouter.DELETE("/files/:id", controller.DeleteFile)
+		filesRouter.GET("/files/:id", controller.RetrieveFile)
+		filesRouter.GET("/files/:id/content", controller.RetrieveFileContent)
+		filesRouter.POST("/batches", controller.CreateBatch)
+		filesRouter.GET("/batches", controller.ListBatches)
+		filesRouter.GET("/batches/:id", controller.RetrieveBatch)
+		filesRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth(), middleware.Distribute())
 	{
@@ -30,11 +43,6 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.POST("/audio/transcriptions", controller.Relay)
 		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/audio/speech", controller.Relay)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
 		relayV1Router.POST("/fine-tunes", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine-tunes", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -43,6 +51,14 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
 		relayV1Router.POST("/rerank", controller.Relay)
+		relayV1Router.POST("/messages", controller.Relay)
+	}
+
+	relayGeminiRouter := router.Group("/v1beta")
+	relayGeminiRouter.Use(middleware.TokenAuth(), middleware.Distribute())
+	{
+		// https://ai.google.dev/api/rest/v1beta/models/generateContent
+		relayGeminiRouter.POST("/models/:model", controller.Relay)
 	}
 
 	relayMjRouter := router.Group("/mj")
@@ -12,16 +12,101 @@ import (
 // disable & notify
 func DisableChannel(channelId int, channelName string, reason string) {
 	model.UpdateChannelStatusById(channelId, common.ChannelStatusAutoDisabled, reason)
+	common.ClearNotifyDedupe(common.NotifyEventChannelEnabled, fmt.Sprintf("%d", channelId))
 	subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelName, channelId)
 	content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelName, channelId, reason)
-	notifyRootUser(subject, content)
+	notifyRootUser(common.NotifyEventChannelDisabled, fmt.Sprintf("%d", channelId), subject, content)
+}
+
+// DisableChannelKey 禁用多密钥渠道中的单个密钥，所有密钥都被禁用时禁用整个渠道
+func DisableChannelKey(channelId int, channelName string, key string, reason string) {
+	enabledCount, err := model.UpdateChannelKeyStatus(channelId, key, common.ChannelStatusAutoDisabled, reason)
+	if err != nil {
+		common.SysError("failed to update channel key status: " + err.Error())
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）的密钥 %s 已被禁用", channelName, channelId, common.MaskKey(key))
+	content := fmt.Sprintf("通道「%s」（#%d）的密钥 %s 已被禁用，原因：%s", channelName, channelId, common.MaskKey(key), reason)
+	notifyRootUser(common.NotifyEventChannelDisabled, channelKeyNotifyKey(channelId, key), subject, content)
+	if enabledCount == 0 {
+		DisableChannel(channelId, channelName, "所有密钥均已被禁用，最后原因："+reason)
+	}
+}
+
+// EnableChannelKey 重新启用多密钥渠道中的单个密钥，渠道已被自动禁用时一并启用渠道，其余密钥保持原状态
+func EnableChannelKey(channelId int, channelName string, key string) {
+	_, err := model.UpdateChannelKeyStatus(channelId, key, common.ChannelStatusEnabled, "")
+	if err != nil {
+		common.SysError("failed to update channel key status: " + err.Error())
+		return
+	}
+	common.ClearNotifyDedupe(common.NotifyEventChannelDisabled, channelKeyNotifyKey(channelId, key))
+	channel, err := model.GetChannelById(channelId, true)
+	if err == nil && channel.Status == common.ChannelStatusAutoDisabled {
+		EnableChannel(channelId, channelName)
+	}
 }
 
 func EnableChannel(channelId int, channelName string) {
 	model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
+	ClearChannelDisabledNotify(channelId)
 	subject := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
 	content := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
-	notifyRootUser(subject, content)
+	notifyRootUser(common.NotifyEventChannelEnabled, fmt.Sprintf("%d", channelId), subject, content)
+}
+
+// ClearChannelDisabledNotify 渠道重新启用后清除渠道及其密钥禁用通知的去重记录，再次被禁用时立即通知
+func ClearChannelDisabledNotify(channelId int) {
+	common.ClearNotifyDedupe(common.NotifyEventChannelDisabled, fmt.Sprintf("%d", channelId))
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return
+	}
+	for _, key := range channel.GetKeys() {
+		common.ClearNotifyDedupe(common.NotifyEventChannelDisabled, channelKeyNotifyKey(channelId, key))
+	}
+}
+
+// channelKeyNotifyKey 密钥禁用通知按密钥哈希去重，首尾相同的密钥脱敏后一致，不能用于区分
+func channelKeyNotifyKey(channelId int, key string) string {
+	return fmt.Sprintf("%d:%s", channelId, model.HashChannelKey(key))
+}
+
+// channelBalanceUSD 返回以美元计的渠道余额，余额单位不是美元的渠道返回 false
+func channelBalanceUSD(channelType int, balance float64) (float64, bool) {
+	switch channelType {
+	case common.ChannelTypeOpenAI, common.ChannelTypeCustom:
+		// 来自 /v1/dashboard/billing/subscription 的 hard_limit_usd 减去已用额度
+		return balance, true
+	default:
+		// AIProxy 为点数，API2GPT、AIGC2D 等第三方为各自的计价单位
+		return 0, false
+	}
+}
+
+// NotifyChannelBalanceLow 渠道余额低于阈值时通知，余额恢复后清除去重记录
+func NotifyChannelBalanceLow(channel *model.Channel, balance float64) {
+	if common.ChannelBalanceLowThreshold <= 0 {
+		return
+	}
+	balance, ok := channelBalanceUSD(channel.Type, balance)
+	if !ok {
+		return
+	}
+	dedupeKey := fmt.Sprintf("%d", channel.Id)
+	if balance >= common.ChannelBalanceLowThreshold {
+		common.ClearNotifyDedupe(common.NotifyEventBalanceLow, dedupeKey)
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）余额不足", channel.Name, channel.Id)
+	content := fmt.Sprintf("通道「%s」（#%d）当前余额 $%.2f，低于提醒阈值 $%.2f", channel.Name, channel.Id, balance, common.ChannelBalanceLowThreshold)
+	common.Notify(common.NotifyEvent{
+		Type:      common.NotifyEventBalanceLow,
+		Subject:   subject,
+		Content:   content,
+		Fields:    map[string]interface{}{"channel_id": channel.Id, "balance": balance},
+		DedupeKey: dedupeKey,
+	})
 }
 
 func ShouldDisableChannel(channelType int, err *relaymodel.OpenAIErrorWithStatusCode) bool {
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"one-api/model/testutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyChannelBalanceLow(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled, sinks, threshold := common.RedisEnabled, common.NotificationSinks, common.ChannelBalanceLowThreshold
+	t.Cleanup(func() {
+		common.RedisEnabled, common.NotificationSinks, common.ChannelBalanceLowThreshold = redisEnabled, sinks, threshold
+	})
+	received := make(chan common.NotifyEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event common.NotifyEvent
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+	common.RedisEnabled = false
+	common.NotificationSinks = []common.NotificationSink{{Type: common.NotifySinkWebhook, URL: server.URL, Enabled: true}}
+	common.ChannelBalanceLowThreshold = 5
+
+	expectNotified := func(notified bool) {
+		select {
+		case event := <-received:
+			asserts.True(notified, "unexpected notification")
+			asserts.Equal(common.NotifyEventBalanceLow, event.Type)
+		case <-time.After(200 * time.Millisecond):
+			asserts.False(notified, "expected notification")
+		}
+	}
+
+	openai := &model.Channel{Id: 9101, Name: "openai", Type: common.ChannelTypeOpenAI}
+	NotifyChannelBalanceLow(openai, 1)
+	expectNotified(true)
+	// 去重窗口内不重复通知
+	NotifyChannelBalanceLow(openai, 1)
+	expectNotified(false)
+	// 余额恢复后清除去重记录，再次低于阈值时立即通知
+	NotifyChannelBalanceLow(openai, 10)
+	NotifyChannelBalanceLow(openai, 1)
+	expectNotified(true)
+
+	// AIProxy 返回的是点数，不与美元阈值比较
+	NotifyChannelBalanceLow(&model.Channel{Id: 9102, Type: common.ChannelTypeAIProxy}, 1)
+	expectNotified(false)
+}
+
+func TestDisableChannelKeyNotify(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled, sinks, rootUserEmail := common.RedisEnabled, common.NotificationSinks, common.RootUserEmail
+	t.Cleanup(func() {
+		common.RedisEnabled, common.NotificationSinks, common.RootUserEmail = redisEnabled, sinks, rootUserEmail
+	})
+	received := make(chan common.NotifyEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event common.NotifyEvent
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+	common.RedisEnabled = false
+	common.NotificationSinks = []common.NotificationSink{{Type: common.NotifySinkWebhook, URL: server.URL, Enabled: true}}
+	common.RootUserEmail = "root@example.com"
+	model.DB = testutil.SetupDB(t, &model.Channel{}, &model.Ability{}, &model.ChannelKey{})
+
+	// 前两个密钥首尾相同，脱敏后一致；第三个密钥保持启用，渠道不会被整体禁用
+	channel := &model.Channel{Type: common.ChannelTypeOpenAI, Name: "multi", Key: "sk-a1111xyz9\nsk-a2222xyz9\nsk-b3333xyz8", MultiKeyMode: model.ChannelKeyModeRoundRobin,
+		Status: common.ChannelStatusEnabled, Models: "gpt-3.5-turbo", Group: "default"}
+	asserts.NoError(channel.Insert())
+	keys := channel.GetKeys()[:2]
+	asserts.Equal(common.MaskKey(keys[0]), common.MaskKey(keys[1]))
+
+	// 每个密钥被禁用时都会通知，不会被另一个密钥的去重记录吞掉
+	for _, key := range keys {
+		DisableChannelKey(channel.Id, channel.Name, key, "invalid api key")
+		select {
+		case event := <-received:
+			asserts.Equal(common.NotifyEventChannelDisabled, event.Type)
+			asserts.Contains(event.Subject, common.MaskKey(key))
+		case <-time.After(200 * time.Millisecond):
+			asserts.Fail("expected notification for key " + common.MaskKey(key))
+		}
+	}
+}
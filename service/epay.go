@@ -1,7 +1,13 @@
 package service
 
 import (
+	"errors"
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"net/url"
 	"one-api/constant"
+	"strconv"
 )
 
 func GetCallbackAddress() string {
@@ -10,3 +16,96 @@ func GetCallbackAddress() string {
 	}
 	return constant.CustomCallbackAddress
 }
+
+func GetEpayClient() *epay.Client {
+	if constant.PayAddress == "" || constant.EpayId == "" || constant.EpayKey == "" {
+		return nil
+	}
+	withUrl, err := epay.NewClient(&epay.Config{
+		PartnerID: constant.EpayId,
+		Key:       constant.EpayKey,
+	}, constant.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return withUrl
+}
+
+type epayProvider struct{}
+
+func (p *epayProvider) Name() string {
+	return PaymentProviderEpay
+}
+
+func (p *epayProvider) Enabled() bool {
+	return constant.PayAddress != "" && constant.EpayId != "" && constant.EpayKey != ""
+}
+
+func (p *epayProvider) Currency() string {
+	return "cny"
+}
+
+func (p *epayProvider) UnitPrice() float64 {
+	return constant.Price
+}
+
+func (p *epayProvider) CreatePayment(order *PaymentOrder) (*PaymentResult, error) {
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	var payType epay.PurchaseType
+	if order.Method == "zfb" {
+		payType = epay.Alipay
+	}
+	if order.Method == "wx" || order.Method == "wxpay" {
+		payType = epay.WechatPay
+	}
+	returnUrl, err := url.Parse(order.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	notifyUrl, err := url.Parse(order.NotifyUrl)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           payType,
+		ServiceTradeNo: order.TradeNo,
+		Name:           order.Title,
+		Money:          strconv.FormatFloat(order.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentResult{Url: uri, Params: params}, nil
+}
+
+func (p *epayProvider) ParseNotify(c *gin.Context) (*PaymentNotify, error) {
+	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
+		r[t] = c.Request.URL.Query().Get(t)
+		return r
+	}, map[string]string{})
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("易支付回调失败 未找到配置信息")
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil || !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	notify := &PaymentNotify{
+		Event:           PaymentEventIgnored,
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderTradeNo: verifyInfo.TradeNo,
+		Currency:        p.Currency(),
+	}
+	notify.Money, _ = strconv.ParseFloat(verifyInfo.Money, 64)
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		notify.Event = PaymentEventPaid
+	}
+	return notify, nil
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InvoiceTypeTopUp      = "topup"
+	InvoiceTypeRedemption = "redemption"
+)
+
+// Invoice 在线充值订单对应发票，兑换码对应收据
+type Invoice struct {
+	Number        string  `json:"number"`
+	Type          string  `json:"type"`
+	Id            int     `json:"id"`
+	UserId        int     `json:"user_id"`
+	Username      string  `json:"username"`
+	Time          int64   `json:"time"`
+	Description   string  `json:"description"`
+	Quota         int     `json:"quota"`
+	Money         float64 `json:"money"`
+	Currency      string  `json:"currency"`
+	Subtotal      float64 `json:"subtotal"`
+	Tax           float64 `json:"tax"`
+	RefundedMoney float64 `json:"refunded_money"`
+	Status        string  `json:"status"`
+}
+
+// Statement 月度用量账单，金额按额度折算为美元
+type Statement struct {
+	UserId      int                    `json:"user_id"`
+	Username    string                 `json:"username"`
+	Month       string                 `json:"month"`
+	StartTime   int64                  `json:"start_time"`
+	EndTime     int64                  `json:"end_time"`
+	Items       []*model.StatementItem `json:"items"`
+	TotalCount  int                    `json:"total_count"`
+	TotalTokens int                    `json:"total_tokens"`
+	TotalQuota  int                    `json:"total_quota"`
+	Amount      float64                `json:"amount"`
+	Subtotal    float64                `json:"subtotal"`
+	Tax         float64                `json:"tax"`
+	Invoices    []*Invoice             `json:"invoices"`
+}
+
+// splitTax 账单金额为含税价，按税率拆分为不含税金额与税额
+func splitTax(amount float64) (subtotal float64, tax float64) {
+	if constant.InvoiceTaxRate <= 0 {
+		return amount, 0
+	}
+	subtotal = math.Round(amount/(1+constant.InvoiceTaxRate/100)*100) / 100
+	return subtotal, math.Round((amount-subtotal)*100) / 100
+}
+
+func quotaToUSD(quota int) float64 {
+	return float64(quota) / common.QuotaPerUnit
+}
+
+func invoiceNumber(invoiceType string, id int, timestamp int64) string {
+	month := time.Unix(timestamp, 0).Format("200601")
+	if invoiceType == InvoiceTypeRedemption {
+		return fmt.Sprintf("RCP-%s-R%d", month, id)
+	}
+	return fmt.Sprintf("INV-%s-T%d", month, id)
+}
+
+func topUpToInvoice(topUp *model.TopUp, username string) *Invoice {
+	invoice := &Invoice{
+		Number:        invoiceNumber(InvoiceTypeTopUp, topUp.Id, topUp.CreateTime),
+		Type:          InvoiceTypeTopUp,
+		Id:            topUp.Id,
+		UserId:        topUp.UserId,
+		Username:      username,
+		Time:          topUp.CreateTime,
+		Description:   fmt.Sprintf("Online top-up %s (%s)", topUp.TradeNo, topUp.PaymentProvider),
+		Quota:         topUp.Amount * int(common.QuotaPerUnit),
+		Money:         topUp.Money,
+		Currency:      strings.ToUpper(topUp.Currency),
+		RefundedMoney: topUp.RefundedMoney,
+		Status:        topUp.Status,
+	}
+	invoice.Subtotal, invoice.Tax = splitTax(topUp.Money)
+	return invoice
+}
+
+func redemptionToInvoice(redemption *model.Redemption, username string) *Invoice {
+	return &Invoice{
+		Number:      invoiceNumber(InvoiceTypeRedemption, redemption.Id, redemption.RedeemedTime),
+		Type:        InvoiceTypeRedemption,
+		Id:          redemption.Id,
+		UserId:      redemption.UsedUserId,
+		Username:    username,
+		Time:        redemption.RedeemedTime,
+		Description: fmt.Sprintf("Redemption code #%d %s", redemption.Id, redemption.Name),
+		Quota:       redemption.Quota,
+		Status:      "redeemed",
+	}
+}
+
+// GetUserInvoices 获取用户在时间段内的发票与收据，按时间倒序，时间为 0 表示不限制
+func GetUserInvoices(userId int, startTime int64, endTime int64) ([]*Invoice, error) {
+	username, _ := model.GetUsernameById(userId)
+	topUps, err := model.GetUserInvoiceTopUps(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	redemptions, err := model.GetUserRedeemedRedemptions(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	invoices := make([]*Invoice, 0, len(topUps)+len(redemptions))
+	for _, topUp := range topUps {
+		invoices = append(invoices, topUpToInvoice(topUp, username))
+	}
+	for _, redemption := range redemptions {
+		invoices = append(invoices, redemptionToInvoice(redemption, username))
+	}
+	sort.SliceStable(invoices, func(i, j int) bool {
+		return invoices[i].Time > invoices[j].Time
+	})
+	return invoices, nil
+}
+
+// GetInvoice 获取单张发票或收据，调用方需自行校验 UserId
+func GetInvoice(invoiceType string, id int) (*Invoice, error) {
+	switch invoiceType {
+	case InvoiceTypeTopUp:
+		topUp := model.GetTopUpById(id)
+		if topUp == nil || (topUp.Status != "success" && topUp.Status != "refunded") {
+			return nil, errors.New("发票不存在")
+		}
+		username, _ := model.GetUsernameById(topUp.UserId)
+		return topUpToInvoice(topUp, username), nil
+	case InvoiceTypeRedemption:
+		redemption, err := model.GetRedemptionById(id)
+		if err != nil || redemption.Status != common.RedemptionCodeStatusUsed {
+			return nil, errors.New("收据不存在")
+		}
+		username, _ := model.GetUsernameById(redemption.UsedUserId)
+		return redemptionToInvoice(redemption, username), nil
+	}
+	return nil, errors.New("无效的发票类型")
+}
+
+// ParseStatementMonth 解析 YYYY-MM 格式的月份，为空时取当前月份
+func ParseStatementMonth(month string) (string, int64, int64, error) {
+	var start time.Time
+	if month == "" {
+		now := time.Now()
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	} else {
+		var err error
+		start, err = time.ParseInLocation("2006-01", month, time.Local)
+		if err != nil {
+			return "", 0, 0, errors.New("月份格式应为 YYYY-MM")
+		}
+	}
+	return start.Format("2006-01"), start.Unix(), start.AddDate(0, 1, 0).Unix(), nil
+}
+
+// GetUserStatement 生成用户的月度账单
+func GetUserStatement(userId int, month string) (*Statement, error) {
+	month, startTime, endTime, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	items, err := model.GetUserStatementItems(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	invoices, err := GetUserInvoices(userId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	username, _ := model.GetUsernameById(userId)
+	statement := &Statement{
+		UserId:    userId,
+		Username:  username,
+		Month:     month,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Items:     items,
+		Invoices:  invoices,
+	}
+	for _, item := range items {
+		statement.TotalCount += item.Count
+		statement.TotalTokens += item.TotalTokens
+		statement.TotalQuota += item.Quota
+	}
+	statement.Amount = math.Round(quotaToUSD(statement.TotalQuota)*100) / 100
+	statement.Subtotal, statement.Tax = splitTax(statement.Amount)
+	return statement, nil
+}
+
+func formatMoney(money float64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", money, currency))
+}
+
+func formatInvoiceTime(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+}
+
+func writeInvoiceHeader(doc *common.PDFDocument, title string) {
+	doc.AddText(title, 18, true)
+	doc.AddSpace(6)
+	if constant.InvoiceCompanyName != "" {
+		doc.AddText(constant.InvoiceCompanyName, 11, true)
+	} else {
+		doc.AddText(common.SystemName, 11, true)
+	}
+	for _, line := range strings.Split(constant.InvoiceCompanyAddress, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			doc.AddText(line, 10, false)
+		}
+	}
+	if constant.InvoiceCompanyTaxId != "" {
+		doc.AddText("Tax ID: "+constant.InvoiceCompanyTaxId, 10, false)
+	}
+	if constant.InvoiceCompanyEmail != "" {
+		doc.AddText("Email: "+constant.InvoiceCompanyEmail, 10, false)
+	}
+	doc.AddLine()
+}
+
+func RenderInvoicePDF(invoice *Invoice) ([]byte, error) {
+	doc := common.NewPDFDocument()
+	title := "INVOICE"
+	if invoice.Type == InvoiceTypeRedemption {
+		title = "RECEIPT"
+	}
+	writeInvoiceHeader(doc, title)
+	doc.AddText("Number: "+invoice.Number, 10, false)
+	doc.AddText("Date: "+formatInvoiceTime(invoice.Time), 10, false)
+	doc.AddText(fmt.Sprintf("Billed to: %s (user #%d)", invoice.Username, invoice.UserId), 10, false)
+	doc.AddText("Status: "+invoice.Status, 10, false)
+	doc.AddSpace(10)
+	widths := []float64{0.55, 0.2, 0.25}
+	doc.AddRow([]string{"Description", "Quota (USD)", "Amount"}, widths, 10, true)
+	doc.AddLine()
+	doc.AddRow([]string{invoice.Description, fmt.Sprintf("$%.2f", quotaToUSD(invoice.Quota)), formatMoney(invoice.Money, invoice.Currency)}, widths, 10, false)
+	doc.AddLine()
+	if invoice.Type == InvoiceTypeTopUp {
+		doc.AddRow([]string{"", "Subtotal", formatMoney(invoice.Subtotal, invoice.Currency)}, widths, 10, false)
+		doc.AddRow([]string{"", fmt.Sprintf("Tax (%g%%)", constant.InvoiceTaxRate), formatMoney(invoice.Tax, invoice.Currency)}, widths, 10, false)
+		doc.AddRow([]string{"", "Total", formatMoney(invoice.Money, invoice.Currency)}, widths, 10, true)
+		if invoice.RefundedMoney > 0 {
+			doc.AddRow([]string{"", "Refunded", formatMoney(invoice.RefundedMoney, invoice.Currency)}, widths, 10, false)
+		}
+	} else {
+		doc.AddText("Quota credited by redemption code, no payment collected.", 10, false)
+	}
+	return doc.Bytes()
+}
+
+func writeCSV(records [][]string) []byte {
+	var buf bytes.Buffer
+	// 写入 BOM，便于 Excel 正确识别 UTF-8
+	buf.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(&buf)
+	_ = writer.WriteAll(records)
+	return buf.Bytes()
+}
+
+func invoiceCSVRecord(invoice *Invoice) []string {
+	return []string{
+		invoice.Number,
+		invoice.Type,
+		formatInvoiceTime(invoice.Time),
+		strconv.Itoa(invoice.UserId),
+		invoice.Username,
+		invoice.Description,
+		strconv.Itoa(invoice.Quota),
+		fmt.Sprintf("%.2f", invoice.Subtotal),
+		fmt.Sprintf("%.2f", invoice.Tax),
+		fmt.Sprintf("%.2f", invoice.Money),
+		invoice.Currency,
+		fmt.Sprintf("%.2f", invoice.RefundedMoney),
+		invoice.Status,
+	}
+}
+
+var invoiceCSVHeader = []string{"number", "type", "time", "user_id", "username", "description", "quota", "subtotal", "tax", "total", "currency", "refunded", "status"}
+
+func RenderInvoicesCSV(invoices []*Invoice) []byte {
+	records := [][]string{invoiceCSVHeader}
+	for _, invoice := range invoices {
+		records = append(records, invoiceCSVRecord(invoice))
+	}
+	return writeCSV(records)
+}
+
+func RenderStatementPDF(statement *Statement) ([]byte, error) {
+	doc := common.NewPDFDocument()
+	writeInvoiceHeader(doc, "STATEMENT "+statement.Month)
+	doc.AddText(fmt.Sprintf("Account: %s (user #%d)", statement.Username, statement.UserId), 10, false)
+	doc.AddText(fmt.Sprintf("Period: %s - %s", formatInvoiceTime(statement.StartTime), formatInvoiceTime(statement.EndTime-1)), 10, false)
+	doc.AddSpace(10)
+	doc.AddText("Usage", 12, true)
+	widths := []float64{0.3, 0.2, 0.12, 0.2, 0.18}
+	doc.AddRow([]string{"Model", "Token", "Requests", "Tokens", "Cost (USD)"}, widths, 9, true)
+	doc.AddLine()
+	for _, item := range statement.Items {
+		doc.AddRow([]string{item.ModelName, item.TokenName, strconv.Itoa(item.Count), strconv.Itoa(item.TotalTokens),
+			fmt.Sprintf("$%.4f", quotaToUSD(item.Quota))}, widths, 9, false)
+	}
+	doc.AddLine()
+	doc.AddRow([]string{"Total", "", strconv.Itoa(statement.TotalCount), strconv.Itoa(statement.TotalTokens),
+		fmt.Sprintf("$%.2f", statement.Amount)}, widths, 9, true)
+	doc.AddRow([]string{"", "", "", "Subtotal", fmt.Sprintf("$%.2f", statement.Subtotal)}, widths, 9, false)
+	doc.AddRow([]string{"", "", "", fmt.Sprintf("Tax (%g%%)", constant.InvoiceTaxRate), fmt.Sprintf("$%.2f", statement.Tax)}, widths, 9, false)
+	if len(statement.Invoices) > 0 {
+		doc.AddSpace(10)
+		doc.AddText("Top-ups and redemptions", 12, true)
+		invoiceWidths := []float64{0.25, 0.3, 0.2, 0.25}
+		doc.AddRow([]string{"Number", "Date", "Quota (USD)", "Amount"}, invoiceWidths, 9, true)
+		doc.AddLine()
+		for _, invoice := range statement.Invoices {
+			doc.AddRow([]string{invoice.Number, formatInvoiceTime(invoice.Time), fmt.Sprintf("$%.2f", quotaToUSD(invoice.Quota)),
+				formatMoney(invoice.Money, invoice.Currency)}, invoiceWidths, 9, false)
+		}
+	}
+	return doc.Bytes()
+}
+
+func RenderStatementCSV(statement *Statement) []byte {
+	records := [][]string{{"month", "model_name", "token_name", "requests", "prompt_tokens", "completion_tokens", "total_tokens", "quota", "cost_usd"}}
+	for _, item := range statement.Items {
+		records = append(records, []string{
+			statement.Month,
+			item.ModelName,
+			item.TokenName,
+			strconv.Itoa(item.Count),
+			strconv.Itoa(item.PromptTokens),
+			strconv.Itoa(item.CompletionTokens),
+			strconv.Itoa(item.TotalTokens),
+			strconv.Itoa(item.Quota),
+			fmt.Sprintf("%.6f", quotaToUSD(item.Quota)),
+		})
+	}
+	records = append(records, []string{statement.Month, "total", "", strconv.Itoa(statement.TotalCount), "", "",
+		strconv.Itoa(statement.TotalTokens), strconv.Itoa(statement.TotalQuota), fmt.Sprintf("%.2f", statement.Amount)})
+	records = append(records, []string{statement.Month, "subtotal", "", "", "", "", "", "", fmt.Sprintf("%.2f", statement.Subtotal)})
+	records = append(records, []string{statement.Month, "tax", "", "", "", "", "", "", fmt.Sprintf("%.2f", statement.Tax)})
+	return writeCSV(records)
+}
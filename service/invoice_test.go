@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupInvoices(t *testing.T) {
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.TopUp{}, &model.Redemption{})
+	assert.NoError(t, model.DB.Create(&model.User{Id: 1, Username: "张三"}).Error)
+	now := time.Now().Unix()
+	topUps := []*model.TopUp{
+		{UserId: 1, Amount: 10, Money: 72, TradeNo: "A1", CreateTime: now - 300, Status: "success", PaymentProvider: PaymentProviderEpay, Currency: "cny"},
+		{UserId: 1, Amount: 5, Money: 5, TradeNo: "A2", CreateTime: now - 100, Status: "refunded", PaymentProvider: PaymentProviderStripe, Currency: "usd", RefundedMoney: 5},
+		{UserId: 1, Amount: 5, Money: 5, TradeNo: "A3", CreateTime: now, Status: "pending", PaymentProvider: PaymentProviderStripe, Currency: "usd"},
+	}
+	for _, topUp := range topUps {
+		assert.NoError(t, topUp.Insert())
+	}
+	redemption := &model.Redemption{Key: "key1", Name: "活动兑换码", Quota: 1000, Status: common.RedemptionCodeStatusUsed, UsedUserId: 1, RedeemedTime: now - 200}
+	assert.NoError(t, model.DB.Create(redemption).Error)
+}
+
+func TestSplitTax(t *testing.T) {
+	asserts := assert.New(t)
+	taxRate := constant.InvoiceTaxRate
+	t.Cleanup(func() {
+		constant.InvoiceTaxRate = taxRate
+	})
+
+	constant.InvoiceTaxRate = 0
+	subtotal, tax := splitTax(100)
+	asserts.Equal(100.0, subtotal)
+	asserts.Equal(0.0, tax)
+
+	// 金额为含税价
+	constant.InvoiceTaxRate = 6
+	subtotal, tax = splitTax(106)
+	asserts.Equal(100.0, subtotal)
+	asserts.Equal(6.0, tax)
+}
+
+func TestGetUserInvoices(t *testing.T) {
+	asserts := assert.New(t)
+	setupInvoices(t)
+
+	invoices, err := GetUserInvoices(1, 0, 0)
+	if !asserts.NoError(err) || !asserts.Len(invoices, 3) {
+		return
+	}
+	// 未支付的订单不出具发票，按时间倒序
+	asserts.Contains(invoices[0].Description, "A2")
+	asserts.Equal(InvoiceTypeRedemption, invoices[1].Type)
+	asserts.Equal(InvoiceTypeTopUp, invoices[2].Type)
+	asserts.Equal("CNY", invoices[2].Currency)
+	asserts.Equal("张三", invoices[2].Username)
+
+	_, err = GetInvoice(InvoiceTypeTopUp, 3)
+	asserts.Error(err)
+	invoice, err := GetInvoice(InvoiceTypeRedemption, 1)
+	if asserts.NoError(err) {
+		asserts.Equal(1000, invoice.Quota)
+	}
+	_, err = GetInvoice("other", 1)
+	asserts.Error(err)
+}
+
+func TestRenderInvoicePDF(t *testing.T) {
+	asserts := assert.New(t)
+	setupInvoices(t)
+	companyName := constant.InvoiceCompanyName
+	constant.InvoiceCompanyName = "示例科技有限公司"
+	t.Cleanup(func() {
+		constant.InvoiceCompanyName = companyName
+	})
+
+	invoices, err := GetUserInvoices(1, 0, 0)
+	if !asserts.NoError(err) {
+		return
+	}
+	for _, invoice := range invoices {
+		data, err := RenderInvoicePDF(invoice)
+		if asserts.NoError(err) {
+			asserts.True(bytes.HasPrefix(data, []byte("%PDF-")))
+		}
+	}
+
+	statement := &Statement{UserId: 1, Username: "张三", Month: "2024-05", Invoices: invoices,
+		Items: []*model.StatementItem{{ModelName: "gpt-4o", TokenName: "默认令牌", Count: 2, TotalTokens: 100, Quota: 500}}}
+	data, err := RenderStatementPDF(statement)
+	if asserts.NoError(err) {
+		asserts.True(bytes.HasPrefix(data, []byte("%PDF-")))
+	}
+}
+
+func TestRenderInvoicesCSV(t *testing.T) {
+	asserts := assert.New(t)
+	setupInvoices(t)
+
+	invoices, err := GetUserInvoices(1, 0, 0)
+	if !asserts.NoError(err) {
+		return
+	}
+	data := string(RenderInvoicesCSV(invoices))
+	asserts.True(strings.HasPrefix(data, "\xEF\xBB\xBFnumber,type,"))
+	asserts.Len(strings.Split(strings.TrimSpace(data), "\n"), 4)
+	asserts.Contains(data, "张三")
+}
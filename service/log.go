@@ -2,6 +2,7 @@ package service
 
 import (
 	"github.com/gin-gonic/gin"
+	"one-api/common"
 	relaycommon "one-api/relay/common"
 )
 
@@ -15,5 +16,17 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	other["admin_info"] = adminInfo
+	if fallbackModel := ctx.GetString("fallback_model"); fallbackModel != "" {
+		other["fallback_from"] = ctx.GetString("request_model")
+	}
+	if ctx.GetBool("response_cache_hit") {
+		other["cache_hit"] = true
+		if similarity, ok := ctx.Get("response_cache_similarity"); ok {
+			other["cache_similarity"] = similarity
+		}
+	}
+	if batchId, _ := ctx.Request.Context().Value(common.BatchIdKey).(string); batchId != "" {
+		other["batch_id"] = batchId
+	}
 	return other
 }
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const payloadCaptureKey = "payload_capture"
+
+// payloadCaptureWriter 在写出响应的同时保存一份，流式响应需要完整内容才能重组，因此按上限的数倍缓存原始数据
+type payloadCaptureWriter struct {
+	gin.ResponseWriter
+	buffer    bytes.Buffer
+	truncated bool
+	upstream  []byte
+}
+
+func (w *payloadCaptureWriter) capture(data []byte) {
+	if w.truncated {
+		return
+	}
+	// 上限为 0 时不截断
+	limit := constant.PayloadCaptureMaxBytes * 8
+	if limit > 0 && w.buffer.Len()+len(data) > limit {
+		w.truncated = true
+		if remain := limit - w.buffer.Len(); remain > 0 {
+			w.buffer.Write(data)
+			w.buffer.Truncate(utf8PrefixLen(w.buffer.Bytes(), limit))
+		}
+		return
+	}
+	w.buffer.Write(data)
+}
+
+// utf8PrefixLen 返回不超过 n 字节且不会截断多字节字符的前缀长度
+func utf8PrefixLen(data []byte, n int) int {
+	if n >= len(data) {
+		return len(data)
+	}
+	for n > 0 && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return n
+}
+
+func (w *payloadCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *payloadCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ShouldCapturePayload 令牌的开关只能由管理员设置，见 controller.UpdateTokenPayloadCapture
+func ShouldCapturePayload(c *gin.Context) bool {
+	return c.GetBool("token_payload_capture") || constant.PayloadCaptureGroups[c.GetString("group")]
+}
+
+// StartPayloadCapture 开始保存本次请求的响应内容，返回的函数在请求结束时调用，负责落库
+func StartPayloadCapture(c *gin.Context) func() {
+	if !ShouldCapturePayload(c) {
+		return func() {}
+	}
+	writer := &payloadCaptureWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Set(payloadCaptureKey, writer)
+	return func() {
+		c.Writer = writer.ResponseWriter
+		savePayloadCapture(c, writer)
+	}
+}
+
+// CaptureUpstreamRequest 记录转换后发往上游的请求体，重试时以最后一次为准
+func CaptureUpstreamRequest(c *gin.Context, body []byte) {
+	value, ok := c.Get(payloadCaptureKey)
+	if !ok {
+		return
+	}
+	writer := value.(*payloadCaptureWriter)
+	writer.upstream = append(writer.upstream[:0], body...)
+}
+
+func savePayloadCapture(c *gin.Context, writer *payloadCaptureWriter) {
+	var request string
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		request = "[multipart/form-data omitted]"
+	} else {
+		requestBody, _ := common.GetRequestBody(c)
+		request = string(requestBody)
+	}
+	isStream := strings.HasPrefix(writer.Header().Get("Content-Type"), "text/event-stream")
+	response := writer.buffer.String()
+	if isStream {
+		response = reassembleStreamResponse(writer.buffer.Bytes())
+	}
+	if writer.truncated {
+		response += "...[truncated]"
+	}
+	capture := &model.PayloadCapture{
+		LogId:           c.GetInt("consume_log_id"),
+		RequestId:       c.GetString(common.RequestIdKey),
+		UserId:          c.GetInt("id"),
+		TokenId:         c.GetInt("token_id"),
+		ChannelId:       c.GetInt("channel_id"),
+		ModelName:       c.GetString("original_model"),
+		StatusCode:      writer.Status(),
+		IsStream:        isStream,
+		Request:         preparePayload(request),
+		UpstreamRequest: preparePayload(string(writer.upstream)),
+		Response:        preparePayload(response),
+		CreatedAt:       common.GetTimestamp(),
+	}
+	if constant.PayloadCaptureEncryptionKey != "" {
+		var err error
+		for _, field := range []*string{&capture.Request, &capture.UpstreamRequest, &capture.Response} {
+			*field, err = common.EncryptString(constant.PayloadCaptureEncryptionKey, *field)
+			if err != nil {
+				common.LogError(c, "failed to encrypt payload capture: "+err.Error())
+				return
+			}
+		}
+		capture.Encrypted = true
+	}
+	if err := capture.Insert(); err != nil {
+		common.LogError(c, "failed to save payload capture: "+err.Error())
+	}
+}
+
+// preparePayload 脱敏并截断
+func preparePayload(payload string) string {
+	for _, re := range constant.PayloadCaptureRedactRegexps {
+		payload = re.ReplaceAllString(payload, "[REDACTED]")
+	}
+	if constant.PayloadCaptureMaxBytes > 0 && len(payload) > constant.PayloadCaptureMaxBytes {
+		payload = payload[:utf8PrefixLen([]byte(payload), constant.PayloadCaptureMaxBytes)] + "...[truncated]"
+	}
+	return payload
+}
+
+type streamEvent struct {
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Delta struct {
+		Text string `json:"text"`
+	} `json:"delta"`
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	Usage         json.RawMessage `json:"usage"`
+	UsageMetadata json.RawMessage `json:"usageMetadata"`
+}
+
+// reassembleStreamResponse 将 OpenAI、Claude、Gemini 格式的 SSE 流重组为完整内容
+func reassembleStreamResponse(data []byte) string {
+	var content, reasoning strings.Builder
+	var usage json.RawMessage
+	events := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			continue
+		}
+		events++
+		for _, choice := range event.Choices {
+			content.WriteString(choice.Text)
+			content.WriteString(choice.Delta.Content)
+			reasoning.WriteString(choice.Delta.ReasoningContent)
+		}
+		content.WriteString(event.Delta.Text)
+		for _, candidate := range event.Candidates {
+			for _, part := range candidate.Content.Parts {
+				content.WriteString(part.Text)
+			}
+		}
+		if len(event.Usage) > 0 && string(event.Usage) != "null" {
+			usage = event.Usage
+		} else if len(event.UsageMetadata) > 0 && string(event.UsageMetadata) != "null" {
+			usage = event.UsageMetadata
+		}
+	}
+	if events == 0 {
+		return string(data)
+	}
+	result, err := json.Marshal(map[string]interface{}{
+		"content":           content.String(),
+		"reasoning_content": reasoning.String(),
+		"usage":             usage,
+		"events":            events,
+	})
+	if err != nil {
+		return string(data)
+	}
+	return string(result)
+}
+
+// DecryptPayloadCapture 解密保存的内容，未加密时原样返回
+func DecryptPayloadCapture(capture *model.PayloadCapture) error {
+	if !capture.Encrypted {
+		return nil
+	}
+	if constant.PayloadCaptureEncryptionKey == "" {
+		return fmt.Errorf("payload capture is encrypted but PAYLOAD_CAPTURE_ENCRYPTION_KEY is not set")
+	}
+	var err error
+	for _, field := range []*string{&capture.Request, &capture.UpstreamRequest, &capture.Response} {
+		*field, err = common.DecryptString(constant.PayloadCaptureEncryptionKey, *field)
+		if err != nil {
+			return err
+		}
+	}
+	capture.Encrypted = false
+	return nil
+}
+
+// PayloadCaptureRetentionSweeper 定期删除超过保留天数的内容
+func PayloadCaptureRetentionSweeper() {
+	for {
+		if constant.PayloadCaptureRetentionDays > 0 {
+			targetTimestamp := time.Now().AddDate(0, 0, -constant.PayloadCaptureRetentionDays).Unix()
+			count, err := model.DeleteOldPayloadCaptures(targetTimestamp)
+			if err != nil {
+				common.SysError("failed to delete old payload captures: " + err.Error())
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("deleted %d expired payload captures", count))
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
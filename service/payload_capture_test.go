@@ -0,0 +1,77 @@
+package service
+
+import (
+	"net/http/httptest"
+	"one-api/constant"
+	"one-api/model"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setPayloadCaptureMaxBytes(t *testing.T, maxBytes int) {
+	old := constant.PayloadCaptureMaxBytes
+	constant.PayloadCaptureMaxBytes = maxBytes
+	t.Cleanup(func() {
+		constant.PayloadCaptureMaxBytes = old
+	})
+}
+
+func TestPreparePayload(t *testing.T) {
+	asserts := assert.New(t)
+	setPayloadCaptureMaxBytes(t, 8)
+
+	asserts.Equal("12345678...[truncated]", preparePayload("1234567890"))
+	// "你好世界" 每个字 3 字节，截断在字符边界上
+	payload := preparePayload("你好世界")
+	asserts.Equal("你好...[truncated]", payload)
+	asserts.True(utf8.ValidString(payload))
+
+	// 上限为 0 时不截断
+	setPayloadCaptureMaxBytes(t, 0)
+	long := strings.Repeat("你好", 1000)
+	asserts.Equal(long, preparePayload(long))
+	asserts.Equal("key=[REDACTED]", preparePayload("key=sk-abcdefghijklmnopqrstuvwxyz"))
+}
+
+func TestPayloadCaptureWriter(t *testing.T) {
+	asserts := assert.New(t)
+
+	setPayloadCaptureMaxBytes(t, 0)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	writer := &payloadCaptureWriter{ResponseWriter: c.Writer}
+	_, _ = writer.WriteString(strings.Repeat("a", 1024))
+	asserts.False(writer.truncated)
+	asserts.Equal(1024, writer.buffer.Len())
+
+	// 原始数据按上限的 8 倍缓存，这里为 16 字节
+	setPayloadCaptureMaxBytes(t, 2)
+	recorder := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	writer = &payloadCaptureWriter{ResponseWriter: c.Writer}
+	_, _ = writer.Write([]byte("0123456789"))
+	_, _ = writer.Write([]byte("你好世界"))
+	_, _ = writer.Write([]byte("more"))
+	asserts.True(writer.truncated)
+	asserts.Equal("0123456789你好", writer.buffer.String())
+	// 客户端收到完整响应
+	asserts.Equal("0123456789你好世界more", recorder.Body.String())
+}
+
+func TestDecryptPayloadCapture(t *testing.T) {
+	asserts := assert.New(t)
+	old := constant.PayloadCaptureEncryptionKey
+	t.Cleanup(func() {
+		constant.PayloadCaptureEncryptionKey = old
+	})
+
+	capture := &model.PayloadCapture{Request: "request", Response: "response"}
+	asserts.NoError(DecryptPayloadCapture(capture))
+	asserts.Equal("request", capture.Request)
+
+	constant.PayloadCaptureEncryptionKey = ""
+	asserts.Error(DecryptPayloadCapture(&model.PayloadCapture{Encrypted: true}))
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"strings"
+	"time"
+)
+
+const (
+	PaymentProviderEpay   = "epay"
+	PaymentProviderStripe = "stripe"
+)
+
+// 支付回调事件
+const (
+	PaymentEventPaid     = "paid"
+	PaymentEventRefunded = "refunded"
+	PaymentEventIgnored  = "ignored"
+	// PaymentEventRenewed 周期订阅的后续扣款，PaymentEventRecurringCancelled 周期订阅已在支付渠道取消
+	PaymentEventRenewed            = "renewed"
+	PaymentEventRecurringCancelled = "recurring_cancelled"
+)
+
+// PaymentProvider 支付渠道，新增渠道时实现该接口并在 paymentProviders 中注册
+type PaymentProvider interface {
+	Name() string
+	Enabled() bool
+	// Currency 支付币种，UnitPrice 为每 1 美元额度对应的价格
+	Currency() string
+	UnitPrice() float64
+	CreatePayment(order *PaymentOrder) (*PaymentResult, error)
+	// ParseNotify 校验并解析支付回调
+	ParseNotify(c *gin.Context) (*PaymentNotify, error)
+}
+
+// RecurringPaymentProvider 支持周期扣款的支付渠道，开启自动续订的订阅由支付渠道按周期扣款续订
+type RecurringPaymentProvider interface {
+	PaymentProvider
+	// UpdateRecurring 开启或关闭周期订阅在当前周期结束后的续订
+	UpdateRecurring(providerSubscriptionId string, autoRenew bool) error
+	// CancelRecurring 立即取消周期订阅
+	CancelRecurring(providerSubscriptionId string) error
+}
+
+type PaymentOrder struct {
+	TradeNo   string
+	Title     string
+	Money     float64
+	Method    string // 渠道内的支付方式，如易支付的 zfb、wx
+	ReturnUrl string
+	NotifyUrl string
+	// Recurring 周期扣款的周期（week、month），为空时为一次性支付；RecurringMoney 为每期金额，首期按 Money 支付
+	Recurring      string
+	RecurringMoney float64
+}
+
+type PaymentResult struct {
+	Url    string
+	Params map[string]string
+}
+
+type PaymentNotify struct {
+	Event           string
+	TradeNo         string
+	ProviderTradeNo string
+	// Money 支付事件为支付金额，退款事件为累计退款金额
+	Money    float64
+	Currency string
+	// ProviderSubscriptionId 周期订阅的 id，首期支付、续订与取消事件中有值
+	ProviderSubscriptionId string
+}
+
+var paymentProviders = map[string]PaymentProvider{
+	PaymentProviderEpay:   &epayProvider{},
+	PaymentProviderStripe: &stripeProvider{},
+}
+
+func GetPaymentProvider(name string) PaymentProvider {
+	return paymentProviders[name]
+}
+
+// GetRecurringPaymentProvider 返回支持周期扣款的支付渠道，不支持时返回 nil
+func GetRecurringPaymentProvider(name string) RecurringPaymentProvider {
+	provider, _ := paymentProviders[name].(RecurringPaymentProvider)
+	return provider
+}
+
+// GetPaymentProviderByMethod 按前端传入的支付方式选择支付渠道，未指定渠道的均走易支付
+func GetPaymentProviderByMethod(method string) PaymentProvider {
+	if provider, ok := paymentProviders[method]; ok {
+		return provider
+	}
+	return paymentProviders[PaymentProviderEpay]
+}
+
+// ConvertPaymentMoney 将以易支付币种计价的金额（如订阅价格）换算为支付渠道币种的金额
+func ConvertPaymentMoney(money float64, provider PaymentProvider) float64 {
+	if provider.Name() == PaymentProviderEpay || constant.Price <= 0 {
+		return money
+	}
+	return math.Round(money/constant.Price*provider.UnitPrice()*100) / 100
+}
+
+func paymentMoneyToQuota(money float64, provider PaymentProvider) int {
+	if provider.UnitPrice() <= 0 {
+		return 0
+	}
+	return int(math.Round(money / provider.UnitPrice() * common.QuotaPerUnit))
+}
+
+// verifyPaidAmount 校验回调的支付币种与配置一致，支付金额与订单金额一致（按最小货币单位比较）
+func verifyPaidAmount(provider PaymentProvider, notify *PaymentNotify, money float64) error {
+	currency := strings.ToLower(provider.Currency())
+	if strings.ToLower(notify.Currency) != currency {
+		return fmt.Errorf("订单 %s 支付币种 %s 与配置的币种 %s 不一致", notify.TradeNo, notify.Currency, currency)
+	}
+	if stripeToMinorUnit(notify.Money, currency) != stripeToMinorUnit(money, currency) {
+		return fmt.Errorf("订单 %s 支付金额 %.2f 与订单金额 %.2f 不一致", notify.TradeNo, notify.Money, money)
+	}
+	return nil
+}
+
+// HandlePaymentNotify 处理已校验的支付回调，根据订单号分别为充值订单入账或开通订阅，重复回调不会重复处理
+func HandlePaymentNotify(provider PaymentProvider, notify *PaymentNotify) error {
+	switch notify.Event {
+	case PaymentEventPaid:
+		if order := model.GetSubscriptionOrderByTradeNo(notify.TradeNo); order != nil {
+			if err := verifyPaidAmount(provider, notify, order.Money); err != nil {
+				return err
+			}
+			order.ProviderTradeNo = notify.ProviderTradeNo
+			order.ProviderSubscriptionId = notify.ProviderSubscriptionId
+			subscription, err := CompleteSubscriptionOrder(order, 0)
+			if err != nil {
+				return err
+			}
+			if subscription != nil {
+				NotifyTopUpCompleted(order.UserId, paymentMoneyToQuota(order.Money, provider), "订阅")
+			}
+			return nil
+		}
+		topUp := model.GetTopUpByTradeNo(notify.TradeNo)
+		if topUp == nil {
+			return errors.New("充值订单不存在")
+		}
+		if err := verifyPaidAmount(provider, notify, topUp.Money); err != nil {
+			return err
+		}
+		topUp, completed, err := model.CompleteTopUp(notify.TradeNo, notify.ProviderTradeNo)
+		if err != nil {
+			return err
+		}
+		if completed {
+			quota := topUp.Amount * int(common.QuotaPerUnit)
+			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%.2f %s", common.LogQuota(quota), topUp.Money, topUp.Currency))
+			NotifyTopUpCompleted(topUp.UserId, quota, provider.Name())
+		}
+	case PaymentEventRefunded:
+		if order := model.GetSubscriptionOrderByProviderTradeNo(notify.ProviderTradeNo); order != nil {
+			return refundSubscriptionOrder(provider, order, notify.Money)
+		}
+		topUp, deducted, err := model.RefundTopUp(notify.ProviderTradeNo, notify.Money)
+		if err != nil {
+			return err
+		}
+		if deducted > 0 {
+			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("在线充值退款，退款金额：%.2f %s，扣除额度 %s", notify.Money, topUp.Currency, common.LogQuota(deducted)))
+		}
+	case PaymentEventRenewed:
+		return renewSubscriptionFromProvider(provider, notify)
+	case PaymentEventRecurringCancelled:
+		subscription, err := model.GetUserSubscriptionByProviderSubscriptionId(notify.ProviderSubscriptionId)
+		if err != nil || !subscription.AutoRenew {
+			return nil
+		}
+		// 支付渠道已不再扣款，订阅在当前周期结束后正常过期
+		return subscription.UpdateAutoRenew(false)
+	}
+	return nil
+}
+
+// renewSubscriptionFromProvider 支付渠道按周期扣款后续订一个周期，每次扣款记录为一笔订阅订单，重复回调不会重复续订
+func renewSubscriptionFromProvider(provider PaymentProvider, notify *PaymentNotify) error {
+	if model.GetSubscriptionOrderByProviderTradeNo(notify.ProviderTradeNo) != nil {
+		return nil
+	}
+	subscription, err := model.GetUserSubscriptionByProviderSubscriptionId(notify.ProviderSubscriptionId)
+	if err != nil {
+		return fmt.Errorf("周期订阅 %s 对应的订阅不存在", notify.ProviderSubscriptionId)
+	}
+	plan, err := model.GetSubscriptionPlanById(subscription.PlanId)
+	if err != nil {
+		return err
+	}
+	order := &model.SubscriptionOrder{
+		UserId:                 subscription.UserId,
+		PlanId:                 plan.Id,
+		SubscriptionId:         subscription.Id,
+		Money:                  notify.Money,
+		TradeNo:                fmt.Sprintf("S%s%d", common.GetRandomString(6), time.Now().Unix()),
+		PaymentMethod:          provider.Name(),
+		ProviderTradeNo:        notify.ProviderTradeNo,
+		ProviderSubscriptionId: notify.ProviderSubscriptionId,
+		AutoRenew:              true,
+		Status:                 "success",
+		CreateTime:             time.Now().Unix(),
+	}
+	if err = order.Insert(); err != nil {
+		return err
+	}
+	if subscription.Status != model.SubscriptionStatusActive {
+		// 订阅已过期、升级或取消，不再续订，停止后续扣款，本期扣款由管理员退款
+		cancelSubscriptionRecurring(subscription)
+		model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅「%s」已失效，支付渠道扣款 %.2f %s 未续订，订单号 %s",
+			plan.Name, notify.Money, notify.Currency, order.TradeNo))
+		return nil
+	}
+	if err = model.RenewUserSubscription(subscription, plan, 0); err != nil {
+		_ = model.DB.Delete(order).Error
+		return err
+	}
+	model.RecordLog(subscription.UserId, model.LogTypeTopup, fmt.Sprintf("订阅「%s」已由支付渠道自动续订，支付金额：%.2f %s", plan.Name, notify.Money, notify.Currency))
+	return nil
+}
+
+// cancelSubscriptionRecurring 立即取消订阅在支付渠道的周期扣款，失败时只记录错误
+func cancelSubscriptionRecurring(subscription *model.UserSubscription) {
+	if subscription.ProviderSubscriptionId == "" {
+		return
+	}
+	provider := GetRecurringPaymentProvider(subscription.PaymentProvider)
+	if provider == nil {
+		return
+	}
+	if err := provider.CancelRecurring(subscription.ProviderSubscriptionId); err != nil {
+		common.SysError(fmt.Sprintf("failed to cancel recurring payment of subscription #%d: %s", subscription.Id, err.Error()))
+	}
+}
+
+// UpdateSubscriptionAutoRenew 开启或关闭自动续订，有周期订阅时同步到支付渠道
+func UpdateSubscriptionAutoRenew(subscription *model.UserSubscription, autoRenew bool) error {
+	if subscription.ProviderSubscriptionId != "" {
+		provider := GetRecurringPaymentProvider(subscription.PaymentProvider)
+		if provider == nil {
+			return errors.New("支付渠道不支持自动续订")
+		}
+		if err := provider.UpdateRecurring(subscription.ProviderSubscriptionId, autoRenew); err != nil {
+			common.SysError("failed to update recurring payment: " + err.Error())
+			return errors.New("更新支付渠道的自动续订失败")
+		}
+	}
+	return subscription.UpdateAutoRenew(autoRenew)
+}
+
+// refundSubscriptionOrder 订阅订单全额退款后立即取消对应的订阅，部分退款只记录累计退款金额，重复回调不会重复处理
+func refundSubscriptionOrder(provider PaymentProvider, order *model.SubscriptionOrder, refundedMoney float64) error {
+	if order.Status == "refunded" || refundedMoney <= order.RefundedMoney {
+		return nil
+	}
+	order.RefundedMoney = refundedMoney
+	currency := strings.ToLower(provider.Currency())
+	if stripeToMinorUnit(refundedMoney, currency) < stripeToMinorUnit(order.Money, currency) {
+		// 部分退款只记录退款金额，订阅保留
+		if err := order.Update(); err != nil {
+			return err
+		}
+		model.RecordLog(order.UserId, model.LogTypeTopup, fmt.Sprintf("订阅订单 %s 部分退款，累计退款金额：%.2f %s，订阅保留", order.TradeNo, refundedMoney, currency))
+		return nil
+	}
+	order.Status = "refunded"
+	if err := order.Update(); err != nil {
+		return err
+	}
+	subscription, err := model.GetUserSubscriptionById(order.SubscriptionId)
+	if err != nil {
+		return nil
+	}
+	plan, err := model.GetSubscriptionPlanById(subscription.PlanId)
+	if err != nil {
+		plan = &model.SubscriptionPlan{}
+	}
+	if err = model.ExpireUserSubscription(subscription, model.SubscriptionStatusCancelled, plan.UpgradeGroup); err != nil {
+		return err
+	}
+	cancelSubscriptionRecurring(subscription)
+	model.RecordLog(order.UserId, model.LogTypeTopup, fmt.Sprintf("订阅订单 %s 已退款，订阅已取消", order.TradeNo))
+	return nil
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"one-api/common"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitMinute = int64(60)
+	rateLimitDay    = int64(24 * 60 * 60)
+)
+
+var minuteRequestLimiter common.InMemoryRateLimiter
+var dayRequestLimiter common.InMemoryRateLimiter
+var minuteTokenCounter common.InMemoryUsageCounter
+
+var requestWindowLock sync.Mutex
+
+// 多个滑动窗口计数，先检查全部窗口，全部未超出限制时才在每个窗口记录本次请求；
+// ARGV 依次为 当前毫秒时间、成员、每个窗口的 窗口毫秒数与上限，
+// 返回 被拒绝窗口的下标（全部通过为 -1），以及每个窗口的请求数与最早一次请求的毫秒时间戳
+var requestWindowsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local result = {-1}
+for i = 1, #KEYS do
+	local window = tonumber(ARGV[i * 2 + 1])
+	redis.call('ZREMRANGEBYSCORE', KEYS[i], 0, now - window)
+	local count = redis.call('ZCARD', KEYS[i])
+	local oldest = now
+	if count > 0 then
+		oldest = tonumber(redis.call('ZRANGE', KEYS[i], 0, 0, 'WITHSCORES')[2])
+	end
+	if result[1] == -1 and count >= tonumber(ARGV[i * 2 + 2]) then
+		result[1] = i - 1
+	end
+	result[i * 2] = count
+	result[i * 2 + 1] = oldest
+end
+if result[1] ~= -1 then
+	return result
+end
+for i = 1, #KEYS do
+	redis.call('ZADD', KEYS[i], now, ARGV[2])
+	redis.call('PEXPIRE', KEYS[i], tonumber(ARGV[i * 2 + 1]))
+	result[i * 2] = result[i * 2] + 1
+end
+return result
+`)
+
+// 汇总窗口内的 token 用量，成员格式为 "唯一标识:token 数"
+var tokenWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], 0, now - tonumber(ARGV[2]))
+local items = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+local total = 0
+local oldest = 0
+for i = 1, #items, 2 do
+	total = total + tonumber(string.match(items[i], ':(%d+)$'))
+	if i == 1 then
+		oldest = tonumber(items[i + 1])
+	end
+end
+return {total, oldest}
+`)
+
+// 将预留的 token 数修正为实际用量，保持原记录时间；ARGV 依次为 原成员、新成员、当前毫秒时间、新用量、窗口毫秒数
+var tokenSettleScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	score = ARGV[3]
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('ZADD', KEYS[1], score, ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+end
+return 1
+`)
+
+type rateLimitScope struct {
+	name   string
+	key    string
+	config common.RateLimitConfig
+}
+
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// RateLimitResult 本次请求各限流范围中剩余最少的请求数与 token 数，Exceeded 非空表示已被限流
+type RateLimitResult struct {
+	Requests *RateLimitStatus
+	Tokens   *RateLimitStatus
+	Exceeded string
+	Type     string
+}
+
+func (result *RateLimitResult) setRequests(status *RateLimitStatus) {
+	if result.Requests == nil || status.Remaining < result.Requests.Remaining {
+		result.Requests = status
+	}
+}
+
+func (result *RateLimitResult) setTokens(status *RateLimitStatus) {
+	if result.Tokens == nil || status.Remaining < result.Tokens.Remaining {
+		result.Tokens = status
+	}
+}
+
+// getRateLimitScopes 返回本次请求需要检查的限流范围：令牌、用户与用户分组
+func getRateLimitScopes(c *gin.Context) []rateLimitScope {
+	scopes := make([]rateLimitScope, 0, 3)
+	if tokenRateLimit, ok := c.Get("token_rate_limit"); ok {
+		config := tokenRateLimit.(common.RateLimitConfig)
+		if !config.IsEmpty() {
+			scopes = append(scopes, rateLimitScope{name: "token", key: fmt.Sprintf("token:%d", c.GetInt("token_id")), config: config})
+		}
+	}
+	userId := c.GetInt("id")
+	if userId != 0 {
+		config, err := model.CacheGetUserRateLimit(userId)
+		if err != nil {
+			common.SysError("failed to get user rate limit: " + err.Error())
+		} else if !config.IsEmpty() {
+			scopes = append(scopes, rateLimitScope{name: "user", key: fmt.Sprintf("user:%d", userId), config: config})
+		}
+	}
+	if group := c.GetString("group"); group != "" {
+		config := common.GetGroupRateLimit(group)
+		if !config.IsEmpty() {
+			scopes = append(scopes, rateLimitScope{name: "group", key: "group:" + group, config: config})
+		}
+	}
+	return scopes
+}
+
+func resetDuration(oldest int64, window int64) time.Duration {
+	reset := time.Until(time.UnixMilli(oldest).Add(time.Duration(window) * time.Second)).Round(time.Second)
+	if reset < time.Second {
+		reset = time.Second
+	}
+	return reset
+}
+
+type requestLimit struct {
+	scope  string
+	key    string
+	mark   string
+	desc   string
+	limit  int
+	window int64
+}
+
+type requestWindowUsage struct {
+	count  int
+	oldest int64
+}
+
+func getRequestLimiter(window int64) *common.InMemoryRateLimiter {
+	limiter := &minuteRequestLimiter
+	if window > rateLimitMinute {
+		limiter = &dayRequestLimiter
+	}
+	limiter.Init(time.Duration(window) * time.Second)
+	return limiter
+}
+
+// requestWindows 检查全部请求频率限制，全部通过后才记录本次请求，避免前面的范围被占用而后面的范围拒绝；
+// 返回被拒绝的限制下标（全部通过为 -1），以及各限制窗口内的请求数与最早一次请求的毫秒时间戳
+func requestWindows(limits []requestLimit) (int, []requestWindowUsage, error) {
+	usages := make([]requestWindowUsage, len(limits))
+	if common.RedisEnabled {
+		now := time.Now().UnixMilli()
+		keys := make([]string, 0, len(limits))
+		args := []interface{}{now, fmt.Sprintf("%d:%s", now, common.GetRandomString(8))}
+		for _, limit := range limits {
+			keys = append(keys, "rateLimit:"+limit.mark+":"+limit.key)
+			args = append(args, limit.window*1000, limit.limit)
+		}
+		result, err := requestWindowsScript.Run(context.Background(), common.RDB, keys, args...).Int64Slice()
+		if err != nil {
+			return -1, nil, err
+		}
+		for i := range limits {
+			usages[i] = requestWindowUsage{count: int(result[i*2+1]), oldest: result[i*2+2]}
+		}
+		return int(result[0]), usages, nil
+	}
+	requestWindowLock.Lock()
+	defer requestWindowLock.Unlock()
+	now := time.Now().Unix()
+	rejected := -1
+	for i, limit := range limits {
+		count, oldest := getRequestLimiter(limit.window).Usage(limit.mark+limit.key, limit.window)
+		if count == 0 {
+			oldest = now
+		}
+		usages[i] = requestWindowUsage{count: count, oldest: oldest * 1000}
+		if rejected == -1 && count >= limit.limit {
+			rejected = i
+		}
+	}
+	if rejected != -1 {
+		return rejected, usages, nil
+	}
+	for i, limit := range limits {
+		getRequestLimiter(limit.window).Request(limit.mark+limit.key, limit.limit, limit.window)
+		usages[i].count++
+	}
+	return -1, usages, nil
+}
+
+// tokenWindow 返回最近一分钟内的 token 用量与最早一条记录的毫秒时间戳
+func tokenWindow(key string) (int, int64, error) {
+	if common.RedisEnabled {
+		result, err := tokenWindowScript.Run(context.Background(), common.RDB, []string{"rateLimit:TPM:" + key},
+			time.Now().UnixMilli(), rateLimitMinute*1000).Int64Slice()
+		if err != nil {
+			return 0, 0, err
+		}
+		return int(result[0]), result[1], nil
+	}
+	minuteTokenCounter.Init(rateLimitMinute)
+	total, oldest := minuteTokenCounter.Sum(key)
+	return total, oldest * 1000, nil
+}
+
+// CheckRateLimit 检查令牌、用户、分组的 RPM/TPM/RPD 限制，全部通过时才在各范围记录本次请求；
+// 准入时判断窗口内已用与预留的 token 数是否达到上限，实际用量在请求开始后通过 ReserveRateLimitTokens 预留
+func CheckRateLimit(c *gin.Context) *RateLimitResult {
+	result := &RateLimitResult{}
+	scopes := getRateLimitScopes(c)
+	var limits []requestLimit
+	for _, scope := range scopes {
+		if scope.config.TPM > 0 {
+			used, oldest, err := tokenWindow(scope.key)
+			if err != nil {
+				common.SysError("failed to check tpm rate limit: " + err.Error())
+			} else {
+				status := &RateLimitStatus{Limit: scope.config.TPM, Remaining: scope.config.TPM - used, Reset: resetDuration(oldest, rateLimitMinute)}
+				if status.Remaining < 0 {
+					status.Remaining = 0
+				}
+				result.setTokens(status)
+				if used >= scope.config.TPM {
+					result.Type = "tokens"
+					result.Exceeded = fmt.Sprintf("Rate limit reached for tokens per min (TPM) on %s: Limit %d, Used %d. Please try again in %s.",
+						scope.name, scope.config.TPM, used, status.Reset)
+					return result
+				}
+			}
+		}
+		if scope.config.RPM > 0 {
+			limits = append(limits, requestLimit{scope: scope.name, key: scope.key, mark: "RPM", desc: "requests per min (RPM)", limit: scope.config.RPM, window: rateLimitMinute})
+		}
+		if scope.config.RPD > 0 {
+			limits = append(limits, requestLimit{scope: scope.name, key: scope.key, mark: "RPD", desc: "requests per day (RPD)", limit: scope.config.RPD, window: rateLimitDay})
+		}
+	}
+	if len(limits) == 0 {
+		return result
+	}
+	rejected, usages, err := requestWindows(limits)
+	if err != nil {
+		common.SysError("failed to check request rate limit: " + err.Error())
+		return result
+	}
+	for i, limit := range limits {
+		status := &RateLimitStatus{Limit: limit.limit, Remaining: limit.limit - usages[i].count, Reset: resetDuration(usages[i].oldest, limit.window)}
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+		result.setRequests(status)
+	}
+	if rejected != -1 {
+		limit := limits[rejected]
+		reset := resetDuration(usages[rejected].oldest, limit.window)
+		result.Requests = &RateLimitStatus{Limit: limit.limit, Remaining: 0, Reset: reset}
+		result.Type = "requests"
+		result.Exceeded = fmt.Sprintf("Rate limit reached for %s on %s: Limit %d, Used %d. Please try again in %s.",
+			limit.desc, limit.scope, limit.limit, usages[rejected].count, reset)
+	}
+	return result
+}
+
+// updateTokenUsage 在所有开启 TPM 的范围中记录或修正一条 token 用量
+func updateTokenUsage(c *gin.Context, id string, tokens int, reserve bool) {
+	for _, scope := range getRateLimitScopes(c) {
+		if scope.config.TPM <= 0 {
+			continue
+		}
+		if common.RedisEnabled {
+			key := "rateLimit:TPM:" + scope.key
+			now := time.Now().UnixMilli()
+			var err error
+			if reserve {
+				err = common.RDB.ZAdd(context.Background(), key, &redis.Z{
+					Score:  float64(now),
+					Member: fmt.Sprintf("%s:%d", id, tokens),
+				}).Err()
+				if err == nil {
+					err = common.RDB.Expire(context.Background(), key, time.Duration(rateLimitMinute)*time.Second).Err()
+				}
+			} else {
+				err = tokenSettleScript.Run(context.Background(), common.RDB, []string{key},
+					fmt.Sprintf("%s:%d", id, c.GetInt("rate_limit_reserved_tokens")), fmt.Sprintf("%s:%d", id, tokens),
+					now, tokens, rateLimitMinute*1000).Err()
+			}
+			if err != nil {
+				common.SysError("failed to record tpm usage: " + err.Error())
+			}
+			continue
+		}
+		minuteTokenCounter.Init(rateLimitMinute)
+		if reserve {
+			minuteTokenCounter.Add(scope.key, id, tokens)
+		} else {
+			minuteTokenCounter.Update(scope.key, id, tokens)
+		}
+	}
+}
+
+// ReserveRateLimitTokens 请求开始时按预估 token 数预留 TPM 额度，使并发请求能看到尚未完成的用量
+func ReserveRateLimitTokens(c *gin.Context, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	id := fmt.Sprintf("%d%s", time.Now().UnixNano(), common.GetRandomString(4))
+	updateTokenUsage(c, id, tokens, true)
+	c.Set("rate_limit_reservation", id)
+	c.Set("rate_limit_reserved_tokens", tokens)
+}
+
+// RecordRateLimitUsage 请求结束后将预留的 token 数修正为实际用量，请求失败时传 0 释放预留
+func RecordRateLimitUsage(c *gin.Context, tokens int) {
+	id := c.GetString("rate_limit_reservation")
+	if id == "" {
+		if tokens <= 0 {
+			return
+		}
+		id = fmt.Sprintf("%d%s", time.Now().UnixNano(), common.GetRandomString(4))
+	}
+	c.Set("rate_limit_reservation", "")
+	updateTokenUsage(c, id, tokens, false)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitTestContext(tokenId int, tokenLimit common.RateLimitConfig, group string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Set("token_id", tokenId)
+	c.Set("token_rate_limit", tokenLimit)
+	c.Set("group", group)
+	return c
+}
+
+func setupRateLimit(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+		common.GroupRateLimit = map[string]common.RateLimitConfig{}
+	})
+}
+
+func TestCheckRateLimitAllScopesOrNothing(t *testing.T) {
+	setupRateLimit(t)
+	asserts := assert.New(t)
+	common.GroupRateLimit = map[string]common.RateLimitConfig{"scope-test": {RPM: 2}}
+
+	// 两个令牌共享分组额度，分组拒绝时令牌范围不应被占用
+	asserts.Empty(CheckRateLimit(newRateLimitTestContext(9001, common.RateLimitConfig{RPM: 2}, "scope-test")).Exceeded)
+	asserts.Empty(CheckRateLimit(newRateLimitTestContext(9002, common.RateLimitConfig{RPM: 2}, "scope-test")).Exceeded)
+	result := CheckRateLimit(newRateLimitTestContext(9001, common.RateLimitConfig{RPM: 2}, "scope-test"))
+	asserts.Contains(result.Exceeded, "on group")
+	asserts.Equal("requests", result.Type)
+	asserts.Equal(0, result.Requests.Remaining)
+
+	count, _ := getRequestLimiter(rateLimitMinute).Usage("RPM"+"token:9001", rateLimitMinute)
+	asserts.Equal(1, count)
+}
+
+func TestReserveRateLimitTokens(t *testing.T) {
+	setupRateLimit(t)
+	asserts := assert.New(t)
+	config := common.RateLimitConfig{TPM: 1000}
+
+	first := newRateLimitTestContext(9101, config, "")
+	asserts.Empty(CheckRateLimit(first).Exceeded)
+	ReserveRateLimitTokens(first, 1000)
+	// 预留的 token 对并发请求可见
+	result := CheckRateLimit(newRateLimitTestContext(9101, config, ""))
+	asserts.Equal("tokens", result.Type)
+	asserts.Equal(0, result.Tokens.Remaining)
+
+	// 按实际用量修正后释放多余的预留
+	RecordRateLimitUsage(first, 300)
+	used, _, _ := tokenWindow("token:9101")
+	asserts.Equal(300, used)
+	RecordRateLimitUsage(first, 0)
+	used, _, _ = tokenWindow("token:9101")
+	asserts.Equal(300, used)
+
+	// 失败的请求释放全部预留
+	second := newRateLimitTestContext(9101, config, "")
+	ReserveRateLimitTokens(second, 500)
+	used, _, _ = tokenWindow("token:9101")
+	asserts.Equal(800, used)
+	RecordRateLimitUsage(second, 0)
+	used, _, _ = tokenWindow("token:9101")
+	asserts.Equal(300, used)
+}
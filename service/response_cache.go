@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"sync"
+	"time"
+)
+
+// CachedResponse 缓存的上游响应，流式请求保存原始 SSE 内容，命中时原样回放
+type CachedResponse struct {
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Usage       dto.Usage `json:"usage"`
+}
+
+type memoryCacheEntry struct {
+	value    []byte
+	expireAt int64
+}
+
+var responseCacheStore = make(map[string]*memoryCacheEntry)
+var responseCacheLock sync.Mutex
+var responseCacheSweepOnce sync.Once
+
+// ResponseCacheKey 由用户、请求的模型、请求类型与规范化后的请求参数生成缓存 key
+func ResponseCacheKey(userId int, modelName string, relayMode int, includeUsage bool, request dto.GeneralOpenAIRequest) string {
+	request.Model = modelName
+	request.StreamOptions = nil
+	request.User = ""
+	jsonData, err := json.Marshal(struct {
+		UserId       int                      `json:"user_id"`
+		RelayMode    int                      `json:"relay_mode"`
+		IncludeUsage bool                     `json:"include_usage"`
+		Request      dto.GeneralOpenAIRequest `json:"request"`
+	}{userId, relayMode, includeUsage, request})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(jsonData)
+	return "response_cache:" + hex.EncodeToString(sum[:])
+}
+
+func sweepResponseCache() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now().Unix()
+		responseCacheLock.Lock()
+		for key, entry := range responseCacheStore {
+			if entry.expireAt <= now {
+				delete(responseCacheStore, key)
+			}
+		}
+		responseCacheLock.Unlock()
+		sweepSemanticCache(now)
+	}
+}
+
+func GetCachedResponse(key string) (*CachedResponse, bool) {
+	var value []byte
+	if common.RedisEnabled {
+		valueString, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		value = []byte(valueString)
+	} else {
+		responseCacheLock.Lock()
+		entry, ok := responseCacheStore[key]
+		responseCacheLock.Unlock()
+		if !ok || entry.expireAt <= time.Now().Unix() {
+			return nil, false
+		}
+		value = entry.value
+	}
+	cached := &CachedResponse{}
+	if err := json.Unmarshal(value, cached); err != nil {
+		return nil, false
+	}
+	return cached, true
+}
+
+func SetCachedResponse(key string, cached *CachedResponse) {
+	value, err := json.Marshal(cached)
+	if err != nil {
+		common.SysError("failed to marshal cached response: " + err.Error())
+		return
+	}
+	ttl := time.Duration(constant.ResponseCacheTTL) * time.Second
+	if common.RedisEnabled {
+		err = common.RedisSet(key, string(value), ttl)
+		if err != nil {
+			common.SysError("failed to set response cache: " + err.Error())
+		}
+		return
+	}
+	responseCacheSweepOnce.Do(func() {
+		go sweepResponseCache()
+	})
+	responseCacheLock.Lock()
+	defer responseCacheLock.Unlock()
+	if _, ok := responseCacheStore[key]; !ok && len(responseCacheStore) >= constant.ResponseCacheMemoryEntries {
+		// 缓存已满时随机淘汰一个
+		for k := range responseCacheStore {
+			delete(responseCacheStore, k)
+			break
+		}
+	}
+	responseCacheStore[key] = &memoryCacheEntry{value: value, expireAt: time.Now().Add(ttl).Unix()}
+}
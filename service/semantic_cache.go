@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SemanticCacheEmbedder 计算语义缓存向量，由 controller 注入，直接通过渠道调用 embeddings 模型，不计入用户的用量
+var SemanticCacheEmbedder func(ctx context.Context, text string) ([]float64, error)
+
+// semanticCacheBucketSize 每个分桶最多保留的向量数，超出时淘汰最早的
+const semanticCacheBucketSize = 100
+
+type semanticCacheEntry struct {
+	Key       string    `json:"key"`
+	Embedding []float64 `json:"embedding"`
+	ExpireAt  int64     `json:"expire_at"`
+}
+
+var semanticCacheStore = make(map[string][]*semanticCacheEntry)
+var semanticCacheLock sync.Mutex
+
+// SemanticCacheBucket 除对话内容外其余参数都相同的请求落在同一分桶，只在分桶内比较相似度
+func SemanticCacheBucket(userId int, modelName string, relayMode int, includeUsage bool, request dto.GeneralOpenAIRequest) string {
+	request.Messages = nil
+	key := ResponseCacheKey(userId, modelName, relayMode, includeUsage, request)
+	if key == "" {
+		return ""
+	}
+	return "semantic_cache:" + strings.TrimPrefix(key, "response_cache:")
+}
+
+// SemanticCacheText 返回用于计算向量的对话文本，包含图片、工具调用等非文本内容时返回空，不使用语义缓存
+func SemanticCacheText(messages []dto.Message) string {
+	var builder strings.Builder
+	for _, message := range messages {
+		if message.ToolCalls != nil || message.ToolCallId != "" {
+			return ""
+		}
+		builder.WriteString(message.Role)
+		builder.WriteString(": ")
+		for _, content := range message.ParseContent() {
+			if content.Type != dto.ContentTypeText {
+				return ""
+			}
+			builder.WriteString(content.Text)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// GetTextEmbedding 使用语义缓存配置的 embeddings 模型计算文本向量
+func GetTextEmbedding(ctx context.Context, text string) ([]float64, error) {
+	if SemanticCacheEmbedder == nil {
+		return nil, errors.New("semantic cache embedder is not initialized")
+	}
+	return SemanticCacheEmbedder(ctx, text)
+}
+
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func getSemanticCacheEntries(bucket string) []*semanticCacheEntry {
+	if common.RedisEnabled {
+		values, err := common.RDB.LRange(context.Background(), bucket, 0, -1).Result()
+		if err != nil {
+			return nil
+		}
+		entries := make([]*semanticCacheEntry, 0, len(values))
+		for _, value := range values {
+			entry := &semanticCacheEntry{}
+			if json.Unmarshal([]byte(value), entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	semanticCacheLock.Lock()
+	defer semanticCacheLock.Unlock()
+	return append([]*semanticCacheEntry(nil), semanticCacheStore[bucket]...)
+}
+
+// FindSemanticCachedResponse 在分桶中查找最相似的请求，相似度达到阈值且响应仍在缓存中时返回
+func FindSemanticCachedResponse(bucket string, embedding []float64) (*CachedResponse, float64, bool) {
+	now := time.Now().Unix()
+	var best *semanticCacheEntry
+	bestSimilarity := 0.0
+	for _, entry := range getSemanticCacheEntries(bucket) {
+		if entry.ExpireAt <= now {
+			continue
+		}
+		similarity := cosineSimilarity(embedding, entry.Embedding)
+		if similarity > bestSimilarity {
+			best = entry
+			bestSimilarity = similarity
+		}
+	}
+	if best == nil || bestSimilarity < constant.SemanticCacheThreshold {
+		return nil, 0, false
+	}
+	cached, ok := GetCachedResponse(best.Key)
+	return cached, bestSimilarity, ok
+}
+
+// AddSemanticCacheEntry 记录已缓存响应的请求向量，供之后相似的请求命中
+func AddSemanticCacheEntry(bucket string, key string, embedding []float64) {
+	ttl := time.Duration(constant.ResponseCacheTTL) * time.Second
+	entry := &semanticCacheEntry{Key: key, Embedding: embedding, ExpireAt: time.Now().Add(ttl).Unix()}
+	if common.RedisEnabled {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			common.SysError("failed to marshal semantic cache entry: " + err.Error())
+			return
+		}
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		pipe.LPush(ctx, bucket, value)
+		pipe.LTrim(ctx, bucket, 0, semanticCacheBucketSize-1)
+		pipe.Expire(ctx, bucket, ttl)
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			common.SysError("failed to set semantic cache: " + err.Error())
+		}
+		return
+	}
+	responseCacheSweepOnce.Do(func() {
+		go sweepResponseCache()
+	})
+	semanticCacheLock.Lock()
+	defer semanticCacheLock.Unlock()
+	if _, ok := semanticCacheStore[bucket]; !ok && len(semanticCacheStore) >= constant.ResponseCacheMemoryEntries {
+		// 分桶数已满时随机淘汰一个
+		for k := range semanticCacheStore {
+			delete(semanticCacheStore, k)
+			break
+		}
+	}
+	entries := append([]*semanticCacheEntry{entry}, semanticCacheStore[bucket]...)
+	if len(entries) > semanticCacheBucketSize {
+		entries = entries[:semanticCacheBucketSize]
+	}
+	semanticCacheStore[bucket] = entries
+}
+
+func sweepSemanticCache(now int64) {
+	semanticCacheLock.Lock()
+	defer semanticCacheLock.Unlock()
+	for bucket, entries := range semanticCacheStore {
+		remaining := entries[:0]
+		for _, entry := range entries {
+			if entry.ExpireAt > now {
+				remaining = append(remaining, entry)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(semanticCacheStore, bucket)
+		} else {
+			semanticCacheStore[bucket] = remaining
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemanticCacheText(t *testing.T) {
+	asserts := assert.New(t)
+	messages := []dto.Message{
+		{Role: "system", Content: json.RawMessage(`"be brief"`)},
+		{Role: "user", Content: json.RawMessage(`[{"type": "text", "text": "hello"}]`)},
+	}
+	asserts.Equal("system: be brief\nuser: hello\n", SemanticCacheText(messages))
+
+	// 图片与工具调用不使用语义缓存
+	image := append(messages, dto.Message{Role: "user", Content: json.RawMessage(`[{"type": "image_url", "image_url": {"url": "https://example.com/a.png"}}]`)})
+	asserts.Empty(SemanticCacheText(image))
+	tool := append(messages, dto.Message{Role: "tool", Content: json.RawMessage(`"42"`), ToolCallId: "call_1"})
+	asserts.Empty(SemanticCacheText(tool))
+}
+
+func TestSemanticCacheBucket(t *testing.T) {
+	asserts := assert.New(t)
+	request := dto.GeneralOpenAIRequest{Messages: []dto.Message{{Role: "user", Content: json.RawMessage(`"a"`)}}, Temperature: 0.5}
+	other := request
+	other.Messages = []dto.Message{{Role: "user", Content: json.RawMessage(`"b"`)}}
+	asserts.Equal(SemanticCacheBucket(1, "gpt-4o", 1, false, request), SemanticCacheBucket(1, "gpt-4o", 1, false, other))
+	other.Temperature = 1
+	asserts.NotEqual(SemanticCacheBucket(1, "gpt-4o", 1, false, request), SemanticCacheBucket(1, "gpt-4o", 1, false, other))
+	asserts.NotEqual(SemanticCacheBucket(1, "gpt-4o", 1, false, request), SemanticCacheBucket(2, "gpt-4o", 1, false, request))
+}
+
+func TestSemanticCache(t *testing.T) {
+	asserts := assert.New(t)
+	redisEnabled, embedder := common.RedisEnabled, SemanticCacheEmbedder
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled, SemanticCacheEmbedder = redisEnabled, embedder
+	})
+	embeddings := map[string][]float64{
+		"user: what is go\n":          {1, 0, 0},
+		"user: what is golang\n":      {0.99, 0.1, 0},
+		"user: how to cook noodles\n": {0, 0, 1},
+	}
+	SemanticCacheEmbedder = func(ctx context.Context, text string) ([]float64, error) {
+		return embeddings[text], nil
+	}
+	ctx := context.Background()
+
+	embedding, err := GetTextEmbedding(ctx, "user: what is go\n")
+	asserts.NoError(err)
+	SetCachedResponse("response_cache:go", &CachedResponse{ContentType: "application/json", Body: []byte(`{"id": "go"}`)})
+	AddSemanticCacheEntry("semantic_cache:test", "response_cache:go", embedding)
+
+	similar, err := GetTextEmbedding(ctx, "user: what is golang\n")
+	asserts.NoError(err)
+	cached, similarity, ok := FindSemanticCachedResponse("semantic_cache:test", similar)
+	asserts.True(ok)
+	asserts.Greater(similarity, constant.SemanticCacheThreshold)
+	asserts.Equal(`{"id": "go"}`, string(cached.Body))
+
+	different, err := GetTextEmbedding(ctx, "user: how to cook noodles\n")
+	asserts.NoError(err)
+	_, _, ok = FindSemanticCachedResponse("semantic_cache:test", different)
+	asserts.False(ok)
+	// 其他分桶不会命中
+	_, _, ok = FindSemanticCachedResponse("semantic_cache:other", similar)
+	asserts.False(ok)
+}
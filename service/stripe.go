@@ -0,0 +1,315 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/constant"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance webhook 签名时间戳允许的误差，防止重放
+const stripeSignatureTolerance = 5 * time.Minute
+
+// 无小数位的币种，金额不需要乘以 100
+var stripeZeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+var stripeHttpClient = &http.Client{Timeout: 30 * time.Second}
+
+type stripeProvider struct{}
+
+type stripeCheckoutSession struct {
+	Id                string            `json:"id"`
+	Url               string            `json:"url"`
+	Mode              string            `json:"mode"`
+	ClientReferenceId string            `json:"client_reference_id"`
+	PaymentStatus     string            `json:"payment_status"`
+	PaymentIntent     string            `json:"payment_intent"`
+	Subscription      string            `json:"subscription"`
+	Invoice           string            `json:"invoice"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeCharge struct {
+	PaymentIntent  string            `json:"payment_intent"`
+	Invoice        string            `json:"invoice"`
+	AmountRefunded int64             `json:"amount_refunded"`
+	Currency       string            `json:"currency"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+type stripeInvoice struct {
+	Id            string `json:"id"`
+	Subscription  string `json:"subscription"`
+	BillingReason string `json:"billing_reason"`
+	AmountPaid    int64  `json:"amount_paid"`
+	Currency      string `json:"currency"`
+}
+
+type stripeSubscription struct {
+	Id string `json:"id"`
+}
+
+type stripeCoupon struct {
+	Id string `json:"id"`
+}
+
+type stripeEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *stripeProvider) Name() string {
+	return PaymentProviderStripe
+}
+
+func (p *stripeProvider) Enabled() bool {
+	return constant.StripeApiSecret != "" && constant.StripeWebhookSecret != ""
+}
+
+func (p *stripeProvider) Currency() string {
+	return constant.StripeCurrency
+}
+
+func (p *stripeProvider) UnitPrice() float64 {
+	return constant.StripePrice
+}
+
+func stripeToMinorUnit(money float64, currency string) int64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return int64(math.Round(money))
+	}
+	return int64(math.Round(money * 100))
+}
+
+func stripeFromMinorUnit(amount int64, currency string) float64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+// stripeRequest 调用 Stripe API，idempotencyKey 不为空时同一个 key 重复请求返回同一个结果
+func stripeRequest(method string, path string, form url.Values, idempotencyKey string, result any) error {
+	req, err := http.NewRequest(method, constant.StripeApiAddress+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+constant.StripeApiSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := stripeHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var stripeErr stripeError
+		_ = json.Unmarshal(body, &stripeErr)
+		return fmt.Errorf("stripe returned status code %d: %s", resp.StatusCode, stripeErr.Error.Message)
+	}
+	return json.Unmarshal(body, result)
+}
+
+// CreatePayment 创建 Checkout 会话；周期订单创建 Stripe 订阅，首期金额低于每期金额时（升级抵扣）用一次性优惠券抵扣差额
+func (p *stripeProvider) CreatePayment(order *PaymentOrder) (*PaymentResult, error) {
+	if !p.Enabled() {
+		return nil, errors.New("当前管理员未配置 Stripe 支付信息")
+	}
+	currency := p.Currency()
+	form := url.Values{}
+	form.Set("success_url", order.ReturnUrl)
+	form.Set("cancel_url", order.ReturnUrl)
+	form.Set("client_reference_id", order.TradeNo)
+	form.Set("metadata[trade_no]", order.TradeNo)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", currency)
+	form.Set("line_items[0][price_data][product_data][name]", order.Title)
+	if order.Recurring == "" {
+		form.Set("mode", "payment")
+		form.Set("payment_intent_data[metadata][trade_no]", order.TradeNo)
+		form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(stripeToMinorUnit(order.Money, currency), 10))
+	} else {
+		form.Set("mode", "subscription")
+		form.Set("subscription_data[metadata][trade_no]", order.TradeNo)
+		form.Set("line_items[0][price_data][recurring][interval]", order.Recurring)
+		amount := stripeToMinorUnit(order.RecurringMoney, currency)
+		form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(amount, 10))
+		if discount := amount - stripeToMinorUnit(order.Money, currency); discount > 0 {
+			coupon := url.Values{}
+			coupon.Set("amount_off", strconv.FormatInt(discount, 10))
+			coupon.Set("currency", currency)
+			coupon.Set("duration", "once")
+			var created stripeCoupon
+			if err := stripeRequest(http.MethodPost, "/v1/coupons", coupon, order.TradeNo+"-coupon", &created); err != nil {
+				return nil, err
+			}
+			form.Set("discounts[0][coupon]", created.Id)
+		}
+	}
+	var session stripeCheckoutSession
+	// 同一订单重复创建时返回同一个会话
+	if err := stripeRequest(http.MethodPost, "/v1/checkout/sessions", form, order.TradeNo, &session); err != nil {
+		return nil, err
+	}
+	return &PaymentResult{Url: session.Url, Params: map[string]string{"session_id": session.Id}}, nil
+}
+
+// UpdateRecurring 关闭自动续订时 Stripe 订阅在当前周期结束后取消，重新开启时撤销取消
+func (p *stripeProvider) UpdateRecurring(providerSubscriptionId string, autoRenew bool) error {
+	form := url.Values{}
+	form.Set("cancel_at_period_end", strconv.FormatBool(!autoRenew))
+	var subscription stripeSubscription
+	return stripeRequest(http.MethodPost, "/v1/subscriptions/"+url.PathEscape(providerSubscriptionId), form, "", &subscription)
+}
+
+// CancelRecurring 立即取消 Stripe 订阅，不再扣款
+func (p *stripeProvider) CancelRecurring(providerSubscriptionId string) error {
+	var subscription stripeSubscription
+	return stripeRequest(http.MethodDelete, "/v1/subscriptions/"+url.PathEscape(providerSubscriptionId), url.Values{}, "", &subscription)
+}
+
+// verifyStripeSignature 校验 Stripe-Signature 请求头：t=时间戳,v1=hex(hmac_sha256(secret, 时间戳 + "." + 请求体))
+func verifyStripeSignature(payload []byte, header string, secret string, now time.Time) error {
+	var timestamp string
+	signatures := make([]string, 0)
+	for _, item := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("invalid stripe signature header")
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid stripe signature timestamp")
+	}
+	if diff := now.Sub(time.Unix(t, 0)); diff > stripeSignatureTolerance || diff < -stripeSignatureTolerance {
+		return errors.New("stripe signature timestamp outside the tolerance zone")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		actual, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+	return errors.New("stripe signature mismatch")
+}
+
+func (p *stripeProvider) ParseNotify(c *gin.Context) (*PaymentNotify, error) {
+	if constant.StripeWebhookSecret == "" {
+		return nil, errors.New("stripe webhook secret is not configured")
+	}
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	err = verifyStripeSignature(payload, c.GetHeader("Stripe-Signature"), constant.StripeWebhookSecret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	notify := &PaymentNotify{Event: PaymentEventIgnored}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session stripeCheckoutSession
+		if err = json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, err
+		}
+		notify.TradeNo = session.ClientReferenceId
+		if notify.TradeNo == "" {
+			notify.TradeNo = session.Metadata["trade_no"]
+		}
+		notify.ProviderTradeNo = session.PaymentIntent
+		if session.Mode == "subscription" {
+			// 周期订阅的扣款按账单区分，退款时 charge 上带有对应的账单
+			notify.ProviderTradeNo = session.Invoice
+			notify.ProviderSubscriptionId = session.Subscription
+		}
+		notify.Money = stripeFromMinorUnit(session.AmountTotal, session.Currency)
+		notify.Currency = session.Currency
+		// 异步支付方式在 completed 时仍为 unpaid，等待 async_payment_succeeded
+		if session.PaymentStatus == "paid" || session.PaymentStatus == "no_payment_required" {
+			notify.Event = PaymentEventPaid
+		}
+	case "charge.refunded":
+		var charge stripeCharge
+		if err = json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return nil, err
+		}
+		notify.Event = PaymentEventRefunded
+		notify.TradeNo = charge.Metadata["trade_no"]
+		notify.ProviderTradeNo = charge.PaymentIntent
+		if charge.Invoice != "" {
+			notify.ProviderTradeNo = charge.Invoice
+		}
+		notify.Money = stripeFromMinorUnit(charge.AmountRefunded, charge.Currency)
+		notify.Currency = charge.Currency
+	case "invoice.paid":
+		var invoice stripeInvoice
+		if err = json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return nil, err
+		}
+		// 首期账单在 checkout.session.completed 中处理，这里只处理后续周期的扣款
+		if invoice.BillingReason == "subscription_cycle" {
+			notify.Event = PaymentEventRenewed
+			notify.ProviderTradeNo = invoice.Id
+			notify.ProviderSubscriptionId = invoice.Subscription
+			notify.Money = stripeFromMinorUnit(invoice.AmountPaid, invoice.Currency)
+			notify.Currency = invoice.Currency
+		}
+	case "customer.subscription.deleted":
+		var subscription stripeSubscription
+		if err = json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return nil, err
+		}
+		notify.Event = PaymentEventRecurringCancelled
+		notify.ProviderSubscriptionId = subscription.Id
+	}
+	return notify, nil
+}
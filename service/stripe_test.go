@@ -0,0 +1,440 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/model/testutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const testStripeWebhookSecret = "whsec_test"
+
+func signStripePayload(payload []byte, secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func setupStripe(t *testing.T, apiAddress string) {
+	apiSecret, webhookSecret, currency, address := constant.StripeApiSecret, constant.StripeWebhookSecret, constant.StripeCurrency, constant.StripeApiAddress
+	constant.StripeApiSecret = "sk_test"
+	constant.StripeWebhookSecret = testStripeWebhookSecret
+	constant.StripeCurrency = "usd"
+	constant.StripeApiAddress = apiAddress
+	t.Cleanup(func() {
+		constant.StripeApiSecret, constant.StripeWebhookSecret, constant.StripeCurrency, constant.StripeApiAddress = apiSecret, webhookSecret, currency, address
+	})
+}
+
+func newStripeWebhookContext(payload []byte, signature string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/stripe/webhook", bytes.NewReader(payload))
+	c.Request.Header.Set("Stripe-Signature", signature)
+	return c
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	asserts := assert.New(t)
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+
+	asserts.NoError(verifyStripeSignature(payload, signStripePayload(payload, "secret", now.Unix()), "secret", now))
+	// 多个 v1 签名时任意一个匹配即可（密钥轮换期间）
+	header := signStripePayload(payload, "old", now.Unix()) + "," + strings.Split(signStripePayload(payload, "secret", now.Unix()), ",")[1]
+	asserts.NoError(verifyStripeSignature(payload, header, "secret", now))
+
+	asserts.Error(verifyStripeSignature(payload, signStripePayload(payload, "other", now.Unix()), "secret", now))
+	asserts.Error(verifyStripeSignature([]byte(`{"id":"evt_2"}`), signStripePayload(payload, "secret", now.Unix()), "secret", now))
+	// 超出时间误差的签名视为重放
+	asserts.Error(verifyStripeSignature(payload, signStripePayload(payload, "secret", now.Add(-10*time.Minute).Unix()), "secret", now))
+	asserts.Error(verifyStripeSignature(payload, "v1=abc", "secret", now))
+	asserts.Error(verifyStripeSignature(payload, "", "secret", now))
+}
+
+func TestStripeCreatePayment(t *testing.T) {
+	asserts := assert.New(t)
+	var form url.Values
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asserts.Equal("/v1/checkout/sessions", r.URL.Path)
+		header = r.Header.Clone()
+		body, _ := io.ReadAll(r.Body)
+		form, _ = url.ParseQuery(string(body))
+		_, _ = w.Write([]byte(`{"id":"cs_test_1","url":"https://checkout.stripe.com/c/pay/cs_test_1"}`))
+	}))
+	defer server.Close()
+	setupStripe(t, server.URL)
+
+	provider := &stripeProvider{}
+	result, err := provider.CreatePayment(&PaymentOrder{TradeNo: "A123", Title: "充值", Money: 12.34, ReturnUrl: "https://example.com/log"})
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.Equal("https://checkout.stripe.com/c/pay/cs_test_1", result.Url)
+	asserts.Equal("cs_test_1", result.Params["session_id"])
+	asserts.Equal("Bearer sk_test", header.Get("Authorization"))
+	asserts.Equal("A123", header.Get("Idempotency-Key"))
+	asserts.Equal("A123", form.Get("client_reference_id"))
+	asserts.Equal("usd", form.Get("line_items[0][price_data][currency]"))
+	asserts.Equal("1234", form.Get("line_items[0][price_data][unit_amount]"))
+
+	// 无小数位的币种直接使用金额
+	constant.StripeCurrency = "jpy"
+	_, err = provider.CreatePayment(&PaymentOrder{TradeNo: "A124", Title: "充值", Money: 1500})
+	asserts.NoError(err)
+	asserts.Equal("1500", form.Get("line_items[0][price_data][unit_amount]"))
+}
+
+func TestStripeCreatePaymentError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"Invalid currency"}}`))
+	}))
+	defer server.Close()
+	setupStripe(t, server.URL)
+
+	_, err := (&stripeProvider{}).CreatePayment(&PaymentOrder{TradeNo: "A123", Money: 1})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Invalid currency")
+	}
+}
+
+func TestStripeParseNotify(t *testing.T) {
+	asserts := assert.New(t)
+	setupStripe(t, "")
+	provider := &stripeProvider{}
+
+	payload := []byte(`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"client_reference_id":"A123","payment_status":"paid","payment_intent":"pi_1","amount_total":1234,"currency":"usd"}}}`)
+	notify, err := provider.ParseNotify(newStripeWebhookContext(payload, signStripePayload(payload, testStripeWebhookSecret, time.Now().Unix())))
+	if asserts.NoError(err) {
+		asserts.Equal(PaymentEventPaid, notify.Event)
+		asserts.Equal("A123", notify.TradeNo)
+		asserts.Equal("pi_1", notify.ProviderTradeNo)
+		asserts.Equal(12.34, notify.Money)
+		asserts.Equal("usd", notify.Currency)
+	}
+
+	// 异步支付尚未到账时忽略
+	payload = []byte(`{"id":"evt_2","type":"checkout.session.completed","data":{"object":{"client_reference_id":"A123","payment_status":"unpaid","amount_total":1234,"currency":"usd"}}}`)
+	notify, err = provider.ParseNotify(newStripeWebhookContext(payload, signStripePayload(payload, testStripeWebhookSecret, time.Now().Unix())))
+	if asserts.NoError(err) {
+		asserts.Equal(PaymentEventIgnored, notify.Event)
+	}
+
+	payload = []byte(`{"id":"evt_3","type":"charge.refunded","data":{"object":{"payment_intent":"pi_1","amount_refunded":500,"currency":"usd"}}}`)
+	notify, err = provider.ParseNotify(newStripeWebhookContext(payload, signStripePayload(payload, testStripeWebhookSecret, time.Now().Unix())))
+	if asserts.NoError(err) {
+		asserts.Equal(PaymentEventRefunded, notify.Event)
+		asserts.Equal("pi_1", notify.ProviderTradeNo)
+		asserts.Equal(5.0, notify.Money)
+	}
+
+	_, err = provider.ParseNotify(newStripeWebhookContext(payload, signStripePayload(payload, "whsec_other", time.Now().Unix())))
+	asserts.Error(err)
+}
+
+func setupTopUp(t *testing.T, money float64) *model.TopUp {
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.TopUp{}, &model.SubscriptionOrder{}, &model.Log{})
+	user := &model.User{Id: 1, Username: "payer", Quota: 0}
+	assert.NoError(t, model.DB.Create(user).Error)
+	topUp := &model.TopUp{UserId: 1, Amount: 10, Money: money, TradeNo: "A123", Status: "pending",
+		PaymentProvider: PaymentProviderStripe, Currency: "usd"}
+	assert.NoError(t, topUp.Insert())
+	return topUp
+}
+
+func getUserQuota(t *testing.T, userId int) int {
+	user, err := model.GetUserById(userId, false)
+	assert.NoError(t, err)
+	return user.Quota
+}
+
+func TestHandlePaymentNotifyTopUp(t *testing.T) {
+	asserts := assert.New(t)
+	setupStripe(t, "")
+	setupTopUp(t, 12.34)
+	provider := GetPaymentProvider(PaymentProviderStripe)
+	quota := 10 * int(common.QuotaPerUnit)
+
+	notify := &PaymentNotify{Event: PaymentEventPaid, TradeNo: "A123", ProviderTradeNo: "pi_1", Money: 12.34, Currency: "usd"}
+	asserts.NoError(HandlePaymentNotify(provider, notify))
+	asserts.Equal(quota, getUserQuota(t, 1))
+	topUp := model.GetTopUpByTradeNo("A123")
+	asserts.Equal("success", topUp.Status)
+	asserts.Equal("pi_1", topUp.ProviderTradeNo)
+
+	// 重复回调不会重复入账
+	asserts.NoError(HandlePaymentNotify(provider, notify))
+	asserts.Equal(quota, getUserQuota(t, 1))
+}
+
+func TestHandlePaymentNotifyMismatch(t *testing.T) {
+	asserts := assert.New(t)
+	setupStripe(t, "")
+	setupTopUp(t, 12.34)
+	provider := GetPaymentProvider(PaymentProviderStripe)
+
+	// 金额不一致
+	asserts.Error(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "A123", ProviderTradeNo: "pi_1", Money: 0.5, Currency: "usd"}))
+	// 币种不一致
+	asserts.Error(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "A123", ProviderTradeNo: "pi_1", Money: 12.34, Currency: "jpy"}))
+	// 订单不存在
+	asserts.Error(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "A999", ProviderTradeNo: "pi_1", Money: 12.34, Currency: "usd"}))
+	asserts.Equal(0, getUserQuota(t, 1))
+	asserts.Equal("pending", model.GetTopUpByTradeNo("A123").Status)
+}
+
+func TestHandlePaymentNotifyRefund(t *testing.T) {
+	asserts := assert.New(t)
+	setupStripe(t, "")
+	setupTopUp(t, 10)
+	provider := GetPaymentProvider(PaymentProviderStripe)
+	quota := 10 * int(common.QuotaPerUnit)
+
+	// 未支付的订单不能退款
+	asserts.Error(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "pi_1", Money: 5, Currency: "usd"}))
+
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "A123", ProviderTradeNo: "pi_1", Money: 10, Currency: "usd"}))
+	asserts.Equal(quota, getUserQuota(t, 1))
+
+	// 部分退款按比例扣回额度
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "pi_1", Money: 4, Currency: "usd"}))
+	asserts.Equal(quota*6/10, getUserQuota(t, 1))
+	asserts.Equal("success", model.GetTopUpByTradeNo("A123").Status)
+
+	// 重复回调只扣除差额
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "pi_1", Money: 4, Currency: "usd"}))
+	asserts.Equal(quota*6/10, getUserQuota(t, 1))
+
+	// 累计退款金额达到支付金额时订单标记为已退款
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "pi_1", Money: 10, Currency: "usd"}))
+	asserts.Equal(0, getUserQuota(t, 1))
+	topUp := model.GetTopUpByTradeNo("A123")
+	asserts.Equal("refunded", topUp.Status)
+	asserts.Equal(10.0, topUp.RefundedMoney)
+}
+
+// newStripeStubServer 记录收到的请求，按路径返回固定的响应
+func newStripeStubServer(t *testing.T) (*httptest.Server, map[string]url.Values) {
+	var lock sync.Mutex
+	requests := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		lock.Lock()
+		requests[r.Method+" "+r.URL.Path] = form
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/v1/coupons":
+			_, _ = w.Write([]byte(`{"id":"coupon_1"}`))
+		case "/v1/checkout/sessions":
+			_, _ = w.Write([]byte(`{"id":"cs_test_1","url":"https://checkout.stripe.com/c/pay/cs_test_1"}`))
+		default:
+			_, _ = w.Write([]byte(`{"id":"sub_1"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestStripeCreatePaymentRecurring(t *testing.T) {
+	asserts := assert.New(t)
+	server, requests := newStripeStubServer(t)
+	setupStripe(t, server.URL)
+
+	provider := &stripeProvider{}
+	_, err := provider.CreatePayment(&PaymentOrder{TradeNo: "S123", Title: "basic", Money: 10, Recurring: "month", RecurringMoney: 10})
+	if !asserts.NoError(err) {
+		return
+	}
+	form := requests["POST /v1/checkout/sessions"]
+	asserts.Equal("subscription", form.Get("mode"))
+	asserts.Equal("month", form.Get("line_items[0][price_data][recurring][interval]"))
+	asserts.Equal("1000", form.Get("line_items[0][price_data][unit_amount]"))
+	asserts.Equal("S123", form.Get("subscription_data[metadata][trade_no]"))
+	asserts.Empty(form.Get("payment_intent_data[metadata][trade_no]"))
+	asserts.Empty(form.Get("discounts[0][coupon]"))
+	asserts.NotContains(requests, "POST /v1/coupons")
+
+	// 升级抵扣后首期金额低于每期金额，差额用一次性优惠券抵扣
+	_, err = provider.CreatePayment(&PaymentOrder{TradeNo: "S124", Title: "pro", Money: 6.5, Recurring: "week", RecurringMoney: 10})
+	if !asserts.NoError(err) {
+		return
+	}
+	coupon := requests["POST /v1/coupons"]
+	asserts.Equal("350", coupon.Get("amount_off"))
+	asserts.Equal("once", coupon.Get("duration"))
+	form = requests["POST /v1/checkout/sessions"]
+	asserts.Equal("week", form.Get("line_items[0][price_data][recurring][interval]"))
+	asserts.Equal("1000", form.Get("line_items[0][price_data][unit_amount]"))
+	asserts.Equal("coupon_1", form.Get("discounts[0][coupon]"))
+}
+
+func TestStripeParseNotifyRecurring(t *testing.T) {
+	asserts := assert.New(t)
+	setupStripe(t, "")
+	provider := &stripeProvider{}
+	parse := func(payload string) *PaymentNotify {
+		notify, err := provider.ParseNotify(newStripeWebhookContext([]byte(payload), signStripePayload([]byte(payload), testStripeWebhookSecret, time.Now().Unix())))
+		asserts.NoError(err)
+		return notify
+	}
+
+	// 周期订阅的首期支付按账单区分
+	notify := parse(`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"mode":"subscription","client_reference_id":"S123","payment_status":"paid","subscription":"sub_1","invoice":"in_1","amount_total":1000,"currency":"usd"}}}`)
+	asserts.Equal(PaymentEventPaid, notify.Event)
+	asserts.Equal("in_1", notify.ProviderTradeNo)
+	asserts.Equal("sub_1", notify.ProviderSubscriptionId)
+
+	notify = parse(`{"id":"evt_2","type":"invoice.paid","data":{"object":{"id":"in_2","subscription":"sub_1","billing_reason":"subscription_cycle","amount_paid":1000,"currency":"usd"}}}`)
+	asserts.Equal(PaymentEventRenewed, notify.Event)
+	asserts.Equal("in_2", notify.ProviderTradeNo)
+	asserts.Equal("sub_1", notify.ProviderSubscriptionId)
+	asserts.Equal(10.0, notify.Money)
+
+	// 首期账单已在 checkout.session.completed 中处理
+	notify = parse(`{"id":"evt_3","type":"invoice.paid","data":{"object":{"id":"in_1","subscription":"sub_1","billing_reason":"subscription_create","amount_paid":1000,"currency":"usd"}}}`)
+	asserts.Equal(PaymentEventIgnored, notify.Event)
+
+	notify = parse(`{"id":"evt_4","type":"customer.subscription.deleted","data":{"object":{"id":"sub_1"}}}`)
+	asserts.Equal(PaymentEventRecurringCancelled, notify.Event)
+	asserts.Equal("sub_1", notify.ProviderSubscriptionId)
+
+	notify = parse(`{"id":"evt_5","type":"charge.refunded","data":{"object":{"payment_intent":"pi_2","invoice":"in_2","amount_refunded":1000,"currency":"usd"}}}`)
+	asserts.Equal(PaymentEventRefunded, notify.Event)
+	asserts.Equal("in_2", notify.ProviderTradeNo)
+}
+
+func setupRecurringSubscription(t *testing.T) *model.SubscriptionPlan {
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.SubscriptionPlan{}, &model.UserSubscription{}, &model.SubscriptionModelUsage{},
+		&model.SubscriptionOrder{}, &model.Log{})
+	assert.NoError(t, model.DB.Create(&model.User{Id: 1, Username: "payer", Password: "password", Group: "default"}).Error)
+	plan := &model.SubscriptionPlan{Name: "basic", Period: model.SubscriptionPeriodMonth, Price: 10, Quota: 100, Enabled: true}
+	assert.NoError(t, plan.Insert())
+	order := &model.SubscriptionOrder{UserId: 1, PlanId: plan.Id, Money: 10, TradeNo: "S123", PaymentMethod: PaymentProviderStripe,
+		AutoRenew: true, Status: "pending"}
+	assert.NoError(t, order.Insert())
+	return plan
+}
+
+func TestHandlePaymentNotifySubscriptionRenewal(t *testing.T) {
+	asserts := assert.New(t)
+	server, requests := newStripeStubServer(t)
+	setupStripe(t, server.URL)
+	setupRecurringSubscription(t)
+	provider := GetPaymentProvider(PaymentProviderStripe)
+
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "S123", ProviderTradeNo: "in_1",
+		ProviderSubscriptionId: "sub_1", Money: 10, Currency: "usd"}))
+	subscription, err := model.GetActiveUserSubscription(1)
+	if !asserts.NoError(err) {
+		return
+	}
+	asserts.Equal(PaymentProviderStripe, subscription.PaymentProvider)
+	asserts.Equal("sub_1", subscription.ProviderSubscriptionId)
+	asserts.True(subscription.AutoRenew)
+
+	// 支付渠道的后续扣款续订一个周期，并记录为一笔订单
+	asserts.NoError(model.DB.Model(subscription).Update("remain_quota", 0).Error)
+	renewed := &PaymentNotify{Event: PaymentEventRenewed, ProviderTradeNo: "in_2", ProviderSubscriptionId: "sub_1", Money: 10, Currency: "usd"}
+	asserts.NoError(HandlePaymentNotify(provider, renewed))
+	current, err := model.GetUserSubscriptionById(subscription.Id)
+	asserts.NoError(err)
+	asserts.Equal(100, current.RemainQuota)
+	asserts.Greater(current.ExpireTime, subscription.ExpireTime)
+	order := model.GetSubscriptionOrderByProviderTradeNo("in_2")
+	if asserts.NotNil(order) {
+		asserts.Equal(subscription.Id, order.SubscriptionId)
+	}
+
+	// 重复回调不会重复续订
+	asserts.NoError(HandlePaymentNotify(provider, renewed))
+	again, _ := model.GetUserSubscriptionById(subscription.Id)
+	asserts.Equal(current.ExpireTime, again.ExpireTime)
+
+	// 关闭自动续订时支付渠道在当前周期结束后取消
+	asserts.NoError(UpdateSubscriptionAutoRenew(current, false))
+	asserts.Equal("true", requests["POST /v1/subscriptions/sub_1"].Get("cancel_at_period_end"))
+	asserts.NoError(UpdateSubscriptionAutoRenew(current, true))
+	asserts.Equal("false", requests["POST /v1/subscriptions/sub_1"].Get("cancel_at_period_end"))
+
+	// 支付渠道取消周期订阅后不再等待续订
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRecurringCancelled, ProviderSubscriptionId: "sub_1"}))
+	current, _ = model.GetUserSubscriptionById(subscription.Id)
+	asserts.False(current.AutoRenew)
+}
+
+func TestProcessDueSubscriptionsProviderGrace(t *testing.T) {
+	asserts := assert.New(t)
+	server, requests := newStripeStubServer(t)
+	setupStripe(t, server.URL)
+	plan := setupRecurringSubscription(t)
+
+	now := common.GetTimestamp()
+	waiting := &model.UserSubscription{UserId: 1, PlanId: plan.Id, Status: model.SubscriptionStatusActive, StartTime: now - 3600,
+		ExpireTime: now - 60, AutoRenew: true, PaymentProvider: PaymentProviderStripe, ProviderSubscriptionId: "sub_1"}
+	overdue := &model.UserSubscription{UserId: 2, PlanId: plan.Id, Status: model.SubscriptionStatusActive, StartTime: now - 3600,
+		ExpireTime: now - subscriptionProviderRenewGrace - 60, AutoRenew: true, PaymentProvider: PaymentProviderStripe, ProviderSubscriptionId: "sub_2"}
+	asserts.NoError(model.DB.Create(waiting).Error)
+	asserts.NoError(model.DB.Create(overdue).Error)
+
+	// 等待期内不会过期，也不会从钱包续订；超过等待期时取消周期订阅并过期
+	processDueSubscriptions()
+	current, _ := model.GetUserSubscriptionById(waiting.Id)
+	asserts.Equal(model.SubscriptionStatusActive, current.Status)
+	current, _ = model.GetUserSubscriptionById(overdue.Id)
+	asserts.Equal(model.SubscriptionStatusExpired, current.Status)
+	asserts.Contains(requests, "DELETE /v1/subscriptions/sub_2")
+	asserts.NotContains(requests, "DELETE /v1/subscriptions/sub_1")
+}
+
+func TestHandlePaymentNotifySubscriptionRefund(t *testing.T) {
+	asserts := assert.New(t)
+	server, requests := newStripeStubServer(t)
+	setupStripe(t, server.URL)
+	setupRecurringSubscription(t)
+	provider := GetPaymentProvider(PaymentProviderStripe)
+
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventPaid, TradeNo: "S123", ProviderTradeNo: "in_1",
+		ProviderSubscriptionId: "sub_1", Money: 10, Currency: "usd"}))
+	subscription, err := model.GetActiveUserSubscription(1)
+	if !asserts.NoError(err) {
+		return
+	}
+
+	// 部分退款保留订阅，只记录累计退款金额
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "in_1", Money: 4, Currency: "usd"}))
+	current, _ := model.GetUserSubscriptionById(subscription.Id)
+	asserts.Equal(model.SubscriptionStatusActive, current.Status)
+	order := model.GetSubscriptionOrderByTradeNo("S123")
+	asserts.Equal("success", order.Status)
+	asserts.Equal(4.0, order.RefundedMoney)
+	asserts.NotContains(requests, "DELETE /v1/subscriptions/sub_1")
+
+	// 累计退款金额达到订单金额时取消订阅
+	asserts.NoError(HandlePaymentNotify(provider, &PaymentNotify{Event: PaymentEventRefunded, ProviderTradeNo: "in_1", Money: 10, Currency: "usd"}))
+	current, _ = model.GetUserSubscriptionById(subscription.Id)
+	asserts.Equal(model.SubscriptionStatusCancelled, current.Status)
+	order = model.GetSubscriptionOrderByTradeNo("S123")
+	asserts.Equal("refunded", order.Status)
+	asserts.Equal(10.0, order.RefundedMoney)
+	asserts.Contains(requests, "DELETE /v1/subscriptions/sub_1")
+}
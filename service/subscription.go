@@ -0,0 +1,326 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+type cachedSubscriptionPlan struct {
+	plan     *model.SubscriptionPlan
+	expireAt time.Time
+}
+
+var subscriptionPlanCache = make(map[int]cachedSubscriptionPlan)
+var subscriptionPlanCacheLock sync.RWMutex
+
+// getSubscriptionPlan 带一分钟内存缓存的套餐查询，扣费时每次请求都会用到
+func getSubscriptionPlan(planId int) (*model.SubscriptionPlan, error) {
+	subscriptionPlanCacheLock.RLock()
+	cached, ok := subscriptionPlanCache[planId]
+	subscriptionPlanCacheLock.RUnlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.plan, nil
+	}
+	plan, err := model.GetSubscriptionPlanById(planId)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionPlanCacheLock.Lock()
+	subscriptionPlanCache[planId] = cachedSubscriptionPlan{plan: plan, expireAt: time.Now().Add(time.Minute)}
+	subscriptionPlanCacheLock.Unlock()
+	return plan, nil
+}
+
+func getActiveSubscription(userId int) (*model.UserSubscription, *model.SubscriptionPlan, error) {
+	subscriptionId, err := model.CacheGetActiveUserSubscriptionId(userId)
+	if err != nil || subscriptionId == 0 {
+		return nil, nil, err
+	}
+	subscription, err := model.GetUserSubscriptionById(subscriptionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subscription.Status != model.SubscriptionStatusActive || subscription.ExpireTime <= common.GetTimestamp() {
+		return nil, nil, nil
+	}
+	plan, err := getSubscriptionPlan(subscription.PlanId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subscription, plan, nil
+}
+
+const subscriptionReservationKey = "subscription_reservation"
+
+// subscriptionReservation 预扣费时从订阅中预留的额度或模型请求次数，请求结束时结算，失败时退回
+type subscriptionReservation struct {
+	subscriptionId int
+	planName       string
+	modelName      string
+	perRequest     bool
+	quota          int
+	settled        bool
+}
+
+func getSubscriptionReservation(c *gin.Context) *subscriptionReservation {
+	value, ok := c.Get(subscriptionReservationKey)
+	if !ok {
+		return nil
+	}
+	reservation, _ := value.(*subscriptionReservation)
+	return reservation
+}
+
+// PreConsumeSubscription 预扣费时先从用户当前订阅中预留，返回仍需由钱包支付的部分，这部分照常检查钱包与令牌额度；
+// 套餐包含该模型的请求次数时占用一次，整个请求由订阅支付
+func PreConsumeSubscription(c *gin.Context, userId int, modelName string, quota int) int {
+	subscription, plan, err := getActiveSubscription(userId)
+	if err != nil {
+		common.SysError("failed to get user subscription: " + err.Error())
+		return quota
+	}
+	if subscription == nil {
+		return quota
+	}
+	reservation := &subscriptionReservation{subscriptionId: subscription.Id, planName: plan.Name, modelName: modelName}
+	if limit, ok := plan.GetModelRequests()[modelName]; ok {
+		covered, err := model.ConsumeSubscriptionModelRequest(subscription.Id, modelName, limit)
+		if err != nil {
+			common.SysError("failed to consume subscription model request: " + err.Error())
+		} else if covered {
+			reservation.perRequest = true
+			c.Set(subscriptionReservationKey, reservation)
+			return 0
+		}
+	}
+	if subscription.RemainQuota <= 0 {
+		return quota
+	}
+	consumed, err := model.ConsumeSubscriptionQuota(subscription.Id, quota)
+	if err != nil {
+		common.SysError("failed to consume subscription quota: " + err.Error())
+		return quota
+	}
+	reservation.quota = consumed
+	c.Set(subscriptionReservationKey, reservation)
+	return quota - consumed
+}
+
+// SubscriptionReserved 本次请求是否已在订阅中预留
+func SubscriptionReserved(c *gin.Context) bool {
+	reservation := getSubscriptionReservation(c)
+	return reservation != nil && !reservation.settled
+}
+
+// SettleSubscription 按实际额度结算订阅的预留，多退少补，返回由订阅支付的额度与日志说明；
+// 没有预留时按先订阅后钱包的顺序扣费
+func SettleSubscription(c *gin.Context, userId int, modelName string, quota int) (int, string) {
+	reservation := getSubscriptionReservation(c)
+	if reservation == nil || reservation.settled {
+		return ConsumeSubscription(userId, modelName, quota)
+	}
+	reservation.settled = true
+	if reservation.perRequest {
+		return quota, fmt.Sprintf("，订阅「%s」按次抵扣", reservation.planName)
+	}
+	covered := reservation.quota
+	if quota > covered {
+		extra, err := model.ConsumeSubscriptionQuota(reservation.subscriptionId, quota-covered)
+		if err != nil {
+			common.SysError("failed to consume subscription quota: " + err.Error())
+		}
+		covered += extra
+	} else if quota < covered {
+		if err := model.RefundSubscriptionQuota(reservation.subscriptionId, covered-quota); err != nil {
+			common.SysError("failed to refund subscription quota: " + err.Error())
+		}
+		covered = quota
+	}
+	if covered <= 0 {
+		return 0, ""
+	}
+	return covered, fmt.Sprintf("，订阅「%s」抵扣 %s", reservation.planName, common.LogQuota(covered))
+}
+
+// ReleaseSubscriptionReservation 请求失败或没有结算时退回订阅的预留，已结算时不做任何操作
+func ReleaseSubscriptionReservation(c *gin.Context) {
+	reservation := getSubscriptionReservation(c)
+	if reservation == nil || reservation.settled {
+		return
+	}
+	reservation.settled = true
+	var err error
+	if reservation.perRequest {
+		err = model.RefundSubscriptionModelRequest(reservation.subscriptionId, reservation.modelName)
+	} else {
+		err = model.RefundSubscriptionQuota(reservation.subscriptionId, reservation.quota)
+	}
+	if err != nil {
+		common.SysError("failed to release subscription reservation: " + err.Error())
+	}
+}
+
+// ConsumeSubscription 按先订阅后钱包的顺序扣费，返回由订阅支付的额度与日志说明
+// 套餐包含该模型的请求次数时，本次请求全部由订阅支付
+func ConsumeSubscription(userId int, modelName string, quota int) (int, string) {
+	if quota <= 0 {
+		return 0, ""
+	}
+	subscription, plan, err := getActiveSubscription(userId)
+	if err != nil {
+		common.SysError("failed to get user subscription: " + err.Error())
+		return 0, ""
+	}
+	if subscription == nil {
+		return 0, ""
+	}
+	if limit, ok := plan.GetModelRequests()[modelName]; ok {
+		covered, err := model.ConsumeSubscriptionModelRequest(subscription.Id, modelName, limit)
+		if err != nil {
+			common.SysError("failed to consume subscription model request: " + err.Error())
+		} else if covered {
+			return quota, fmt.Sprintf("，订阅「%s」按次抵扣", plan.Name)
+		}
+	}
+	consumed, err := model.ConsumeSubscriptionQuota(subscription.Id, quota)
+	if err != nil {
+		common.SysError("failed to consume subscription quota: " + err.Error())
+		return 0, ""
+	}
+	if consumed == 0 {
+		return 0, ""
+	}
+	return consumed, fmt.Sprintf("，订阅「%s」抵扣 %s", plan.Name, common.LogQuota(consumed))
+}
+
+// SubscriptionMoneyToQuota 将订阅价格换算为钱包额度，与在线充值的汇率一致
+func SubscriptionMoneyToQuota(money float64) int {
+	if constant.Price <= 0 {
+		return 0
+	}
+	return int(math.Ceil(money / constant.Price * common.QuotaPerUnit))
+}
+
+// CalculateSubscriptionPrice 计算购买套餐的应付金额，已有其他生效订阅时按剩余时间折算抵扣
+func CalculateSubscriptionPrice(userId int, plan *model.SubscriptionPlan) (price float64, credit float64, err error) {
+	subscription, currentPlan, err := getActiveSubscription(userId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if subscription == nil {
+		return plan.Price, 0, nil
+	}
+	if subscription.PlanId == plan.Id {
+		return 0, 0, errors.New("已订阅该套餐，如需续订请开启自动续订")
+	}
+	duration := subscription.ExpireTime - subscription.StartTime
+	remain := subscription.ExpireTime - common.GetTimestamp()
+	if duration > 0 && remain > 0 {
+		credit = currentPlan.Price * float64(remain) / float64(duration)
+	}
+	price = plan.Price - credit
+	if price < 0 {
+		price = 0
+	}
+	return math.Round(price*100) / 100, math.Round(credit*100) / 100, nil
+}
+
+// CompleteSubscriptionOrder 订单支付成功后开通订阅，重复回调时返回 nil；
+// 钱包支付时 walletCost 为扣除的额度，与开通在同一事务中完成
+func CompleteSubscriptionOrder(order *model.SubscriptionOrder, walletCost int) (*model.UserSubscription, error) {
+	plan, err := model.GetSubscriptionPlanById(order.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := order.Claim()
+	if err != nil || !claimed {
+		return nil, err
+	}
+	previous, _ := model.GetActiveUserSubscription(order.UserId)
+	subscription, err := model.ActivateUserSubscription(order, plan, walletCost)
+	if errors.Is(err, model.ErrInsufficientUserQuota) {
+		order.Status = "failed"
+		_ = order.Update()
+		return nil, fmt.Errorf("余额不足，需要 %s", common.LogQuota(walletCost))
+	}
+	if err != nil {
+		// 开通失败时恢复为待处理，等待支付渠道重试回调
+		order.Status = "pending"
+		_ = order.Update()
+		return nil, err
+	}
+	order.SubscriptionId = subscription.Id
+	if err = order.Update(); err != nil {
+		common.SysError("failed to update subscription order: " + err.Error())
+	}
+	if previous != nil {
+		// 被替换的订阅不再续订
+		cancelSubscriptionRecurring(previous)
+	}
+	model.RecordLog(order.UserId, model.LogTypeTopup, fmt.Sprintf("开通订阅「%s」，支付金额：%.2f，有效期至 %s", plan.Name, order.Money,
+		time.Unix(subscription.ExpireTime, 0).Format("2006-01-02 15:04:05")))
+	return subscription, nil
+}
+
+// subscriptionProviderRenewGrace 由支付渠道续订的订阅到期后等待扣款回调的时间，超过后按过期处理
+const subscriptionProviderRenewGrace = int64(3 * 24 * 3600)
+
+// renewSubscriptionFromWallet 钱包自动续订：没有支付渠道周期订阅（钱包或易支付购买）的订阅到期时从钱包余额中扣除套餐价格，
+// 余额不足时续订失败，订阅按正常流程过期。用户开通时选择自动续订即表示同意从钱包扣费
+func renewSubscriptionFromWallet(subscription *model.UserSubscription, plan *model.SubscriptionPlan) error {
+	cost := SubscriptionMoneyToQuota(plan.Price)
+	err := model.RenewUserSubscription(subscription, plan, cost)
+	if errors.Is(err, model.ErrInsufficientUserQuota) {
+		return fmt.Errorf("余额不足，续订需要 %s", common.LogQuota(cost))
+	}
+	if err != nil {
+		return err
+	}
+	model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅「%s」已从钱包余额自动续订，扣除 %s", plan.Name, common.LogQuota(cost)))
+	return nil
+}
+
+// processDueSubscriptions 处理到期的订阅：开启自动续订且没有周期订阅的从钱包扣费续订一个周期，
+// 由支付渠道续订的订阅超过等待时间仍未扣款时取消周期订阅，其余标记为过期并恢复分组
+func processDueSubscriptions() {
+	subscriptions, err := model.GetDueUserSubscriptions(100, subscriptionProviderRenewGrace)
+	if err != nil {
+		common.SysError("failed to get due subscriptions: " + err.Error())
+		return
+	}
+	for _, subscription := range subscriptions {
+		plan, err := model.GetSubscriptionPlanById(subscription.PlanId)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get plan of subscription #%d: %s", subscription.Id, err.Error()))
+			plan = &model.SubscriptionPlan{}
+		}
+		if subscription.AutoRenew && subscription.ProviderSubscriptionId != "" {
+			cancelSubscriptionRecurring(subscription)
+			model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅「%s」到期后未收到支付渠道的续订扣款，已取消自动续订", plan.Name))
+		} else if subscription.AutoRenew && plan.Id != 0 && plan.Enabled {
+			err = renewSubscriptionFromWallet(subscription, plan)
+			if err == nil {
+				continue
+			}
+			model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅「%s」钱包自动续订失败：%s", plan.Name, err.Error()))
+		}
+		err = model.ExpireUserSubscription(subscription, model.SubscriptionStatusExpired, plan.UpgradeGroup)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to expire subscription #%d: %s", subscription.Id, err.Error()))
+		}
+	}
+}
+
+func SubscriptionTask() {
+	for {
+		processDueSubscriptions()
+		time.Sleep(time.Minute)
+	}
+}
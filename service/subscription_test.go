@@ -0,0 +1,160 @@
+package service
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/model/testutil"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSubscription(t *testing.T, plan *model.SubscriptionPlan, remainQuota int) *model.UserSubscription {
+	model.DB = testutil.SetupDB(t, &model.SubscriptionPlan{}, &model.UserSubscription{}, &model.SubscriptionModelUsage{})
+	assert.NoError(t, plan.Insert())
+	subscriptionPlanCacheLock.Lock()
+	delete(subscriptionPlanCache, plan.Id)
+	subscriptionPlanCacheLock.Unlock()
+	subscription := &model.UserSubscription{UserId: 1, PlanId: plan.Id, Status: model.SubscriptionStatusActive,
+		StartTime: common.GetTimestamp(), ExpireTime: common.GetTimestamp() + 3600, RemainQuota: remainQuota}
+	assert.NoError(t, model.DB.Create(subscription).Error)
+	return subscription
+}
+
+func newSubscriptionTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return c
+}
+
+func getRemainQuota(t *testing.T, subscriptionId int) int {
+	subscription, err := model.GetUserSubscriptionById(subscriptionId)
+	assert.NoError(t, err)
+	return subscription.RemainQuota
+}
+
+func TestPreConsumeSubscriptionQuota(t *testing.T) {
+	asserts := assert.New(t)
+	subscription := setupSubscription(t, &model.SubscriptionPlan{Name: "basic", Quota: 100, Enabled: true}, 100)
+
+	// 订阅只够支付一部分，剩余部分返回给调用方走钱包检查
+	c := newSubscriptionTestContext()
+	asserts.Equal(50, PreConsumeSubscription(c, 1, "gpt-4o", 150))
+	asserts.True(SubscriptionReserved(c))
+	asserts.Equal(0, getRemainQuota(t, subscription.Id))
+	covered, _ := SettleSubscription(c, 1, "gpt-4o", 120)
+	asserts.Equal(100, covered)
+	asserts.False(SubscriptionReserved(c))
+	// 已结算后不再退回
+	ReleaseSubscriptionReservation(c)
+	asserts.Equal(0, getRemainQuota(t, subscription.Id))
+}
+
+func TestSettleSubscriptionRefundsUnused(t *testing.T) {
+	asserts := assert.New(t)
+	subscription := setupSubscription(t, &model.SubscriptionPlan{Name: "basic", Quota: 100, Enabled: true}, 100)
+
+	c := newSubscriptionTestContext()
+	asserts.Equal(0, PreConsumeSubscription(c, 1, "gpt-4o", 60))
+	asserts.Equal(40, getRemainQuota(t, subscription.Id))
+	covered, content := SettleSubscription(c, 1, "gpt-4o", 25)
+	asserts.Equal(25, covered)
+	asserts.Contains(content, "basic")
+	asserts.Equal(75, getRemainQuota(t, subscription.Id))
+
+	// 请求失败时全部退回
+	c = newSubscriptionTestContext()
+	asserts.Equal(0, PreConsumeSubscription(c, 1, "gpt-4o", 60))
+	ReleaseSubscriptionReservation(c)
+	asserts.Equal(75, getRemainQuota(t, subscription.Id))
+}
+
+func TestPreConsumeSubscriptionModelRequests(t *testing.T) {
+	asserts := assert.New(t)
+	subscription := setupSubscription(t, &model.SubscriptionPlan{Name: "pro", ModelRequests: `{"gpt-4o": 1}`, Enabled: true}, 0)
+
+	c := newSubscriptionTestContext()
+	asserts.Equal(0, PreConsumeSubscription(c, 1, "gpt-4o", 500))
+	asserts.Equal(1, model.GetSubscriptionModelUsed(subscription.Id, "gpt-4o"))
+	ReleaseSubscriptionReservation(c)
+	asserts.Equal(0, model.GetSubscriptionModelUsed(subscription.Id, "gpt-4o"))
+
+	c = newSubscriptionTestContext()
+	asserts.Equal(0, PreConsumeSubscription(c, 1, "gpt-4o", 500))
+	covered, _ := SettleSubscription(c, 1, "gpt-4o", 800)
+	asserts.Equal(800, covered)
+
+	// 次数用完且没有订阅额度时全部由钱包支付
+	c = newSubscriptionTestContext()
+	asserts.Equal(500, PreConsumeSubscription(c, 1, "gpt-4o", 500))
+	asserts.False(SubscriptionReserved(c))
+	// 没有订阅的用户不受影响
+	asserts.Equal(500, PreConsumeSubscription(c, 2, "gpt-4o", 500))
+}
+
+func setupWalletSubscription(t *testing.T, userQuota int) (*model.SubscriptionPlan, *model.UserSubscription) {
+	model.DB = testutil.SetupDB(t, &model.User{}, &model.SubscriptionPlan{}, &model.UserSubscription{}, &model.SubscriptionModelUsage{},
+		&model.SubscriptionOrder{}, &model.Log{})
+	price := constant.Price
+	constant.Price = 1
+	t.Cleanup(func() {
+		constant.Price = price
+	})
+	assert.NoError(t, model.DB.Create(&model.User{Id: 1, Username: "user", Password: "password", Group: "default", Quota: userQuota}).Error)
+	plan := &model.SubscriptionPlan{Name: "basic", Period: model.SubscriptionPeriodMonth, Price: 1, Quota: 100, Enabled: true}
+	assert.NoError(t, plan.Insert())
+	subscription := &model.UserSubscription{UserId: 1, PlanId: plan.Id, Status: model.SubscriptionStatusActive,
+		StartTime: common.GetTimestamp() - 3600, ExpireTime: common.GetTimestamp() - 1, RemainQuota: 0, AutoRenew: true}
+	assert.NoError(t, model.DB.Create(subscription).Error)
+	return plan, subscription
+}
+
+func TestRenewSubscriptionFromWallet(t *testing.T) {
+	asserts := assert.New(t)
+	cost := int(common.QuotaPerUnit)
+	plan, subscription := setupWalletSubscription(t, cost+10)
+
+	asserts.NoError(renewSubscriptionFromWallet(subscription, plan))
+	asserts.Equal(10, getUserQuota(t, 1))
+	asserts.Equal(100, getRemainQuota(t, subscription.Id))
+
+	// 余额不足时不扣费也不续订
+	expireTime := subscription.ExpireTime
+	asserts.ErrorContains(renewSubscriptionFromWallet(subscription, plan), "余额不足")
+	asserts.Equal(10, getUserQuota(t, 1))
+	renewed, err := model.GetUserSubscriptionById(subscription.Id)
+	asserts.NoError(err)
+	asserts.Equal(expireTime, renewed.ExpireTime)
+}
+
+func TestRenewSubscriptionFromWalletRollsBackDebit(t *testing.T) {
+	asserts := assert.New(t)
+	cost := int(common.QuotaPerUnit)
+	plan, subscription := setupWalletSubscription(t, cost)
+
+	// 续订写入失败时，扣费随事务一起回滚
+	asserts.NoError(model.DB.Migrator().DropTable(&model.SubscriptionModelUsage{}))
+	asserts.Error(renewSubscriptionFromWallet(subscription, plan))
+	asserts.Equal(cost, getUserQuota(t, 1))
+}
+
+func TestCompleteSubscriptionOrderWalletDoubleCharge(t *testing.T) {
+	asserts := assert.New(t)
+	cost := int(common.QuotaPerUnit)
+	plan, _ := setupWalletSubscription(t, cost+cost/2)
+
+	// 余额只够一次，两笔订单只有一笔能扣费开通
+	first := &model.SubscriptionOrder{UserId: 1, PlanId: plan.Id, Money: 1, TradeNo: "S1", PaymentMethod: "balance", Status: "pending"}
+	second := &model.SubscriptionOrder{UserId: 1, PlanId: plan.Id, Money: 1, TradeNo: "S2", PaymentMethod: "balance", Status: "pending"}
+	asserts.NoError(first.Insert())
+	asserts.NoError(second.Insert())
+	subscription, err := CompleteSubscriptionOrder(first, cost)
+	asserts.NoError(err)
+	asserts.NotNil(subscription)
+	_, err = CompleteSubscriptionOrder(second, cost)
+	asserts.ErrorContains(err, "余额不足")
+	asserts.Equal(cost/2, getUserQuota(t, 1))
+	asserts.Equal("failed", model.GetSubscriptionOrderByTradeNo("S2").Status)
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+)
+
+// StartUpstreamSpan 开始上游请求的 span，并通过 traceparent 请求头把链路传递给上游
+func StartUpstreamSpan(c *gin.Context, req *http.Request) *common.Span {
+	span := common.StartGinSpan(c, "upstream.request", common.SpanKindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("channel.id", c.GetInt("channel_id"))
+	span.SetAttribute("channel.type", c.GetInt("channel_type"))
+	common.InjectTraceContext(c.Request.Context(), req.Header)
+	return span
+}
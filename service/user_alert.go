@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"github.com/bytedance/gopkg/util/gopool"
+	"one-api/common"
+	"one-api/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userAlertDebounce 同一用户（令牌）的提醒检查间隔，避免每次请求都查库
+const userAlertDebounce = time.Minute
+
+var userAlertLastCheck = make(map[string]int64)
+var userAlertLastCheckLock sync.Mutex
+
+func userAlertShouldCheck(userId int, tokenId int) bool {
+	key := fmt.Sprintf("%d:%d", userId, tokenId)
+	now := time.Now().Unix()
+	userAlertLastCheckLock.Lock()
+	defer userAlertLastCheckLock.Unlock()
+	if now-userAlertLastCheck[key] < int64(userAlertDebounce.Seconds()) {
+		return false
+	}
+	if len(userAlertLastCheck) > 100000 {
+		userAlertLastCheck = make(map[string]int64)
+	}
+	userAlertLastCheck[key] = now
+	return true
+}
+
+func startOfToday() int64 {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+}
+
+// CheckUserUsageAlerts 在扣费后异步检查用户设置的余额与用量提醒，每个提醒每天最多发送一次
+func CheckUserUsageAlerts(userId int, tokenId int, tokenName string) {
+	if !userAlertShouldCheck(userId, tokenId) {
+		return
+	}
+	gopool.Go(func() {
+		setting, err := model.GetUserAlertSetting(userId)
+		if err != nil {
+			common.SysError("failed to get user alert setting: " + err.Error())
+			return
+		}
+		if !setting.HasChannel() {
+			return
+		}
+		today := time.Now().Format("20060102")
+		if setting.QuotaThreshold > 0 {
+			quota, err := model.CacheGetUserQuota(userId)
+			if err == nil && quota < setting.QuotaThreshold && !userAlertDeduplicated(fmt.Sprintf("quota:%d:%s", userId, today)) {
+				sendUserAlert(setting, "您的额度即将用尽",
+					fmt.Sprintf("当前剩余额度为 %s，低于您设置的提醒阈值 %s，为了不影响您的使用，请及时充值。", common.LogQuota(quota), common.LogQuota(setting.QuotaThreshold)))
+			}
+		}
+		if setting.DailyQuotaThreshold > 0 {
+			used, err := model.SumUserConsumeQuota(userId, 0, startOfToday())
+			if err == nil && used >= setting.DailyQuotaThreshold && !userAlertDeduplicated(fmt.Sprintf("daily:%d:%s", userId, today)) {
+				sendUserAlert(setting, "今日消耗已超过提醒阈值",
+					fmt.Sprintf("今日已消耗 %s，超过您设置的提醒阈值 %s。", common.LogQuota(used), common.LogQuota(setting.DailyQuotaThreshold)))
+			}
+		}
+		if setting.TokenDailyQuotaThreshold > 0 && tokenId != 0 {
+			used, err := model.SumUserConsumeQuota(userId, tokenId, startOfToday())
+			if err == nil && used >= setting.TokenDailyQuotaThreshold && !userAlertDeduplicated(fmt.Sprintf("token:%d:%s", tokenId, today)) {
+				sendUserAlert(setting, fmt.Sprintf("令牌「%s」今日消耗已超过提醒阈值", tokenName),
+					fmt.Sprintf("令牌「%s」今日已消耗 %s，超过您设置的提醒阈值 %s。", tokenName, common.LogQuota(used), common.LogQuota(setting.TokenDailyQuotaThreshold)))
+			}
+		}
+	})
+}
+
+func userAlertDeduplicated(key string) bool {
+	return common.Deduplicated("user_alert:"+key, 24*time.Hour)
+}
+
+// sendUserAlert 通过用户设置的所有渠道发送提醒
+func sendUserAlert(setting *model.UserAlertSetting, subject string, content string) {
+	user, err := model.GetUserById(setting.UserId, false)
+	if err != nil {
+		common.SysError("failed to get user for alert: " + err.Error())
+		return
+	}
+	if setting.EmailEnabled && user.Email != "" {
+		err = common.SendEmail(subject, user.Email, strings.ReplaceAll(content, "\n", "<br/>"))
+		if err != nil {
+			common.SysError("failed to send alert email: " + err.Error())
+		}
+	}
+	event := common.NotifyEvent{
+		Type:      common.NotifyEventUserAlert,
+		Subject:   subject,
+		Content:   content,
+		Fields:    map[string]interface{}{"user_id": user.Id},
+		Timestamp: time.Now().Unix(),
+	}
+	if setting.WebhookUrl != "" {
+		err = common.SendUserNotification(common.NotificationSink{
+			Type:   common.NotifySinkWebhook,
+			URL:    setting.WebhookUrl,
+			Secret: setting.WebhookSecret,
+		}, event)
+		if err != nil {
+			common.SysError("failed to send alert webhook: " + err.Error())
+		}
+	}
+	if setting.TelegramEnabled && user.TelegramId != "" {
+		err = common.SendNotification(common.NotificationSink{
+			Type:   common.NotifySinkTelegram,
+			ChatId: user.TelegramId,
+		}, event)
+		if err != nil {
+			common.SysError("failed to send alert telegram message: " + err.Error())
+		}
+	}
+}
+
+// SendUserWeeklyDigests 为开启周报的用户汇总最近 7 天各模型的用量
+func SendUserWeeklyDigests() {
+	now := time.Now()
+	weekAgo := now.AddDate(0, 0, -7).Unix()
+	settings, err := model.GetWeeklyDigestAlertSettings(weekAgo)
+	if err != nil {
+		common.SysError("failed to get weekly digest settings: " + err.Error())
+		return
+	}
+	for _, setting := range settings {
+		if !setting.HasChannel() {
+			continue
+		}
+		quotaDatas, err := model.GetQuotaDataByUserId(setting.UserId, weekAgo, now.Unix())
+		if err != nil {
+			common.SysError("failed to get quota data: " + err.Error())
+			continue
+		}
+		sendUserAlert(setting, "每周用量报告", buildWeeklyDigest(quotaDatas, weekAgo, now.Unix()))
+		if err = setting.UpdateLastDigestTime(now.Unix()); err != nil {
+			common.SysError("failed to update last digest time: " + err.Error())
+		}
+	}
+}
+
+func buildWeeklyDigest(quotaDatas []*model.QuotaData, startTime int64, endTime int64) string {
+	models := make(map[string]*model.QuotaData)
+	total := model.QuotaData{}
+	for _, data := range quotaDatas {
+		item, ok := models[data.ModelName]
+		if !ok {
+			item = &model.QuotaData{ModelName: data.ModelName}
+			models[data.ModelName] = item
+		}
+		item.Count += data.Count
+		item.Quota += data.Quota
+		item.TokenUsed += data.TokenUsed
+		total.Count += data.Count
+		total.Quota += data.Quota
+		total.TokenUsed += data.TokenUsed
+	}
+	items := make([]*model.QuotaData, 0, len(models))
+	for _, item := range models {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Quota > items[j].Quota
+	})
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s 至 %s 共请求 %d 次，消耗 %d tokens，%s。",
+		time.Unix(startTime, 0).Format("2006-01-02"), time.Unix(endTime, 0).Format("2006-01-02"),
+		total.Count, total.TokenUsed, common.LogQuota(total.Quota)))
+	for _, item := range items {
+		builder.WriteString(fmt.Sprintf("\n%s：%d 次，%d tokens，%s", item.ModelName, item.Count, item.TokenUsed, common.LogQuota(item.Quota)))
+	}
+	return builder.String()
+}
+
+func UserWeeklyDigestTask() {
+	for {
+		SendUserWeeklyDigests()
+		time.Sleep(time.Hour)
+	}
+}
@@ -6,7 +6,14 @@ import (
 	"one-api/model"
 )
 
-func notifyRootUser(subject string, content string) {
+// notifyRootUser 邮件通知 root 用户，同时分发到订阅了该事件的通知渠道
+func notifyRootUser(eventType string, dedupeKey string, subject string, content string) {
+	common.Notify(common.NotifyEvent{
+		Type:      eventType,
+		Subject:   subject,
+		Content:   content,
+		DedupeKey: dedupeKey,
+	})
 	if common.RootUserEmail == "" {
 		common.RootUserEmail = model.GetRootUserEmail()
 	}
@@ -15,3 +22,24 @@ func notifyRootUser(subject string, content string) {
 		common.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
 	}
 }
+
+// NotifyTaskFailed 异步任务（Midjourney、Suno 等）执行失败时通知
+func NotifyTaskFailed(platform string, taskId string, userId int, reason string) {
+	common.Notify(common.NotifyEvent{
+		Type:      common.NotifyEventTaskFailed,
+		Subject:   fmt.Sprintf("%s 任务 %s 执行失败", platform, taskId),
+		Content:   fmt.Sprintf("用户 #%d 的 %s 任务 %s 执行失败，原因：%s", userId, platform, taskId, reason),
+		Fields:    map[string]interface{}{"platform": platform, "task_id": taskId, "user_id": userId},
+		DedupeKey: platform + ":" + taskId,
+	})
+}
+
+// NotifyTopUpCompleted 用户充值或兑换成功时通知
+func NotifyTopUpCompleted(userId int, quota int, method string) {
+	common.Notify(common.NotifyEvent{
+		Type:    common.NotifyEventTopUpCompleted,
+		Subject: fmt.Sprintf("用户 #%d 充值成功", userId),
+		Content: fmt.Sprintf("用户 #%d 通过%s充值 %s", userId, method, common.LogQuota(quota)),
+		Fields:  map[string]interface{}{"user_id": userId, "quota": quota, "method": method},
+	})
+}
@@ -16,6 +16,7 @@ export default function GeneralSettings(props) {
     ChatLink2: '',
     QuotaPerUnit: '',
     RetryTimes: '',
+    RetryTimeout: '',
     DisplayInCurrencyEnabled: false,
     DisplayTokenStatEnabled: false,
     DefaultCollapseSidebar: false,
@@ -131,6 +132,16 @@ export default function GeneralSettings(props) {
                   showClear
                 />
               </Col>
+              <Col span={8}>
+                <Form.Input
+                  field={'RetryTimeout'}
+                  label={'重试总时长上限（秒）'}
+                  initValue={''}
+                  placeholder='超过该时长后不再重试，0 表示不限制'
+                  onChange={onChange}
+                  showClear
+                />
+              </Col>
             </Row>
             <Row gutter={16}>
               <Col span={8}>